@@ -6,8 +6,10 @@ package main
 
 import (
 	"context"
+	"errors"
 	"os"
 	"os/signal"
+	"runtime/debug"
 	"syscall"
 
 	"go.uber.org/zap"
@@ -19,22 +21,116 @@ import (
 
 var Version = "dev"
 
+// GitCommit is injected with ldflags, the VCS revision embedded by go build is used when it is not set
+var GitCommit = ""
+
+const (
+	exitCodeOK = 0
+	// exitCodeApplied is returned with --signal-applied when at least one migration was applied
+	exitCodeApplied = 3
+	// exitCodeInterrupted is returned when the run was cancelled by a signal, the migration in flight is rolled back
+	exitCodeInterrupted = 4
+	// exitCodeOutsideWindow is returned when migrations are pending outside of the maintenance window
+	exitCodeOutsideWindow = 5
+)
+
 func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	zapLogger := bootstrap.Logger()
+	// The logger depends on the config, errors loading it are logged in the auto detected format
+	cfg, err := config.LoadConfig(Version, gitCommit())
+	if err != nil {
+		bootstrap.Logger().Fatal("Error loading config", zap.Error(err))
+	}
+
+	zapLogger, err := bootstrap.NewLogger(cfg.LogFormat(), cfg.LogLevel())
+	if err != nil {
+		bootstrap.Logger().Fatal("Error creating logger", zap.Error(err))
+	}
 	defer func() { _ = zapLogger.Sync() }()
-	logger := zapLogger.Sugar()
 
-	logger.Infof("Starting clbs-dbtool %v...", Version)
+	zapLogger.Info("Starting clbs-dbtool "+Version+"...", zap.String("git_commit", cfg.GitCommit()))
 
-	logger.Info("Loading config...")
+	if cfg.PrintConfig() {
+		zapLogger.Info("Effective configuration", bootstrap.ConfigFields(cfg)...)
+	}
+
+	if cfg.SelfTest() {
+		err = dbtool.SelfTest(zapLogger)
+		if err != nil {
+			zapLogger.Fatal("Self-test failed", zap.Error(err))
+		}
+		return
+	}
 
-	cfg, err := config.LoadConfig(Version)
+	var result dbtool.Result
+	switch cfg.Command() {
+	case config.CommandVerify:
+		err = dbtool.Verify(ctx, zapLogger, cfg)
+	case config.CommandBaseline:
+		err = dbtool.Baseline(ctx, zapLogger, cfg)
+	case config.CommandRepair:
+		err = dbtool.Repair(ctx, zapLogger, cfg)
+	case config.CommandVersionDB:
+		err = dbtool.SchemaVersion(ctx, zapLogger, cfg, os.Stdout)
+	case config.CommandCheck:
+		err = dbtool.Check(ctx, zapLogger, cfg)
+	case config.CommandApplyFile:
+		err = dbtool.ApplyFile(ctx, zapLogger, cfg)
+	case config.CommandDrift:
+		err = dbtool.Drift(ctx, zapLogger, cfg)
+	case config.CommandHistory:
+		err = dbtool.History(ctx, zapLogger, cfg, os.Stdout)
+	case config.CommandLint:
+		err = dbtool.Lint(zapLogger, cfg)
+	case config.CommandPlanHash:
+		err = dbtool.PlanHash(zapLogger, cfg, os.Stdout)
+	default:
+		result, err = dbtool.Run(ctx, zapLogger, cfg)
+	}
+	if errors.Is(err, dbtool.ErrInterrupted) {
+		zapLogger.Error("Interrupted running "+cfg.Command(), zap.Error(err))
+		cancel()
+		_ = zapLogger.Sync()
+		os.Exit(exitCodeInterrupted)
+	}
+	if errors.Is(err, dbtool.ErrOutsideMaintenanceWindow) {
+		zapLogger.Error("Refused running "+cfg.Command(), zap.Error(err))
+		cancel()
+		_ = zapLogger.Sync()
+		os.Exit(exitCodeOutsideWindow)
+	}
 	if err != nil {
-		logger.Fatal("Error loading config", zap.Error(err))
+		zapLogger.Fatal("Error running "+cfg.Command(), zap.Error(err))
 	}
 
-	dbtool.Run(ctx, zapLogger, cfg)
+	if code := exitCode(result, cfg.SignalApplied()); code != exitCodeOK {
+		cancel()
+		_ = zapLogger.Sync()
+		os.Exit(code)
+	}
+}
+
+// gitCommit returns the commit the binary was built from, empty when unknown
+func gitCommit() string {
+	if GitCommit != "" {
+		return GitCommit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return ""
+}
+
+// exitCode returns the exit code of a successful run
+func exitCode(result dbtool.Result, signalApplied bool) int {
+	if signalApplied && result.Applied > 0 {
+		return exitCodeApplied
+	}
+	return exitCodeOK
 }
@@ -0,0 +1,27 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/clbs-io/dbtool/internal/dbtool"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCode(t *testing.T) {
+	t.Run("No-op run", func(t *testing.T) {
+		assert.Equal(t, exitCodeOK, exitCode(dbtool.Result{Applied: 0}, true))
+		assert.Equal(t, exitCodeOK, exitCode(dbtool.Result{Applied: 0}, false))
+	})
+
+	t.Run("Applied run", func(t *testing.T) {
+		assert.Equal(t, exitCodeApplied, exitCode(dbtool.Result{Applied: 2}, true))
+	})
+
+	t.Run("Applied run without signal-applied", func(t *testing.T) {
+		assert.Equal(t, exitCodeOK, exitCode(dbtool.Result{Applied: 2}, false))
+	})
+}
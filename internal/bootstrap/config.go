@@ -0,0 +1,106 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package bootstrap
+
+import (
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"go.uber.org/zap"
+)
+
+// ConfigFields returns the resolved settings of the config as log fields, the passwords of the connection string
+// and the URLs are masked and only the keys of the variables are included as their values may be secrets
+func ConfigFields(cfg *config.Config) []zap.Field {
+	filenamePattern := ""
+	if re := cfg.FilenamePattern(); re != nil {
+		filenamePattern = re.String()
+	}
+	pushgatewayURL := cfg.PushgatewayURL()
+	if pushgatewayURL != "" {
+		pushgatewayURL = config.RedactURL(pushgatewayURL)
+	}
+
+	return []zap.Field{
+		zap.String("command", cfg.Command()),
+		zap.String("app_id", cfg.AppId()),
+		zap.String("applied_by", cfg.AppliedBy()),
+		zap.Strings("migrations_dirs", cfg.Dirs()),
+		zap.String("files_from", cfg.FilesFrom()),
+		zap.String("connection_string", config.RedactConnectionString(cfg.ConnectionString())),
+		zap.String("tracking_connection_string", config.RedactConnectionString(cfg.TrackingConnectionString())),
+		zap.String("application_name", cfg.ApplicationName()),
+		zap.Duration("connection_timeout", time.Duration(cfg.ConnectionTimeout())*time.Second),
+		zap.Int("connect_retries", cfg.ConnectRetries()),
+		zap.Duration("connect_retry_interval", cfg.ConnectRetryInterval()),
+		zap.Int("max_reconnects", cfg.MaxReconnects()),
+		zap.Int("retry_on_conflict", cfg.RetryOnConflict()),
+		zap.Duration("inter_migration_delay", cfg.InterMigrationDelay()),
+		zap.Bool("check_replication_lag", cfg.CheckReplicationLag()),
+		zap.Duration("ping_timeout", cfg.PingTimeout()),
+		zap.Duration("statement_timeout", cfg.StatementTimeout()),
+		zap.Bool("create_database", cfg.CreateDatabase()),
+		zap.Int("min_server_version", cfg.MinServerVersion()),
+		zap.String("not_before", cfg.NotBefore()),
+		zap.String("not_after", cfg.NotAfter()),
+		zap.Bool("force", cfg.Force()),
+		zap.Int("steps", cfg.Steps()),
+		zap.String("target", cfg.Target()),
+		zap.String("from", cfg.From()),
+		zap.Int("max_depth", cfg.MaxDepth()),
+		zap.Int("max_parallel", cfg.MaxParallel()),
+		zap.Strings("include", cfg.Include()),
+		zap.Strings("exclude", cfg.Exclude()),
+		zap.String("filename_pattern", filenamePattern),
+		zap.Strings("tags", cfg.Tags()),
+		zap.String("seed_dir", cfg.SeedDir()),
+		zap.Bool("schema_per_app", cfg.SchemaPerApp()),
+		zap.String("hash_algorithm", cfg.HashAlgorithm()),
+		zap.String("hash_mode", cfg.HashMode()),
+		zap.String("sort", cfg.Sort()),
+		zap.String("encoding", cfg.Encoding()),
+		zap.Bool("skip_file_validation", cfg.SkipFileValidation()),
+		zap.Bool("reapply_changed", cfg.ReapplyChanged()),
+		zap.Bool("checksum_only_validation", cfg.ChecksumOnlyValidation()),
+		zap.Bool("verify_sidecar_checksums", cfg.VerifySidecarChecksums()),
+		zap.String("missing_sidecar", cfg.MissingSidecarPolicy()),
+		zap.Bool("allow_out_of_order", cfg.AllowOutOfOrder()),
+		zap.Bool("allow_missing", cfg.AllowMissing()),
+		zap.Bool("fail_on_empty", cfg.FailOnEmpty()),
+		zap.Bool("unique_basenames", cfg.UniqueBasenames()),
+		zap.Bool("use_snapshots", cfg.UseSnapshots()),
+		zap.Bool("split_statements", cfg.SplitStatements()),
+		zap.Bool("resolve_includes", cfg.ResolveIncludes()),
+		zap.Bool("strip_meta_commands", cfg.StripMetaCommands()),
+		zap.Bool("continue_on_error", cfg.ContinueOnError()),
+		zap.Strings("search_path", cfg.SearchPath()),
+		zap.String("before_each", cfg.BeforeEach()),
+		zap.String("after_each", cfg.AfterEach()),
+		zap.Strings("vars", slices.Sorted(maps.Keys(cfg.Vars()))),
+		zap.Any("metadata", cfg.Metadata()),
+		zap.Bool("store_sql", cfg.StoreSQL()),
+		zap.Bool("store_sql_compressed", cfg.StoreSQLCompressed()),
+		zap.Bool("validate_execute", cfg.ValidateExecute()),
+		zap.String("lock_strategy", cfg.LockStrategy()),
+		zap.Duration("lock_ttl", cfg.LockTTL()),
+		zap.Bool("write_status", cfg.WriteStatus()),
+		zap.Bool("track_schema_hash", cfg.TrackSchemaHash()),
+		zap.String("metrics_textfile", cfg.MetricsTextfile()),
+		zap.String("json_plan_file", cfg.JSONPlanFile()),
+		zap.String("pushgateway_url", pushgatewayURL),
+		zap.Bool("fail_on_pending", cfg.FailOnPending()),
+		zap.Bool("signal_applied", cfg.SignalApplied()),
+		zap.Bool("confirm", cfg.Confirm()),
+		zap.Bool("yes", cfg.Yes()),
+		zap.String("output", cfg.Output()),
+		zap.String("log_format", cfg.LogFormat()),
+		zap.String("log_level", cfg.LogLevel()),
+		zap.Bool("quiet", cfg.Quiet()),
+		zap.Bool("print_sql", cfg.PrintSQL()),
+		zap.Bool("log_notices", cfg.LogNotices()),
+	}
+}
@@ -5,25 +5,52 @@
 package bootstrap
 
 import (
+	"fmt"
 	"os"
 
+	"github.com/clbs-io/dbtool/internal/config"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
+// Logger returns the logger with the format detected automatically
 func Logger() *zap.Logger {
-	var zapLogger *zap.Logger
-	var err error
-	if _, ok := os.LookupEnv("KUBERNETES_SERVICE_HOST"); ok {
-		zapLogger, err = zap.NewProduction()
-		if err != nil {
-			panic(err)
+	zapLogger, err := NewLogger(config.LogFormatAuto, "")
+	if err != nil {
+		panic(err)
+	}
+
+	return zapLogger
+}
+
+// NewLogger returns the logger writing in the given format from the given level, in the auto format
+// JSON is logged when running in Kubernetes and human readable output otherwise.
+// An empty level keeps the default of the format, debug for console and info for JSON.
+func NewLogger(format string, level string) (*zap.Logger, error) {
+	if format == config.LogFormatAuto {
+		format = config.LogFormatConsole
+		if _, ok := os.LookupEnv("KUBERNETES_SERVICE_HOST"); ok {
+			format = config.LogFormatJSON
 		}
-	} else {
-		zapLogger, err = zap.NewDevelopment()
+	}
+
+	var zapConfig zap.Config
+	switch format {
+	case config.LogFormatJSON:
+		zapConfig = zap.NewProductionConfig()
+	case config.LogFormatConsole:
+		zapConfig = zap.NewDevelopmentConfig()
+	default:
+		return nil, fmt.Errorf("unknown log format %s", format)
+	}
+
+	if level != "" {
+		l, err := zapcore.ParseLevel(level)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
+		zapConfig.Level = zap.NewAtomicLevelAt(l)
 	}
 
-	return zapLogger
+	return zapConfig.Build()
 }
@@ -8,6 +8,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/clbs-io/dbtool/internal/config"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 )
@@ -58,3 +59,67 @@ func TestLogger(t *testing.T) {
 		})
 	})
 }
+
+func TestNewLogger(t *testing.T) {
+	t.Run("Explicit formats", func(t *testing.T) {
+		for _, format := range []string{config.LogFormatAuto, config.LogFormatJSON, config.LogFormatConsole} {
+			logger, err := NewLogger(format, "")
+			assert.NoError(t, err, format)
+			assert.NotNil(t, logger, format)
+		}
+	})
+
+	t.Run("JSON logs without Kubernetes", func(t *testing.T) {
+		t.Setenv("KUBERNETES_SERVICE_HOST", "")
+		_ = os.Unsetenv("KUBERNETES_SERVICE_HOST")
+
+		logger, err := NewLogger(config.LogFormatJSON, "")
+		assert.NoError(t, err)
+		// The development logger used for the console format enables debug messages
+		assert.False(t, logger.Core().Enabled(zap.DebugLevel))
+	})
+
+	t.Run("Console logs in Kubernetes", func(t *testing.T) {
+		t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+
+		logger, err := NewLogger(config.LogFormatConsole, "")
+		assert.NoError(t, err)
+		assert.True(t, logger.Core().Enabled(zap.DebugLevel))
+	})
+
+	t.Run("Unknown format", func(t *testing.T) {
+		_, err := NewLogger("xml", "")
+		assert.Error(t, err)
+	})
+}
+
+func TestNewLoggerLevel(t *testing.T) {
+	t.Run("Warn silences debug and info", func(t *testing.T) {
+		logger, err := NewLogger(config.LogFormatConsole, "warn")
+		assert.NoError(t, err)
+		assert.False(t, logger.Core().Enabled(zap.DebugLevel))
+		assert.False(t, logger.Core().Enabled(zap.InfoLevel))
+		assert.True(t, logger.Core().Enabled(zap.WarnLevel))
+		assert.True(t, logger.Core().Enabled(zap.FatalLevel))
+	})
+
+	t.Run("Default level keeps info and fatal", func(t *testing.T) {
+		for _, format := range []string{config.LogFormatJSON, config.LogFormatConsole} {
+			logger, err := NewLogger(format, "")
+			assert.NoError(t, err)
+			assert.True(t, logger.Core().Enabled(zap.InfoLevel), format)
+			assert.True(t, logger.Core().Enabled(zap.FatalLevel), format)
+		}
+	})
+
+	t.Run("Debug for JSON", func(t *testing.T) {
+		logger, err := NewLogger(config.LogFormatJSON, "debug")
+		assert.NoError(t, err)
+		assert.True(t, logger.Core().Enabled(zap.DebugLevel))
+	})
+
+	t.Run("Unknown level", func(t *testing.T) {
+		_, err := NewLogger(config.LogFormatJSON, "loud")
+		assert.Error(t, err)
+	})
+}
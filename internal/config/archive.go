@@ -0,0 +1,50 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package config
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SplitZipPath splits a migrations directory leading through a .zip file, e.g. migrations.zip/v2, into the path
+// of the archive and the directory inside it, it reports false for a plain directory
+func SplitZipPath(dir string) (string, string, bool) {
+	parts := strings.Split(filepath.ToSlash(filepath.Clean(dir)), "/")
+	for i, part := range parts {
+		if !strings.EqualFold(path.Ext(part), ".zip") {
+			continue
+		}
+		archive := filepath.FromSlash(strings.Join(parts[:i+1], "/"))
+		if info, err := os.Stat(archive); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		return archive, path.Join(append([]string{"."}, parts[i+1:]...)...), true
+	}
+	return "", "", false
+}
+
+// checkZipDir checks that the archive is a zip file holding the directory
+func checkZipDir(archive string, dir string) error {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+
+	info, err := fs.Stat(r, dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s in %s is not a directory", dir, archive)
+	}
+	return nil
+}
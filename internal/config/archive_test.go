@@ -0,0 +1,84 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package config
+
+import (
+	"archive/zip"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeZip writes an archive with the files to a temporary directory and returns its path
+func writeZip(t *testing.T, files map[string]string) string {
+	archive := filepath.Join(t.TempDir(), "migrations.zip")
+	f, err := os.Create(archive)
+	assert.NoError(t, err)
+	w := zip.NewWriter(f)
+	for name, content := range files {
+		fw, err := w.Create(name)
+		assert.NoError(t, err)
+		_, err = fw.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+	assert.NoError(t, f.Close())
+	return archive
+}
+
+func TestSplitZipPath(t *testing.T) {
+	archive := writeZip(t, map[string]string{"v2/001_init.sql": "SELECT 1;"})
+
+	t.Run("Archive", func(t *testing.T) {
+		zipPath, inner, ok := SplitZipPath(archive)
+		assert.True(t, ok)
+		assert.Equal(t, archive, zipPath)
+		assert.Equal(t, ".", inner)
+	})
+
+	t.Run("Directory inside the archive", func(t *testing.T) {
+		zipPath, inner, ok := SplitZipPath(filepath.Join(archive, "v2"))
+		assert.True(t, ok)
+		assert.Equal(t, archive, zipPath)
+		assert.Equal(t, "v2", inner)
+	})
+
+	t.Run("Plain directory", func(t *testing.T) {
+		_, _, ok := SplitZipPath("../../testing/samples/valid")
+		assert.False(t, ok)
+	})
+
+	t.Run("Missing archive", func(t *testing.T) {
+		_, _, ok := SplitZipPath(filepath.Join(t.TempDir(), "missing.zip"))
+		assert.False(t, ok)
+	})
+}
+
+func TestLoad_ZipMigrationsDir(t *testing.T) {
+	newFlagSet := func() *flag.FlagSet {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		return fs
+	}
+	archive := writeZip(t, map[string]string{"v2/001_init.sql": "SELECT 1;"})
+	validateDir := func(dir string) error {
+		cfg, err := load(newFlagSet(), []string{"--app-id", "app", "--connection-string", "postgres://localhost/db", "--migrations-dir", dir})
+		assert.NoError(t, err)
+		return cfg.validate()
+	}
+
+	assert.NoError(t, validateDir(archive))
+	assert.NoError(t, validateDir(filepath.Join(archive, "v2")))
+	assert.ErrorIs(t, validateDir(filepath.Join(archive, "v3")), ErrInvalidMigrationsDirectory)
+	assert.ErrorIs(t, validateDir(filepath.Join(archive, "v2", "001_init.sql")), ErrInvalidMigrationsDirectory)
+
+	notZip := filepath.Join(t.TempDir(), "broken.zip")
+	assert.NoError(t, os.WriteFile(notZip, []byte("not a zip"), 0o644))
+	assert.ErrorIs(t, validateDir(notZip), ErrInvalidMigrationsDirectory)
+}
@@ -8,61 +8,733 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
 	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 const (
 	defaultSteps             = -1
 	defaultConnectionTimeout = 45 // Seconds
+	defaultMaxDepth          = -1 // Unlimited
+	defaultMaxParallel       = 1
+
+	defaultConnectRetryInterval = time.Second
+	defaultLockTTL              = 15 * time.Minute
+	defaultPingTimeout          = 10 * time.Second
+
+	CommandMigrate   = "migrate"
+	CommandVerify    = "verify"
+	CommandBaseline  = "baseline"
+	CommandRepair    = "repair"
+	CommandVersionDB = "version-db"
+	CommandCheck     = "check"
+	CommandApplyFile = "apply-file"
+	CommandDrift     = "drift"
+	CommandHistory   = "history"
+	CommandLint      = "lint"
+	CommandPlanHash  = "plan-hash"
+
+	OutputText = "text"
+	OutputJSON = "json"
+	// OutputCSV is supported by the history command only
+	OutputCSV = "csv"
+
+	LogFormatAuto    = "auto"
+	LogFormatJSON    = "json"
+	LogFormatConsole = "console"
+
+	HashSHA256  = "sha256"
+	HashSHA512  = "sha512"
+	HashBLAKE2b = "blake2b"
+
+	HashModeExact = "exact"
+	// HashModeNormalized computes the checksums over the SQL without comments and with collapsed whitespace
+	HashModeNormalized = "normalized"
+
+	SortLexical = "lexical"
+	// SortNatural compares the digit runs of the path segments as numbers, e.g. file2.sql precedes file10.sql
+	SortNatural = "natural"
+
+	EncodingUTF8    = "utf-8"
+	EncodingUTF16LE = "utf-16le"
+	EncodingUTF16BE = "utf-16be"
+
+	MissingSidecarError  = "error"
+	MissingSidecarWarn   = "warn"
+	MissingSidecarIgnore = "ignore"
+
+	LockStrategyAdvisory = "advisory"
+	LockStrategyTable    = "table"
+
+	// MaxAppIdLength and MaxFilePathLength are the widths of the app_id and file_path columns of the dbtool tables
+	MaxAppIdLength    = 255
+	MaxFilePathLength = 4096
+	// MaxAppliedByLength is the width of the applied_by column of the migrations table
+	MaxAppliedByLength = 128
 )
 
 // Config fields are not exported, making Config immutable
 // Use getters to read a value from the Config struct
 type Config struct {
 	version string
-	appId   string
+	// gitCommit is the commit dbtool was built from, empty when unknown
+	gitCommit string
+	appId     string
+	appliedBy string
+	command   string
 
 	dir                    string
+	dirs                   stringList
+	withoutDir             bool
 	connectionString       string
+	targets                []string // all connection strings when migrating several databases
 	connectionStringFile   string
+	trackingConnection     string // connection string of a separate database of the dbtool tables
+	filesFrom              string
+	fileList               []string // paths read from filesFrom
 	connectionStringFormat string
 	connectionTimeout      int
 	steps                  int
 	skipFileValidation     bool
+	reapplyChanged         bool
+	metricsTextfile        string
+	jsonPlanFile           string
+	pushgatewayURL         string
+	lockStrategy           string
+	lockTTL                time.Duration
+	verifySidecarChecksums bool
+	missingSidecarPolicy   string
+	maxDepth               int
+	failOnPending          bool
+	signalApplied          bool
+	confirm                bool
+	yes                    bool
+	selfTest               bool
+	hashAlgorithm          string
+	hashMode               string
+	sortMode               string
+	splitStatements        bool
+	resolveIncludes        bool
+	stripMetaCommands      bool
+	target                 string
+	from                   string
+	include                stringList
+	exclude                stringList
+	filenamePattern        string
+	filenameRegexp         *regexp.Regexp
+	minServerVersion       string
+	// minServerVersionNum is minServerVersion in the form of server_version_num, set by validate
+	minServerVersionNum  int
+	notBefore            string
+	notAfter             string
+	notBeforeTime        time.Duration // time of day of notBefore, set by validate
+	notAfterTime         time.Duration // time of day of notAfter, set by validate
+	force                bool
+	connectRetries       int
+	connectRetryInterval time.Duration
+	maxReconnects        int
+	retryOnConflict      int
+	interMigrationDelay  time.Duration
+	checkReplicationLag  bool
+	pingTimeout          time.Duration
+	statementTimeout     time.Duration
+	allowOutOfOrder      bool
+	varList              stringList
+	vars                 map[string]string
+	metadataList         stringList
+	metadata             map[string]string
+	maxParallel          int
+	output               string
+	storeSQL             bool
+	storeSQLCompressed   bool
+	validateExecute      bool
+	logFormat            string
+	logLevel             string
+	quiet                bool
+	allowMissing         bool
+	failOnEmpty          bool
+	searchPath           stringList
+	uniqueBasenames      bool
+	printSQL             bool
+	logNotices           bool
+	encoding             string
+	applyFile            string
+	schemaSnapshot       string
+	sinceFlag            string
+	since                time.Time
+	untilFlag            string
+	until                time.Time
+	updateSchemaSnapshot bool
+	failOnDrift          bool
+	applicationName      string
+	continueOnError      bool
+	beforeEach           string
+	afterEach            string
+	writeStatus          bool
+	createDatabase       bool
+	checksumOnly         bool
+	useSnapshots         bool
+	printConfig          bool
+	trackSchemaHash      bool
+	tags                 stringList
+	seedDir              string
+	schemaPerApp         bool
+	ssl                  sslSettings
+}
+
+// Command returns the subcommand to run, migrate when none was given
+func (cfg *Config) Command() string {
+	return cfg.command
 }
 
+// Dir returns the first migrations directory
 func (cfg *Config) Dir() string {
 	return cfg.dir
 }
 
+// Dirs returns all migrations directories, their files are merged into one sequence
+func (cfg *Config) Dirs() []string {
+	if len(cfg.dirs.values) > 0 {
+		return cfg.dirs.values
+	}
+	if cfg.dir == "" {
+		return nil
+	}
+	return []string{cfg.dir}
+}
+
 func (cfg *Config) ConnectionString() string {
 	return cfg.connectionString
 }
 
+// Targets returns the connection strings of all databases migrate runs against, the first one is ConnectionString
+func (cfg *Config) Targets() []string {
+	if len(cfg.targets) == 0 {
+		return []string{cfg.connectionString}
+	}
+	return cfg.targets
+}
+
+// ForTarget returns a copy of the config connecting to the database of the target with the index
+func (cfg *Config) ForTarget(idx int) *Config {
+	target := *cfg
+	target.connectionString = cfg.Targets()[idx]
+	target.targets = nil
+	return &target
+}
+
+// resolveTrackingConnection normalizes the tracking connection string and applies the SSL settings to it,
+// the same as to the connection string
+func (cfg *Config) resolveTrackingConnection() error {
+	if cfg.trackingConnection == "" {
+		return nil
+	}
+	var err error
+	if cfg.trackingConnection, err = NormalizeConnectionString(cfg.trackingConnection, cfg.connectionStringFormat); err != nil {
+		return err
+	}
+	cfg.trackingConnection, err = cfg.ssl.apply(cfg.trackingConnection)
+	return err
+}
+
+// TrackingConnectionString returns the connection string of the separate database holding the dbtool tables,
+// empty when they are in the migrated database
+func (cfg *Config) TrackingConnectionString() string {
+	return cfg.trackingConnection
+}
+
+// ForTracking returns a copy of the config connecting to the database of the dbtool tables, the config itself
+// when they are in the migrated database. The database is neither created nor is its version checked,
+// both are about the migrated database
+func (cfg *Config) ForTracking() *Config {
+	if cfg.trackingConnection == "" {
+		return cfg
+	}
+	tracking := *cfg
+	tracking.connectionString = cfg.trackingConnection
+	tracking.targets = nil
+	tracking.createDatabase = false
+	tracking.minServerVersionNum = 0
+	return &tracking
+}
+
 func (cfg *Config) Steps() int {
 	return cfg.steps
 }
 
+// ValidateOnly reports whether --steps 0 is set without --target, the migrations are matched and the plan is
+// reported but nothing is applied
+func (cfg *Config) ValidateOnly() bool {
+	return cfg.steps == 0 && cfg.target == ""
+}
+
+// ValidateExecute reports whether the pending migrations are executed in a transaction that is always rolled back
+// instead of being applied
+func (cfg *Config) ValidateExecute() bool {
+	return cfg.validateExecute
+}
+
 func (cfg *Config) SkipFileValidation() bool {
 	return cfg.skipFileValidation
 }
 
+// ReapplyChanged reports whether applied migrations whose checksum has changed are executed again,
+// it takes precedence over SkipFileValidation
+func (cfg *Config) ReapplyChanged() bool {
+	return cfg.reapplyChanged
+}
+
+func (cfg *Config) MetricsTextfile() string {
+	return cfg.metricsTextfile
+}
+
+// JSONPlanFile returns the path the JSON plan of the migrate run is written to, empty when not written
+func (cfg *Config) JSONPlanFile() string {
+	return cfg.jsonPlanFile
+}
+
+func (cfg *Config) PushgatewayURL() string {
+	return cfg.pushgatewayURL
+}
+
+// LockStrategy returns how concurrent runs of the app are serialized, advisory unless set
+func (cfg *Config) LockStrategy() string {
+	if cfg.lockStrategy == "" {
+		return LockStrategyAdvisory
+	}
+	return cfg.lockStrategy
+}
+
+// LockTTL returns the age after which a row of the lock table is considered stale and taken over
+func (cfg *Config) LockTTL() time.Duration {
+	if cfg.lockTTL == 0 {
+		return defaultLockTTL
+	}
+	return cfg.lockTTL
+}
+
+func (cfg *Config) VerifySidecarChecksums() bool {
+	return cfg.verifySidecarChecksums
+}
+
+func (cfg *Config) MissingSidecarPolicy() string {
+	return cfg.missingSidecarPolicy
+}
+
+func (cfg *Config) MaxDepth() int {
+	return cfg.maxDepth
+}
+
+func (cfg *Config) FailOnPending() bool {
+	return cfg.failOnPending
+}
+
+func (cfg *Config) SignalApplied() bool {
+	return cfg.signalApplied
+}
+
+// Confirm reports whether migrate asks for a confirmation of the plan before applying
+func (cfg *Config) Confirm() bool {
+	return cfg.confirm
+}
+
+// Yes reports whether the confirmation is given in advance, e.g. in CI where stdin is not a terminal
+func (cfg *Config) Yes() bool {
+	return cfg.yes
+}
+
+func (cfg *Config) SelfTest() bool {
+	return cfg.selfTest
+}
+
+// HashAlgorithm returns the algorithm used to compute checksums of new migrations
+func (cfg *Config) HashAlgorithm() string {
+	if cfg.hashAlgorithm == "" {
+		return HashSHA256
+	}
+	return cfg.hashAlgorithm
+}
+
+// HashMode returns whether checksums of new migrations are computed over the exact or the normalized SQL
+func (cfg *Config) HashMode() string {
+	if cfg.hashMode == "" {
+		return HashModeExact
+	}
+	return cfg.hashMode
+}
+
+// Sort returns whether the migration paths are ordered lexically or naturally
+func (cfg *Config) Sort() string {
+	if cfg.sortMode == "" {
+		return SortLexical
+	}
+	return cfg.sortMode
+}
+
+func (cfg *Config) SplitStatements() bool {
+	return cfg.splitStatements
+}
+
+// ResolveIncludes reports whether the psql \i lines of migrations are replaced with the included files
+func (cfg *Config) ResolveIncludes() bool {
+	return cfg.resolveIncludes
+}
+
+// StripMetaCommands reports whether the psql meta-command lines of migrations are removed instead of failing the run
+func (cfg *Config) StripMetaCommands() bool {
+	return cfg.stripMetaCommands
+}
+
+// Target returns the relative path of the last migration to process, empty when not set
+func (cfg *Config) Target() string {
+	return cfg.target
+}
+
+// From returns the relative path of the applied migration the steps start after, empty when not set
+func (cfg *Config) From() string {
+	return cfg.from
+}
+
+// Include returns the glob patterns a migration file path has to match, all files match when empty
+func (cfg *Config) Include() []string {
+	return cfg.include.values
+}
+
+// Exclude returns the glob patterns of migration file paths to leave out
+func (cfg *Config) Exclude() []string {
+	return cfg.exclude.values
+}
+
+// FilenamePattern returns the compiled file name pattern override, nil when the default pattern is used
+func (cfg *Config) FilenamePattern() *regexp.Regexp {
+	return cfg.filenameRegexp
+}
+
+func (cfg *Config) ConnectRetries() int {
+	return cfg.connectRetries
+}
+
+// ConnectRetryInterval returns the delay before the first retry, the delay doubles with every further retry
+func (cfg *Config) ConnectRetryInterval() time.Duration {
+	return cfg.connectRetryInterval
+}
+
+// MaxReconnects returns how many times a connection lost between migrations is replaced during a run,
+// zero fails the run on a lost connection
+func (cfg *Config) MaxReconnects() int {
+	return cfg.maxReconnects
+}
+
+// RetryOnConflict returns how many times a migration failing with a serialization failure or a deadlock is retried,
+// zero fails the run on the first conflict
+func (cfg *Config) RetryOnConflict() int {
+	return cfg.retryOnConflict
+}
+
+// InterMigrationDelay returns how long to wait between consecutive migrations, zero does not wait
+func (cfg *Config) InterMigrationDelay() time.Duration {
+	return cfg.interMigrationDelay
+}
+
+// CheckReplicationLag reports whether the replication lag of the standbys is logged before waiting between migrations
+func (cfg *Config) CheckReplicationLag() bool {
+	return cfg.checkReplicationLag
+}
+
+// AllowOutOfOrder reports whether files not applied yet are applied even when they sort before applied ones
+func (cfg *Config) AllowOutOfOrder() bool {
+	return cfg.allowOutOfOrder
+}
+
+// Vars returns the variables substituted for ${key} placeholders in the migrations, empty when substitution is off
+func (cfg *Config) Vars() map[string]string {
+	return cfg.vars
+}
+
+// Metadata returns the key-values stored as JSON with every migration recorded by the run, empty when none is set
+func (cfg *Config) Metadata() map[string]string {
+	return cfg.metadata
+}
+
+// MaxParallel returns the maximum number of migrations of a parallel directory executed concurrently
+func (cfg *Config) MaxParallel() int {
+	if cfg.maxParallel == 0 {
+		return defaultMaxParallel
+	}
+	return cfg.maxParallel
+}
+
+// Output returns the format of the command output, text by default
+func (cfg *Config) Output() string {
+	if cfg.output == "" {
+		return OutputText
+	}
+	return cfg.output
+}
+
+// StoreSQL reports whether the executed SQL is stored in the migration table
+func (cfg *Config) StoreSQL() bool {
+	return cfg.storeSQL || cfg.storeSQLCompressed
+}
+
+// StoreSQLCompressed reports whether the stored SQL is compressed with gzip and encoded with base64
+func (cfg *Config) StoreSQLCompressed() bool {
+	return cfg.storeSQLCompressed
+}
+
+// LogFormat returns the format of the log output, auto by default
+func (cfg *Config) LogFormat() string {
+	if cfg.logFormat == "" {
+		return LogFormatAuto
+	}
+	return cfg.logFormat
+}
+
+// LogLevel returns the minimum level of logged messages, empty for the default of the log format,
+// warn with --quiet unless the level is set explicitly
+func (cfg *Config) LogLevel() string {
+	if cfg.logLevel == "" && cfg.quiet {
+		return "warn"
+	}
+	return cfg.logLevel
+}
+
+// Quiet reports whether only warnings and errors are logged unless --log-level is set
+func (cfg *Config) Quiet() bool {
+	return cfg.quiet
+}
+
+// FailOnEmpty reports whether finding no SQL files is an error instead of a warning
+func (cfg *Config) FailOnEmpty() bool {
+	return cfg.failOnEmpty
+}
+
+// AllowMissing reports whether applied migrations missing on disk are only warned about
+func (cfg *Config) AllowMissing() bool {
+	return cfg.allowMissing
+}
+
+// SearchPath returns the schemas the search_path is set to for every migration, empty keeps the server default,
+// with --schema-per-app the schema of the app comes first
+func (cfg *Config) SearchPath() []string {
+	if cfg.schemaPerApp {
+		return slices.Concat([]string{pgx.Identifier{cfg.AppSchema()}.Sanitize()}, cfg.searchPath.values)
+	}
+	return cfg.searchPath.values
+}
+
+// SchemaPerApp reports whether the migrations and the dbtool tables of the app live in the schema of the app
+func (cfg *Config) SchemaPerApp() bool {
+	return cfg.schemaPerApp
+}
+
+// AppSchema returns the name of the schema of the app with --schema-per-app, the app id itself, empty otherwise
+func (cfg *Config) AppSchema() string {
+	if !cfg.schemaPerApp {
+		return ""
+	}
+	return cfg.appId
+}
+
+// UniqueBasenames reports whether SQL files in different directories must not share a base name
+func (cfg *Config) UniqueBasenames() bool {
+	return cfg.uniqueBasenames
+}
+
+// LogNotices reports whether the notices of the server, e.g. RAISE NOTICE, are logged at info level
+func (cfg *Config) LogNotices() bool {
+	return cfg.logNotices
+}
+
+// PrintSQL reports whether the SQL is logged at debug level before it is executed
+func (cfg *Config) PrintSQL() bool {
+	return cfg.printSQL
+}
+
+// Encoding returns the encoding migration files are decoded from, a byte order mark takes precedence
+func (cfg *Config) Encoding() string {
+	if cfg.encoding == "" {
+		return EncodingUTF8
+	}
+	return cfg.encoding
+}
+
+// NotBefore returns the start of the maintenance window as given
+func (cfg *Config) NotBefore() string {
+	return cfg.notBefore
+}
+
+// NotAfter returns the end of the maintenance window as given
+func (cfg *Config) NotAfter() string {
+	return cfg.notAfter
+}
+
+// MaintenanceWindow returns the times of day migrations are applied between, ok is false without a window.
+// A window ending before it starts spans midnight
+func (cfg *Config) MaintenanceWindow() (notBefore, notAfter time.Duration, ok bool) {
+	return cfg.notBeforeTime, cfg.notAfterTime, cfg.notBefore != "" || cfg.notAfter != ""
+}
+
+// Force reports whether migrations are applied outside the maintenance window
+func (cfg *Config) Force() bool {
+	return cfg.force
+}
+
+// ApplyFile returns the relative path of the migration applied by the apply-file command
+func (cfg *Config) ApplyFile() string {
+	return cfg.applyFile
+}
+
+// MinServerVersion returns the minimum server_version_num the server must have, zero when not set
+func (cfg *Config) MinServerVersion() int {
+	return cfg.minServerVersionNum
+}
+
+// Tags returns the environments whose tagged migrations are applied, untagged ones are applied always
+func (cfg *Config) Tags() []string {
+	return cfg.tags.values
+}
+
+// SeedDir returns the directory of the idempotent seed scripts executed after every run, empty when none
+func (cfg *Config) SeedDir() string {
+	return cfg.seedDir
+}
+
+// TrackSchemaHash reports whether the schema hash is recorded after the run and compared before the next one
+func (cfg *Config) TrackSchemaHash() bool {
+	return cfg.trackSchemaHash
+}
+
+// PrintConfig reports whether the effective configuration is logged before the command runs
+func (cfg *Config) PrintConfig() bool {
+	return cfg.printConfig
+}
+
+// UseSnapshots reports whether the first run starts from the last snapshot directory instead of replaying all migrations
+func (cfg *Config) UseSnapshots() bool {
+	return cfg.useSnapshots
+}
+
+// SchemaSnapshot returns the path of the file the drift command compares the live schema against
+func (cfg *Config) SchemaSnapshot() string {
+	return cfg.schemaSnapshot
+}
+
+// UpdateSchemaSnapshot reports whether the drift command writes the live schema to the snapshot file instead
+func (cfg *Config) UpdateSchemaSnapshot() bool {
+	return cfg.updateSchemaSnapshot
+}
+
+// FailOnDrift reports whether the drift command fails when the live schema differs from the snapshot
+func (cfg *Config) FailOnDrift() bool {
+	return cfg.failOnDrift
+}
+
+// Since returns the start of the time range the history command lists, inclusive, zero when not limited
+func (cfg *Config) Since() time.Time {
+	return cfg.since
+}
+
+// Until returns the end of the time range the history command lists, exclusive, zero when not limited
+func (cfg *Config) Until() time.Time {
+	return cfg.until
+}
+
+// ApplicationName returns the application_name of the database sessions, empty unless set explicitly
+func (cfg *Config) ApplicationName() string {
+	return cfg.applicationName
+}
+
+// ContinueOnError reports whether a failed migration in a best-effort directory is recorded and skipped
+func (cfg *Config) ContinueOnError() bool {
+	return cfg.continueOnError
+}
+
+// BeforeEach returns the SQL executed before every migration in its transaction, empty when none
+func (cfg *Config) BeforeEach() string {
+	return cfg.beforeEach
+}
+
+// AfterEach returns the SQL executed after every migration in its transaction, empty when none
+func (cfg *Config) AfterEach() string {
+	return cfg.afterEach
+}
+
+// WriteStatus reports whether the progress of the run is written to the clbs_dbtool_status table
+func (cfg *Config) WriteStatus() bool {
+	return cfg.writeStatus
+}
+
+// CreateDatabase reports whether a missing target database is created through the postgres database
+func (cfg *Config) CreateDatabase() bool {
+	return cfg.createDatabase
+}
+
+// ChecksumOnlyValidation reports whether applied migrations moved on disk are matched by checksum and their paths updated
+func (cfg *Config) ChecksumOnlyValidation() bool {
+	return cfg.checksumOnly
+}
+
 func (cfg *Config) Version() string {
 	return cfg.version
 }
 
+// GitCommit returns the commit dbtool was built from, empty when unknown
+func (cfg *Config) GitCommit() string {
+	return cfg.gitCommit
+}
+
 func (cfg *Config) AppId() string {
 	return cfg.appId
 }
 
+// FilesFrom returns the file holding the list of migration files to use instead of walking the directories,
+// - is stdin
+func (cfg *Config) FilesFrom() string {
+	return cfg.filesFrom
+}
+
+// FileList returns the paths relative to the migrations directory read from FilesFrom
+func (cfg *Config) FileList() []string {
+	return cfg.fileList
+}
+
+// AppliedBy returns the user or service account recorded next to every applied migration
+func (cfg *Config) AppliedBy() string {
+	return cfg.appliedBy
+}
+
 func (cfg *Config) ConnectionTimeout() int {
 	return cfg.connectionTimeout
 }
 
+// PingTimeout returns the timeout of the ping following every connection
+func (cfg *Config) PingTimeout() time.Duration {
+	if cfg.pingTimeout == 0 {
+		return defaultPingTimeout
+	}
+	return cfg.pingTimeout
+}
+
+// StatementTimeout returns the statement_timeout set in the transaction of every migration, zero when not set
+func (cfg *Config) StatementTimeout() time.Duration {
+	return cfg.statementTimeout
+}
+
 func (cfg *Config) Host() string {
 	tmp, err := pgxpool.ParseConfig(cfg.connectionString)
 	if err != nil || tmp.ConnConfig == nil {
@@ -71,18 +743,101 @@ func (cfg *Config) Host() string {
 	return fmt.Sprintf("%s:%d", tmp.ConnConfig.Host, tmp.ConnConfig.Port)
 }
 
-func LoadConfig(version string) (*Config, error) {
-	cfg, err := load()
+func LoadConfig(version string, gitCommit string) (*Config, error) {
+	cfg, err := load(flag.CommandLine, os.Args[1:])
 	if err != nil {
 		return nil, err
 	}
 	cfg.version = version
+	cfg.gitCommit = gitCommit
 	err = cfg.validate()
 	return cfg, err
 }
 
+// connectionStringList is a flag that can be repeated, unlike stringList the values are not split at commas.
+// Values set by the flag replace the one from the environment variable.
+type connectionStringList struct {
+	values []string
+	set    bool
+}
+
+func newConnectionStringList(envValue string) connectionStringList {
+	var l connectionStringList
+	if envValue != "" {
+		l.values = []string{envValue}
+	}
+	return l
+}
+
+func (l *connectionStringList) String() string {
+	return strings.Join(l.values, " ")
+}
+
+func (l *connectionStringList) Set(value string) error {
+	if !l.set {
+		l.values = nil
+		l.set = true
+	}
+	l.values = append(l.values, value)
+	return nil
+}
+
+// readConnectionStrings returns the non-empty lines of the connection string file, lines starting with # are ignored
+func readConnectionStrings(data string) []string {
+	var values []string
+	for line := range strings.Lines(data) {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			values = append(values, line)
+		}
+	}
+	return values
+}
+
+// stringList is a flag that can be repeated, each occurrence can hold comma separated values.
+// Values set by the flag replace the ones from the environment variable.
+type stringList struct {
+	values []string
+	set    bool
+}
+
+func newStringList(envValue string) stringList {
+	var l stringList
+	l.appendSplit(envValue)
+	return l
+}
+
+func (l *stringList) String() string {
+	return strings.Join(l.values, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	if !l.set {
+		l.values = nil
+		l.set = true
+	}
+	l.appendSplit(value)
+	return nil
+}
+
+func (l *stringList) appendSplit(value string) {
+	for v := range strings.SplitSeq(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l.values = append(l.values, v)
+		}
+	}
+}
+
+// defaultAppliedBy returns the name of the OS user, PGUSER when it cannot be determined, e.g. in a container running
+// with a user id that has no passwd entry
+func defaultAppliedBy() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("PGUSER")
+}
+
 type flagTypes interface {
-	~int | ~string | ~bool
+	~int | ~string | ~bool | time.Duration
 }
 
 // getEnvironmentOrDefault retrieves the value of the environment variable named by the key.
@@ -102,6 +857,10 @@ func getEnvironmentOrDefault[T flagTypes](envVar string, fallbackValue T) T {
 			if v, err := strconv.ParseBool(value); err == nil {
 				return any(v).(T)
 			}
+		case time.Duration:
+			if v, err := time.ParseDuration(value); err == nil {
+				return any(v).(T)
+			}
 		}
 	}
 	return fallbackValue
@@ -110,55 +869,345 @@ func getEnvironmentOrDefault[T flagTypes](envVar string, fallbackValue T) T {
 var (
 	ErrInvalidADOConnectionString    = errors.New("failed to parse ADO connection string")
 	ErrConnectionStringFileReadError = errors.New("failed to read connection string file")
+	ErrHookFileReadError             = errors.New("failed to read hook file")
+	ErrFilesFromReadError            = errors.New("failed to read files-from list")
 )
 
-func load() (*Config, error) {
-	cfg := &Config{}
+// load parses the command line arguments into a new Config,
+// the first argument is treated as the command name unless it is a flag
+func load(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg := &Config{command: CommandMigrate}
 
-	flag.StringVar(&cfg.appId, "app-id", getEnvironmentOrDefault("APP_ID", ""), "Application ID")
-	flag.StringVar(&cfg.dir, "migrations-dir", getEnvironmentOrDefault("MIGRATIONS_DIR", ""), "Root directory where to look for SQL files")
-	flag.StringVar(&cfg.connectionString, "connection-string", getEnvironmentOrDefault("CONNECTION_STRING", ""), "Database URL to connect to")
-	flag.StringVar(&cfg.connectionStringFile, "connection-string-file", getEnvironmentOrDefault("CONNECTION_STRING_FILE", ""), "Path to a file containing database URL to connect to")
-	flag.StringVar(&cfg.connectionStringFormat, "connection-string-format", getEnvironmentOrDefault("CONNECTION_STRING_FORMAT", "default"), "Connection string format. [default, ado]")
-	flag.IntVar(&cfg.steps, "steps", getEnvironmentOrDefault("STEPS", defaultSteps), "Number of steps to apply (default: -1, apply all migrations)")
-	flag.BoolVar(&cfg.skipFileValidation, "skip-file-validation", getEnvironmentOrDefault("SKIP_FILE_VALIDATION", false), "Skip file validation (default: false)")
-	flag.IntVar(&cfg.connectionTimeout, "connection-timeout", getEnvironmentOrDefault("CONNECTION_TIMEOUT", defaultConnectionTimeout), fmt.Sprintf("Connection timeout in seconds, must be a positive number (default: %d)", defaultConnectionTimeout))
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cfg.command = args[0]
+		args = args[1:]
+	}
 
-	flag.Parse()
+	// The file of apply-file can be given before or after the flags
+	if cfg.command == CommandApplyFile && len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cfg.applyFile = args[0]
+		args = args[1:]
+	}
 
-	if strings.EqualFold(cfg.connectionStringFormat, "ado") {
-		tmp, err := connectionStringFromADO(cfg.connectionString)
-		if err != nil {
+	fs.StringVar(&cfg.appId, "app-id", getEnvironmentOrDefault("APP_ID", ""), "Application ID")
+	fs.StringVar(&cfg.appliedBy, "applied-by", getEnvironmentOrDefault("APPLIED_BY", defaultAppliedBy()), "User or service account recorded next to every applied migration (default: the OS user or PGUSER)")
+	cfg.dirs = newStringList(getEnvironmentOrDefault("MIGRATIONS_DIR", ""))
+	fs.Var(&cfg.dirs, "migrations-dir", "Root directory where to look for SQL files, can be repeated or comma separated to merge several directories")
+	connectionStrings := newConnectionStringList(getEnvironmentOrDefault("CONNECTION_STRING", ""))
+	fs.Var(&connectionStrings, "connection-string", "Database URL to connect to, can be repeated to migrate several databases one after another")
+	fs.StringVar(&cfg.filesFrom, "files-from", getEnvironmentOrDefault("FILES_FROM", ""), "File with the newline separated migration files to use instead of walking the directories, - reads stdin")
+	fs.StringVar(&cfg.trackingConnection, "tracking-connection-string", getEnvironmentOrDefault("TRACKING_CONNECTION_STRING", ""), "Database URL of a separate database holding the migration table and the other dbtool tables")
+	fs.StringVar(&cfg.connectionStringFile, "connection-string-file", getEnvironmentOrDefault("CONNECTION_STRING_FILE", ""), "Path to a file containing database URL to connect to, one per line to migrate several databases")
+	var params connectionParams
+	fs.StringVar(&params.host, "host", getEnvironmentOrDefault("PGHOST", ""), "Database host used when no connection string is given")
+	fs.StringVar(&params.port, "port", getEnvironmentOrDefault("PGPORT", ""), "Database port used when no connection string is given")
+	fs.StringVar(&params.user, "user", getEnvironmentOrDefault("PGUSER", ""), "Database user used when no connection string is given, the password is read from PGPASSWORD or the password file")
+	fs.StringVar(&params.dbname, "dbname", getEnvironmentOrDefault("PGDATABASE", ""), "Database name used when no connection string is given")
+	fs.StringVar(&params.sslmode, "sslmode", getEnvironmentOrDefault("PGSSLMODE", ""), "SSL mode used when no connection string is given")
+	fs.StringVar(&cfg.applicationName, "application-name", getEnvironmentOrDefault("APPLICATION_NAME", ""), "application_name of the database sessions, overrides the one of the connection string (default: clbs-dbtool/<version>/<app-id>)")
+	fs.StringVar(&cfg.ssl.mode, "ssl-mode", getEnvironmentOrDefault("SSL_MODE", ""), "SSL mode, overrides the one of the connection string")
+	fs.StringVar(&cfg.ssl.cert, "ssl-cert", getEnvironmentOrDefault("SSL_CERT", ""), "Path of the client certificate file, overrides the one of the connection string")
+	fs.StringVar(&cfg.ssl.key, "ssl-key", getEnvironmentOrDefault("SSL_KEY", ""), "Path of the client private key file, overrides the one of the connection string")
+	fs.StringVar(&cfg.ssl.rootCert, "ssl-root-cert", getEnvironmentOrDefault("SSL_ROOT_CERT", ""), "Path of the root certificate file verifying the server, overrides the one of the connection string")
+	fs.StringVar(&cfg.connectionStringFormat, "connection-string-format", getEnvironmentOrDefault("CONNECTION_STRING_FORMAT", "default"), "Connection string format. [default, ado]")
+	fs.IntVar(&cfg.steps, "steps", getEnvironmentOrDefault("STEPS", defaultSteps), "Number of steps to apply, 0 validates and reports the plan without applying anything (default: -1, apply all migrations)")
+	fs.BoolVar(&cfg.validateExecute, "validate-execute", getEnvironmentOrDefault("VALIDATE_EXECUTE", false), "Execute the pending migrations in a transaction that is rolled back instead of applying them, nothing is recorded (default: false)")
+	fs.BoolVar(&cfg.skipFileValidation, "skip-file-validation", getEnvironmentOrDefault("SKIP_FILE_VALIDATION", false), "Skip file validation (default: false)")
+	fs.BoolVar(&cfg.reapplyChanged, "reapply-changed", getEnvironmentOrDefault("REAPPLY_CHANGED", false), "Execute applied migrations whose file has changed again and update their row, e.g. views maintained in place (default: false)")
+	fs.IntVar(&cfg.connectionTimeout, "connection-timeout", getEnvironmentOrDefault("CONNECTION_TIMEOUT", defaultConnectionTimeout), fmt.Sprintf("Timeout in seconds of establishing a connection, it bounds neither the ping nor the migrations, must be a positive number (default: %d)", defaultConnectionTimeout))
+	fs.DurationVar(&cfg.pingTimeout, "ping-timeout", getEnvironmentOrDefault("PING_TIMEOUT", defaultPingTimeout), fmt.Sprintf("Timeout of the ping right after connecting (default: %s)", defaultPingTimeout))
+	fs.DurationVar(&cfg.statementTimeout, "statement-timeout", getEnvironmentOrDefault("STATEMENT_TIMEOUT", time.Duration(0)), "statement_timeout set in the transaction of every migration, bounds a hung migration, 0 leaves the one of the server (default: 0)")
+	fs.BoolVar(&cfg.verifySidecarChecksums, "verify-sidecar-checksums", getEnvironmentOrDefault("VERIFY_SIDECAR_CHECKSUMS", false), "Verify each SQL file against its <file>.sha256 sidecar before applying (default: false)")
+	fs.StringVar(&cfg.missingSidecarPolicy, "missing-sidecar", getEnvironmentOrDefault("MISSING_SIDECAR", MissingSidecarError), "What to do when a sidecar checksum file is missing. [error, warn, ignore]")
+	fs.IntVar(&cfg.maxDepth, "max-depth", getEnvironmentOrDefault("MAX_DEPTH", defaultMaxDepth), "Maximum subdirectory depth of SQL files, 0 allows files in the root directory only (default: -1, unlimited)")
+	fs.BoolVar(&cfg.failOnPending, "fail-on-pending", getEnvironmentOrDefault("FAIL_ON_PENDING", false), "verify: also fail when there are SQL files that have not been applied yet (default: false)")
+	fs.BoolVar(&cfg.signalApplied, "signal-applied", getEnvironmentOrDefault("SIGNAL_APPLIED", false), "Exit with code 3 when at least one migration was applied, 0 stays reserved for runs with nothing pending (default: false)")
+	fs.BoolVar(&cfg.confirm, "confirm", getEnvironmentOrDefault("CONFIRM", false), "Print the plan and ask for a confirmation on the terminal before applying, fails without a terminal unless --yes is set (default: false)")
+	fs.BoolVar(&cfg.yes, "yes", getEnvironmentOrDefault("YES", false), "Answer the --confirm prompt with yes, e.g. in CI (default: false)")
+	fs.BoolVar(&cfg.selfTest, "self-test", getEnvironmentOrDefault("SELF_TEST", false), "Run the built-in self-test without connecting to a database and exit (default: false)")
+	fs.StringVar(&cfg.hashAlgorithm, "hash-algorithm", getEnvironmentOrDefault("HASH_ALGORITHM", HashSHA256), "Checksum algorithm for newly applied migrations. [sha256, sha512, blake2b]")
+	fs.StringVar(&cfg.hashMode, "hash-mode", getEnvironmentOrDefault("HASH_MODE", HashModeExact), "Checksum newly applied migrations over the exact file or over the SQL without comments and with collapsed whitespace. [exact, normalized]")
+	fs.StringVar(&cfg.sortMode, "sort", getEnvironmentOrDefault("SORT", SortLexical), "Order of the migration paths, natural compares the numbers in the names numerically. [lexical, natural]")
+	fs.BoolVar(&cfg.splitStatements, "split-statements", getEnvironmentOrDefault("SPLIT_STATEMENTS", false), "Split each migration file into statements executed one by one (default: false)")
+	fs.BoolVar(&cfg.stripMetaCommands, "strip-meta-commands", getEnvironmentOrDefault("STRIP_META_COMMANDS", false), "Remove psql meta-command lines such as \\timing or \\set from migrations instead of failing on them (default: false)")
+	fs.BoolVar(&cfg.resolveIncludes, "resolve-includes", getEnvironmentOrDefault("RESOLVE_INCLUDES", false), "Replace \\i lines of migrations with the file they include relative to the migration, included files are not migrations (default: false)")
+	fs.StringVar(&cfg.target, "target", getEnvironmentOrDefault("TARGET", ""), "Relative path of the last migration to process (inclusive), takes precedence over --steps")
+	fs.StringVar(&cfg.from, "from", getEnvironmentOrDefault("FROM", ""), "Relative path of an applied migration, --steps and --target count from the file after it and fail while it or an earlier file is pending")
+	cfg.include = newStringList(getEnvironmentOrDefault("INCLUDE", ""))
+	fs.Var(&cfg.include, "include", "Glob pattern matched against the relative path of migration files to include, can be repeated or comma separated")
+	cfg.exclude = newStringList(getEnvironmentOrDefault("EXCLUDE", ""))
+	fs.Var(&cfg.exclude, "exclude", "Glob pattern matched against the relative path of migration files to exclude, can be repeated or comma separated")
+	cfg.tags = newStringList(getEnvironmentOrDefault("TAGS", ""))
+	fs.Var(&cfg.tags, "tags", "Tags of the environment, migrations declaring -- dbtool:tags= are applied only when a tag matches, can be repeated or comma separated")
+	fs.StringVar(&cfg.filenamePattern, "filename-pattern", getEnvironmentOrDefault("FILENAME_PATTERN", ""), "Regular expression SQL file names must match, overrides the default pattern")
+	fs.IntVar(&cfg.connectRetries, "connect-retries", getEnvironmentOrDefault("CONNECT_RETRIES", 0), "Number of times to retry connecting to the database (default: 0)")
+	fs.DurationVar(&cfg.connectRetryInterval, "connect-retry-interval", getEnvironmentOrDefault("CONNECT_RETRY_INTERVAL", defaultConnectRetryInterval), fmt.Sprintf("Delay before the first connection retry, doubled after every attempt (default: %s)", defaultConnectRetryInterval))
+	fs.IntVar(&cfg.retryOnConflict, "retry-on-conflict", getEnvironmentOrDefault("RETRY_ON_CONFLICT", 0), "Number of times to retry a migration failing with a serialization failure or a deadlock (default: 0)")
+	fs.DurationVar(&cfg.interMigrationDelay, "inter-migration-delay", getEnvironmentOrDefault("INTER_MIGRATION_DELAY", time.Duration(0)), "Time to wait between consecutive migrations to throttle the load on the primary, e.g. 30s (default: 0, no delay)")
+	fs.BoolVar(&cfg.checkReplicationLag, "check-replication-lag", getEnvironmentOrDefault("CHECK_REPLICATION_LAG", false), "Log the replication lag of the standbys from pg_stat_replication before every --inter-migration-delay (default: false)")
+	fs.IntVar(&cfg.maxReconnects, "max-reconnects", getEnvironmentOrDefault("MAX_RECONNECTS", 0), "Number of times to reconnect and resume when the connection is lost between migrations (default: 0)")
+	fs.BoolVar(&cfg.allowOutOfOrder, "allow-out-of-order", getEnvironmentOrDefault("ALLOW_OUT_OF_ORDER", false), "Apply files that have not been applied yet even when they sort before already applied ones (default: false)")
+	cfg.varList = newStringList(getEnvironmentOrDefault("VARS", ""))
+	fs.Var(&cfg.varList, "var", "Variable substituted for ${key} placeholders in the migrations as key=value, can be repeated or comma separated")
+	cfg.metadataList = newStringList(getEnvironmentOrDefault("METADATA", ""))
+	fs.Var(&cfg.metadataList, "metadata", "Metadata stored as JSON with every migration recorded by the run as key=value, e.g. the CI pipeline, can be repeated or comma separated")
+	fs.IntVar(&cfg.maxParallel, "max-parallel", getEnvironmentOrDefault("MAX_PARALLEL", defaultMaxParallel), fmt.Sprintf("Maximum number of migrations in a parallel directory executed concurrently (default: %d)", defaultMaxParallel))
+	fs.StringVar(&cfg.output, "output", getEnvironmentOrDefault("OUTPUT", OutputText), "Output format of commands printing a result, csv for history only. [text, json, csv]")
+	fs.BoolVar(&cfg.storeSQL, "store-sql", getEnvironmentOrDefault("STORE_SQL", false), "Store the executed SQL of every applied migration in the migration table (default: false)")
+	fs.BoolVar(&cfg.storeSQLCompressed, "store-sql-compressed", getEnvironmentOrDefault("STORE_SQL_COMPRESSED", false), "Store the executed SQL compressed with gzip and encoded with base64, implies --store-sql (default: false)")
+	fs.StringVar(&cfg.logFormat, "log-format", getEnvironmentOrDefault("LOG_FORMAT", LogFormatAuto), "Log format, auto logs JSON in Kubernetes and console output otherwise. [auto, json, console]")
+	fs.StringVar(&cfg.logLevel, "log-level", getEnvironmentOrDefault("LOG_LEVEL", ""), "Minimum level of logged messages, debug for console and info for JSON logs by default. [debug, info, warn, error]")
+	fs.BoolVar(&cfg.quiet, "quiet", getEnvironmentOrDefault("QUIET", false), "Log warnings and errors only, an explicit --log-level takes precedence (default: false)")
+	fs.BoolVar(&cfg.failOnEmpty, "fail-on-empty", getEnvironmentOrDefault("FAIL_ON_EMPTY", false), "Fail when no SQL files are found instead of only warning, e.g. when a wrong directory is mounted (default: false)")
+	fs.BoolVar(&cfg.allowMissing, "allow-missing", getEnvironmentOrDefault("ALLOW_MISSING", false), "Only warn about applied migrations whose files are missing on disk (default: false)")
+	cfg.searchPath = newStringList(getEnvironmentOrDefault("SEARCH_PATH", ""))
+	fs.Var(&cfg.searchPath, "search-path", "Schemas the search_path is set to for every migration, can be repeated or comma separated")
+	fs.BoolVar(&cfg.uniqueBasenames, "unique-basenames", getEnvironmentOrDefault("UNIQUE_BASENAMES", false), "Fail when SQL files in different directories share the same base name (default: false)")
+	fs.BoolVar(&cfg.logNotices, "log-notices", getEnvironmentOrDefault("LOG_NOTICES", true), "Log the notices of the server, e.g. RAISE NOTICE, tagged with the migration file (default: true)")
+	fs.BoolVar(&cfg.printSQL, "print-sql", getEnvironmentOrDefault("PRINT_SQL", false), "Log every statement at debug level before it is executed, long statements are truncated (default: false)")
+	fs.StringVar(&cfg.encoding, "encoding", getEnvironmentOrDefault("ENCODING", EncodingUTF8), "Encoding of migration files without a byte order mark. [utf-8, utf-16le, utf-16be]")
+	fs.BoolVar(&cfg.continueOnError, "continue-on-error", getEnvironmentOrDefault("CONTINUE_ON_ERROR", false), "Record a failed migration of a best-effort directory and continue with the next one, the run still fails at the end (default: false)")
+	fs.StringVar(&cfg.beforeEach, "before-each", getEnvironmentOrDefault("BEFORE_EACH", ""), "SQL executed before every migration in its transaction, @path reads it from a file")
+	fs.StringVar(&cfg.afterEach, "after-each", getEnvironmentOrDefault("AFTER_EACH", ""), "SQL executed after every migration in its transaction, @path reads it from a file")
+	fs.BoolVar(&cfg.writeStatus, "write-status", getEnvironmentOrDefault("WRITE_STATUS", false), "Write running, done or failed to the clbs_dbtool_status table for the app to poll (default: false)")
+	fs.BoolVar(&cfg.createDatabase, "create-database", getEnvironmentOrDefault("CREATE_DATABASE", false), "Create the target database through the postgres database when it does not exist, requires the CREATEDB privilege (default: false)")
+	fs.BoolVar(&cfg.checksumOnly, "checksum-only-validation", getEnvironmentOrDefault("CHECKSUM_ONLY_VALIDATION", false), "Match applied migrations missing at their path to files with the same checksum and update the stored path (default: false)")
+	fs.StringVar(&cfg.notBefore, "not-before", getEnvironmentOrDefault("NOT_BEFORE", ""), "Time of day as HH:MM on the database clock before which migrations are not applied, with --not-after the maintenance window")
+	fs.StringVar(&cfg.notAfter, "not-after", getEnvironmentOrDefault("NOT_AFTER", ""), "Time of day as HH:MM on the database clock from which migrations are not applied, with --not-before the maintenance window")
+	fs.BoolVar(&cfg.force, "force", getEnvironmentOrDefault("FORCE", false), "Apply migrations outside the maintenance window (default: false)")
+	fs.StringVar(&cfg.minServerVersion, "min-server-version", getEnvironmentOrDefault("MIN_SERVER_VERSION", ""), "Minimum PostgreSQL server version, e.g. 15, 15.2 or 150002, checked right after connecting")
+	fs.BoolVar(&cfg.trackSchemaHash, "track-schema-hash", getEnvironmentOrDefault("TRACK_SCHEMA_HASH", false), "Record a hash of the schema after the run and warn when the next run finds it changed out-of-band (default: false)")
+	fs.BoolVar(&cfg.printConfig, "print-config", getEnvironmentOrDefault("PRINT_CONFIG", false), "Log the effective configuration with the passwords masked before running the command (default: false)")
+	fs.BoolVar(&cfg.useSnapshots, "use-snapshots", getEnvironmentOrDefault("USE_SNAPSHOTS", true), "Start the first run from the last directory marked with a .snapshot file, false replays all migrations (default: true)")
+	fs.StringVar(&cfg.metricsTextfile, "metrics-textfile", getEnvironmentOrDefault("METRICS_TEXTFILE", ""), "Path of an OpenMetrics text file written at the end of the run (e.g. for node_exporter textfile collector)")
+	fs.StringVar(&cfg.schemaSnapshot, "schema-snapshot", getEnvironmentOrDefault("SCHEMA_SNAPSHOT", ""), "drift: path of the expected schema snapshot file")
+	fs.StringVar(&cfg.sinceFlag, "since", getEnvironmentOrDefault("SINCE", ""), "history: list the migrations applied at or after the RFC 3339 time or the duration ago, e.g. 24h")
+	fs.StringVar(&cfg.untilFlag, "until", getEnvironmentOrDefault("UNTIL", ""), "history: list the migrations applied before the RFC 3339 time or the duration ago, e.g. 1h")
+	fs.BoolVar(&cfg.updateSchemaSnapshot, "update-schema-snapshot", getEnvironmentOrDefault("UPDATE_SCHEMA_SNAPSHOT", false), "drift: write the live schema to the snapshot file instead of comparing (default: false)")
+	fs.BoolVar(&cfg.failOnDrift, "fail-on-drift", getEnvironmentOrDefault("FAIL_ON_DRIFT", false), "drift: fail when the live schema differs from the snapshot, otherwise the differences are only logged (default: false)")
+	fs.StringVar(&cfg.lockStrategy, "lock-strategy", getEnvironmentOrDefault("LOCK_STRATEGY", LockStrategyAdvisory), "How concurrent runs of the app are serialized, table works behind transaction-pooling poolers. [advisory, table]")
+	fs.DurationVar(&cfg.lockTTL, "lock-ttl", getEnvironmentOrDefault("LOCK_TTL", defaultLockTTL), fmt.Sprintf("Age after which a lock table row of a crashed run is taken over (default: %s)", defaultLockTTL))
+	fs.BoolVar(&cfg.schemaPerApp, "schema-per-app", getEnvironmentOrDefault("SCHEMA_PER_APP", false), "Create the schema named after the app id, set the search_path to it and keep the dbtool tables in it (default: false)")
+	fs.StringVar(&cfg.seedDir, "seed-dir", getEnvironmentOrDefault("SEED_DIR", ""), "Directory of idempotent .sql seed scripts executed in sorted order after the migrations on every run, they are not recorded")
+	fs.StringVar(&cfg.jsonPlanFile, "json-plan-file", getEnvironmentOrDefault("JSON_PLAN_FILE", ""), "Path of a JSON file listing every discovered migration with its status, written at the end of the migrate run")
+	fs.StringVar(&cfg.pushgatewayURL, "pushgateway-url", getEnvironmentOrDefault("PUSHGATEWAY_URL", ""), "URL of a Prometheus Pushgateway the metrics are pushed to at the end of the run")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if cfg.command == CommandApplyFile && cfg.applyFile == "" {
+		cfg.applyFile = fs.Arg(0)
+	}
+	if cfg.applyFile != "" {
+		cfg.applyFile = path.Clean(filepath.ToSlash(cfg.applyFile))
+	}
+
+	if len(cfg.dirs.values) > 0 {
+		cfg.dir = cfg.dirs.values[0]
+	}
+
+	if cfg.filesFrom != "" {
+		var err error
+		if cfg.fileList, err = readFilesFrom(cfg.filesFrom, os.Stdin); err != nil {
 			return nil, err
 		}
-		cfg.connectionString = tmp
 	}
+
+	targets := connectionStrings.values
 	if cfg.connectionStringFile != "" {
 		data, err := os.ReadFile(cfg.connectionStringFile)
 		if err != nil {
 			return nil, fmt.Errorf("%w: %s", ErrConnectionStringFileReadError, err)
 		}
-		cfg.connectionString = strings.TrimSpace(string(data))
+		targets = readConnectionStrings(string(data))
+	}
+	if len(targets) == 0 {
+		targets = []string{""}
+	}
+
+	for i, connectionString := range targets {
+		// The connection string read from the file is in the configured format as well
+		connectionString, err := NormalizeConnectionString(connectionString, cfg.connectionStringFormat)
+		if err != nil {
+			return nil, err
+		}
+		if connectionString == "" {
+			connectionString = params.connectionString()
+		}
+		if targets[i], err = cfg.ssl.apply(connectionString); err != nil {
+			return nil, err
+		}
+	}
+	cfg.connectionString = targets[0]
+	if len(targets) > 1 {
+		cfg.targets = targets
+	}
+
+	if err := cfg.resolveTrackingConnection(); err != nil {
+		return nil, err
+	}
+
+	for _, hook := range []*string{&cfg.beforeEach, &cfg.afterEach} {
+		var err error
+		if *hook, err = readHook(*hook); err != nil {
+			return nil, err
+		}
 	}
 
 	return cfg, nil
 }
 
+// readFilesFrom returns the cleaned paths listed in the file, - reads stdin,
+// blank lines and lines starting with # are ignored
+func readFilesFrom(name string, stdin io.Reader) ([]string, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrFilesFromReadError, err)
+	}
+
+	var paths []string
+	for line := range strings.Lines(string(data)) {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			paths = append(paths, path.Clean(filepath.ToSlash(line)))
+		}
+	}
+	return paths, nil
+}
+
+// readHook returns the hook SQL, a value starting with @ is the path of the file holding it
+func readHook(value string) (string, error) {
+	name, ok := strings.CutPrefix(value, "@")
+	if !ok {
+		return value, nil
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrHookFileReadError, err)
+	}
+	return string(data), nil
+}
+
+// sslSettings are the TLS settings merged into the connection string, so pgx builds the TLS config from them
+type sslSettings struct {
+	mode     string
+	cert     string
+	key      string
+	rootCert string
+}
+
+// apply sets the non-empty settings in the connection string, replacing the ones it holds,
+// an empty connection string is left empty
+func (s sslSettings) apply(connectionString string) (string, error) {
+	settings := [][2]string{{"sslmode", s.mode}, {"sslcert", s.cert}, {"sslkey", s.key}, {"sslrootcert", s.rootCert}}
+	if connectionString == "" {
+		return "", nil
+	}
+
+	if strings.HasPrefix(connectionString, "postgres://") || strings.HasPrefix(connectionString, "postgresql://") {
+		u, err := url.Parse(connectionString)
+		if err != nil {
+			return "", ErrInvalidConnectionString
+		}
+		query := u.Query()
+		for _, kv := range settings {
+			if kv[1] != "" {
+				query.Set(kv[0], kv[1])
+			}
+		}
+		u.RawQuery = query.Encode()
+		return u.String(), nil
+	}
+
+	// In the key-value format the last occurrence of a key wins
+	for _, kv := range settings {
+		if kv[1] != "" {
+			connectionString += " " + kv[0] + "=" + quoteKeyValue(kv[1])
+		}
+	}
+	return connectionString, nil
+}
+
+// files returns the certificate and key files that are set
+func (s sslSettings) files() []string {
+	var files []string
+	for _, f := range []string{s.cert, s.key, s.rootCert} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// connectionParams are the discrete connection settings assembled into a connection string
+type connectionParams struct {
+	host    string
+	port    string
+	user    string
+	dbname  string
+	sslmode string
+}
+
+// connectionString returns the settings in the key-value format, empty when none is set
+func (p connectionParams) connectionString() string {
+	var parts []string
+	for _, kv := range [][2]string{{"host", p.host}, {"port", p.port}, {"user", p.user}, {"dbname", p.dbname}, {"sslmode", p.sslmode}} {
+		if kv[1] != "" {
+			parts = append(parts, kv[0]+"="+quoteKeyValue(kv[1]))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// NormalizeConnectionString converts the connection string in the given format to a form accepted by pgx
+func NormalizeConnectionString(connectionString string, format string) (string, error) {
+	if strings.EqualFold(format, "ado") {
+		return connectionStringFromADO(connectionString)
+	}
+	return connectionString, nil
+}
+
+// connectionStringFromADO converts the ADO.NET connection string to the pgx key-value format.
+// Values can be quoted with single or double quotes, a doubled quote character inside is an escaped quote
+// and semicolons inside quotes are part of the value.
 func connectionStringFromADO(connectionString string) (string, error) {
 	var sb strings.Builder
-	for entry := range strings.SplitSeq(connectionString, ";") {
-		// Skip empty entries (in case of trailing or multiple semicolons)
-		if len(strings.TrimSpace(entry)) == 0 {
+	rest := connectionString
+	for entry := 1; len(rest) > 0; entry++ {
+		entryEnd := strings.IndexAny(rest, "=;")
+		if entryEnd == -1 {
+			if len(strings.TrimSpace(rest)) > 0 {
+				return "", fmt.Errorf("%w: entry %d is not a key=value pair", ErrInvalidADOConnectionString, entry)
+			}
+			break
+		}
+
+		// Skip empty entries (in case of trailing or multiple semicolons), other entries need a key-value pair
+		if rest[entryEnd] == ';' {
+			if len(strings.TrimSpace(rest[:entryEnd])) > 0 {
+				return "", fmt.Errorf("%w: entry %d is not a key=value pair", ErrInvalidADOConnectionString, entry)
+			}
+			rest = rest[entryEnd+1:]
 			continue
 		}
 
-		// Split key-value pairs
-		parts := strings.SplitN(entry, "=", 2)
-		if len(parts) != 2 {
-			return "", ErrInvalidADOConnectionString
+		key := strings.ToLower(strings.TrimSpace(rest[:entryEnd]))
+		if key == "" {
+			return "", fmt.Errorf("%w: entry %d has an empty key", ErrInvalidADOConnectionString, entry)
 		}
 
-		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		// The value is left out of the error as it may be a password
+		value, quoted, remainder, err := parseADOValue(rest[entryEnd+1:])
+		if err != nil {
+			return "", fmt.Errorf("%w: entry %d (%s): %w", ErrInvalidADOConnectionString, entry, key, err)
+		}
+		rest = remainder
 
 		switch key {
 		case "database":
@@ -172,19 +1221,9 @@ func connectionStringFromADO(connectionString string) (string, error) {
 			key = strings.ReplaceAll(key, " ", "")
 		}
 
-		value := strings.TrimSpace(parts[1])
-
-		// ADO.NET supports string values quoted either in single or double quotes. Go pgx does not support double-quoted values.
-		if strings.HasPrefix(value, "\"") && strings.HasSuffix(value, "\"") {
-			if len(value) == 2 {
-				value = "''"
-			} else {
-				// Unescape inner double quotes, escape single quotes and add single quotes around the value
-				inner := value[1 : len(value)-1]
-				inner = strings.ReplaceAll(inner, "\\\"", "\"")
-				inner = strings.ReplaceAll(inner, "'", "\\'")
-				value = fmt.Sprintf("'%s'", inner)
-			}
+		// Quoted values stay quoted, other values only when pgx would not read them back unchanged
+		if quoted || value == "" || strings.ContainsAny(value, " \t'\\") {
+			value = quoteKeyValue(value)
 		}
 
 		sb.WriteString(key)
@@ -196,49 +1235,509 @@ func connectionStringFromADO(connectionString string) (string, error) {
 	return strings.TrimSpace(sb.String()), nil
 }
 
+// parseADOValue parses the value at the start of s up to the semicolon separating the next entry,
+// it returns the unquoted value, whether it was quoted and the rest after the separator
+func parseADOValue(s string) (string, bool, string, error) {
+	trimmed := strings.TrimLeft(s, " \t")
+	if trimmed == "" || (trimmed[0] != '"' && trimmed[0] != '\'') {
+		value, rest, _ := strings.Cut(s, ";")
+		return strings.TrimSpace(value), false, rest, nil
+	}
+
+	quote := trimmed[0]
+	var sb strings.Builder
+	for i := 1; i < len(trimmed); i++ {
+		c := trimmed[i]
+		switch {
+		case c == quote && i+1 < len(trimmed) && trimmed[i+1] == quote:
+			// ADO.NET escapes a quote by doubling it
+			sb.WriteByte(c)
+			i++
+		case c == '\\' && quote == '"' && i+1 < len(trimmed) && trimmed[i+1] == '"':
+			// Backslash escaped double quotes were accepted by earlier versions
+			sb.WriteByte('"')
+			i++
+		case c == quote:
+			after := strings.TrimLeft(trimmed[i+1:], " \t")
+			if after != "" && after[0] != ';' {
+				return "", false, "", errors.New("unexpected characters after quoted value")
+			}
+			return sb.String(), true, strings.TrimPrefix(after, ";"), nil
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	return "", false, "", errors.New("unterminated quoted value")
+}
+
+// quoteKeyValue quotes the value for the pgx key-value connection string format
+func quoteKeyValue(value string) string {
+	value = strings.ReplaceAll(value, "\\", "\\\\")
+	value = strings.ReplaceAll(value, "'", "\\'")
+	return "'" + value + "'"
+}
+
 var (
-	ErrInvalidMigrationsDirectory = errors.New("invalid migrations directory path")
-	ErrInvalidConnectionString    = errors.New("connection string is invalid")
-	ErrInvalidSteps               = errors.New("invalid steps: must be positive integer")
-	ErrInvalidAppId               = errors.New("app-id is required")
-	ErrInvalidConnectionTimeout   = errors.New("connection timeout must be a positive integer")
+	ErrUnknownCommand              = errors.New("unknown command")
+	ErrInvalidMigrationsDirectory  = errors.New("invalid migrations directory path")
+	ErrInvalidConnectionString     = errors.New("connection string is invalid")
+	ErrInvalidTrackingConnection   = errors.New("tracking connection string is invalid")
+	ErrInvalidSteps                = errors.New("invalid steps: must be -1 or a non-negative integer")
+	ErrInvalidAppId                = errors.New("app-id is required")
+	ErrAppIdTooLong                = fmt.Errorf("app-id is too long: must be at most %d characters", MaxAppIdLength)
+	ErrAppliedByTooLong            = fmt.Errorf("applied-by is too long: must be at most %d characters", MaxAppliedByLength)
+	ErrInvalidConnectionTimeout    = errors.New("connection timeout must be a positive integer")
+	ErrInvalidMaxDepth             = errors.New("invalid max depth: must be -1 or a non-negative integer")
+	ErrInvalidHashAlgorithm        = errors.New("invalid hash algorithm: must be one of sha256, sha512, blake2b")
+	ErrInvalidHashMode             = errors.New("invalid hash mode: must be one of exact, normalized")
+	ErrInvalidSort                 = errors.New("invalid sort: must be one of lexical, natural")
+	ErrInvalidPattern              = errors.New("invalid include or exclude glob pattern")
+	ErrInvalidFilenamePattern      = errors.New("invalid file name pattern")
+	ErrInvalidConnectRetries       = errors.New("connect retries must not be negative")
+	ErrInvalidConnectRetryInterval = errors.New("connect retry interval must be positive")
+	ErrInvalidMaxReconnects        = errors.New("max reconnects must not be negative")
+	ErrInvalidRetryOnConflict      = errors.New("retry on conflict must not be negative")
+	ErrInvalidInterMigrationDelay  = errors.New("inter-migration delay must not be negative")
+	ErrInvalidMaintenanceWindow    = errors.New("invalid maintenance window: --not-before and --not-after must be different times of day as HH:MM or HH:MM:SS")
+	ErrInvalidFilesFrom            = errors.New("files-from must list paths relative to the migrations directory")
+	ErrInvalidMissingSidecar       = errors.New("invalid missing sidecar policy: must be one of error, warn, ignore")
+	ErrInvalidMaxParallel          = errors.New("max parallel must not be negative")
+	ErrInvalidOutput               = errors.New("invalid output format: must be one of text, json, or csv for history")
+	ErrInvalidLogFormat            = errors.New("invalid log format: must be one of auto, json, console")
+	ErrInvalidLogLevel             = errors.New("invalid log level: must be one of debug, info, warn, error")
+	ErrInvalidVar                  = errors.New("invalid variable: must be key=value with a key of letters, digits and underscores")
+	ErrInvalidMetadata             = errors.New("invalid metadata: must be key=value with a non-empty key")
+
+	ErrInvalidSearchPath       = errors.New("invalid search path: schemas must be identifiers or double-quoted identifiers")
+	ErrInvalidEncoding         = errors.New("invalid encoding: must be one of utf-8, utf-16le, utf-16be")
+	ErrMissingApplyFile        = errors.New("apply-file requires the relative path of a migration file")
+	ErrInvalidSSLFile          = errors.New("invalid SSL certificate or key file")
+	ErrInvalidPushgatewayURL   = errors.New("invalid pushgateway url: must be an http or https url")
+	ErrInvalidMinServerVersion = errors.New("invalid minimum server version: must be e.g. 15, 15.2 or 150002")
+	ErrMissingSchemaSnapshot   = errors.New("drift requires --schema-snapshot")
+	ErrInvalidSince            = errors.New("invalid since: must be an RFC 3339 time or a positive duration such as 24h")
+	ErrInvalidUntil            = errors.New("invalid until: must be an RFC 3339 time or a positive duration such as 24h")
+	ErrInvalidTimeRange        = errors.New("invalid time range: since must be before until")
+	ErrInvalidLockStrategy     = errors.New("invalid lock strategy: must be one of advisory, table")
+	ErrInvalidLockTTL          = errors.New("lock ttl must be positive")
+	ErrInvalidPingTimeout      = errors.New("ping timeout must be positive")
+	ErrInvalidStatementTimeout = errors.New("statement timeout must be 0 or at least 1ms")
+	ErrInvalidSeedDirectory    = errors.New("invalid seed directory path")
+	ErrInvalidAppSchema        = errors.New("app id cannot be used as schema name: must be at most 63 bytes of text, must not start with pg_ or contain $")
+
+	reVarKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// reSchema matches an unquoted identifier or a double-quoted one, e.g. "$user"
+	reSchema = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_$]*|"([^"]|"")+")$`)
 )
 
-func (cfg *Config) validate() error {
-	if cfg.dir == "" {
-		return ErrInvalidMigrationsDirectory
+// parseMaintenanceWindow sets the times of day of the window, a missing start is midnight, a missing end the end
+// of the day
+func (cfg *Config) parseMaintenanceWindow() error {
+	cfg.notBeforeTime, cfg.notAfterTime = 0, 24*time.Hour
+	var err error
+	if cfg.notBefore != "" {
+		if cfg.notBeforeTime, err = parseTimeOfDay(cfg.notBefore); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidMaintenanceWindow, cfg.notBefore)
+		}
+	}
+	if cfg.notAfter != "" {
+		if cfg.notAfterTime, err = parseTimeOfDay(cfg.notAfter); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidMaintenanceWindow, cfg.notAfter)
+		}
+	}
+	if cfg.notBeforeTime == cfg.notAfterTime {
+		return fmt.Errorf("%w: the window is empty", ErrInvalidMaintenanceWindow)
 	}
+	return nil
+}
 
-	fileInfo, err := os.Stat(cfg.dir)
+// parseTimeOfDay returns the time since midnight of an HH:MM or HH:MM:SS value
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
 	if err != nil {
-		return ErrInvalidMigrationsDirectory
+		if t, err = time.Parse("15:04:05", value); err != nil {
+			return 0, err
+		}
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second, nil
+}
+
+// offline reports whether the command only reads the migrations without connecting to a database
+func (cfg *Config) offline() bool {
+	return cfg.command == CommandLint || cfg.command == CommandPlanHash
+}
+
+func (cfg *Config) validate() error {
+	switch cfg.LogFormat() {
+	case LogFormatAuto, LogFormatJSON, LogFormatConsole:
+	default:
+		return ErrInvalidLogFormat
+	}
+
+	switch cfg.logLevel {
+	case "", "debug", "info", "warn", "error":
+	default:
+		return ErrInvalidLogLevel
+	}
+
+	// The self-test does not use any other configuration
+	if cfg.selfTest {
+		return nil
+	}
+
+	switch cfg.command {
+	case "", CommandMigrate, CommandVerify, CommandBaseline, CommandRepair, CommandVersionDB, CommandCheck, CommandApplyFile, CommandDrift, CommandHistory, CommandLint, CommandPlanHash:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownCommand, cfg.command)
+	}
+
+	if cfg.command == CommandApplyFile && cfg.applyFile == "" {
+		return ErrMissingApplyFile
+	}
+
+	if cfg.command == CommandDrift && cfg.schemaSnapshot == "" {
+		return ErrMissingSchemaSnapshot
+	}
+
+	now := time.Now()
+	var err error
+	if cfg.since, err = parseTimeFlag(cfg.sinceFlag, now); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSince, cfg.sinceFlag)
+	}
+	if cfg.until, err = parseTimeFlag(cfg.untilFlag, now); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidUntil, cfg.untilFlag)
 	}
+	if !cfg.since.IsZero() && !cfg.until.IsZero() && !cfg.since.Before(cfg.until) {
+		return ErrInvalidTimeRange
+	}
+
+	// Reading the database only does not need the migrations
+	if !cfg.withoutDir && cfg.command != CommandVersionDB && cfg.command != CommandCheck && cfg.command != CommandDrift && cfg.command != CommandHistory {
+		if cfg.dir == "" {
+			return ErrInvalidMigrationsDirectory
+		}
+
+		for _, dir := range cfg.Dirs() {
+			if archive, inner, ok := SplitZipPath(dir); ok {
+				if err := checkZipDir(archive, inner); err != nil {
+					return fmt.Errorf("%w: %s", ErrInvalidMigrationsDirectory, err)
+				}
+				continue
+			}
+
+			fileInfo, err := os.Stat(dir)
+			if err != nil {
+				return ErrInvalidMigrationsDirectory
+			}
+
+			if !fileInfo.IsDir() {
+				return ErrInvalidMigrationsDirectory
+			}
+		}
 
-	if !fileInfo.IsDir() {
-		return ErrInvalidMigrationsDirectory
+		if len(slices.Compact(slices.Sorted(slices.Values(cfg.Dirs())))) != len(cfg.Dirs()) {
+			return fmt.Errorf("%w: directory given more than once", ErrInvalidMigrationsDirectory)
+		}
 	}
 
-	if cfg.connectionString == "" {
+	// The offline commands read the migrations only, they need neither a database nor an app
+	if cfg.connectionString == "" && !cfg.offline() {
 		return ErrInvalidConnectionString
 	}
 
+	if cfg.pushgatewayURL != "" {
+		u, err := url.Parse(cfg.pushgatewayURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ErrInvalidPushgatewayURL
+		}
+	}
+
+	if cfg.seedDir != "" {
+		if fileInfo, err := os.Stat(cfg.seedDir); err != nil || !fileInfo.IsDir() {
+			return ErrInvalidSeedDirectory
+		}
+	}
+
+	for _, f := range cfg.ssl.files() {
+		if _, err := os.Stat(f); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidSSLFile, err)
+		}
+	}
+
 	// Validate connection string by parsing it using pgxpool that has more options
-	_, err = pgxpool.ParseConfig(cfg.connectionString)
-	if err != nil {
-		return ErrInvalidConnectionString
+	for _, connectionString := range cfg.Targets() {
+		if _, err := pgxpool.ParseConfig(connectionString); err != nil {
+			return ErrInvalidConnectionString
+		}
+	}
+	if len(cfg.targets) > 1 && cfg.command != CommandMigrate {
+		return fmt.Errorf("%w: several databases are supported by migrate only", ErrInvalidConnectionString)
 	}
 
-	if cfg.steps <= 0 && cfg.steps != defaultSteps {
-		return ErrInvalidSteps
+	if cfg.trackingConnection != "" {
+		if _, err := pgxpool.ParseConfig(cfg.trackingConnection); err != nil {
+			return ErrInvalidTrackingConnection
+		}
+		// The rows of the app in a shared tracking database would mix, the hash is of the migrated schema
+		if len(cfg.targets) > 1 {
+			return fmt.Errorf("%w: cannot be combined with several databases", ErrInvalidTrackingConnection)
+		}
+		if cfg.trackSchemaHash {
+			return fmt.Errorf("%w: cannot be combined with --track-schema-hash", ErrInvalidTrackingConnection)
+		}
 	}
 
-	if cfg.appId == "" {
+	// Negative steps are reserved for rolling back, which needs down migrations dbtool does not have
+	if cfg.steps < defaultSteps {
+		return fmt.Errorf("%w: rolling back with negative steps is not supported as there are no down migrations", ErrInvalidSteps)
+	}
+
+	if cfg.appId == "" && !cfg.offline() {
 		return ErrInvalidAppId
 	}
+	if utf8.RuneCountInString(cfg.appId) > MaxAppIdLength {
+		return ErrAppIdTooLong
+	}
+	if utf8.RuneCountInString(cfg.appliedBy) > MaxAppliedByLength {
+		return ErrAppliedByTooLong
+	}
+
+	if cfg.schemaPerApp {
+		if err := checkAppSchema(cfg.appId); err != nil {
+			return err
+		}
+	}
 
 	if cfg.connectionTimeout <= 0 {
 		return ErrInvalidConnectionTimeout
 	}
 
+	if cfg.connectRetries < 0 {
+		return ErrInvalidConnectRetries
+	}
+
+	if cfg.connectRetries > 0 && cfg.connectRetryInterval <= 0 {
+		return ErrInvalidConnectRetryInterval
+	}
+
+	if cfg.maxReconnects < 0 {
+		return ErrInvalidMaxReconnects
+	}
+
+	if cfg.retryOnConflict < 0 {
+		return ErrInvalidRetryOnConflict
+	}
+
+	if cfg.interMigrationDelay < 0 {
+		return ErrInvalidInterMigrationDelay
+	}
+	// The lag is only logged while waiting between the migrations
+	if cfg.checkReplicationLag && cfg.interMigrationDelay == 0 {
+		return fmt.Errorf("%w: --check-replication-lag requires a delay", ErrInvalidInterMigrationDelay)
+	}
+
+	for _, p := range cfg.fileList {
+		if !fs.ValidPath(p) || p == "." {
+			return fmt.Errorf("%w: %s", ErrInvalidFilesFrom, p)
+		}
+	}
+
+	if cfg.pingTimeout < 0 {
+		return ErrInvalidPingTimeout
+	}
+
+	// statement_timeout is set in milliseconds, a shorter one would disable it
+	if cfg.statementTimeout < 0 || (cfg.statementTimeout > 0 && cfg.statementTimeout < time.Millisecond) {
+		return ErrInvalidStatementTimeout
+	}
+
+	if cfg.maxParallel < 0 {
+		return ErrInvalidMaxParallel
+	}
+
+	if cfg.maxDepth < defaultMaxDepth {
+		return ErrInvalidMaxDepth
+	}
+
+	for _, pattern := range slices.Concat(cfg.include.values, cfg.exclude.values) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidPattern, pattern)
+		}
+	}
+
+	if cfg.filenamePattern != "" {
+		re, err := regexp.Compile(cfg.filenamePattern)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidFilenamePattern, err)
+		}
+		cfg.filenameRegexp = re
+	}
+
+	if cfg.minServerVersion != "" {
+		num, err := parseServerVersion(cfg.minServerVersion)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidMinServerVersion, cfg.minServerVersion)
+		}
+		cfg.minServerVersionNum = num
+	}
+
+	if err := cfg.parseMaintenanceWindow(); err != nil {
+		return err
+	}
+
+	if len(cfg.varList.values) > 0 {
+		cfg.vars = make(map[string]string, len(cfg.varList.values))
+		for _, v := range cfg.varList.values {
+			key, value, ok := strings.Cut(v, "=")
+			if !ok || !reVarKey.MatchString(key) {
+				return fmt.Errorf("%w: %s", ErrInvalidVar, v)
+			}
+			cfg.vars[key] = value
+		}
+	}
+
+	if len(cfg.metadataList.values) > 0 {
+		cfg.metadata = make(map[string]string, len(cfg.metadataList.values))
+		for _, v := range cfg.metadataList.values {
+			key, value, ok := strings.Cut(v, "=")
+			if !ok || key == "" {
+				return fmt.Errorf("%w: %s", ErrInvalidMetadata, v)
+			}
+			cfg.metadata[key] = value
+		}
+	}
+
+	for _, schema := range cfg.searchPath.values {
+		if !reSchema.MatchString(schema) {
+			return fmt.Errorf("%w: %s", ErrInvalidSearchPath, schema)
+		}
+	}
+
+	switch cfg.Output() {
+	case OutputText, OutputJSON:
+	case OutputCSV:
+		if cfg.command != CommandHistory {
+			return ErrInvalidOutput
+		}
+	default:
+		return ErrInvalidOutput
+	}
+
+	switch cfg.HashAlgorithm() {
+	case HashSHA256, HashSHA512, HashBLAKE2b:
+	default:
+		return ErrInvalidHashAlgorithm
+	}
+
+	switch cfg.HashMode() {
+	case HashModeExact, HashModeNormalized:
+	default:
+		return ErrInvalidHashMode
+	}
+	// The files are normalized as bytes, which only works for an encoding compatible with ASCII
+	if cfg.HashMode() == HashModeNormalized && cfg.Encoding() != EncodingUTF8 {
+		return fmt.Errorf("%w: normalized checksums require the utf-8 encoding", ErrInvalidHashMode)
+	}
+
+	switch cfg.Sort() {
+	case SortLexical, SortNatural:
+	default:
+		return ErrInvalidSort
+	}
+
+	switch cfg.Encoding() {
+	case EncodingUTF8, EncodingUTF16LE, EncodingUTF16BE:
+	default:
+		return ErrInvalidEncoding
+	}
+
+	switch cfg.LockStrategy() {
+	case LockStrategyAdvisory, LockStrategyTable:
+	default:
+		return ErrInvalidLockStrategy
+	}
+
+	if cfg.lockTTL < 0 {
+		return ErrInvalidLockTTL
+	}
+
+	if cfg.verifySidecarChecksums {
+		switch cfg.missingSidecarPolicy {
+		case MissingSidecarError, MissingSidecarWarn, MissingSidecarIgnore:
+		default:
+			return ErrInvalidMissingSidecar
+		}
+	}
+
+	return nil
+}
+
+// parseServerVersion converts a PostgreSQL version to the form of server_version_num, e.g. 15.2 to 150002
+// and 9.6.3 to 90603, a number of five or more digits is taken as server_version_num already
+func parseServerVersion(version string) (int, error) {
+	parts := strings.Split(version, ".")
+	nums := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid version part %q", part)
+		}
+		nums[i] = n
+	}
+
+	major := nums[0]
+	switch {
+	case len(nums) == 1 && major >= 10000:
+		return major, nil
+	case major >= 10 && len(nums) <= 2:
+		// Since PostgreSQL 10 the second number is the minor version
+		num := major * 10000
+		if len(nums) == 2 {
+			num += nums[1]
+		}
+		return num, nil
+	case major > 0 && major < 10 && len(nums) <= 3:
+		num := major * 10000
+		if len(nums) > 1 {
+			num += nums[1] * 100
+		}
+		if len(nums) > 2 {
+			num += nums[2]
+		}
+		return num, nil
+	default:
+		return 0, fmt.Errorf("invalid version %q", version)
+	}
+}
+
+// maxIdentifierLength is the longest identifier PostgreSQL keeps, longer ones are truncated
+const maxIdentifierLength = 63
+
+// checkAppSchema fails unless the app id is a valid schema name, it is always quoted so most other characters are
+// allowed, $ is not as the name is embedded in dollar-quoted upgrade statements
+func checkAppSchema(appId string) error {
+	if len(appId) > maxIdentifierLength || !utf8.ValidString(appId) || strings.ContainsAny(appId, "\x00$") ||
+		strings.HasPrefix(appId, "pg_") {
+		return ErrInvalidAppSchema
+	}
 	return nil
 }
+
+// parseTimeFlag parses an RFC 3339 time or a duration before now, empty is the zero time
+func parseTimeFlag(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if d <= 0 {
+		return time.Time{}, fmt.Errorf("duration %s is not positive", value)
+	}
+	return now.Add(-d), nil
+}
@@ -0,0 +1,219 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package config
+
+import (
+	"time"
+)
+
+// Options holds the settings of a Config created programmatically by New,
+// zero values select the same defaults as the command line flags
+type Options struct {
+	Version   string
+	GitCommit string
+	AppId     string
+	// AppliedBy defaults to the OS user or PGUSER
+	AppliedBy string
+
+	// Dir is the migrations directory, it is not required with WithoutDir
+	Dir string
+	// WithoutDir is set when the migrations are not read from Dir, e.g. from an embed.FS
+	WithoutDir bool
+
+	ConnectionString       string
+	ConnectionStringFormat string
+	ApplicationName        string
+	// SSLMode, SSLCert, SSLKey and SSLRootCert override the ones of the connection string
+	SSLMode     string
+	SSLCert     string
+	SSLKey      string
+	SSLRootCert string
+	// TrackingConnection is a separate database holding the dbtool tables, empty for the migrated one
+	TrackingConnection string
+	// ConnectionTimeout defaults to 45 seconds
+	ConnectionTimeout time.Duration
+	// Steps is the number of migrations to apply, zero applies all of them
+	Steps              int
+	Target             string
+	From               string
+	SkipFileValidation bool
+	ReapplyChanged     bool
+	HashAlgorithm      string
+	HashMode           string
+	Sort               string
+	SplitStatements    bool
+	ResolveIncludes    bool
+	StripMetaCommands  bool
+	PrintSQL           bool
+	IgnoreNotices      bool // does not log the notices of the server
+	Encoding           string
+	Include            []string
+	Exclude            []string
+	FilenamePattern    string
+	AllowOutOfOrder    bool
+	AllowMissing       bool
+	FailOnEmpty        bool
+	SearchPath         []string
+	UniqueBasenames    bool
+	ContinueOnError    bool
+	WriteStatus        bool
+	CreateDatabase     bool
+	// ChecksumOnlyValidation matches moved files by checksum and updates the stored paths
+	ChecksumOnlyValidation bool
+	// Tags select the tagged migrations to apply, untagged ones are applied always
+	Tags []string
+	// SeedDir is the directory of the seed scripts executed after the migrations on every run
+	SeedDir string
+	// SchemaPerApp keeps the migrations and the dbtool tables in the schema named after the app id
+	SchemaPerApp bool
+	// TrackSchemaHash records the schema hash after the run and warns about out-of-band changes on the next one
+	TrackSchemaHash bool
+	// MinServerVersion is the minimum PostgreSQL version, e.g. 15 or 15.2
+	MinServerVersion string
+	// NotBefore and NotAfter are the times of day of the maintenance window as HH:MM, Force applies outside of it
+	NotBefore string
+	NotAfter  string
+	Force     bool
+	// IgnoreSnapshots replays all migrations on the first run instead of starting from the last snapshot directory
+	IgnoreSnapshots bool
+	// LockStrategy defaults to advisory, LockTTL to 15 minutes
+	LockStrategy string
+	LockTTL      time.Duration
+	// BeforeEach and AfterEach are executed around every migration in its transaction
+	BeforeEach string
+	AfterEach  string
+	// Vars are substituted for ${key} placeholders in the migrations
+	Vars map[string]string
+	// Metadata is stored as JSON with every recorded migration
+	Metadata map[string]string
+
+	StoreSQL           bool
+	StoreSQLCompressed bool
+	// ValidateExecute executes the pending migrations in a transaction that is rolled back
+	ValidateExecute bool
+	// MaxParallel defaults to one, executing parallel migrations serially
+	MaxParallel int
+
+	ConnectRetries int
+	// ConnectRetryInterval defaults to one second
+	ConnectRetryInterval time.Duration
+	MaxReconnects        int
+	RetryOnConflict      int
+	InterMigrationDelay  time.Duration
+	CheckReplicationLag  bool
+	// PingTimeout defaults to 10 seconds, StatementTimeout is not set by default
+	PingTimeout      time.Duration
+	StatementTimeout time.Duration
+}
+
+// New creates a validated Config from the options
+func New(opts Options) (*Config, error) {
+	connectionString, err := NormalizeConnectionString(opts.ConnectionString, opts.ConnectionStringFormat)
+	if err != nil {
+		return nil, err
+	}
+	ssl := sslSettings{mode: opts.SSLMode, cert: opts.SSLCert, key: opts.SSLKey, rootCert: opts.SSLRootCert}
+	if connectionString, err = ssl.apply(connectionString); err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{
+		version:                opts.Version,
+		gitCommit:              opts.GitCommit,
+		appId:                  opts.AppId,
+		command:                CommandMigrate,
+		dir:                    opts.Dir,
+		withoutDir:             opts.WithoutDir,
+		connectionString:       connectionString,
+		connectionStringFormat: opts.ConnectionStringFormat,
+		trackingConnection:     opts.TrackingConnection,
+		applicationName:        opts.ApplicationName,
+		ssl:                    ssl,
+		connectionTimeout:      defaultConnectionTimeout,
+		steps:                  defaultSteps,
+		skipFileValidation:     opts.SkipFileValidation,
+		reapplyChanged:         opts.ReapplyChanged,
+		maxDepth:               defaultMaxDepth,
+		hashAlgorithm:          opts.HashAlgorithm,
+		hashMode:               opts.HashMode,
+		sortMode:               opts.Sort,
+		splitStatements:        opts.SplitStatements,
+		resolveIncludes:        opts.ResolveIncludes,
+		stripMetaCommands:      opts.StripMetaCommands,
+		printSQL:               opts.PrintSQL,
+		logNotices:             !opts.IgnoreNotices,
+		encoding:               opts.Encoding,
+		target:                 opts.Target,
+		from:                   opts.From,
+		include:                stringList{values: opts.Include},
+		exclude:                stringList{values: opts.Exclude},
+		filenamePattern:        opts.FilenamePattern,
+		connectRetries:         opts.ConnectRetries,
+		connectRetryInterval:   defaultConnectRetryInterval,
+		maxReconnects:          opts.MaxReconnects,
+		retryOnConflict:        opts.RetryOnConflict,
+		interMigrationDelay:    opts.InterMigrationDelay,
+		checkReplicationLag:    opts.CheckReplicationLag,
+		allowOutOfOrder:        opts.AllowOutOfOrder,
+		allowMissing:           opts.AllowMissing,
+		failOnEmpty:            opts.FailOnEmpty,
+		searchPath:             stringList{values: opts.SearchPath},
+		beforeEach:             opts.BeforeEach,
+		afterEach:              opts.AfterEach,
+		uniqueBasenames:        opts.UniqueBasenames,
+		continueOnError:        opts.ContinueOnError,
+		writeStatus:            opts.WriteStatus,
+		createDatabase:         opts.CreateDatabase,
+		checksumOnly:           opts.ChecksumOnlyValidation,
+		useSnapshots:           !opts.IgnoreSnapshots,
+		minServerVersion:       opts.MinServerVersion,
+		notBefore:              opts.NotBefore,
+		notAfter:               opts.NotAfter,
+		force:                  opts.Force,
+		trackSchemaHash:        opts.TrackSchemaHash,
+		tags:                   stringList{values: opts.Tags},
+		seedDir:                opts.SeedDir,
+		schemaPerApp:           opts.SchemaPerApp,
+		lockStrategy:           opts.LockStrategy,
+		lockTTL:                opts.LockTTL,
+		pingTimeout:            opts.PingTimeout,
+		statementTimeout:       opts.StatementTimeout,
+		maxParallel:            opts.MaxParallel,
+		storeSQL:               opts.StoreSQL,
+		storeSQLCompressed:     opts.StoreSQLCompressed,
+		validateExecute:        opts.ValidateExecute,
+	}
+
+	if err := cfg.resolveTrackingConnection(); err != nil {
+		return nil, err
+	}
+	if opts.ConnectionTimeout != 0 {
+		// Round up so that a sub-second timeout does not turn into an invalid zero
+		cfg.connectionTimeout = int((opts.ConnectionTimeout + time.Second - 1) / time.Second)
+	}
+	if opts.Steps != 0 {
+		cfg.steps = opts.Steps
+	}
+	if opts.ConnectRetryInterval != 0 {
+		cfg.connectRetryInterval = opts.ConnectRetryInterval
+	}
+	cfg.appliedBy = opts.AppliedBy
+	if cfg.appliedBy == "" {
+		cfg.appliedBy = defaultAppliedBy()
+	}
+
+	for key, value := range opts.Vars {
+		cfg.varList.values = append(cfg.varList.values, key+"="+value)
+	}
+	for key, value := range opts.Metadata {
+		cfg.metadataList.values = append(cfg.metadataList.values, key+"="+value)
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
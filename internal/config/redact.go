@@ -0,0 +1,50 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package config
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const redacted = "xxxxx"
+
+// reKeyValuePassword matches the password settings of a key-value connection string, quoted and unquoted values
+// may contain characters escaped with a backslash, e.g. quotes and spaces
+var reKeyValuePassword = regexp.MustCompile(`(?i)\b(password|sslpassword)\s*=\s*('(?:[^'\\]|\\.)*'|(?:[^\s\\]|\\.)+)`)
+
+// secretParams are the query parameters of a connection URL holding a secret
+var secretParams = []string{"password", "sslpassword"}
+
+// RedactConnectionString masks the passwords of a URL or key-value connection string, e.g. for logging
+func RedactConnectionString(connectionString string) string {
+	if !strings.HasPrefix(connectionString, "postgres://") && !strings.HasPrefix(connectionString, "postgresql://") {
+		return reKeyValuePassword.ReplaceAllString(connectionString, "${1}="+redacted)
+	}
+	return RedactURL(connectionString)
+}
+
+// RedactURL masks the password of the URL and of its secret query parameters,
+// an unparsable URL is masked completely as the password cannot be separated from it
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return redacted
+	}
+
+	query := u.Query()
+	changed := false
+	for _, param := range secretParams {
+		if query.Has(param) {
+			query.Set(param, redacted)
+			changed = true
+		}
+	}
+	if changed {
+		u.RawQuery = query.Encode()
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,75 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgconn/ctxwatch"
+	"go.uber.org/zap"
+)
+
+// ErrInterrupted is returned when the run is cancelled, e.g. by SIGTERM, the migration in flight is rolled back
+var ErrInterrupted = errors.New("migrations interrupted")
+
+// rollbackTimeout bounds the rollback of an aborted migration, which runs after the context has been cancelled
+const rollbackTimeout = 5 * time.Second
+
+// cancelQueriesOnCancel makes a cancelled context cancel the running statement on the server instead of closing
+// the connection, so the transaction of an aborted migration can still be rolled back on it
+func cancelQueriesOnCancel(connConfig *pgx.ConnConfig) {
+	connConfig.BuildContextWatcherHandler = func(pgConn *pgconn.PgConn) ctxwatch.Handler {
+		return &pgconn.CancelRequestContextWatcherHandler{Conn: pgConn, DeadlineDelay: rollbackTimeout}
+	}
+}
+
+// inMigrationTx runs fn in a transaction, committed when fn succeeds. The transaction is rolled back on failure,
+// when the context has been cancelled the migration is reported as aborted
+func inMigrationTx(ctx context.Context, db txBeginner, filePath string, logger *zap.Logger, fn func(pgx.Tx) error) (err error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w before %s: %w", ErrInterrupted, filePath, ctx.Err())
+		}
+		return err
+	}
+	// Also the notices of the rollback are tagged
+	defer tagNotices(tx.Conn(), filePath)()
+
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		rollbackCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+		defer cancel()
+		rollbackErr := tx.Rollback(rollbackCtx)
+		if errors.Is(rollbackErr, pgx.ErrTxClosed) {
+			rollbackErr = nil
+		}
+		if ctx.Err() == nil {
+			return
+		}
+
+		if rollbackErr != nil {
+			// The server rolls back the transaction of a closed connection as well
+			logger.Warn("Migration aborted, rollback failed, the transaction is discarded with the connection",
+				zap.String("file", filePath), zap.Error(rollbackErr))
+		} else {
+			logger.Warn("Migration aborted, transaction rolled back", zap.String("file", filePath))
+		}
+		err = fmt.Errorf("%w, migration %s rolled back: %w", ErrInterrupted, filePath, ctx.Err())
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,120 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// fakeTx records the executed statements and how the transaction was finished, the other methods are not used
+type fakeTx struct {
+	pgx.Tx
+	executed    []string
+	execErr     error
+	committed   bool
+	rolledBack  bool
+	rollbackErr error
+}
+
+func (tx *fakeTx) Exec(_ context.Context, sql string, _ ...any) (pgconn.CommandTag, error) {
+	tx.executed = append(tx.executed, sql)
+	return pgconn.CommandTag{}, tx.execErr
+}
+
+func (tx *fakeTx) Conn() *pgx.Conn {
+	return nil
+}
+
+func (tx *fakeTx) Commit(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tx.committed = true
+	return nil
+}
+
+func (tx *fakeTx) Rollback(ctx context.Context) error {
+	if tx.committed {
+		return pgx.ErrTxClosed
+	}
+	tx.rollbackErr = ctx.Err()
+	tx.rolledBack = true
+	return nil
+}
+
+type fakeBeginner struct {
+	tx *fakeTx
+}
+
+func (b *fakeBeginner) Begin(ctx context.Context) (pgx.Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return b.tx, nil
+}
+
+func TestInMigrationTx(t *testing.T) {
+	t.Run("Committed", func(t *testing.T) {
+		db := &fakeBeginner{tx: &fakeTx{}}
+		err := inMigrationTx(context.Background(), db, "001-init.sql", zap.NewNop(), func(pgx.Tx) error { return nil })
+		assert.NoError(t, err)
+		assert.True(t, db.tx.committed)
+		assert.False(t, db.tx.rolledBack)
+	})
+
+	t.Run("Failed migration is rolled back", func(t *testing.T) {
+		db := &fakeBeginner{tx: &fakeTx{}}
+		failure := errors.New("syntax error")
+		err := inMigrationTx(context.Background(), db, "001-init.sql", zap.NewNop(), func(pgx.Tx) error { return failure })
+		assert.ErrorIs(t, err, failure)
+		assert.NotErrorIs(t, err, ErrInterrupted)
+		assert.True(t, db.tx.rolledBack)
+	})
+
+	t.Run("Cancelled migration is rolled back and aborted", func(t *testing.T) {
+		core, logs := observer.New(zap.WarnLevel)
+		db := &fakeBeginner{tx: &fakeTx{}}
+		ctx, cancel := context.WithCancel(context.Background())
+		err := inMigrationTx(ctx, db, "001-init.sql", zap.New(core), func(pgx.Tx) error {
+			cancel()
+			return context.Canceled
+		})
+		assert.ErrorIs(t, err, ErrInterrupted)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.ErrorContains(t, err, "migration 001-init.sql rolled back")
+		assert.True(t, db.tx.rolledBack)
+		// The rollback is not cancelled with the run
+		assert.NoError(t, db.tx.rollbackErr)
+		assert.Equal(t, 1, logs.FilterMessage("Migration aborted, transaction rolled back").Len())
+	})
+
+	t.Run("Cancelled before commit", func(t *testing.T) {
+		db := &fakeBeginner{tx: &fakeTx{}}
+		ctx, cancel := context.WithCancel(context.Background())
+		err := inMigrationTx(ctx, db, "001-init.sql", zap.NewNop(), func(pgx.Tx) error {
+			cancel()
+			return nil
+		})
+		assert.ErrorIs(t, err, ErrInterrupted)
+		assert.False(t, db.tx.committed)
+		assert.True(t, db.tx.rolledBack)
+	})
+
+	t.Run("Cancelled before begin", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := inMigrationTx(ctx, &fakeBeginner{tx: &fakeTx{}}, "001-init.sql", zap.NewNop(), func(pgx.Tx) error { return nil })
+		assert.ErrorIs(t, err, ErrInterrupted)
+		assert.ErrorContains(t, err, "before 001-init.sql")
+	})
+}
@@ -0,0 +1,113 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"go.uber.org/zap"
+)
+
+// ApplyFile applies the single migration file configured for the apply-file command, regardless of the pending
+// migrations before it
+func ApplyFile(ctx context.Context, logger *zap.Logger, cfg *config.Config) (err error) {
+	fsys, closeFS, err := openMigrationsFS(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeFS()
+
+	sqlFiles, err := discoverFiles(fsys, cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	conn, err := connect(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer closeConnection(ctx, conn, &err)
+
+	track, tr, err := connectTracking(ctx, conn, cfg, logger)
+	if err != nil {
+		return err
+	}
+	if tr != nil {
+		defer closeConnection(ctx, track, &err)
+	}
+
+	lock, err := acquireLock(ctx, track, cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer lock.release(ctx)
+
+	// The run is cancelled once the row of a table lock has been taken over
+	ctx, stopKeepAlive, err := lock.keepAlive(ctx)
+	if err != nil {
+		return err
+	}
+	defer stopKeepAlive()
+	defer func() {
+		err = lockLostError(ctx, err)
+	}()
+
+	err = ensureMigrationTableExists(ctx, *track, cfg)
+	if err != nil {
+		return fmt.Errorf("error ensuring migration table exists: %w", err)
+	}
+
+	appliedMigrations, err := getAppliedMigrations(ctx, *track, cfg)
+	if err != nil {
+		return fmt.Errorf("error reading applied migrations: %w", err)
+	}
+
+	sqlFiles, err = dropSkipped(sqlFiles, appliedMigrations, logger)
+	if err != nil {
+		return err
+	}
+
+	f, pendingBefore, err := selectApplyFile(sqlFiles, appliedMigrations, cfg.ApplyFile())
+	if err != nil {
+		return err
+	}
+
+	if len(pendingBefore) > 0 {
+		logger.Warn("Applying migration out of order, earlier migrations are still pending, later runs need --allow-out-of-order",
+			zap.String("file", f.path), zap.Strings("pending", pendingBefore))
+	}
+
+	if err := applyMigration(ctx, conn, tr, fsys, f, cfg, nil, logger); err != nil {
+		return err
+	}
+
+	logger.Info("clbs-dbtool finished", zap.Int("applied", 1))
+	return nil
+}
+
+// selectApplyFile returns the discovered file with the path and the pending files ordered before it,
+// it fails when the file has already been applied
+func selectApplyFile(files []sqlFile, applied []migration, filePath string) (sqlFile, []string, error) {
+	recorded := make(map[string]struct{}, len(applied))
+	for _, m := range applied {
+		recorded[m.filePath] = struct{}{}
+	}
+
+	var pendingBefore []string
+	for _, f := range files {
+		if f.path == filePath {
+			if _, ok := recorded[f.path]; ok {
+				return sqlFile{}, nil, fmt.Errorf("file %s has already been applied", f.path)
+			}
+			return f, pendingBefore, nil
+		}
+		if _, ok := recorded[f.path]; !ok {
+			pendingBefore = append(pendingBefore, f.path)
+		}
+	}
+	return sqlFile{}, nil, fmt.Errorf("file %s does not match any migration file", filePath)
+}
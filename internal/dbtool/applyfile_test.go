@@ -0,0 +1,43 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectApplyFile(t *testing.T) {
+	files := []sqlFile{
+		{path: "001-init.sql", hash: "a"},
+		{path: "002-users.sql", hash: "b"},
+		{path: "003-orders.sql", hash: "c"},
+	}
+
+	t.Run("Next pending file", func(t *testing.T) {
+		f, pendingBefore, err := selectApplyFile(files, []migration{{filePath: "001-init.sql"}}, "002-users.sql")
+		assert.NoError(t, err)
+		assert.Equal(t, "b", f.hash)
+		assert.Empty(t, pendingBefore)
+	})
+
+	t.Run("Out of order", func(t *testing.T) {
+		f, pendingBefore, err := selectApplyFile(files, nil, "003-orders.sql")
+		assert.NoError(t, err)
+		assert.Equal(t, "003-orders.sql", f.path)
+		assert.Equal(t, []string{"001-init.sql", "002-users.sql"}, pendingBefore)
+	})
+
+	t.Run("Already applied", func(t *testing.T) {
+		_, _, err := selectApplyFile(files, []migration{{filePath: "001-init.sql"}}, "001-init.sql")
+		assert.EqualError(t, err, "file 001-init.sql has already been applied")
+	})
+
+	t.Run("Unknown file", func(t *testing.T) {
+		_, _, err := selectApplyFile(files, nil, "004-items.sql")
+		assert.EqualError(t, err, "file 004-items.sql does not match any migration file")
+	})
+}
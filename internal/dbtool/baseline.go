@@ -0,0 +1,133 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// Baseline records the discovered migrations as applied without executing them,
+// up to the target file or the number of steps, the files already recorded are left out
+func Baseline(ctx context.Context, logger *zap.Logger, cfg *config.Config) (err error) {
+	fsys, closeFS, err := openMigrationsFS(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeFS()
+
+	sqlFiles, err := discoverFiles(fsys, cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	conn, err := connect(ctx, cfg.ForTracking(), logger)
+	if err != nil {
+		return err
+	}
+	defer closeConnection(ctx, conn, &err)
+
+	lock, err := acquireLock(ctx, conn, cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer lock.release(ctx)
+
+	logger.Info("Ensuring migration table exists...")
+
+	err = ensureMigrationTableExists(ctx, *conn, cfg)
+	if err != nil {
+		return fmt.Errorf("error ensuring migration table exists: %w", err)
+	}
+
+	appliedMigrations, err := getAppliedMigrations(ctx, *conn, cfg)
+	if err != nil {
+		return fmt.Errorf("error reading applied migrations: %w", err)
+	}
+
+	// Mirror the snapshot handling of the initial migrate run so the next run matches the recorded rows
+	if len(appliedMigrations) == 0 && cfg.UseSnapshots() {
+		if detect, dir := getLastSnapshot(&sqlFiles); detect {
+			logger.Info("The last snapshot detected, skipping migrations before folder " + dir)
+		}
+	}
+
+	sqlFiles, err = dropSkipped(sqlFiles, appliedMigrations, logger)
+	if err != nil {
+		return err
+	}
+	sqlFiles = selectByTags(sqlFiles, appliedMigrations, cfg.Tags())
+
+	baseline, err := selectBaselineFiles(fsys, sqlFiles, appliedMigrations, cfg.Target(), cfg.Steps())
+	if err != nil {
+		return err
+	}
+
+	//goland:noinspection SqlResolve
+	insertBaselineSQL := `INSERT INTO ` + dbtoolTable(cfg, migrationsTableName) + ` (file_path, file_hash, app_id, clbs_dbtool_version, migrations_root, git_commit, applied_at, metadata, applied_by) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+	err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		for _, f := range baseline {
+			_, err := tx.Exec(ctx, insertBaselineSQL, f.path, f.hash, cfg.AppId(), cfg.Version(), f.rootOrNil(), gitCommitOrNil(cfg), appliedAt(), metadataOrNil(cfg), appliedByOrNil(cfg))
+			if err != nil {
+				return fmt.Errorf("error while inserting baseline row for %s: %w", f.path, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, f := range baseline {
+		logger.Info("Baselined migration", zap.String("file", f.path), zap.String("hash", f.hash))
+	}
+	logger.Info("Baseline finished", zap.Int("inserted", len(baseline)))
+
+	return nil
+}
+
+// selectBaselineFiles returns the files up to and including target, or the first steps files when target is empty,
+// leaving out the ones already recorded with their checksum, it fails when any of them is recorded with another one
+func selectBaselineFiles(fsys fs.FS, files []sqlFile, applied []migration, target string, steps int) ([]sqlFile, error) {
+	count := len(files)
+	if target != "" {
+		idx, err := targetIndex(files, target)
+		if err != nil {
+			return nil, err
+		}
+		count = idx + 1
+	} else if steps >= 0 && steps < count {
+		count = steps
+	}
+
+	recorded := make(map[string]string, len(applied))
+	for _, m := range applied {
+		recorded[m.filePath] = m.fileHash
+	}
+
+	var baseline []sqlFile
+	for _, f := range files[:count] {
+		hash, ok := recorded[f.path]
+		if !ok {
+			baseline = append(baseline, f)
+			continue
+		}
+		matches, err := hashMatches(hash, f, fsys)
+		if err != nil {
+			return nil, err
+		}
+		if !matches {
+			return nil, fmt.Errorf("refusing to baseline, file %s is already recorded with a different checksum", f.path)
+		}
+	}
+
+	return baseline, nil
+}
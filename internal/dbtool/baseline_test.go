@@ -0,0 +1,68 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectBaselineFiles(t *testing.T) {
+	files := []sqlFile{
+		{path: "001-init.sql"},
+		{path: "002-users.sql"},
+		{path: "003-orders.sql"},
+	}
+
+	paths := func(files []sqlFile) []string {
+		var result []string
+		for _, f := range files {
+			result = append(result, f.path)
+		}
+		return result
+	}
+
+	t.Run("All files", func(t *testing.T) {
+		baseline, err := selectBaselineFiles(nil, files, nil, "", -1)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"001-init.sql", "002-users.sql", "003-orders.sql"}, paths(baseline))
+	})
+
+	t.Run("Up to target", func(t *testing.T) {
+		baseline, err := selectBaselineFiles(nil, files, nil, "002-users.sql", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"001-init.sql", "002-users.sql"}, paths(baseline))
+	})
+
+	t.Run("Steps", func(t *testing.T) {
+		baseline, err := selectBaselineFiles(nil, files, nil, "", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"001-init.sql"}, paths(baseline))
+	})
+
+	t.Run("Unknown target", func(t *testing.T) {
+		_, err := selectBaselineFiles(nil, files, nil, "004-missing.sql", -1)
+		assert.ErrorContains(t, err, "does not match any migration file")
+	})
+
+	t.Run("Already recorded files left out", func(t *testing.T) {
+		// e.g. a migration applied but not recorded after the earlier ones
+		baseline, err := selectBaselineFiles(nil, files, []migration{{filePath: "001-init.sql"}}, "002-users.sql", -1)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"002-users.sql"}, paths(baseline))
+	})
+
+	t.Run("Nothing left to record", func(t *testing.T) {
+		baseline, err := selectBaselineFiles(nil, files, []migration{{filePath: "001-init.sql"}}, "", 1)
+		assert.NoError(t, err)
+		assert.Empty(t, baseline)
+	})
+
+	t.Run("File recorded with another checksum", func(t *testing.T) {
+		_, err := selectBaselineFiles(nil, files, []migration{{filePath: "001-init.sql", fileHash: "0123abcd"}}, "", -1)
+		assert.ErrorContains(t, err, "001-init.sql is already recorded with a different checksum")
+	})
+}
@@ -0,0 +1,57 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// bestEffortDirName is the name of the directory holding migrations whose failure does not stop the run
+// with --continue-on-error
+const bestEffortDirName = "best-effort"
+
+var ErrMigrationsFailed = errors.New("best-effort migrations failed")
+
+// isBestEffort reports whether the file is placed in a best-effort directory
+func isBestEffort(filePath string) bool {
+	dirs := strings.Split(filePath, "/")
+	return slices.Contains(dirs[:len(dirs)-1], bestEffortDirName)
+}
+
+// applyMigrationOrContinue applies the migration, with --continue-on-error the failure of a best-effort migration
+// is recorded and reported as failed instead of stopping the run
+func applyMigrationOrContinue(ctx context.Context, db txBeginner, tr *tracker, fsys fs.FS, f sqlFile, cfg *config.Config, timings *migrationTimings, logger *zap.Logger) (bool, error) {
+	err := applyMigration(ctx, db, tr, fsys, f, cfg, timings, logger)
+	if err == nil || !cfg.ContinueOnError() || !isBestEffort(f.path) || ctx.Err() != nil {
+		return false, err
+	}
+
+	logger.Error("Best-effort migration failed, continuing", zap.String("file", f.path), zap.Error(err))
+	if err := recordFailedMigration(ctx, db, tr, f, cfg); err != nil {
+		return true, fmt.Errorf("error recording failed migration %s: %w", f.path, err)
+	}
+	timings.recordFailed(f.path)
+	return true, nil
+}
+
+// recordFailedMigration inserts the row of the failed migration, so it is not retried by later runs
+func recordFailedMigration(ctx context.Context, db txBeginner, tr *tracker, f sqlFile, cfg *config.Config) error {
+	//goland:noinspection SqlResolve
+	insertFailedMigrationSQL := `INSERT INTO ` + dbtoolTable(cfg, migrationsTableName) + ` (file_path, file_hash, app_id, clbs_dbtool_version, migrations_root, git_commit, applied_at, metadata, applied_by, failed) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, TRUE)`
+
+	return inTrackingTx(ctx, db, tr, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, insertFailedMigrationSQL, f.path, f.hash, cfg.AppId(), cfg.Version(), f.rootOrNil(), gitCommitOrNil(cfg), appliedAt(), metadataOrNil(cfg), appliedByOrNil(cfg))
+		return err
+	})
+}
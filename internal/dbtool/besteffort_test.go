@@ -0,0 +1,19 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsBestEffort(t *testing.T) {
+	assert.True(t, isBestEffort("best-effort/001-backfill.sql"))
+	assert.True(t, isBestEffort("v2/best-effort/parallel/002-backfill.sql"))
+	assert.False(t, isBestEffort("v2/001-init.sql"))
+	assert.False(t, isBestEffort("best-effort.sql"))
+	assert.False(t, isBestEffort("v2/best-effort-001.sql"))
+}
@@ -0,0 +1,43 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"go.uber.org/zap"
+)
+
+var ErrMigrationTableMissing = errors.New("migration table does not exist")
+
+// Check connects to the database and confirms the migration table is readable, e.g. for readiness probes,
+// it neither creates the table nor modifies any state
+func Check(ctx context.Context, logger *zap.Logger, cfg *config.Config) (err error) {
+	conn, err := connect(ctx, cfg.ForTracking(), logger)
+	if err != nil {
+		return err
+	}
+	defer closeConnection(ctx, conn, &err)
+
+	exists, err := migrationTableExists(ctx, *conn, cfg)
+	if err != nil {
+		return fmt.Errorf("error checking migration table: %w", err)
+	}
+	if !exists {
+		return ErrMigrationTableMissing
+	}
+
+	//goland:noinspection SqlResolve
+	_, err = conn.Exec(ctx, `SELECT 1 FROM `+dbtoolTable(cfg, migrationsTableName)+` LIMIT 0`)
+	if err != nil {
+		return fmt.Errorf("error reading migration table: %w", err)
+	}
+
+	logger.Info("Check passed")
+	return nil
+}
@@ -0,0 +1,53 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/clbs-io/dbtool/internal/config"
+)
+
+var ErrNotConfirmed = errors.New("migrations not confirmed")
+
+// isTerminal reports whether the file is a terminal rather than a pipe or a regular file
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// confirmPlan prints the files to apply to out and reads the answer from in, without a terminal the plan is
+// only applied with --yes
+func confirmPlan(in io.Reader, out io.Writer, terminal bool, files []sqlFile, plan migrationPlan, cfg *config.Config) error {
+	if plan.toApply == 0 || cfg.Yes() {
+		return nil
+	}
+	if !terminal {
+		return fmt.Errorf("%w: stdin is not a terminal, use --yes to apply without a prompt", ErrNotConfirmed)
+	}
+
+	_, _ = fmt.Fprintf(out, "%d migrations to apply to %s:\n", plan.toApply, config.RedactConnectionString(cfg.ConnectionString()))
+	for _, f := range files {
+		if f.apply {
+			_, _ = fmt.Fprintf(out, "  %s\n", f.path)
+		}
+	}
+	_, _ = fmt.Fprint(out, "Apply the migrations? [y/N] ")
+
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error reading the confirmation: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return ErrNotConfirmed
+}
@@ -0,0 +1,68 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+const (
+	pgCodeSerializationFailure = "40001"
+	pgCodeDeadlockDetected     = "40P01"
+)
+
+// conflictRetryInterval is the delay before the first retry of a conflicting migration, it doubles with every retry
+const conflictRetryInterval = 100 * time.Millisecond
+
+// conflictCode returns the SQLSTATE of a serialization failure or a deadlock, the transaction of the migration
+// can succeed when it is run again
+func conflictCode(err error) (string, bool) {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return "", false
+	}
+	switch pgErr.Code {
+	case pgCodeSerializationFailure, pgCodeDeadlockDetected:
+		return pgErr.Code, true
+	}
+	return "", false
+}
+
+// inRetriedMigrationTx runs fn in a migration transaction like inMigrationTx, a transaction failing with
+// a serialization failure or a deadlock is rolled back and run again after a backoff, up to retries times
+func inRetriedMigrationTx(ctx context.Context, db txBeginner, filePath string, retries int, logger *zap.Logger, fn func(pgx.Tx) error) error {
+	interval := conflictRetryInterval
+	for attempt := 1; ; attempt++ {
+		err := inMigrationTx(ctx, db, filePath, logger, fn)
+		code, ok := conflictCode(err)
+		if !ok || ctx.Err() != nil {
+			return err
+		}
+		if attempt > retries {
+			if retries == 0 {
+				return err
+			}
+			return fmt.Errorf("migration %s still conflicting after %d retries: %w", filePath, retries, err)
+		}
+
+		logger.Warn("Migration conflicted with another transaction, retrying...", zap.String("file", filePath),
+			zap.String("sqlstate", code), zap.Int("attempt", attempt), zap.Int("retry_on_conflict", retries),
+			zap.Duration("backoff", interval), zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w before retrying %s: %w", ErrInterrupted, filePath, ctx.Err())
+		case <-time.After(interval):
+		}
+		interval *= 2
+	}
+}
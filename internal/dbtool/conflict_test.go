@@ -0,0 +1,102 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestConflictCode(t *testing.T) {
+	code, ok := conflictCode(fmt.Errorf("error: %w", &pgconn.PgError{Code: pgCodeSerializationFailure}))
+	assert.True(t, ok)
+	assert.Equal(t, "40001", code)
+
+	code, ok = conflictCode(&pgconn.PgError{Code: pgCodeDeadlockDetected})
+	assert.True(t, ok)
+	assert.Equal(t, "40P01", code)
+
+	_, ok = conflictCode(&pgconn.PgError{Code: "42P01"})
+	assert.False(t, ok)
+	_, ok = conflictCode(errors.New("connection reset"))
+	assert.False(t, ok)
+	_, ok = conflictCode(nil)
+	assert.False(t, ok)
+}
+
+func TestInRetriedMigrationTx(t *testing.T) {
+	conflict := &pgconn.PgError{Code: pgCodeDeadlockDetected, Message: "deadlock detected"}
+	// failing returns fn failing with err the first n calls and the number of calls
+	failing := func(n int, err error) (func(pgx.Tx) error, *int) {
+		calls := 0
+		return func(pgx.Tx) error {
+			calls++
+			if calls <= n {
+				return err
+			}
+			return nil
+		}, &calls
+	}
+
+	t.Run("Retried until committed", func(t *testing.T) {
+		core, logs := observer.New(zapcore.WarnLevel)
+		db := &fakeBeginner{tx: &fakeTx{}}
+		fn, calls := failing(2, conflict)
+		err := inRetriedMigrationTx(context.Background(), db, "001-init.sql", 3, zap.New(core), fn)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, *calls)
+		assert.True(t, db.tx.committed)
+
+		retries := logs.FilterMessage("Migration conflicted with another transaction, retrying...").All()
+		assert.Len(t, retries, 2)
+		assert.Equal(t, "40P01", retries[0].ContextMap()["sqlstate"])
+		assert.Equal(t, "001-init.sql", retries[0].ContextMap()["file"])
+	})
+
+	t.Run("Retries exhausted", func(t *testing.T) {
+		fn, calls := failing(10, conflict)
+		err := inRetriedMigrationTx(context.Background(), &fakeBeginner{tx: &fakeTx{}}, "001-init.sql", 1, zap.NewNop(), fn)
+		assert.ErrorIs(t, err, conflict)
+		assert.ErrorContains(t, err, "still conflicting after 1 retries")
+		assert.Equal(t, 2, *calls)
+	})
+
+	t.Run("Not retried without retries", func(t *testing.T) {
+		fn, calls := failing(1, conflict)
+		err := inRetriedMigrationTx(context.Background(), &fakeBeginner{tx: &fakeTx{}}, "001-init.sql", 0, zap.NewNop(), fn)
+		assert.Same(t, conflict, err)
+		assert.Equal(t, 1, *calls)
+	})
+
+	t.Run("Other errors fail immediately", func(t *testing.T) {
+		syntax := &pgconn.PgError{Code: "42601"}
+		fn, calls := failing(1, syntax)
+		err := inRetriedMigrationTx(context.Background(), &fakeBeginner{tx: &fakeTx{}}, "001-init.sql", 3, zap.NewNop(), fn)
+		assert.ErrorIs(t, err, syntax)
+		assert.Equal(t, 1, *calls)
+	})
+
+	t.Run("Cancelled during backoff", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		err := inRetriedMigrationTx(ctx, &fakeBeginner{tx: &fakeTx{}}, "001-init.sql", 3, zap.NewNop(), func(pgx.Tx) error {
+			calls++
+			// As by a signal arriving while the conflicting migration fails
+			defer cancel()
+			return conflict
+		})
+		assert.ErrorIs(t, err, ErrInterrupted)
+		assert.Equal(t, 1, calls)
+	})
+}
@@ -0,0 +1,52 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+// maintenanceDatabase is connected to with the same credentials to create the missing target database
+const maintenanceDatabase = "postgres"
+
+const (
+	pgCodeInvalidCatalogName = "3D000"
+	pgCodeDuplicateDatabase  = "42P04"
+)
+
+// isPgError reports whether the error is a PostgreSQL error with the SQLSTATE code
+func isPgError(err error, code string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == code
+}
+
+// createDatabase creates the database of the connection config through the maintenance database,
+// a database created concurrently by another run is not an error
+func createDatabase(ctx context.Context, connConfig *pgx.ConnConfig, cfg *config.Config, logger *zap.Logger) error {
+	name := connConfig.Database
+	logger.Info("Database does not exist, creating it...", zap.String("database", name))
+
+	maintenanceConfig := connConfig.Copy()
+	maintenanceConfig.Database = maintenanceDatabase
+
+	conn, err := connectAndPing(ctx, maintenanceConfig, cfg, logger)
+	if err != nil {
+		return fmt.Errorf("error connecting to the %s database: %w", maintenanceDatabase, err)
+	}
+	defer func() { _ = conn.Close(ctx) }()
+
+	_, err = conn.Exec(ctx, "CREATE DATABASE "+pgx.Identifier{name}.Sanitize())
+	if err != nil && !isPgError(err, pgCodeDuplicateDatabase) {
+		return fmt.Errorf("error creating database %s: %w", name, err)
+	}
+	return nil
+}
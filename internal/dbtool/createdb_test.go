@@ -0,0 +1,21 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPgError(t *testing.T) {
+	missing := fmt.Errorf("error connecting to database: %w", &pgconn.PgError{Code: pgCodeInvalidCatalogName})
+	assert.True(t, isPgError(missing, pgCodeInvalidCatalogName))
+	assert.False(t, isPgError(missing, pgCodeDuplicateDatabase))
+	assert.False(t, isPgError(errors.New("error connecting to database: timeout"), pgCodeInvalidCatalogName))
+}
@@ -6,23 +6,31 @@ package dbtool
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"io/fs"
 	"os"
-	"path/filepath"
+	"path"
 	"regexp"
 	"slices"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/clbs-io/dbtool/internal/config"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/blake2b"
 	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/unicode"
 	"golang.org/x/text/transform"
@@ -33,7 +41,13 @@ import (
 // hash: checksum of the SQL file
 
 var (
-	reFilename = regexp.MustCompile(`^[a-z0-9]+[a-z0-9-_]*.sql$`)
+	reFilename = regexp.MustCompile(`^[a-z0-9]+[a-z0-9-_]*.sql(\.gz)?$`)
+
+	ErrDuplicateBasename = errors.New("SQL files share the same base name")
+	ErrFromNotApplied    = errors.New("migration to start from is not applied")
+	ErrInvalidText       = errors.New("migration is not valid UTF-8 text")
+	ErrNoMigrationFiles  = errors.New("no SQL files found")
+	ErrPathTooLong       = fmt.Errorf("path is too long: must be at most %d characters", config.MaxFilePathLength)
 )
 
 type fileType int
@@ -44,87 +58,397 @@ const (
 	fileTypeSnapshot
 )
 
-func Run(ctx context.Context, logger *zap.Logger, cfg *config.Config) {
-	logger.Info("Looking for SQL files", zap.String("dir", cfg.Dir()))
-
-	var sqlFiles []sqlFile
+// Result summarizes a migration run
+type Result struct {
+	// Applied is the number of migrations applied by the run
+	Applied int
+	// Skipped is the number of migrations that had already been applied before the run
+	Skipped int
+	// Pending is the number of migrations left for a later run because of the steps limit
+	Pending int
+	// Failed is the number of best-effort migrations that failed with --continue-on-error
+	Failed int
+}
 
-	err := readDir(&sqlFiles, cfg.Dir(), "")
+// Run applies the migrations from the configured migrations directories to each configured database
+func Run(ctx context.Context, logger *zap.Logger, cfg *config.Config) (Result, error) {
+	fsys, closeFS, err := openMigrationsFS(cfg)
 	if err != nil {
-		logger.Fatal("Error reading dir", zap.Error(err))
+		return Result{}, err
 	}
+	defer closeFS()
 
-	prepareFiles(sqlFiles)
+	if len(cfg.Targets()) > 1 {
+		return runTargets(ctx, logger, cfg, fsys)
+	}
+	return RunFS(ctx, logger, cfg, fsys)
+}
 
-	logger.Debug("Found matching SQL files:")
-	for _, f := range sqlFiles {
-		logger.Debug(fmt.Sprintf("- %s", f.path))
+// RunFS applies the migrations read from fsys, e.g. an embed.FS, the migrations directory of the config is not used
+func RunFS(ctx context.Context, logger *zap.Logger, cfg *config.Config, fsys fs.FS) (result Result, err error) {
+	start := time.Now()
+	timings := &migrationTimings{}
+	if cfg.MetricsTextfile() != "" || cfg.PushgatewayURL() != "" {
+		defer func() {
+			m := runMetrics{appId: cfg.AppId(), timestamp: time.Now(), applied: result.Applied, success: err == nil, durations: timings.sorted()}
+			exportMetrics(ctx, cfg, m, logger)
+		}()
 	}
 
-	logger.Info(fmt.Sprintf("Connecting to database %s...", cfg.Host()))
+	sqlFiles, err := discoverFiles(fsys, cfg, logger)
+	if err != nil {
+		return result, err
+	}
+	// Selecting the files by tags reuses the slice
+	discovered := slices.Clone(sqlFiles)
 
-	timeoutCtx, timeoutCancel := context.WithTimeout(ctx, time.Duration(cfg.ConnectionTimeout())*time.Second)
-	defer timeoutCancel()
+	conn, err := connect(ctx, cfg, logger)
+	if err != nil {
+		return result, err
+	}
+	// Closes the connection in use when the run ends, it is replaced when lost between migrations
+	defer func() {
+		closeConnection(ctx, conn, &err)
+	}()
 
-	// Parse connection string using pgxpool that has more options although we won't use the pool
-	connConfig, err := pgxpool.ParseConfig(cfg.ConnectionString())
+	// The dbtool tables are read and written through track, the migrations run on conn
+	track, tr, err := connectTracking(ctx, conn, cfg, logger)
 	if err != nil {
-		logger.Fatal("Error parsing connection string", zap.Error(err))
+		return result, err
+	}
+	if tr != nil {
+		defer closeConnection(ctx, track, &err)
 	}
 
-	conn, err := pgx.ConnectConfig(timeoutCtx, connConfig.ConnConfig)
+	lock, err := acquireLock(ctx, track, cfg, logger)
 	if err != nil {
-		if errors.Is(err, context.DeadlineExceeded) {
-			logger.Fatal("Error connecting to database: timeout")
-		}
+		return result, err
+	}
+	defer lock.release(ctx)
 
-		logger.Fatal("Error connecting to database", zap.Error(err))
+	// The run is cancelled once the row of a table lock has been taken over
+	ctx, stopKeepAlive, err := lock.keepAlive(ctx)
+	if err != nil {
+		return result, err
 	}
+	defer stopKeepAlive()
 	defer func() {
-		err = conn.Close(ctx)
-		if err != nil {
-			logger.Fatal("Error closing connection", zap.Error(err))
-		}
+		err = lockLostError(ctx, err)
 	}()
 
-	logger.Info("Pinging the database...")
-	pingErr := conn.Ping(ctx)
-	if pingErr != nil {
-		logger.Fatal("Could not ping the database", zap.Error(pingErr))
+	if cfg.WriteStatus() {
+		if err := writeStatusRunning(ctx, track, cfg); err != nil {
+			return result, fmt.Errorf("error writing status: %w", err)
+		}
+		// Runs before the connection is closed, also when the run fails, conn may have been replaced by a reconnect
+		defer func() {
+			if serr := writeStatusFinished(ctx, trackingConn(conn, tr), cfg, err); serr != nil {
+				logger.Error("Error writing status", zap.Error(serr))
+			}
+		}()
 	}
 
 	logger.Info("Ensuring migration table exists...")
 
-	err = ensureMigrationTableExists(ctx, *conn)
+	err = ensureMigrationTableExists(ctx, *track, cfg)
 	if err != nil {
-		logger.Fatal("Error ensuring migration table exists", zap.Error(err))
+		return result, fmt.Errorf("error ensuring migration table exists: %w", err)
+	}
+
+	if cfg.TrackSchemaHash() {
+		if err := checkSchemaHash(ctx, conn, cfg, logger); err != nil {
+			return result, fmt.Errorf("error checking schema hash: %w", err)
+		}
+		// Also when the run fails, the migrations applied before the failure have changed the schema
+		defer func() {
+			if herr := recordSchemaHash(ctx, conn, cfg); herr != nil {
+				logger.Error("Error recording schema hash", zap.Error(herr))
+			}
+		}()
 	}
 
 	// Detect which migrations need to be applied
-	if initialState, err := isInitialState(ctx, *conn, cfg); err != nil {
-		logger.Fatal("Error checking initial state", zap.Error(err))
-	} else if initialState {
+	if initialState, err := isInitialState(ctx, *track, cfg); err != nil {
+		return result, fmt.Errorf("error checking initial state: %w", err)
+	} else if initialState && cfg.UseSnapshots() {
 		if detect, dir := getLastSnapshot(&sqlFiles); detect {
 			logger.Info("The last snapshot detected, skipping migrations before folder " + dir)
 		}
 	}
 
-	err = prepareListOfMigrations(ctx, *conn, sqlFiles, cfg)
+	sqlFiles, result.Skipped, err = prepareListOfMigrations(ctx, *track, fsys, sqlFiles, cfg, logger)
 	if err != nil {
-		logger.Fatal("Error preparing list of migrations", zap.Error(err))
+		return result, fmt.Errorf("error preparing list of migrations: %w", err)
+	}
+
+	// Written once the statuses are known, also when applying fails
+	if path := cfg.JSONPlanFile(); path != "" {
+		defer func() {
+			plan := newPlanFile(cfg.AppId(), discovered, sqlFiles, timings, result.Applied, err)
+			if perr := writePlanFile(path, plan); perr != nil {
+				logger.Error("Error writing JSON plan file", zap.String("path", path), zap.Error(perr))
+			}
+		}()
 	}
 
 	logger.Debug("Migrations to apply:")
 	for _, f := range sqlFiles {
-		if !f.apply {
-			continue
+		if f.apply {
+			logger.Debug(fmt.Sprintf("- %s", f.path))
+		}
+	}
+	plan := newMigrationPlan(sqlFiles, result.Skipped)
+	plan.log(logger)
+	result.Pending = plan.pending
+
+	if cfg.ValidateOnly() {
+		logger.Info("Migrations validated, nothing applied with --steps 0", zap.Int("pending", result.Pending))
+		return result, nil
+	}
+
+	// Nothing is committed, so neither the window nor a confirmation is needed
+	if cfg.ValidateExecute() {
+		result.Pending += plan.toApply
+		return result, validateExecute(ctx, conn, fsys, sqlFiles, cfg, logger)
+	}
+
+	// Only a run with something to apply is refused, so routine deploys outside the window still succeed
+	if plan.toApply > 0 {
+		if err := checkMaintenanceWindow(ctx, conn, cfg, logger); err != nil {
+			return result, err
+		}
+	}
+
+	// Asked while holding the lock, so the plan cannot change before it is applied
+	if cfg.Confirm() {
+		if err := confirmPlan(os.Stdin, os.Stderr, isTerminal(os.Stdin), sqlFiles, plan, cfg); err != nil {
+			return result, err
+		}
+	}
+
+	// Written by every real run once the plan has been matched, also when nothing is pending,
+	// validation runs and runs failing before this point leave the recorded paths alone
+	if err := writeRelocations(ctx, track, sqlFiles, cfg, logger); err != nil {
+		return result, fmt.Errorf("error updating paths of moved migrations: %w", err)
+	}
+
+	var pool *pgxpool.Pool
+	if cfg.MaxParallel() > 1 && hasParallelBatch(sqlFiles) {
+		pool, err = newPool(ctx, cfg, logger)
+		if err != nil {
+			return result, err
+		}
+		defer pool.Close()
+	}
+
+	reconnectRun := func(ctx context.Context) (*pgx.Conn, error) {
+		newConn, err := reconnect(ctx, conn, lock, cfg, logger)
+		if err != nil {
+			return nil, err
+		}
+		conn = newConn
+		return conn, nil
+	}
+
+	result.Applied, result.Failed, err = applyMigrations(ctx, conn, tr, reconnectRun, pool, fsys, sqlFiles, cfg, timings, logger)
+	if err != nil {
+		return result, err
+	}
+	if result.Failed > 0 {
+		return result, fmt.Errorf("%w: %d of %d", ErrMigrationsFailed, result.Failed, result.Failed+result.Applied)
+	}
+
+	// The seeds expect the latest schema
+	if cfg.SeedDir() != "" {
+		if result.Pending > 0 {
+			logger.Info("Migrations are still pending, skipping the seed scripts", zap.Int("pending", result.Pending))
+		} else if err := runSeeds(ctx, conn, cfg, logger); err != nil {
+			return result, err
 		}
+	}
+
+	totalBytes, statements := timings.totals()
+	logger.Info("clbs-dbtool finished", zap.Int("applied", result.Applied), zap.Int("statements", statements),
+		zap.Int64("bytes", totalBytes), zap.Duration("duration", time.Since(start)))
+
+	return result, nil
+}
+
+// discoverFiles reads the migrations from fsys and returns the SQL files in the order they are applied
+func discoverFiles(fsys fs.FS, cfg *config.Config, logger *zap.Logger) ([]sqlFile, error) {
+	logger.Info("Looking for SQL files", zap.Strings("dirs", cfg.Dirs()))
+
+	var sqlFiles []sqlFile
+
+	opts := readDirOptionsFromConfig(cfg)
+	var err error
+	if cfg.FilesFrom() != "" {
+		err = readFileList(&sqlFiles, fsys, cfg.FileList(), opts)
+	} else {
+		err = readDir(&sqlFiles, fsys, "", opts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading dir: %w", err)
+	}
+
+	if cfg.ResolveIncludes() {
+		if sqlFiles, err = dropIncludedFiles(fsys, sqlFiles, cfg.Encoding()); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.UniqueBasenames() {
+		if err := checkUniqueBasenames(sqlFiles); err != nil {
+			return nil, err
+		}
+	}
+
+	prepareFiles(sqlFiles, cfg.Sort())
+	moveRepeatableLast(sqlFiles)
+	tagRoots(fsys, sqlFiles)
+	if err := assignRoles(fsys, sqlFiles); err != nil {
+		return nil, err
+	}
+
+	if err := checkPathLengths(sqlFiles); err != nil {
+		return nil, err
+	}
+
+	order, ok, err := readOrderFile(fsys)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		logger.Info("Ordering SQL files by " + orderFileName)
+		// The repeatable migrations always run last, so they are not listed
+		versioned, _ := splitRepeatable(sqlFiles)
+		if err := orderByManifest(versioned, order, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Debug("Found matching SQL files:")
+	for _, f := range sqlFiles {
 		logger.Debug(fmt.Sprintf("- %s", f.path))
 	}
 
-	applyMigrations(ctx, conn, cfg.Dir(), sqlFiles, cfg, logger)
+	// A directory that resolved but is the wrong one, e.g. a mount mistake, holds no migrations either
+	if len(sqlFiles) == 0 {
+		if cfg.FailOnEmpty() && len(cfg.Dirs()) > 0 {
+			return nil, fmt.Errorf("%w in %s", ErrNoMigrationFiles, strings.Join(cfg.Dirs(), ", "))
+		}
+		if cfg.FailOnEmpty() {
+			return nil, ErrNoMigrationFiles
+		}
+		logger.Warn("No SQL files found, check the migrations directory", zap.Strings("dirs", cfg.Dirs()))
+	}
+
+	if cfg.VerifySidecarChecksums() {
+		logger.Info("Verifying sidecar checksums...")
+		err = verifySidecarChecksums(fsys, sqlFiles, cfg.MissingSidecarPolicy(), logger)
+		if err != nil {
+			return nil, fmt.Errorf("error verifying sidecar checksums: %w", err)
+		}
+	}
 
-	logger.Info("clbs-dbtool finished")
+	return sqlFiles, nil
+}
+
+// connect opens the connection to the database and pings it,
+// failed attempts are retried with exponential backoff up to the configured number of retries
+func connect(ctx context.Context, cfg *config.Config, logger *zap.Logger) (*pgx.Conn, error) {
+	logger.Info(fmt.Sprintf("Connecting to database %s...", cfg.Host()))
+
+	// Parse connection string using pgxpool that has more options although we won't use the pool
+	connConfig, err := pgxpool.ParseConfig(cfg.ConnectionString())
+	if err != nil {
+		return nil, fmt.Errorf("error parsing connection string: %w", err)
+	}
+	setApplicationName(connConfig.ConnConfig, cfg)
+	cancelQueriesOnCancel(connConfig.ConnConfig)
+	logNotices(connConfig.ConnConfig, cfg, logger)
+
+	interval := cfg.ConnectRetryInterval()
+	created := false
+	for attempt := 1; ; attempt++ {
+		conn, err := connectAndPing(ctx, connConfig.ConnConfig, cfg, logger)
+		if err == nil {
+			// Fail before anything is executed, the syntax of later versions only fails in the middle of a run
+			if minVersion := cfg.MinServerVersion(); minVersion > 0 {
+				if err := checkServerVersion(ctx, conn, minVersion); err != nil {
+					_ = conn.Close(ctx)
+					return nil, err
+				}
+			}
+			return conn, nil
+		}
+		if cfg.CreateDatabase() && !created && isPgError(err, pgCodeInvalidCatalogName) {
+			if err := createDatabase(ctx, connConfig.ConnConfig, cfg, logger); err != nil {
+				return nil, err
+			}
+			// Reconnecting to the created database does not count as a retry
+			created = true
+			attempt--
+			continue
+		}
+		if attempt > cfg.ConnectRetries() || ctx.Err() != nil {
+			return nil, err
+		}
+
+		logger.Warn("Could not connect to the database, retrying...", zap.Int("attempt", attempt), zap.Duration("backoff", interval), zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+		interval *= 2
+	}
+}
+
+// setApplicationName sets the application_name identifying the sessions in pg_stat_activity, an explicitly
+// configured name overrides the one of the connection string, which overrides the default
+func setApplicationName(connConfig *pgx.ConnConfig, cfg *config.Config) {
+	if name := cfg.ApplicationName(); name != "" {
+		connConfig.RuntimeParams["application_name"] = name
+		return
+	}
+	if _, ok := connConfig.RuntimeParams["application_name"]; !ok {
+		connConfig.RuntimeParams["application_name"] = fmt.Sprintf("clbs-dbtool/%s/%s", cfg.Version(), cfg.AppId())
+	}
+}
+
+func connectAndPing(ctx context.Context, connConfig *pgx.ConnConfig, cfg *config.Config, logger *zap.Logger) (*pgx.Conn, error) {
+	timeoutCtx, timeoutCancel := context.WithTimeout(ctx, time.Duration(cfg.ConnectionTimeout())*time.Second)
+	defer timeoutCancel()
+
+	conn, err := pgx.ConnectConfig(timeoutCtx, connConfig)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, errors.New("error connecting to database: timeout")
+		}
+
+		return nil, fmt.Errorf("error connecting to database: %w", err)
+	}
+
+	logger.Info("Pinging the database...")
+	pingCtx, pingCancel := context.WithTimeout(ctx, cfg.PingTimeout())
+	defer pingCancel()
+	pingErr := conn.Ping(pingCtx)
+	if pingErr != nil {
+		_ = conn.Close(ctx)
+		return nil, fmt.Errorf("could not ping the database: %w", pingErr)
+	}
+
+	return conn, nil
+}
+
+// closeConnection closes the connection, the close error is reported through err unless it already holds an error
+func closeConnection(ctx context.Context, conn *pgx.Conn, err *error) {
+	closeErr := conn.Close(ctx)
+	if closeErr != nil && *err == nil {
+		*err = fmt.Errorf("error closing connection: %w", closeErr)
+	}
 }
 
 type sqlFile struct {
@@ -132,28 +456,143 @@ type sqlFile struct {
 	hash       string
 	apply      bool
 	isSnapshot bool
+	// root is the migrations directory the file is read from, only set with several directories
+	root string
+	// repeatable migrations are applied after the versioned ones whenever their checksum changes
+	repeatable bool
+	// recorded is set for a file found in the migrations table before the run
+	recorded bool
+	// changed is set for a repeatable migration, or a versioned one with --reapply-changed, applied again because
+	// its checksum differs from the recorded one
+	changed bool
+	// tags are the environments declared by the file, an untagged file is applied in all of them
+	tags []string
+	// skip is set for a file with the -- dbtool:skip directive, it is left out once its name has been validated
+	skip bool
+	// role is the role the file is applied as, from the role file of its directory, empty for the connecting user
+	role string
+	// noTransaction is set for a file with the -- dbtool:no-transaction directive, its statements are executed
+	// one by one outside of a transaction
+	noTransaction bool
+	// movedFrom is the recorded path of an applied migration moved to this file with --checksum-only-validation
+	movedFrom string
+}
+
+// rootOrNil returns the root for the bookkeeping insert, NULL unless several directories are used
+func (f sqlFile) rootOrNil() *string {
+	if f.root == "" {
+		return nil
+	}
+	return &f.root
+}
+
+// readDirOptions controls which files readDir collects
+type readDirOptions struct {
+	// maxDepth is the maximum number of subdirectories a SQL file can be nested in, negative means unlimited
+	maxDepth int
+	// hashAlgorithm is the algorithm used to compute the file checksums, with the normalized suffix in that mode
+	hashAlgorithm string
+	// include and exclude are glob patterns matched against the relative file path
+	include []string
+	exclude []string
+	// filenamePattern overrides reFilename when set
+	filenamePattern *regexp.Regexp
+}
+
+const unlimitedDepth = -1
+
+func readDirOptionsFromConfig(cfg *config.Config) readDirOptions {
+	return readDirOptions{
+		maxDepth:        cfg.MaxDepth(),
+		hashAlgorithm:   hashAlgorithmFromConfig(cfg),
+		include:         cfg.Include(),
+		exclude:         cfg.Exclude(),
+		filenamePattern: cfg.FilenamePattern(),
+	}
+}
+
+// isFileSelected checks the relative path against the include and exclude patterns
+func (opts readDirOptions) isFileSelected(filePath string) bool {
+	included := len(opts.include) == 0
+	for _, pattern := range opts.include {
+		if ok, _ := path.Match(pattern, filePath); ok {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+
+	for _, pattern := range opts.exclude {
+		if ok, _ := path.Match(pattern, filePath); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// checkUniqueBasenames fails when SQL files in different directories share the same base name, listing all conflicts
+func checkUniqueBasenames(files []sqlFile) error {
+	byName := make(map[string][]string)
+	var names []string
+	for _, f := range files {
+		name := path.Base(f.path)
+		if _, ok := byName[name]; !ok {
+			names = append(names, name)
+		}
+		byName[name] = append(byName[name], f.path)
+	}
+
+	var conflicts []string
+	for _, name := range names {
+		if paths := byName[name]; len(paths) > 1 {
+			conflicts = append(conflicts, fmt.Sprintf("%s (%s)", name, strings.Join(paths, ", ")))
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%w: %s", ErrDuplicateBasename, strings.Join(conflicts, "; "))
+	}
+	return nil
 }
 
-// readDir reads the directory recursively and appends all SQL files to the sqlFiles slice
-func readDir(files *[]sqlFile, rootDir string, subDir string) error {
-	currentDir := filepath.Join(rootDir, subDir)
-	entry, err := os.ReadDir(currentDir)
+// checkPathLengths fails when the path or the migrations directory of a file does not fit the migrations table
+func checkPathLengths(files []sqlFile) error {
+	for _, f := range files {
+		for _, p := range []string{f.path, f.root} {
+			if utf8.RuneCountInString(p) > config.MaxFilePathLength {
+				return fmt.Errorf("%w: %.100s...", ErrPathTooLong, p)
+			}
+		}
+	}
+	return nil
+}
+
+// readDir reads the directory of fsys recursively and appends all SQL files to the sqlFiles slice
+func readDir(files *[]sqlFile, fsys fs.FS, subDir string, opts readDirOptions) error {
+	currentDir := path.Join(".", subDir)
+	entry, err := fs.ReadDir(fsys, currentDir)
 	if err != nil {
 		return err
 	}
 
-	allowSnapshotTag := len(strings.Split(subDir, string(os.PathSeparator))) == 1
+	allowSnapshotTag := len(strings.Split(subDir, "/")) == 1
+
+	depth := 0
+	if subDir != "" {
+		depth = len(strings.Split(subDir, "/"))
+	}
 
 	var isSnapshot bool
 	var localFiles []sqlFile
 
 	for _, e := range entry {
 		entryName := e.Name()
-		entryPath := filepath.Join(subDir, entryName)
+		entryPath := path.Join(subDir, entryName)
 
 		// depth first
 		if e.IsDir() {
-			err := readDir(&localFiles, rootDir, entryPath)
+			err := readDir(&localFiles, fsys, entryPath, opts)
 			if err != nil {
 				return err
 			}
@@ -161,13 +600,13 @@ func readDir(files *[]sqlFile, rootDir string, subDir string) error {
 			continue
 		}
 
-		fileType := getFileType(entryName)
+		fileType := getFileType(versionedName(entryName), opts.filenamePattern)
 
 		switch fileType {
 		case fileTypeUnknown:
 			// if the file has a .sql extension, it's strange a probably a mistake
-			if strings.HasSuffix(entryName, ".sql") {
-				return fmt.Errorf("the file name '%s' which has .sql extension contains invalid characters", entryName)
+			if isSQLFileName(entryName) {
+				return fmt.Errorf("the file name '%s' which has .sql extension does not match the file name pattern %s", entryName, filenamePatternOrDefault(opts.filenamePattern))
 			}
 			// Non .sql files are just skipped
 			continue
@@ -180,16 +619,19 @@ func readDir(files *[]sqlFile, rootDir string, subDir string) error {
 			continue
 
 		case fileTypeSql:
+			if opts.maxDepth >= 0 && depth > opts.maxDepth {
+				return fmt.Errorf("the file '%s' is nested deeper than the maximum depth %d", entryPath, opts.maxDepth)
+			}
+			if !opts.isFileSelected(entryPath) {
+				continue
+			}
 		}
 
-		fileHash, err := getFileHash(filepath.Join(rootDir, entryPath))
+		f, err := readSQLFile(fsys, entryPath, opts)
 		if err != nil {
 			return err
 		}
-
-		localFiles = append(localFiles, sqlFile{path: entryPath, hash: fileHash,
-			apply: false,
-		})
+		localFiles = append(localFiles, f)
 	}
 
 	if isSnapshot {
@@ -203,9 +645,25 @@ func readDir(files *[]sqlFile, rootDir string, subDir string) error {
 	return nil
 }
 
-// isValidFileName checks if the file name is valid
-func getFileType(name string) fileType {
-	if reFilename.MatchString(name) {
+// readSQLFile computes the hash of the SQL file and reads its directives
+func readSQLFile(fsys fs.FS, filePath string, opts readDirOptions) (sqlFile, error) {
+	fileHash, err := getFileHash(fsys, filePath, opts.hashAlgorithm)
+	if err != nil {
+		return sqlFile{}, err
+	}
+
+	directives, err := readDirectives(fsys, filePath)
+	if err != nil {
+		return sqlFile{}, err
+	}
+
+	return sqlFile{path: filePath, hash: fileHash, apply: false, repeatable: isRepeatablePath(filePath),
+		tags: directives.tags, skip: directives.skip, noTransaction: directives.noTransaction}, nil
+}
+
+// getFileType detects the type of the file by its name, SQL file names must match the pattern (reFilename when nil)
+func getFileType(name string, pattern *regexp.Regexp) fileType {
+	if filenamePatternOrDefault(pattern).MatchString(name) {
 		return fileTypeSql
 	}
 	if name == ".snapshot" {
@@ -214,39 +672,200 @@ func getFileType(name string) fileType {
 	return fileTypeUnknown
 }
 
-// getFileHash returns the sha256 checksum of the file
-func getFileHash(path string) (string, error) {
-	f, err := os.Open(path)
+func filenamePatternOrDefault(pattern *regexp.Regexp) *regexp.Regexp {
+	if pattern == nil {
+		return reFilename
+	}
+	return pattern
+}
+
+// getFileHash returns the checksum of the file computed with the algorithm, over the normalized SQL
+// for a normalized algorithm, the checksum is formatted as stored in the migrations table
+func getFileHash(fsys fs.FS, name string, algorithm string) (string, error) {
+	base, normalized := strings.CutSuffix(algorithm, normalizedHashSuffix)
+	h, err := newHash(base)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := openMigrationFile(fsys, name)
 	if err != nil {
 		return "", err
 	}
 	defer func() { _ = f.Close() }()
 
-	h := sha256.New()
-	if _, err = io.Copy(h, f); err != nil {
+	if !normalized {
+		if _, err = io.Copy(h, f); err != nil {
+			return "", err
+		}
+		return formatHash(algorithm, h.Sum(nil)), nil
+	}
+
+	// The BOM is removed with the text
+	text, err := readText(f)
+	if err != nil {
 		return "", err
 	}
+	sql, err := normalizeSQL(text)
+	if err != nil {
+		return "", fmt.Errorf("could not normalize %s: %w", name, err)
+	}
+	_, _ = io.WriteString(h, sql)
+	return formatHash(algorithm, h.Sum(nil)), nil
+}
 
-	return hex.EncodeToString(h.Sum(nil)), nil
+// gzipExtension marks a compressed migration, it is decompressed before hashing and execution
+const gzipExtension = ".gz"
+
+// isSQLFileName reports whether the name has the extension of a plain or a compressed migration
+func isSQLFileName(name string) bool {
+	return strings.HasSuffix(name, ".sql") || strings.HasSuffix(name, ".sql"+gzipExtension)
+}
+
+// gzipFile closes both the gzip reader and the underlying file
+type gzipFile struct {
+	*gzip.Reader
+	file fs.File
 }
 
-func ensureMigrationTableExists(ctx context.Context, conn pgx.Conn) error {
-	createTableSQL := `
-		CREATE TABLE IF NOT EXISTS public.clbs_dbtool_migrations (
+func (f gzipFile) Close() error {
+	return errors.Join(f.Reader.Close(), f.file.Close())
+}
+
+// openMigrationFile opens the migration and transparently decompresses a .sql.gz file
+func openMigrationFile(fsys fs.FS, name string) (io.ReadCloser, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(name, gzipExtension) {
+		return f, nil
+	}
+
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("could not decompress %s: %w", name, err)
+	}
+	return gzipFile{Reader: r, file: f}, nil
+}
+
+func newHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case config.HashSHA256:
+		return sha256.New(), nil
+	case config.HashSHA512:
+		return sha512.New(), nil
+	case config.HashBLAKE2b:
+		return blake2b.New512(nil)
+	}
+	return nil, fmt.Errorf("unsupported hash algorithm: %s", algorithm)
+}
+
+// formatHash prefixes the hex digest with the algorithm name,
+// sha256 checksums are not prefixed to stay compatible with the rows written before the algorithm was configurable
+func formatHash(algorithm string, sum []byte) string {
+	if algorithm == config.HashSHA256 {
+		return hex.EncodeToString(sum)
+	}
+	return algorithm + ":" + hex.EncodeToString(sum)
+}
+
+// hashAlgorithmOf returns the algorithm the stored checksum was computed with
+func hashAlgorithmOf(stored string) string {
+	if algorithm, _, ok := strings.Cut(stored, ":"); ok {
+		return algorithm
+	}
+	return config.HashSHA256
+}
+
+// hashMatches compares the stored checksum with the file using the algorithm of the stored checksum
+func hashMatches(stored string, f sqlFile, fsys fs.FS) (bool, error) {
+	algorithm := hashAlgorithmOf(stored)
+	if algorithm == hashAlgorithmOf(f.hash) {
+		return stored == f.hash, nil
+	}
+
+	h, err := getFileHash(fsys, f.path, algorithm)
+	if err != nil {
+		return false, err
+	}
+	return stored == h, nil
+}
+
+// createMigrationTableSQL returns the statement creating the migrations table of the quoted qualified name
+func createMigrationTableSQL(table string) string {
+	return `
+		CREATE TABLE IF NOT EXISTS ` + table + ` (
 			id BIGSERIAL PRIMARY KEY,
-			app_id VARCHAR(64) NOT NULL,
-			file_path VARCHAR(1024) NOT NULL,
-			file_hash VARCHAR(64) NOT NULL, -- sha256 hash as hex string
-			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			clbs_dbtool_version VARCHAR(10) NOT NULL
+			app_id VARCHAR(255) NOT NULL,
+			file_path VARCHAR(4096) NOT NULL,
+			file_hash VARCHAR(160) NOT NULL, -- hex string, prefixed with the algorithm unless sha256
+			applied_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP, -- recorded by dbtool in UTC
+			clbs_dbtool_version VARCHAR(10) NOT NULL,
+			duration_ms BIGINT, -- execution time of the migration SQL
+			sql_text TEXT, -- executed SQL, only stored with --store-sql
+			migrations_root VARCHAR(4096), -- migrations directory of the file, only stored with several directories
+			git_commit VARCHAR(40), -- commit dbtool was built from, NULL when unknown
+			failed BOOLEAN NOT NULL DEFAULT FALSE, -- best-effort migration that failed with --continue-on-error
+			metadata JSONB, -- key-values of --metadata, NULL when none is set
+			applied_by VARCHAR(128) -- user or service account of --applied-by, NULL when unknown
 		)`
+}
 
-	_, err := conn.Exec(ctx, createTableSQL)
-	return err
+// upgradeMigrationTableSQL returns the statements bringing tables created by older versions in the schema
+// up to date, the statements must be idempotent
+func upgradeMigrationTableSQL(schema string) []string {
+	table := pgx.Identifier{schema, migrationsTableName}.Sanitize()
+	columnOf := "WHERE table_schema = " + quoteLiteral(schema) + " AND table_name = '" + migrationsTableName + "'"
+	return []string{
+		// Widen file_hash for algorithms with longer digests
+		widenColumnSQL(schema, migrationsTableName, "file_hash", 160),
+		widenColumnSQL(schema, migrationsTableName, "app_id", config.MaxAppIdLength),
+		widenColumnSQL(schema, migrationsTableName, "file_path", config.MaxFilePathLength),
+		`ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS duration_ms BIGINT`,
+		`ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS sql_text TEXT`,
+		`ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS migrations_root VARCHAR(4096)`,
+		widenColumnSQL(schema, migrationsTableName, "migrations_root", config.MaxFilePathLength),
+		`ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS git_commit VARCHAR(40)`,
+		`ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS failed BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS metadata JSONB`,
+		`ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS applied_by VARCHAR(128)`,
+		// Store applied_at with the time zone, the existing values are read in the time zone of the session as before.
+		// The column is left as it is when a view depends on it, altering it would fail.
+		`DO $$
+	BEGIN
+		IF (SELECT data_type FROM information_schema.columns
+			` + columnOf + ` AND column_name = 'applied_at') = 'timestamp without time zone' THEN
+			ALTER TABLE ` + table + ` ALTER COLUMN applied_at TYPE TIMESTAMPTZ;
+		END IF;
+	EXCEPTION WHEN feature_not_supported THEN
+		RAISE NOTICE 'applied_at of clbs_dbtool_migrations is used by a view, it is left without time zone';
+	END $$`,
+	}
+}
+
+func ensureMigrationTableExists(ctx context.Context, conn pgx.Conn, cfg *config.Config) error {
+	if err := ensureAppSchema(ctx, &conn, cfg); err != nil {
+		return err
+	}
+
+	_, err := conn.Exec(ctx, createMigrationTableSQL(dbtoolTable(cfg, migrationsTableName)))
+	if err != nil {
+		return err
+	}
+
+	for _, sql := range upgradeMigrationTableSQL(dbtoolSchema(cfg)) {
+		_, err = conn.Exec(ctx, sql)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func isInitialState(ctx context.Context, conn pgx.Conn, cfg *config.Config) (bool, error) {
-	query := `SELECT 1 FROM public.clbs_dbtool_migrations WHERE app_id = $1`
+	query := `SELECT 1 FROM ` + dbtoolTable(cfg, migrationsTableName) + ` WHERE app_id = $1`
 	err := conn.QueryRow(ctx, query, cfg.AppId()).Scan(nil)
 	if errors.Is(err, pgx.ErrNoRows) {
 		return true, nil
@@ -254,101 +873,688 @@ func isInitialState(ctx context.Context, conn pgx.Conn, cfg *config.Config) (boo
 	return false, err
 }
 
-func prepareListOfMigrations(ctx context.Context, conn pgx.Conn, files []sqlFile, cfg *config.Config) error {
-	type migration struct {
-		filePath string
-		fileHash string
-	}
+// migration is a row of the migrations table
+type migration struct {
+	filePath string
+	fileHash string
+}
 
+// getAppliedMigrations returns the migrations applied for the app in the order they were applied
+func getAppliedMigrations(ctx context.Context, conn pgx.Conn, cfg *config.Config) ([]migration, error) {
 	//goland:noinspection SqlResolve
-	selectMigrationsSQL := `SELECT file_path, file_hash FROM public.clbs_dbtool_migrations WHERE app_id = $1 ORDER BY id ASC`
+	selectMigrationsSQL := `SELECT file_path, file_hash FROM ` + dbtoolTable(cfg, migrationsTableName) + ` WHERE app_id = $1 ORDER BY id ASC`
 
 	rows, err := conn.Query(ctx, selectMigrationsSQL, cfg.AppId())
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	appliedMigrations, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (migration, error) {
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (migration, error) {
 		var m migration
 		err := row.Scan(&m.filePath, &m.fileHash)
 		return m, err
 	})
+}
+
+// migrationTableExists checks whether the migrations table exists without creating it
+func migrationTableExists(ctx context.Context, conn pgx.Conn, cfg *config.Config) (bool, error) {
+	var exists bool
+	err := conn.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL`, dbtoolTable(cfg, migrationsTableName)).Scan(&exists)
+	return exists, err
+}
+
+// prepareListOfMigrations marks the files to be applied and returns the files of the requested tags
+// and the number of already applied ones
+func prepareListOfMigrations(ctx context.Context, conn pgx.Conn, fsys fs.FS, files []sqlFile, cfg *config.Config, logger *zap.Logger) ([]sqlFile, int, error) {
+	appliedMigrations, err := getAppliedMigrations(ctx, conn, cfg)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if cfg.FilesFrom() != "" {
+		appliedMigrations = listedMigrations(appliedMigrations, files)
+	}
+
+	files, err = dropSkipped(files, appliedMigrations, logger)
+	if err != nil {
+		return nil, 0, err
+	}
+	selected := selectByTags(files, appliedMigrations, cfg.Tags())
+
+	// The repeatable migrations are matched by path only, they are allowed to change
+	files, repeatableFiles := splitRepeatable(selected)
+	appliedMigrations, appliedRepeatable := splitRepeatableMigrations(appliedMigrations)
+
+	if cfg.ChecksumOnlyValidation() {
+		appliedMigrations, err = relocateMovedMigrations(fsys, files, appliedMigrations, logger)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// Filtering out an applied migration would silently drop it from the validation
+	opts := readDirOptionsFromConfig(cfg)
+	for _, m := range appliedMigrations {
+		if !opts.isFileSelected(m.filePath) {
+			return nil, 0, fmt.Errorf("file %s has been applied but is excluded by the include/exclude patterns", m.filePath)
+		}
+	}
+
+	// The changed files are marked once the others are
+	skipFileValidation := cfg.SkipFileValidation() || cfg.ReapplyChanged()
+	appliedMigrations, err = checkPreflight(fsys, files, appliedMigrations, skipFileValidation, cfg.AllowMissing(), logger)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The pending migrations all follow the from file, so the steps and the target count from it
+	if cfg.From() != "" {
+		if err := checkFrom(files, appliedMigrations, cfg.From()); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// The target takes precedence over the steps
+	steps := cfg.Steps()
+	if cfg.Target() != "" {
+		steps = -1
+	}
+
+	var skipped int
+	if cfg.AllowOutOfOrder() {
+		skipped, err = markMigrationsOutOfOrder(fsys, files, appliedMigrations, steps, skipFileValidation)
+	} else {
+		skipped, err = markMigrations(fsys, files, appliedMigrations, steps, skipFileValidation)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Like the repeatable migrations nothing is applied again with --steps 0
+	if cfg.ReapplyChanged() && steps != 0 {
+		changed, err := markChanged(fsys, files, appliedMigrations, cfg.Target(), logger)
+		if err != nil {
+			return nil, 0, err
+		}
+		skipped -= changed
+	}
+
+	if cfg.Target() != "" {
+		if err := limitToTarget(files, cfg.Target()); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// The repeatable migrations may depend on any versioned one, so they wait until none is left pending,
+	// with --steps 0 nothing is applied at all
+	if len(repeatableFiles) > 0 && steps != 0 && !hasPending(files, skipped) {
+		unchanged, err := markRepeatable(fsys, repeatableFiles, appliedRepeatable, logger)
+		if err != nil {
+			return nil, 0, err
+		}
+		skipped += unchanged
+	}
+
+	markRecorded(selected, slices.Concat(appliedMigrations, appliedRepeatable))
+	return selected, skipped, nil
+}
+
+// limitToTarget unmarks the files following the target, nothing is left marked when the target has already been applied
+func limitToTarget(files []sqlFile, target string) error {
+	idx, err := targetIndex(files, target)
 	if err != nil {
 		return err
 	}
+	for i := idx + 1; i < len(files); i++ {
+		files[i].apply = false
+	}
+	return nil
+}
 
-	appliedIdx := 0
-	toBeApplied := 0
+// checkFrom fails unless the from file and all the files before it have been applied, a pending earlier file
+// would be skipped with --allow-out-of-order and reported as out of order otherwise
+func checkFrom(files []sqlFile, appliedMigrations []migration, from string) error {
+	idx := slices.IndexFunc(files, func(f sqlFile) bool { return f.path == from })
+	if idx == -1 {
+		return fmt.Errorf("from %s does not match any migration file", from)
+	}
+
+	applied := make(map[string]bool, len(appliedMigrations))
+	for _, m := range appliedMigrations {
+		applied[m.filePath] = true
+	}
+	for _, f := range files[:idx] {
+		if !applied[f.path] {
+			return fmt.Errorf("%w: %s precedes %s and is pending", ErrFromNotApplied, f.path, from)
+		}
+	}
+	if !applied[from] {
+		return fmt.Errorf("%w: %s is pending", ErrFromNotApplied, from)
+	}
+	return nil
+}
+
+// targetIndex returns the index of the file with the target path
+func targetIndex(files []sqlFile, target string) (int, error) {
 	for idx, f := range files {
-		if appliedIdx < len(appliedMigrations) {
-			m := appliedMigrations[appliedIdx]
-			appliedIdx++
+		if f.path == target {
+			return idx, nil
+		}
+	}
+	return 0, fmt.Errorf("target %s does not match any migration file", target)
+}
 
-			if m.filePath != f.path {
-				return fmt.Errorf("file %s has been moved since applied, %s", f.path, m.filePath)
-			}
+// markMigrations matches the files against the applied migrations and marks up to steps of the following files.
+// The applied migrations have to be the first files in the order they were applied, the migrations of a parallel
+// directory are recorded in the order they finished, so they are matched as a set. It returns the number of matched
+// applied migrations.
+func markMigrations(fsys fs.FS, files []sqlFile, appliedMigrations []migration, steps int, skipFileValidation bool) (int, error) {
+	onDisk := make(map[string]int, len(files))
+	for idx, f := range files {
+		onDisk[f.path] = idx
+	}
 
-			if m.fileHash != f.hash {
-				if cfg.SkipFileValidation() {
-					continue
-				}
+	matched := 0
+	toBeApplied := 0
+	for idx := 0; idx < len(files); {
+		end := batchEnd(files, idx)
+
+		// The next applied migrations belong to the batch as long as their files are in it
+		inBatch := make(map[int]bool)
+		for ; matched < len(appliedMigrations); matched++ {
+			m := appliedMigrations[matched]
+			i, ok := onDisk[m.filePath]
+			if !ok || i < idx || i >= end {
+				break
+			}
+			if err := validateAppliedFile(fsys, m, files[i], skipFileValidation); err != nil {
+				return 0, fmt.Errorf("%w %s", err, positionOf(files, i, appliedMigrations, matched))
+			}
+			inBatch[i] = true
+		}
 
-				return fmt.Errorf("file %s has changed", f.path)
+		for i := idx; i < end; i++ {
+			if inBatch[i] {
+				continue
 			}
+			// A file that is not matched while applied migrations are left is out of place, mismatchError tells why
+			if matched < len(appliedMigrations) {
+				return 0, mismatchError(fsys, files, i, appliedMigrations, matched)
+			}
+			if toBeApplied == steps {
+				return matched, nil
+			}
+
+			files[i].apply = true
+			toBeApplied++
+		}
+		idx = end
+	}
+
+	return matched, nil
+}
 
-			// if migration has already been applied, continue
+// batchEnd returns the index following the files matched together with the file at idx, the rest of its parallel
+// directory or only the file itself when it is serial
+func batchEnd(files []sqlFile, idx int) int {
+	end := idx + 1
+	if group := parallelGroup(files[idx].path); group != "" {
+		for end < len(files) && parallelGroup(files[end].path) == group {
+			end++
+		}
+	}
+	return end
+}
+
+// markMigrationsOutOfOrder marks up to steps files that have not been applied yet regardless of their position
+// relative to the applied ones, every applied migration has to be still present
+func markMigrationsOutOfOrder(fsys fs.FS, files []sqlFile, appliedMigrations []migration, steps int, skipFileValidation bool) (int, error) {
+	applied := make(map[string]migration, len(appliedMigrations))
+	for _, m := range appliedMigrations {
+		applied[m.filePath] = m
+	}
+
+	skipped := 0
+	toBeApplied := 0
+	for idx, f := range files {
+		if m, ok := applied[f.path]; ok {
+			if err := validateAppliedFile(fsys, m, f, skipFileValidation); err != nil {
+				return 0, err
+			}
+			delete(applied, f.path)
+			skipped++
 			continue
 		}
 
-		if toBeApplied == cfg.Steps() {
-			break
+		if toBeApplied == steps {
+			continue
 		}
 
 		files[idx].apply = true
 		toBeApplied++
 	}
 
+	for _, m := range appliedMigrations {
+		if _, ok := applied[m.filePath]; ok {
+			return 0, fmt.Errorf("file %s has been applied but is missing", m.filePath)
+		}
+	}
+
+	return skipped, nil
+}
+
+// validateAppliedFile checks that the applied migration file has not changed since it was applied
+func validateAppliedFile(fsys fs.FS, m migration, f sqlFile, skipFileValidation bool) error {
+	matches, err := hashMatches(m.fileHash, f, fsys)
+	if err != nil {
+		return err
+	}
+
+	if !matches && !skipFileValidation {
+		return fmt.Errorf("%w: file %s has changed since applied, restore its content or use repair to accept it", ErrFileChanged, f.path)
+	}
+
 	return nil
 }
 
-func applyMigrations(ctx context.Context, conn *pgx.Conn, rootDir string, files []sqlFile, cfg *config.Config, logger *zap.Logger) {
-	//goland:noinspection SqlResolve
-	insertExecutedMigrationSQL := `INSERT INTO public.clbs_dbtool_migrations (file_path, file_hash, app_id, clbs_dbtool_version) VALUES ($1, $2, $3, $4)`
+// applyMigrations executes the marked files and returns the number of applied and of failed best-effort migrations,
+// every migration runs in its own transaction together with its bookkeeping insert unless it opts out of it.
+// The batches of parallel files are executed concurrently through the pool when it is not nil
+func applyMigrations(ctx context.Context, conn *pgx.Conn, tr *tracker, reconnect reconnectFunc, pool *pgxpool.Pool, fsys fs.FS, files []sqlFile, cfg *config.Config, timings *migrationTimings, logger *zap.Logger) (int, int, error) {
+	applied, failed, reconnects := 0, 0, 0
+	// The migrations recorded when the connection was last replaced, nil before
+	var recorded map[string]string
+	started := false
+	for _, batch := range migrationBatches(files) {
+		// Do not start another migration once cancelled, the one in flight is rolled back by its transaction
+		if err := ctx.Err(); err != nil {
+			return applied, failed, fmt.Errorf("%w before %s: %w", ErrInterrupted, batch[0].path, err)
+		}
 
-	for _, f := range files {
-		if !f.apply {
+		if pool == nil || len(batch) == 1 {
+			for i := 0; i < len(batch); i++ {
+				f := batch[i]
+				if err := ctx.Err(); err != nil {
+					return applied, failed, fmt.Errorf("%w before %s: %w", ErrInterrupted, f.path, err)
+				}
+				if isRecorded(recorded, f) {
+					logger.Info("Migration recorded while reconnecting, skipping", zap.String("file", f.path))
+					continue
+				}
+				if started {
+					if err := pauseBetweenMigrations(ctx, conn, f.path, cfg, logger); err != nil {
+						return applied, failed, err
+					}
+				}
+				started = true
+				migrationFailed, err := applyMigrationOrContinue(ctx, conn, tr, fsys, f, cfg, timings, logger)
+				if err != nil && connectionLost(ctx, conn) && reconnect != nil {
+					if reconnects >= cfg.MaxReconnects() {
+						return applied, failed, reconnectsExhausted(cfg, err)
+					}
+					reconnects++
+					logger.Warn("Connection lost, reconnecting...", zap.String("file", f.path),
+						zap.Int("attempt", reconnects), zap.Int("max_reconnects", cfg.MaxReconnects()), zap.Error(err))
+
+					if conn, err = reconnect(ctx); err != nil {
+						return applied, failed, fmt.Errorf("error reconnecting after losing the connection in %s: %w", f.path, err)
+					}
+					if recorded, err = recordedMigrations(ctx, trackingConn(conn, tr), cfg); err != nil {
+						return applied, failed, fmt.Errorf("error reading applied migrations: %w", err)
+					}
+					// The commit of the migration in flight may have succeeded before the connection was lost
+					if isRecorded(recorded, f) {
+						logger.Info("Reconnected, the migration had been committed before the connection was lost", zap.String("file", f.path))
+						applied++
+					} else {
+						logger.Info("Reconnected, resuming with the migration", zap.String("file", f.path))
+						i--
+					}
+					continue
+				}
+				if err != nil {
+					return applied, failed, err
+				}
+				if migrationFailed {
+					failed++
+				} else {
+					applied++
+				}
+			}
 			continue
 		}
 
-		logger.Info("Running migration...", zap.String("file", f.path))
+		if started {
+			if err := pauseBetweenMigrations(ctx, conn, batch[0].path, cfg, logger); err != nil {
+				return applied, failed, err
+			}
+		}
+		started = true
+		n, nFailed, err := applyParallelBatch(ctx, pool, tr, fsys, batch, cfg, timings, logger)
+		applied += n
+		failed += nFailed
+		if err != nil {
+			return applied, failed, err
+		}
+	}
+
+	return applied, failed, nil
+}
+
+// gitCommitOrNil returns the commit dbtool was built from, nil when unknown so that NULL is stored
+func gitCommitOrNil(cfg *config.Config) *string {
+	commit := cfg.GitCommit()
+	if commit == "" {
+		return nil
+	}
+	return &commit
+}
+
+// appliedByOrNil returns the user or service account applying the migrations, nil when unknown so that NULL is stored
+func appliedByOrNil(cfg *config.Config) *string {
+	appliedBy := cfg.AppliedBy()
+	if appliedBy == "" {
+		return nil
+	}
+	return &appliedBy
+}
+
+// metadataOrNil returns the --metadata key-values as a JSON object, nil when none is set so that NULL is stored
+func metadataOrNil(cfg *config.Config) *string {
+	if len(cfg.Metadata()) == 0 {
+		return nil
+	}
+	// A map of strings always marshals, the keys are sorted
+	data, _ := json.Marshal(cfg.Metadata())
+	metadata := string(data)
+	return &metadata
+}
+
+// appliedAt returns the time recorded for a migration, taken in UTC by dbtool so it does not depend on the time zone
+// of the server or the session
+func appliedAt() time.Time {
+	return time.Now().UTC()
+}
+
+// txBeginner is implemented by both a single connection and a pool
+type txBeginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
 
-		fd, err := os.Open(filepath.Join(rootDir, f.path))
+// applyMigration executes the migration file and records it in the migrations table in one transaction,
+// with a tracker it is recorded in the tracking database once the migration has been committed. A migration with
+// the -- dbtool:no-transaction directive is executed outside of a transaction and recorded afterwards
+func applyMigration(ctx context.Context, db txBeginner, tr *tracker, fsys fs.FS, f sqlFile, cfg *config.Config, timings *migrationTimings, logger *zap.Logger) error {
+	//goland:noinspection SqlResolve
+	insertExecutedMigrationSQL := `INSERT INTO ` + dbtoolTable(cfg, migrationsTableName) + ` (file_path, file_hash, app_id, clbs_dbtool_version, duration_ms, sql_text, migrations_root, git_commit, applied_at, metadata, applied_by) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+
+	logger.Info("Running migration...", zap.String("file", f.path))
+
+	sql, err := readMigrationSQL(fsys, f.path, cfg)
+	if err != nil {
+		return err
+	}
+
+	var sqlText *string
+	if cfg.StoreSQL() {
+		text, err := storedSQLText(sql, cfg.StoreSQLCompressed())
 		if err != nil {
-			logger.Fatal("Could not open migration file", zap.Error(err))
+			return fmt.Errorf("error compressing SQL of %s: %w", f.path, err)
 		}
+		sqlText = &text
+	}
 
-		sql, err := readText(fd)
-		_ = fd.Close()
+	var duration time.Duration
+	var at time.Time
+	record := func(ctx context.Context, tx pgx.Tx) error {
+		args := []any{f.path, f.hash, cfg.AppId(), cfg.Version(), duration.Milliseconds(), sqlText, f.rootOrNil(), gitCommitOrNil(cfg), at, metadataOrNil(cfg), appliedByOrNil(cfg)}
+		var err error
+		if f.repeatable || f.changed {
+			err = recordRepeatableMigration(ctx, tx, dbtoolTable(cfg, migrationsTableName), insertExecutedMigrationSQL, args)
+		} else {
+			_, err = tx.Exec(ctx, insertExecutedMigrationSQL, args...)
+		}
 		if err != nil {
-			logger.Fatal("Could not read text from migration file", zap.Error(err))
+			return fmt.Errorf("error while updating dbtool migrations table: %w", err)
 		}
+		return nil
+	}
+	if f.noTransaction {
+		duration, err = executeWithoutTransaction(ctx, db, f, sql, cfg, logger)
+		at = appliedAt()
+	} else {
+		err = inRetriedMigrationTx(ctx, db, f.path, cfg.RetryOnConflict(), logger, func(tx pgx.Tx) error {
+			if err := setLocalSettings(ctx, tx, cfg); err != nil {
+				return err
+			}
+			if err := setRole(ctx, tx, f); err != nil {
+				return err
+			}
 
-		_, err = conn.Exec(ctx, sql)
+			// The hooks are neither part of the checksum nor of the stored SQL
+			if hook := cfg.BeforeEach(); hook != "" {
+				if _, err := tx.Exec(ctx, hook); err != nil {
+					return fmt.Errorf("error while executing the before-each hook of migration %s: %w", f.path, err)
+				}
+			}
+
+			start := time.Now()
+			err := executeMigration(ctx, tx, f.path, sql, cfg.SplitStatements(), sqlLogger(cfg, logger))
+			duration = time.Since(start)
+			if err != nil {
+				return err
+			}
+
+			if hook := cfg.AfterEach(); hook != "" {
+				if _, err := tx.Exec(ctx, hook); err != nil {
+					return fmt.Errorf("error while executing the after-each hook of migration %s: %w", f.path, err)
+				}
+			}
+			if err := resetRole(ctx, tx, f); err != nil {
+				return err
+			}
+
+			at = appliedAt()
+			if tr != nil {
+				return nil
+			}
+			return record(ctx, tx)
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	// The migration is committed, so recording it is not cancelled with the run
+	if tr != nil || f.noTransaction {
+		recordCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), recordTimeout)
+		defer cancel()
+		if err := inTrackingTx(recordCtx, db, tr, func(tx pgx.Tx) error { return record(recordCtx, tx) }); err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrNotRecorded, f.path, err)
+		}
+	}
+
+	timings.record(f.path, duration, at)
+	timings.recordSize(len(sql), countStatements(sql))
+	logger.Info("Migration applied", zap.String("file", f.path), zap.Duration("duration", duration))
+	return nil
+}
+
+// readMigrationSQL reads the text of the file in the configured encoding, resolves the includes and substitutes
+// the variables
+func readMigrationSQL(fsys fs.FS, filePath string, cfg *config.Config) (string, error) {
+	sql, err := readSQLText(fsys, filePath, cfg.Encoding())
+	if err != nil {
+		return "", err
+	}
+
+	// The hash stays the one of the raw file, so it does not depend on the environment nor on the included files
+	if cfg.ResolveIncludes() {
+		sql, err = resolveIncludes(fsys, filePath, sql, cfg.Encoding())
 		if err != nil {
-			logger.Fatal("Error while executing migration", zap.Error(err))
+			return "", err
 		}
+	}
+	if sql, err = checkMetaCommands(sql, filePath, cfg.StripMetaCommands()); err != nil {
+		return "", err
+	}
+	if vars := cfg.Vars(); len(vars) > 0 {
+		sql, err = substituteVars(sql, vars)
+		if err != nil {
+			return "", fmt.Errorf("error substituting variables in %s: %w", filePath, err)
+		}
+	}
+	return sql, nil
+}
+
+// readSQLText reads the text of the SQL file in the encoding
+func readSQLText(fsys fs.FS, filePath string, enc string) (string, error) {
+	fd, err := openMigrationFile(fsys, filePath)
+	if err != nil {
+		return "", fmt.Errorf("could not open migration file: %w", err)
+	}
 
-		_, err = conn.Exec(ctx, insertExecutedMigrationSQL, f.path, f.hash, cfg.AppId(), cfg.Version())
+	sql, err := readTextEncoded(fd, enc)
+	_ = fd.Close()
+	if err != nil {
+		return "", fmt.Errorf("could not read text from migration file: %w", err)
+	}
+	if err := checkText(sql); err != nil {
+		return "", fmt.Errorf("migration file %s: %w", filePath, err)
+	}
+	return sql, nil
+}
+
+// setLocalSettings applies the statement timeout and the search path to the transaction of a migration,
+// SET LOCAL lasts until the end of the transaction, so pooled connections are not affected
+func setLocalSettings(ctx context.Context, tx pgx.Tx, cfg *config.Config) error {
+	if timeout := cfg.StatementTimeout(); timeout > 0 {
+		if _, err := tx.Exec(ctx, setStatementTimeoutSQL(timeout)); err != nil {
+			return fmt.Errorf("error while setting statement_timeout: %w", err)
+		}
+	}
+	if searchPath := cfg.SearchPath(); len(searchPath) > 0 {
+		if _, err := tx.Exec(ctx, setSearchPathSQL(searchPath)); err != nil {
+			return fmt.Errorf("error while setting search_path: %w", err)
+		}
+	}
+	return nil
+}
+
+// setStatementTimeoutSQL returns the statement setting the statement_timeout for the current transaction
+func setStatementTimeoutSQL(timeout time.Duration) string {
+	return fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout.Milliseconds())
+}
+
+// setSearchPathSQL returns the statement setting the search_path for the current transaction,
+// the schemas have been validated as identifiers by the config
+func setSearchPathSQL(schemas []string) string {
+	return "SET LOCAL search_path TO " + strings.Join(schemas, ", ")
+}
+
+// compressedSQLPrefix marks a stored SQL text compressed with gzip and encoded with base64
+const compressedSQLPrefix = "gzip+base64:"
+
+// storedSQLText returns the executed SQL as stored in the sql_text column
+func storedSQLText(sql string, compress bool) (string, error) {
+	if !compress {
+		return sql, nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(sql)); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return compressedSQLPrefix + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// maxPrintedSQLLength is the number of characters of a statement logged with --print-sql
+const maxPrintedSQLLength = 2000
+
+// sqlLogger returns the logger of the executed SQL, nil unless --print-sql is set
+func sqlLogger(cfg *config.Config, logger *zap.Logger) *zap.Logger {
+	if !cfg.PrintSQL() {
+		return nil
+	}
+	return logger
+}
+
+// truncateSQL shortens a statement longer than maxPrintedSQLLength characters for the log
+func truncateSQL(sql string) string {
+	runes := []rune(sql)
+	if len(runes) <= maxPrintedSQLLength {
+		return sql
+	}
+	return fmt.Sprintf("%s... (truncated, %d characters in total)", string(runes[:maxPrintedSQLLength]), len(runes))
+}
+
+// executeMigration executes the SQL of the migration, either at once or statement by statement,
+// with a non-nil sqlLog every statement is logged before it is executed
+func executeMigration(ctx context.Context, tx execer, path string, sql string, split bool, sqlLog *zap.Logger) error {
+	if !split {
+		if sqlLog != nil {
+			sqlLog.Debug("Executing SQL", zap.String("file", path), zap.String("sql", truncateSQL(sql)))
+		}
+		_, err := tx.Exec(ctx, sql)
+		if err != nil {
+			return fmt.Errorf("error while executing migration %s: %w", path, err)
+		}
+		return nil
+	}
+
+	statements, err := splitStatements(sql)
+	if err != nil {
+		return fmt.Errorf("error while splitting migration %s into statements: %w", path, err)
+	}
+
+	for idx, statement := range statements {
+		if sqlLog != nil {
+			sqlLog.Debug("Executing SQL", zap.String("file", path), zap.Int("statement", idx+1), zap.String("sql", truncateSQL(statement)))
+		}
+		_, err = tx.Exec(ctx, statement)
 		if err != nil {
-			logger.Fatal("Error while updating dbtool migrations table, this may lead to inconsistent database state", zap.Error(err))
+			return fmt.Errorf("error while executing statement %d of migration %s: %w", idx+1, path, err)
 		}
 	}
+	return nil
+}
+
+// checkText fails on invalid UTF-8 and on NUL bytes, which usually mean a UTF-16 file without a BOM
+func checkText(text string) error {
+	for offset := 0; offset < len(text); {
+		r, size := utf8.DecodeRuneInString(text[offset:])
+		if r == utf8.RuneError && size == 1 {
+			return fmt.Errorf("%w: invalid byte sequence at byte offset %d", ErrInvalidText, offset)
+		}
+		if r == 0 {
+			return fmt.Errorf("%w: NUL byte at byte offset %d, the file may be UTF-16 encoded (see --encoding)", ErrInvalidText, offset)
+		}
+		offset += size
+	}
+	return nil
 }
 
 // readText reads the text from the reader and returns it as a string
 // it also handles the BOM (Byte Order Mark) at the beginning of the file
 func readText(reader io.Reader) (string, error) {
-	var transformer = unicode.BOMOverride(encoding.Nop.NewDecoder())
+	return readTextEncoded(reader, config.EncodingUTF8)
+}
+
+// readTextEncoded reads the text decoded from the encoding, a BOM overrides the encoding
+func readTextEncoded(reader io.Reader, enc string) (string, error) {
+	fallback := encoding.Nop.NewDecoder()
+	switch enc {
+	case config.EncodingUTF16LE:
+		fallback = unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder()
+	case config.EncodingUTF16BE:
+		fallback = unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewDecoder()
+	}
+
+	var transformer = unicode.BOMOverride(fallback)
 	tmp := &bytes.Buffer{}
 	_, err := tmp.ReadFrom(transform.NewReader(reader, transformer))
 	if err != nil {
@@ -357,14 +1563,19 @@ func readText(reader io.Reader) (string, error) {
 	return tmp.String(), nil
 }
 
-func prepareFiles(sqlFiles []sqlFile) {
+// prepareFiles sorts the files by their path segments, compared lexically or naturally with the sort mode
+func prepareFiles(sqlFiles []sqlFile, sortMode string) {
+	compare := strings.Compare
+	if sortMode == config.SortNatural {
+		compare = naturalCompare
+	}
 	cache := make(map[string][]string)
 
 	splitCached := func(s string) []string {
 		if t, ok := cache[s]; ok {
 			return t
 		}
-		t := strings.Split(s, string(os.PathSeparator))
+		t := strings.Split(s, "/")
 		cache[s] = t
 		return t
 	}
@@ -377,7 +1588,7 @@ func prepareFiles(sqlFiles []sqlFile) {
 
 		commonLen := min(li, lj)
 		for k := range commonLen {
-			c := strings.Compare(si[k], sj[k])
+			c := compare(si[k], sj[k])
 			if c != 0 {
 				return c
 			}
@@ -395,7 +1606,7 @@ func getLastSnapshot(sqlFiles *[]sqlFile) (bool, string) {
 	for idx, sqlfile := range slices.Backward(*sqlFiles) {
 		if sqlfile.isSnapshot {
 			if lastSnapshotIndex == -1 {
-				lastSnapshotDir = strings.SplitN(sqlfile.path, string(os.PathSeparator), 2)[0] + string(os.PathSeparator)
+				lastSnapshotDir = strings.SplitN(sqlfile.path, "/", 2)[0] + "/"
 			} else if !strings.HasPrefix(sqlfile.path, lastSnapshotDir) {
 				break
 			}
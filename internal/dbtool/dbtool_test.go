@@ -5,19 +5,33 @@
 package dbtool
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestOrder(t *testing.T) {
 	var sqlFiles []sqlFile
-	err := readDir(&sqlFiles, filepath.Join("..", "..", "testing", "samples", "test-dir"), "")
+	err := readDir(&sqlFiles, os.DirFS(filepath.Join("..", "..", "testing", "samples", "test-dir")), "", readDirOptions{maxDepth: unlimitedDepth, hashAlgorithm: config.HashSHA256})
 	assert.NoError(t, err)
 
-	prepareFiles(sqlFiles)
+	prepareFiles(sqlFiles, config.SortLexical)
 
 	ref := []string{
 		"subdir/0000001-init.sql",
@@ -46,10 +60,10 @@ func TestOrder(t *testing.T) {
 
 func TestSnapshots(t *testing.T) {
 	var sqlFiles []sqlFile
-	err := readDir(&sqlFiles, filepath.Join("..", "..", "testing", "samples", "test-dir"), "")
+	err := readDir(&sqlFiles, os.DirFS(filepath.Join("..", "..", "testing", "samples", "test-dir")), "", readDirOptions{maxDepth: unlimitedDepth, hashAlgorithm: config.HashSHA256})
 	assert.NoError(t, err)
 
-	prepareFiles(sqlFiles)
+	prepareFiles(sqlFiles, config.SortLexical)
 	getLastSnapshot(&sqlFiles)
 
 	ref := []string{
@@ -64,6 +78,95 @@ func TestSnapshots(t *testing.T) {
 	}
 }
 
+func TestReadDirMaxDepth(t *testing.T) {
+	testDir := os.DirFS(filepath.Join("..", "..", "testing", "samples", "test-dir"))
+
+	t.Run("Files nested deeper than allowed return error", func(t *testing.T) {
+		var sqlFiles []sqlFile
+		err := readDir(&sqlFiles, testDir, "", readDirOptions{maxDepth: 2, hashAlgorithm: config.HashSHA256})
+		assert.ErrorContains(t, err, "subdir3/subsubdir/tobuscus")
+	})
+
+	t.Run("Files within the limit are collected", func(t *testing.T) {
+		var sqlFiles []sqlFile
+		err := readDir(&sqlFiles, testDir, "", readDirOptions{maxDepth: 3, hashAlgorithm: config.HashSHA256})
+		assert.NoError(t, err)
+		assert.Len(t, sqlFiles, 16)
+	})
+
+	t.Run("Flat layout", func(t *testing.T) {
+		var sqlFiles []sqlFile
+		err := readDir(&sqlFiles, os.DirFS(filepath.Join("..", "..", "testing", "samples", "valid")), "", readDirOptions{maxDepth: 0, hashAlgorithm: config.HashSHA256})
+		assert.NoError(t, err)
+		assert.Len(t, sqlFiles, 1)
+
+		err = readDir(&sqlFiles, testDir, "", readDirOptions{maxDepth: 0, hashAlgorithm: config.HashSHA256})
+		assert.Error(t, err)
+	})
+}
+
+func TestReadDirIncludeExclude(t *testing.T) {
+	testDir := os.DirFS(filepath.Join("..", "..", "testing", "samples", "test-dir"))
+
+	read := func(t *testing.T, include []string, exclude []string) []string {
+		var sqlFiles []sqlFile
+		err := readDir(&sqlFiles, testDir, "", readDirOptions{maxDepth: unlimitedDepth, hashAlgorithm: config.HashSHA256, include: include, exclude: exclude})
+		assert.NoError(t, err)
+		prepareFiles(sqlFiles, config.SortLexical)
+
+		var paths []string
+		for _, f := range sqlFiles {
+			paths = append(paths, f.path)
+		}
+		return paths
+	}
+
+	t.Run("Include", func(t *testing.T) {
+		assert.Equal(t, []string{"subdir4/init1.sql", "subdir4/init2.sql", "subdir5/init1.sql", "subdir5/init2.sql"}, read(t, []string{"subdir4/*", "subdir5/*"}, nil))
+	})
+
+	t.Run("Exclude", func(t *testing.T) {
+		paths := read(t, nil, []string{"subdir/*", "subdir3/*/*", "subdir3/*/*/*"})
+		assert.Equal(t, []string{
+			"subdir2/eagle_has_landed.sql",
+			"subdir2/raymond-reddington.sql",
+			"subdir4/init1.sql",
+			"subdir4/init2.sql",
+			"subdir5/init1.sql",
+			"subdir5/init2.sql",
+			"subdir6/justanother.sql",
+		}, paths)
+	})
+
+	t.Run("Exclude takes precedence over include", func(t *testing.T) {
+		assert.Equal(t, []string{"subdir4/init2.sql"}, read(t, []string{"subdir4/*"}, []string{"*/init1.sql"}))
+	})
+}
+
+func TestReadDirFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"002-users.sql":         {Data: []byte("CREATE TABLE users (id INT);")},
+		"001-init.sql":          {Data: []byte("\xef\xbb\xbfCREATE TABLE init (id INT);")},
+		"README.md":             {Data: []byte("not a migration")},
+		"nested/003-orders.sql": {Data: []byte("CREATE TABLE orders (id INT);")},
+	}
+
+	var sqlFiles []sqlFile
+	err := readDir(&sqlFiles, fsys, "", readDirOptions{maxDepth: unlimitedDepth, hashAlgorithm: config.HashSHA256})
+	assert.NoError(t, err)
+	prepareFiles(sqlFiles, config.SortLexical)
+
+	var paths []string
+	for _, f := range sqlFiles {
+		paths = append(paths, f.path)
+	}
+	assert.Equal(t, []string{"001-init.sql", "002-users.sql", "nested/003-orders.sql"}, paths)
+
+	hash, err := getFileHash(fsys, "001-init.sql", config.HashSHA256)
+	assert.NoError(t, err)
+	assert.Equal(t, hash, sqlFiles[0].hash)
+}
+
 func TestGetFileType(t *testing.T) {
 	t.Run("Valid SQL filenames", func(t *testing.T) {
 		validNames := []string{
@@ -74,9 +177,10 @@ func TestGetFileType(t *testing.T) {
 			"0-start.sql",
 			"a.sql",
 			"test-123_abc.sql",
+			"002-seed.sql.gz",
 		}
 		for _, name := range validNames {
-			result := getFileType(name)
+			result := getFileType(name, nil)
 			assert.Equal(t, fileTypeSql, result, "Expected %s to be valid SQL file", name)
 		}
 	})
@@ -89,15 +193,24 @@ func TestGetFileType(t *testing.T) {
 			".sql",               // starts with dot
 			"test.SQL",           // uppercase extension
 			"test$.sql",          // special char
+			"test.gz",            // no .sql extension
+			"test.sql.zip",       // unsupported compression
 		}
 		for _, name := range invalidNames {
-			result := getFileType(name)
+			result := getFileType(name, nil)
 			assert.Equal(t, fileTypeUnknown, result, "Expected %s to be invalid", name)
 		}
 	})
 
+	t.Run("Custom pattern", func(t *testing.T) {
+		pattern := regexp.MustCompile(`^V[0-9.]+__[A-Za-z0-9_]+\.sql$`)
+		assert.Equal(t, fileTypeSql, getFileType("V2.1__init.sql", pattern))
+		assert.Equal(t, fileTypeUnknown, getFileType("001-init.sql", pattern))
+		assert.Equal(t, fileTypeSnapshot, getFileType(".snapshot", pattern))
+	})
+
 	t.Run("Snapshot file", func(t *testing.T) {
-		result := getFileType(".snapshot")
+		result := getFileType(".snapshot", nil)
 		assert.Equal(t, fileTypeSnapshot, result)
 	})
 
@@ -109,34 +222,112 @@ func TestGetFileType(t *testing.T) {
 			"data.csv",
 		}
 		for _, name := range nonSqlFiles {
-			result := getFileType(name)
+			result := getFileType(name, nil)
 			assert.Equal(t, fileTypeUnknown, result, "Expected %s to be unknown type", name)
 		}
 	})
 }
 
 func TestGetFileHash(t *testing.T) {
+	validDir := os.DirFS(filepath.Join("..", "..", "testing", "samples", "valid"))
+
 	t.Run("Hash of existing file", func(t *testing.T) {
-		testFile := filepath.Join("..", "..", "testing", "samples", "valid", "001_valid.sql")
-		hash, err := getFileHash(testFile)
+		hash, err := getFileHash(validDir, "001_valid.sql", config.HashSHA256)
 		assert.NoError(t, err)
 		assert.NotEmpty(t, hash)
 		assert.Len(t, hash, 64) // SHA256 produces 64 hex characters
 	})
 
 	t.Run("Hash is consistent", func(t *testing.T) {
-		testFile := filepath.Join("..", "..", "testing", "samples", "valid", "001_valid.sql")
-		hash1, err1 := getFileHash(testFile)
-		hash2, err2 := getFileHash(testFile)
+		hash1, err1 := getFileHash(validDir, "001_valid.sql", config.HashSHA256)
+		hash2, err2 := getFileHash(validDir, "001_valid.sql", config.HashSHA256)
 		assert.NoError(t, err1)
 		assert.NoError(t, err2)
 		assert.Equal(t, hash1, hash2)
 	})
 
 	t.Run("Non-existent file returns error", func(t *testing.T) {
-		_, err := getFileHash("/non/existent/file.sql")
+		_, err := getFileHash(validDir, "non/existent/file.sql", config.HashSHA256)
+		assert.Error(t, err)
+	})
+
+	t.Run("Compressed file hashes the decompressed contents", func(t *testing.T) {
+		sql := []byte("INSERT INTO seed VALUES (1);\n")
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		_, err := zw.Write(sql)
+		assert.NoError(t, err)
+		assert.NoError(t, zw.Close())
+
+		fsys := fstest.MapFS{
+			"001-seed.sql":    {Data: sql},
+			"002-seed.sql.gz": {Data: compressed.Bytes()},
+			"003-bad.sql.gz":  {Data: sql},
+		}
+		plain, err := getFileHash(fsys, "001-seed.sql", config.HashSHA256)
+		assert.NoError(t, err)
+		gzipped, err := getFileHash(fsys, "002-seed.sql.gz", config.HashSHA256)
+		assert.NoError(t, err)
+		assert.Equal(t, plain, gzipped)
+
+		_, err = getFileHash(fsys, "003-bad.sql.gz", config.HashSHA256)
+		assert.ErrorContains(t, err, "could not decompress 003-bad.sql.gz")
+
+		var files []sqlFile
+		assert.NoError(t, readDir(&files, fstest.MapFS{
+			"001-seed.sql":    {Data: sql},
+			"002-seed.sql.gz": {Data: compressed.Bytes()},
+		}, "", readDirOptions{maxDepth: unlimitedDepth, hashAlgorithm: config.HashSHA256}))
+		assert.Len(t, files, 2)
+	})
+}
+
+func TestHashAlgorithms(t *testing.T) {
+	validDir := os.DirFS(filepath.Join("..", "..", "testing", "samples", "valid"))
+
+	t.Run("Digest length and prefix", func(t *testing.T) {
+		sha256Hash, err := getFileHash(validDir, "001_valid.sql", config.HashSHA256)
+		assert.NoError(t, err)
+		assert.Len(t, sha256Hash, 64)
+		assert.Equal(t, config.HashSHA256, hashAlgorithmOf(sha256Hash))
+
+		sha512Hash, err := getFileHash(validDir, "001_valid.sql", config.HashSHA512)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(sha512Hash, "sha512:"))
+		assert.Len(t, sha512Hash, len("sha512:")+128)
+		assert.Equal(t, config.HashSHA512, hashAlgorithmOf(sha512Hash))
+
+		blake2bHash, err := getFileHash(validDir, "001_valid.sql", config.HashBLAKE2b)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(blake2bHash, "blake2b:"))
+		assert.Len(t, blake2bHash, len("blake2b:")+128)
+	})
+
+	t.Run("Unsupported algorithm", func(t *testing.T) {
+		_, err := getFileHash(validDir, "001_valid.sql", "md5")
 		assert.Error(t, err)
 	})
+
+	t.Run("Stored hash is compared using its own algorithm", func(t *testing.T) {
+		sha256Hash, err := getFileHash(validDir, "001_valid.sql", config.HashSHA256)
+		assert.NoError(t, err)
+		sha512Hash, err := getFileHash(validDir, "001_valid.sql", config.HashSHA512)
+		assert.NoError(t, err)
+
+		f := sqlFile{path: "001_valid.sql", hash: sha512Hash}
+
+		matches, err := hashMatches(sha256Hash, f, validDir)
+		assert.NoError(t, err)
+		assert.True(t, matches)
+
+		matches, err = hashMatches(sha512Hash, f, validDir)
+		assert.NoError(t, err)
+		assert.True(t, matches)
+
+		matches, err = hashMatches("0000", f, validDir)
+		assert.NoError(t, err)
+		assert.False(t, matches)
+	})
 }
 
 func TestReadText(t *testing.T) {
@@ -165,6 +356,34 @@ func TestReadText(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, content, result)
 	})
+
+	t.Run("Read UTF-16 with BOM", func(t *testing.T) {
+		result, err := readText(strings.NewReader("\xFF\xFES\x00E\x00L\x00"))
+		assert.NoError(t, err)
+		assert.Equal(t, "SEL", result)
+	})
+
+	t.Run("Read forced UTF-16 without BOM", func(t *testing.T) {
+		result, err := readTextEncoded(strings.NewReader("S\x00E\x00L\x00"), config.EncodingUTF16LE)
+		assert.NoError(t, err)
+		assert.Equal(t, "SEL", result)
+
+		result, err = readTextEncoded(strings.NewReader("\x00S\x00E\x00L"), config.EncodingUTF16BE)
+		assert.NoError(t, err)
+		assert.Equal(t, "SEL", result)
+	})
+}
+
+func TestCheckText(t *testing.T) {
+	assert.NoError(t, checkText("SELECT 'žluťoučký kůň';"))
+
+	err := checkText("SELECT '\xC3\x28';")
+	assert.ErrorIs(t, err, ErrInvalidText)
+	assert.ErrorContains(t, err, "invalid byte sequence at byte offset 8")
+
+	err = checkText("S\x00E\x00L\x00")
+	assert.ErrorIs(t, err, ErrInvalidText)
+	assert.ErrorContains(t, err, "NUL byte at byte offset 1")
 }
 
 func TestPrepareFiles(t *testing.T) {
@@ -174,7 +393,7 @@ func TestPrepareFiles(t *testing.T) {
 			{path: "a/file.sql"},
 			{path: "m/file.sql"},
 		}
-		prepareFiles(files)
+		prepareFiles(files, config.SortLexical)
 		assert.Equal(t, "a/file.sql", files[0].path)
 		assert.Equal(t, "m/file.sql", files[1].path)
 		assert.Equal(t, "z/file.sql", files[2].path)
@@ -186,7 +405,7 @@ func TestPrepareFiles(t *testing.T) {
 			{path: "a/b/file.sql"},
 			{path: "a/file.sql"},
 		}
-		prepareFiles(files)
+		prepareFiles(files, config.SortLexical)
 		// The sorting algorithm puts deeper paths first (directories before parent files)
 		assert.Equal(t, "a/b/c/file.sql", files[0].path)
 		assert.Equal(t, "a/b/file.sql", files[1].path)
@@ -218,4 +437,402 @@ func TestGetLastSnapshot(t *testing.T) {
 		assert.Equal(t, "b/", dir)
 		assert.Len(t, files, 3) // b/file2.sql, b/file3.sql, c/file4.sql
 	})
+
+	t.Run("Last of several snapshot directories wins", func(t *testing.T) {
+		files := []sqlFile{
+			{path: "v1/file1.sql", isSnapshot: true},
+			{path: "v2/file2.sql", isSnapshot: false},
+			{path: "v3/sub/file3.sql", isSnapshot: true},
+			{path: "v3/file4.sql", isSnapshot: true},
+			{path: "v4/file5.sql", isSnapshot: false},
+		}
+		detected, dir := getLastSnapshot(&files)
+		assert.True(t, detected)
+		assert.Equal(t, "v3/", dir)
+		assert.Equal(t, []sqlFile{
+			{path: "v3/sub/file3.sql", isSnapshot: true},
+			{path: "v3/file4.sql", isSnapshot: true},
+			{path: "v4/file5.sql", isSnapshot: false},
+		}, files)
+	})
+}
+
+func TestMarkMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001-init.sql":   {Data: []byte("CREATE TABLE init (id INT);")},
+		"002-users.sql":  {Data: []byte("CREATE TABLE users (id INT);")},
+		"003-orders.sql": {Data: []byte("CREATE TABLE orders (id INT);")},
+		"004-items.sql":  {Data: []byte("CREATE TABLE items (id INT);")},
+	}
+
+	files := func(t *testing.T) []sqlFile {
+		var sqlFiles []sqlFile
+		assert.NoError(t, readDir(&sqlFiles, fsys, "", readDirOptions{maxDepth: unlimitedDepth, hashAlgorithm: config.HashSHA256}))
+		prepareFiles(sqlFiles, config.SortLexical)
+		return sqlFiles
+	}
+
+	applied := func(t *testing.T, paths ...string) []migration {
+		var result []migration
+		for _, p := range paths {
+			hash, err := getFileHash(fsys, p, config.HashSHA256)
+			assert.NoError(t, err)
+			result = append(result, migration{filePath: p, fileHash: hash})
+		}
+		return result
+	}
+
+	marked := func(files []sqlFile) []string {
+		var result []string
+		for _, f := range files {
+			if f.apply {
+				result = append(result, f.path)
+			}
+		}
+		return result
+	}
+
+	t.Run("Strict", func(t *testing.T) {
+		sqlFiles := files(t)
+		skipped, err := markMigrations(fsys, sqlFiles, applied(t, "001-init.sql", "002-users.sql"), -1, false)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, skipped)
+		assert.Equal(t, []string{"003-orders.sql", "004-items.sql"}, marked(sqlFiles))
+	})
+
+	t.Run("Strict with steps", func(t *testing.T) {
+		sqlFiles := files(t)
+		_, err := markMigrations(fsys, sqlFiles, applied(t, "001-init.sql"), 1, false)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"002-users.sql"}, marked(sqlFiles))
+	})
+
+	t.Run("Zero steps marks nothing", func(t *testing.T) {
+		sqlFiles := files(t)
+		skipped, err := markMigrations(fsys, sqlFiles, applied(t, "001-init.sql"), 0, false)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, skipped)
+		assert.Empty(t, marked(sqlFiles))
+
+		sqlFiles = files(t)
+		_, err = markMigrationsOutOfOrder(fsys, sqlFiles, applied(t, "003-orders.sql"), 0, false)
+		assert.NoError(t, err)
+		assert.Empty(t, marked(sqlFiles))
+	})
+
+	t.Run("Strict rejects a gap", func(t *testing.T) {
+		_, err := markMigrations(fsys, files(t), applied(t, "001-init.sql", "003-orders.sql"), -1, false)
+		assert.ErrorContains(t, err, "has been moved since applied")
+	})
+
+	t.Run("Changed file", func(t *testing.T) {
+		m := applied(t, "001-init.sql")
+		m[0].fileHash = "0000"
+		_, err := markMigrations(fsys, files(t), m, -1, false)
+		assert.ErrorContains(t, err, "file 001-init.sql has changed")
+
+		_, err = markMigrations(fsys, files(t), m, -1, true)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Out of order fills a gap", func(t *testing.T) {
+		sqlFiles := files(t)
+		skipped, err := markMigrationsOutOfOrder(fsys, sqlFiles, applied(t, "001-init.sql", "003-orders.sql"), -1, false)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, skipped)
+		assert.Equal(t, []string{"002-users.sql", "004-items.sql"}, marked(sqlFiles))
+	})
+
+	t.Run("Out of order with steps", func(t *testing.T) {
+		sqlFiles := files(t)
+		_, err := markMigrationsOutOfOrder(fsys, sqlFiles, applied(t, "003-orders.sql"), 2, false)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"001-init.sql", "002-users.sql"}, marked(sqlFiles))
+	})
+
+	t.Run("Out of order rejects a missing applied file", func(t *testing.T) {
+		m := append(applied(t, "001-init.sql"), migration{filePath: "000-removed.sql", fileHash: "0000"})
+		_, err := markMigrationsOutOfOrder(fsys, files(t), m, -1, false)
+		assert.ErrorContains(t, err, "file 000-removed.sql has been applied but is missing")
+	})
+
+	t.Run("Out of order validates hashes", func(t *testing.T) {
+		m := applied(t, "002-users.sql")
+		m[0].fileHash = "0000"
+		_, err := markMigrationsOutOfOrder(fsys, files(t), m, -1, false)
+		assert.ErrorContains(t, err, "file 002-users.sql has changed")
+	})
+
+	t.Run("Target", func(t *testing.T) {
+		sqlFiles := files(t)
+		_, err := markMigrations(fsys, sqlFiles, applied(t, "001-init.sql"), -1, false)
+		assert.NoError(t, err)
+		assert.NoError(t, limitToTarget(sqlFiles, "003-orders.sql"))
+		assert.Equal(t, []string{"002-users.sql", "003-orders.sql"}, marked(sqlFiles))
+	})
+
+	t.Run("Target already applied", func(t *testing.T) {
+		sqlFiles := files(t)
+		_, err := markMigrations(fsys, sqlFiles, applied(t, "001-init.sql", "002-users.sql"), -1, false)
+		assert.NoError(t, err)
+		assert.NoError(t, limitToTarget(sqlFiles, "001-init.sql"))
+		assert.Empty(t, marked(sqlFiles))
+	})
+
+	t.Run("Unknown target", func(t *testing.T) {
+		err := limitToTarget(files(t), "005-missing.sql")
+		assert.ErrorContains(t, err, "target 005-missing.sql does not match any migration file")
+	})
+
+	t.Run("Steps from an applied file", func(t *testing.T) {
+		sqlFiles := files(t)
+		m := applied(t, "001-init.sql", "002-users.sql")
+		assert.NoError(t, checkFrom(sqlFiles, m, "002-users.sql"))
+		_, err := markMigrations(fsys, sqlFiles, m, 1, false)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"003-orders.sql"}, marked(sqlFiles))
+	})
+
+	t.Run("From a pending file", func(t *testing.T) {
+		err := checkFrom(files(t), applied(t, "001-init.sql"), "002-users.sql")
+		assert.ErrorIs(t, err, ErrFromNotApplied)
+		assert.ErrorContains(t, err, "002-users.sql is pending")
+	})
+
+	t.Run("From after a pending file", func(t *testing.T) {
+		err := checkFrom(files(t), applied(t, "001-init.sql", "003-orders.sql"), "003-orders.sql")
+		assert.ErrorIs(t, err, ErrFromNotApplied)
+		assert.ErrorContains(t, err, "002-users.sql precedes 003-orders.sql and is pending")
+	})
+
+	t.Run("Unknown from", func(t *testing.T) {
+		err := checkFrom(files(t), applied(t, "001-init.sql"), "005-missing.sql")
+		assert.ErrorContains(t, err, "from 005-missing.sql does not match any migration file")
+	})
+}
+
+func TestStoredSQLText(t *testing.T) {
+	sql := "CREATE TABLE users (id INT);\n"
+
+	t.Run("Plain", func(t *testing.T) {
+		text, err := storedSQLText(sql, false)
+		assert.NoError(t, err)
+		assert.Equal(t, sql, text)
+	})
+
+	t.Run("Compressed", func(t *testing.T) {
+		text, err := storedSQLText(sql, true)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(text, compressedSQLPrefix))
+
+		data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(text, compressedSQLPrefix))
+		assert.NoError(t, err)
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		assert.NoError(t, err)
+		decompressed, err := io.ReadAll(zr)
+		assert.NoError(t, err)
+		assert.Equal(t, sql, string(decompressed))
+	})
+}
+
+func TestSetSearchPathSQL(t *testing.T) {
+	assert.Equal(t, "SET LOCAL search_path TO app", setSearchPathSQL([]string{"app"}))
+	assert.Equal(t, `SET LOCAL search_path TO app, "$user", public`, setSearchPathSQL([]string{"app", `"$user"`, "public"}))
+}
+
+func TestApplyMigrationsCancelled(t *testing.T) {
+	files := []sqlFile{
+		{path: "001-init.sql", apply: true},
+		{path: "002-users.sql", apply: true},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	applied, failed, err := applyMigrations(ctx, nil, nil, nil, nil, fstest.MapFS{}, files, nil, nil, zap.NewNop())
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, err, ErrInterrupted)
+	assert.ErrorContains(t, err, "migrations interrupted before 001-init.sql")
+	assert.Equal(t, 0, applied)
+	assert.Equal(t, 0, failed)
+}
+
+func TestCheckUniqueBasenames(t *testing.T) {
+	t.Run("Unique", func(t *testing.T) {
+		files := []sqlFile{{path: "001-init.sql"}, {path: "v2/002-users.sql"}}
+		assert.NoError(t, checkUniqueBasenames(files))
+	})
+
+	t.Run("Duplicates", func(t *testing.T) {
+		files := []sqlFile{
+			{path: "other/001.sql"},
+			{path: "subdir/001.sql"},
+			{path: "subdir/002.sql"},
+			{path: "v2/002.sql"},
+			{path: "v2/003.sql"},
+		}
+		err := checkUniqueBasenames(files)
+		assert.ErrorIs(t, err, ErrDuplicateBasename)
+		assert.EqualError(t, err, "SQL files share the same base name: 001.sql (other/001.sql, subdir/001.sql); 002.sql (subdir/002.sql, v2/002.sql)")
+	})
+}
+
+func TestTruncateSQL(t *testing.T) {
+	t.Run("Short statement is kept", func(t *testing.T) {
+		assert.Equal(t, "SELECT 1", truncateSQL("SELECT 1"))
+	})
+
+	t.Run("Long statement is truncated", func(t *testing.T) {
+		sql := strings.Repeat("é", maxPrintedSQLLength+5)
+		assert.Equal(t, strings.Repeat("é", maxPrintedSQLLength)+"... (truncated, 2005 characters in total)", truncateSQL(sql))
+	})
+}
+
+func TestGitCommitOrNil(t *testing.T) {
+	cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db"})
+	assert.NoError(t, err)
+	assert.Nil(t, gitCommitOrNil(cfg))
+
+	cfg, err = config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db", GitCommit: "0123abc"})
+	assert.NoError(t, err)
+	assert.Equal(t, "0123abc", *gitCommitOrNil(cfg))
+}
+
+func TestCheckPathLengths(t *testing.T) {
+	assert.NoError(t, checkPathLengths([]sqlFile{{path: strings.Repeat("a", config.MaxFilePathLength)}}))
+	assert.ErrorIs(t, checkPathLengths([]sqlFile{{path: "001.sql", root: strings.Repeat("a", config.MaxFilePathLength+1)}}), ErrPathTooLong)
+	assert.ErrorIs(t, checkPathLengths([]sqlFile{{path: strings.Repeat("a", config.MaxFilePathLength+1)}}), ErrPathTooLong)
+}
+
+func TestMigrationTableColumnWidths(t *testing.T) {
+	create := createMigrationTableSQL(pgx.Identifier{defaultSchema, migrationsTableName}.Sanitize())
+	assert.Contains(t, create, fmt.Sprintf("app_id VARCHAR(%d)", config.MaxAppIdLength))
+	assert.Contains(t, create, fmt.Sprintf("file_path VARCHAR(%d)", config.MaxFilePathLength))
+	for _, create := range []string{createLockTableSQL("lock"), createStatusTableSQL("status"), createSchemaStateTableSQL("state")} {
+		assert.Contains(t, create, fmt.Sprintf("app_id VARCHAR(%d)", config.MaxAppIdLength))
+	}
+}
+
+func TestMetadataOrNil(t *testing.T) {
+	cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db"})
+	assert.NoError(t, err)
+	assert.Nil(t, metadataOrNil(cfg))
+
+	cfg, err = config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db", Metadata: map[string]string{"pipeline_id": "1234", "branch": "main"}})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"branch": "main", "pipeline_id": "1234"}`, *metadataOrNil(cfg))
+}
+
+func TestAppliedByOrNil(t *testing.T) {
+	cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db", AppliedBy: "ci-pipeline"})
+	assert.NoError(t, err)
+	assert.Equal(t, "ci-pipeline", *appliedByOrNil(cfg))
+}
+
+func TestSetLocalSettings(t *testing.T) {
+	t.Run("Nothing set", func(t *testing.T) {
+		cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db"})
+		assert.NoError(t, err)
+		tx := &fakeTx{}
+		assert.NoError(t, setLocalSettings(context.Background(), tx, cfg))
+		assert.Empty(t, tx.executed)
+	})
+
+	t.Run("Statement timeout and search path", func(t *testing.T) {
+		cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db", StatementTimeout: 90 * time.Second, SearchPath: []string{"app"}})
+		assert.NoError(t, err)
+		tx := &fakeTx{}
+		assert.NoError(t, setLocalSettings(context.Background(), tx, cfg))
+		assert.Equal(t, []string{"SET LOCAL statement_timeout = 90000", "SET LOCAL search_path TO app"}, tx.executed)
+	})
+}
+
+func TestAppliedAt(t *testing.T) {
+	before := time.Now()
+	at := appliedAt()
+	assert.Equal(t, time.UTC, at.Location())
+	assert.WithinRange(t, at, before, time.Now())
+}
+
+func TestMigrationTableAppliedAtWithTimeZone(t *testing.T) {
+	upgrade := upgradeMigrationTableSQL(defaultSchema)
+	assert.Contains(t, createMigrationTableSQL(pgx.Identifier{defaultSchema, migrationsTableName}.Sanitize()), "applied_at TIMESTAMPTZ")
+	assert.Contains(t, upgrade[len(upgrade)-1], "ALTER COLUMN applied_at TYPE TIMESTAMPTZ")
+}
+
+func TestSetApplicationName(t *testing.T) {
+	newConfig := func(t *testing.T, connectionString string, applicationName string) *config.Config {
+		cfg, err := config.New(config.Options{Version: "v1.2.3", AppId: "app", WithoutDir: true, ConnectionString: connectionString, ApplicationName: applicationName})
+		assert.NoError(t, err)
+		return cfg
+	}
+
+	applicationName := func(t *testing.T, cfg *config.Config) string {
+		connConfig, err := pgx.ParseConfig(cfg.ConnectionString())
+		assert.NoError(t, err)
+		setApplicationName(connConfig, cfg)
+		return connConfig.RuntimeParams["application_name"]
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		assert.Equal(t, "clbs-dbtool/v1.2.3/app", applicationName(t, newConfig(t, "postgres://localhost/db", "")))
+	})
+
+	t.Run("Connection string", func(t *testing.T) {
+		assert.Equal(t, "custom", applicationName(t, newConfig(t, "postgres://localhost/db?application_name=custom", "")))
+	})
+
+	t.Run("Flag overrides the connection string", func(t *testing.T) {
+		assert.Equal(t, "override", applicationName(t, newConfig(t, "postgres://localhost/db?application_name=custom", "override")))
+	})
+}
+
+func TestDiscoverFiles_Empty(t *testing.T) {
+	dir := t.TempDir()
+	fsys := fstest.MapFS{"README.md": {Data: []byte("no migrations yet")}}
+
+	t.Run("Warning", func(t *testing.T) {
+		cfg, err := config.New(config.Options{AppId: "app", Dir: dir, ConnectionString: "postgres://localhost/db"})
+		assert.NoError(t, err)
+
+		core, logs := observer.New(zap.WarnLevel)
+		files, err := discoverFiles(fsys, cfg, zap.New(core))
+		assert.NoError(t, err)
+		assert.Empty(t, files)
+		assert.Equal(t, 1, logs.FilterMessage("No SQL files found, check the migrations directory").Len())
+	})
+
+	t.Run("Fail on empty", func(t *testing.T) {
+		cfg, err := config.New(config.Options{AppId: "app", Dir: dir, ConnectionString: "postgres://localhost/db", FailOnEmpty: true})
+		assert.NoError(t, err)
+
+		_, err = discoverFiles(fsys, cfg, zap.NewNop())
+		assert.ErrorIs(t, err, ErrNoMigrationFiles)
+		assert.EqualError(t, err, "no SQL files found in "+dir)
+
+		cfg, err = config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db", FailOnEmpty: true})
+		assert.NoError(t, err)
+		_, err = discoverFiles(fsys, cfg, zap.NewNop())
+		assert.EqualError(t, err, "no SQL files found")
+	})
+
+	t.Run("Files found", func(t *testing.T) {
+		cfg, err := config.New(config.Options{AppId: "app", Dir: dir, ConnectionString: "postgres://localhost/db", FailOnEmpty: true})
+		assert.NoError(t, err)
+
+		files, err := discoverFiles(fstest.MapFS{"001-init.sql": {Data: []byte("SELECT 1;")}}, cfg, zap.NewNop())
+		assert.NoError(t, err)
+		assert.Len(t, files, 1)
+	})
+}
+
+func TestRun_ReturnsErrors(t *testing.T) {
+	// Run never exits the process, the failures before connecting are returned as well
+	dir := t.TempDir()
+	cfg, err := config.New(config.Options{AppId: "app", Dir: dir, ConnectionString: "postgres://localhost/db", FailOnEmpty: true})
+	assert.NoError(t, err)
+
+	result, err := Run(context.Background(), zap.NewNop(), cfg)
+	assert.ErrorIs(t, err, ErrNoMigrationFiles)
+	assert.Equal(t, Result{}, result)
 }
@@ -0,0 +1,172 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+var ErrSchemaDrift = errors.New("schema differs from the snapshot")
+
+const schemaSnapshotHeader = "# clbs-dbtool schema snapshot, written by dbtool drift --update-schema-snapshot"
+
+// dumpSchemaSQL returns one line per schema object outside the system schemas, the tables of dbtool itself
+// and the objects of extensions are left out, function bodies are represented by their md5
+const dumpSchemaSQL = `
+	WITH user_schemas AS (
+		SELECT oid, nspname FROM pg_catalog.pg_namespace
+		WHERE nspname NOT IN ('pg_catalog', 'information_schema') AND nspname NOT LIKE 'pg\_toast%' AND nspname NOT LIKE 'pg\_temp\_%'
+	), rels AS (
+		SELECT c.oid, c.relname, c.relkind, n.nspname FROM pg_catalog.pg_class c JOIN user_schemas n ON n.oid = c.relnamespace
+		WHERE c.relname NOT LIKE 'clbs\_dbtool\_%'
+			AND NOT EXISTS (SELECT 1 FROM pg_catalog.pg_depend d WHERE d.objid = c.oid AND d.deptype = 'e')
+	)
+	SELECT line FROM (
+		SELECT format('schema %I', nspname) AS line FROM user_schemas
+		UNION ALL
+		SELECT format('table %I.%I', nspname, relname) FROM rels WHERE relkind IN ('r', 'p', 'f')
+		UNION ALL
+		SELECT format('column %I.%I.%I %s%s%s', r.nspname, r.relname, a.attname, pg_catalog.format_type(a.atttypid, a.atttypmod),
+			CASE WHEN a.attnotnull THEN ' NOT NULL' ELSE '' END, COALESCE(' DEFAULT ' || pg_catalog.pg_get_expr(d.adbin, d.adrelid), ''))
+		FROM pg_catalog.pg_attribute a JOIN rels r ON r.oid = a.attrelid
+		LEFT JOIN pg_catalog.pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE r.relkind IN ('r', 'p', 'f', 'v', 'm') AND a.attnum > 0 AND NOT a.attisdropped
+		UNION ALL
+		SELECT format('constraint %I.%I.%I %s', r.nspname, r.relname, con.conname, pg_catalog.pg_get_constraintdef(con.oid))
+		FROM pg_catalog.pg_constraint con JOIN rels r ON r.oid = con.conrelid
+		UNION ALL
+		SELECT format('index %s', pg_catalog.pg_get_indexdef(i.indexrelid)) FROM pg_catalog.pg_index i JOIN rels r ON r.oid = i.indrelid
+		UNION ALL
+		SELECT format('view %I.%I %s', nspname, relname, regexp_replace(pg_catalog.pg_get_viewdef(oid), '\s+', ' ', 'g'))
+		FROM rels WHERE relkind IN ('v', 'm')
+		UNION ALL
+		SELECT format('sequence %I.%I', nspname, relname) FROM rels WHERE relkind = 'S'
+		UNION ALL
+		SELECT format('trigger %I.%I.%I %s', r.nspname, r.relname, t.tgname, pg_catalog.pg_get_triggerdef(t.oid))
+		FROM pg_catalog.pg_trigger t JOIN rels r ON r.oid = t.tgrelid WHERE NOT t.tgisinternal
+		UNION ALL
+		SELECT format('function %I.%I(%s) %s', n.nspname, p.proname, pg_catalog.pg_get_function_identity_arguments(p.oid), md5(pg_catalog.pg_get_functiondef(p.oid)))
+		FROM pg_catalog.pg_proc p JOIN user_schemas n ON n.oid = p.pronamespace
+		WHERE p.prokind IN ('f', 'p') AND NOT EXISTS (SELECT 1 FROM pg_catalog.pg_depend d WHERE d.objid = p.oid AND d.deptype = 'e')
+	) objects ORDER BY line`
+
+// Drift compares the live schema against the snapshot file and logs every difference, it fails with --fail-on-drift,
+// with --update-schema-snapshot the live schema is written to the snapshot file instead
+func Drift(ctx context.Context, logger *zap.Logger, cfg *config.Config) (err error) {
+	conn, err := connect(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer closeConnection(ctx, conn, &err)
+
+	actual, err := dumpSchema(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("error reading schema: %w", err)
+	}
+
+	if cfg.UpdateSchemaSnapshot() {
+		if err := writeSchemaSnapshot(cfg.SchemaSnapshot(), actual); err != nil {
+			return fmt.Errorf("error writing schema snapshot: %w", err)
+		}
+		logger.Info("Schema snapshot written", zap.String("path", cfg.SchemaSnapshot()), zap.Int("objects", len(actual)))
+		return nil
+	}
+
+	expected, err := readSchemaSnapshot(cfg.SchemaSnapshot())
+	if err != nil {
+		return fmt.Errorf("error reading schema snapshot: %w", err)
+	}
+
+	missing, unexpected := diffSchema(expected, actual)
+
+	log := logger.Warn
+	if cfg.FailOnDrift() {
+		log = logger.Error
+	}
+	for _, line := range missing {
+		log("Schema drift, object missing in the database", zap.String("object", line))
+	}
+	for _, line := range unexpected {
+		log("Schema drift, object not in the snapshot", zap.String("object", line))
+	}
+
+	if len(missing) == 0 && len(unexpected) == 0 {
+		logger.Info("No schema drift", zap.Int("objects", len(actual)))
+		return nil
+	}
+	if cfg.FailOnDrift() {
+		return fmt.Errorf("%w: %d missing, %d not in the snapshot", ErrSchemaDrift, len(missing), len(unexpected))
+	}
+	return nil
+}
+
+// dumpSchema returns the sorted lines describing the schema objects
+func dumpSchema(ctx context.Context, conn *pgx.Conn) ([]string, error) {
+	rows, err := conn.Query(ctx, dumpSchemaSQL)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// readSchemaSnapshot returns the lines of the snapshot file, blank lines and lines starting with # are ignored
+func readSchemaSnapshot(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+func writeSchemaSnapshot(path string, lines []string) error {
+	content := schemaSnapshotHeader + "\n" + strings.Join(lines, "\n") + "\n"
+	return os.WriteFile(path, []byte(content), 0o644)
+}
+
+// diffSchema returns the sorted lines of the snapshot missing in the database and the ones of the database
+// missing in the snapshot
+func diffSchema(expected []string, actual []string) ([]string, []string) {
+	inActual := make(map[string]struct{}, len(actual))
+	for _, line := range actual {
+		inActual[line] = struct{}{}
+	}
+	inExpected := make(map[string]struct{}, len(expected))
+	for _, line := range expected {
+		inExpected[line] = struct{}{}
+	}
+
+	var missing, unexpected []string
+	for _, line := range expected {
+		if _, ok := inActual[line]; !ok {
+			missing = append(missing, line)
+		}
+	}
+	for _, line := range actual {
+		if _, ok := inExpected[line]; !ok {
+			unexpected = append(unexpected, line)
+		}
+	}
+	slices.Sort(missing)
+	slices.Sort(unexpected)
+	return missing, unexpected
+}
@@ -0,0 +1,53 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffSchema(t *testing.T) {
+	t.Run("Identical", func(t *testing.T) {
+		missing, unexpected := diffSchema([]string{"schema public", "table public.users"}, []string{"schema public", "table public.users"})
+		assert.Empty(t, missing)
+		assert.Empty(t, unexpected)
+	})
+
+	t.Run("Changed column", func(t *testing.T) {
+		expected := []string{"column public.users.name text", "table public.users"}
+		actual := []string{"column public.users.name text NOT NULL", "index CREATE INDEX users_name ON public.users USING btree (name)", "table public.users"}
+
+		missing, unexpected := diffSchema(expected, actual)
+		assert.Equal(t, []string{"column public.users.name text"}, missing)
+		assert.Equal(t, []string{"column public.users.name text NOT NULL", "index CREATE INDEX users_name ON public.users USING btree (name)"}, unexpected)
+	})
+}
+
+func TestSchemaSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schema.txt")
+	lines := []string{"schema public", "table public.users"}
+
+	assert.NoError(t, writeSchemaSnapshot(path, lines))
+
+	read, err := readSchemaSnapshot(path)
+	assert.NoError(t, err)
+	assert.Equal(t, lines, read)
+
+	t.Run("Comments and blank lines are ignored", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(path, []byte("# comment\n\nschema public\n  table public.users  \n"), 0o644))
+		read, err := readSchemaSnapshot(path)
+		assert.NoError(t, err)
+		assert.Equal(t, lines, read)
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		_, err := readSchemaSnapshot(filepath.Join(t.TempDir(), "missing.txt"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
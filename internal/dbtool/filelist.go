@@ -0,0 +1,65 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+)
+
+// readFileList reads the files of the --files-from list instead of walking the migrations, the listed files are
+// validated like the ones found by readDir
+func readFileList(files *[]sqlFile, fsys fs.FS, list []string, opts readDirOptions) error {
+	snapshots := make(map[string]bool)
+	for _, filePath := range slices.Compact(slices.Sorted(slices.Values(list))) {
+		fileName := path.Base(filePath)
+		if getFileType(versionedName(fileName), opts.filenamePattern) != fileTypeSql {
+			return fmt.Errorf("the listed file '%s' does not match the file name pattern %s", filePath, filenamePatternOrDefault(opts.filenamePattern))
+		}
+		if depth := len(strings.Split(filePath, "/")) - 1; opts.maxDepth >= 0 && depth > opts.maxDepth {
+			return fmt.Errorf("the file '%s' is nested deeper than the maximum depth %d", filePath, opts.maxDepth)
+		}
+		if !opts.isFileSelected(filePath) {
+			continue
+		}
+
+		f, err := readSQLFile(fsys, filePath, opts)
+		if err != nil {
+			return err
+		}
+
+		// Like readDir, a .snapshot file marks the files of the root or a first level directory
+		dir := path.Dir(filePath)
+		if isSnapshot, ok := snapshots[dir]; ok {
+			f.isSnapshot = isSnapshot
+		} else if !strings.Contains(dir, "/") {
+			_, err := fs.Stat(fsys, path.Join(dir, ".snapshot"))
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			snapshots[dir] = err == nil
+			f.isSnapshot = err == nil
+		}
+		*files = append(*files, f)
+	}
+	return nil
+}
+
+// listedMigrations leaves out the applied migrations whose files are not listed, they are neither matched nor
+// validated, so the list can hold only the files changed since the last run
+func listedMigrations(applied []migration, files []sqlFile) []migration {
+	listed := make(map[string]struct{}, len(files))
+	for _, f := range files {
+		listed[f.path] = struct{}{}
+	}
+	return slices.DeleteFunc(slices.Clone(applied), func(m migration) bool {
+		_, ok := listed[m.filePath]
+		return !ok
+	})
+}
@@ -0,0 +1,88 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadFileList(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001-init.sql":          {Data: []byte("CREATE TABLE a (id INT);")},
+		"002-users.sql":         {Data: []byte("CREATE TABLE b (id INT);")},
+		"v2/.snapshot":          {},
+		"v2/003-snapshot.sql":   {Data: []byte("CREATE TABLE c (id INT);")},
+		"v2/004-skipped.sql":    {Data: []byte("-- dbtool:skip\nSELECT 1;")},
+		"v3/a/005-deep.sql":     {Data: []byte("SELECT 1;")},
+		"notes.txt":             {Data: []byte("notes")},
+		"R__views.sql":          {Data: []byte("CREATE VIEW v AS SELECT 1;")},
+		"v2/006-unlisted.sql":   {Data: []byte("SELECT 1;")},
+		"v3/007-unselected.sql": {Data: []byte("SELECT 1;")},
+	}
+	opts := readDirOptions{maxDepth: unlimitedDepth, hashAlgorithm: config.HashSHA256}
+
+	t.Run("Listed files only", func(t *testing.T) {
+		var files []sqlFile
+		err := readFileList(&files, fsys, []string{"v2/003-snapshot.sql", "002-users.sql", "R__views.sql", "v2/004-skipped.sql", "002-users.sql"}, opts)
+		assert.NoError(t, err)
+
+		var paths []string
+		for _, f := range files {
+			paths = append(paths, f.path)
+			assert.NotEmpty(t, f.hash)
+		}
+		assert.Equal(t, []string{"002-users.sql", "R__views.sql", "v2/003-snapshot.sql", "v2/004-skipped.sql"}, paths)
+		assert.False(t, files[0].isSnapshot)
+		assert.True(t, files[1].repeatable)
+		assert.True(t, files[2].isSnapshot)
+		assert.True(t, files[3].skip)
+	})
+
+	t.Run("Include and exclude", func(t *testing.T) {
+		var files []sqlFile
+		err := readFileList(&files, fsys, []string{"001-init.sql", "v3/007-unselected.sql"}, readDirOptions{maxDepth: unlimitedDepth, hashAlgorithm: config.HashSHA256, exclude: []string{"v3/*"}})
+		assert.NoError(t, err)
+		assert.Len(t, files, 1)
+		assert.Equal(t, "001-init.sql", files[0].path)
+	})
+
+	t.Run("Empty list", func(t *testing.T) {
+		var files []sqlFile
+		assert.NoError(t, readFileList(&files, fsys, nil, opts))
+		assert.Empty(t, files)
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		var files []sqlFile
+		err := readFileList(&files, fsys, []string{"009-missing.sql"}, opts)
+		assert.ErrorContains(t, err, "009-missing.sql")
+	})
+
+	t.Run("Not a migration", func(t *testing.T) {
+		var files []sqlFile
+		err := readFileList(&files, fsys, []string{"notes.txt"}, opts)
+		assert.ErrorContains(t, err, "the listed file 'notes.txt' does not match the file name pattern")
+	})
+
+	t.Run("Too deep", func(t *testing.T) {
+		var files []sqlFile
+		err := readFileList(&files, fsys, []string{"v3/a/005-deep.sql"}, readDirOptions{maxDepth: 1, hashAlgorithm: config.HashSHA256})
+		assert.ErrorContains(t, err, "nested deeper than the maximum depth 1")
+	})
+}
+
+func TestListedMigrations(t *testing.T) {
+	applied := []migration{{filePath: "001-init.sql"}, {filePath: "002-users.sql"}, {filePath: "003-orders.sql"}}
+	files := []sqlFile{{path: "003-orders.sql"}, {path: "004-items.sql"}}
+
+	assert.Equal(t, []migration{{filePath: "003-orders.sql"}}, listedMigrations(applied, files))
+	// The applied migrations are not modified
+	assert.Len(t, applied, 3)
+	assert.Empty(t, listedMigrations(applied, nil))
+}
@@ -0,0 +1,129 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// historyEntry is a row of the migrations table as exported by the history command
+type historyEntry struct {
+	FilePath  string     `json:"file_path"`
+	FileHash  string     `json:"file_hash"`
+	AppliedAt *time.Time `json:"applied_at"`
+	Version   string     `json:"clbs_dbtool_version"`
+	Failed    bool       `json:"failed"`
+}
+
+// History writes every migration recorded for the app in the order they were recorded to w, limited to the ones
+// applied between --since and --until, it never modifies the database and does not need the migrations
+func History(ctx context.Context, logger *zap.Logger, cfg *config.Config, w io.Writer) (err error) {
+	conn, err := connect(ctx, cfg.ForTracking(), logger)
+	if err != nil {
+		return err
+	}
+	defer closeConnection(ctx, conn, &err)
+
+	history, err := getHistory(ctx, *conn, cfg)
+	if err != nil {
+		return fmt.Errorf("error reading migration history: %w", err)
+	}
+
+	return writeHistory(w, history, cfg.Output())
+}
+
+func getHistory(ctx context.Context, conn pgx.Conn, cfg *config.Config) ([]historyEntry, error) {
+	exists, err := migrationTableExists(ctx, conn, cfg)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	//goland:noinspection SqlResolve
+	selectHistorySQL := `SELECT file_path, file_hash, applied_at, clbs_dbtool_version, failed FROM ` + dbtoolTable(cfg, migrationsTableName) + ` WHERE app_id = $1
+		AND ($2::TIMESTAMPTZ IS NULL OR applied_at >= $2) AND ($3::TIMESTAMPTZ IS NULL OR applied_at < $3) ORDER BY id`
+
+	rows, err := conn.Query(ctx, selectHistorySQL, cfg.AppId(), timeOrNil(cfg.Since()), timeOrNil(cfg.Until()))
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (historyEntry, error) {
+		var e historyEntry
+		err := row.Scan(&e.FilePath, &e.FileHash, &e.AppliedAt, &e.Version, &e.Failed)
+		return e, err
+	})
+}
+
+func writeHistory(w io.Writer, history []historyEntry, output string) error {
+	switch output {
+	case config.OutputJSON:
+		if history == nil {
+			history = []historyEntry{}
+		}
+		return json.NewEncoder(w).Encode(history)
+	case config.OutputCSV:
+		return writeHistoryCSV(w, history)
+	}
+
+	if len(history) == 0 {
+		_, err := fmt.Fprintln(w, "No migrations applied")
+		return err
+	}
+	for _, e := range history {
+		line := fmt.Sprintf("%s %s %s %s", formatAppliedAt(e.AppliedAt), e.FilePath, e.FileHash, e.Version)
+		if e.Failed {
+			line += " failed"
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeHistoryCSV writes the history as RFC 4180 CSV with a header record
+func writeHistoryCSV(w io.Writer, history []historyEntry) error {
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+
+	if err := cw.Write([]string{"file_path", "file_hash", "applied_at", "clbs_dbtool_version", "failed"}); err != nil {
+		return err
+	}
+	for _, e := range history {
+		appliedAt := ""
+		if e.AppliedAt != nil {
+			appliedAt = e.AppliedAt.Format(time.RFC3339Nano)
+		}
+		if err := cw.Write([]string{e.FilePath, e.FileHash, appliedAt, e.Version, strconv.FormatBool(e.Failed)}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// timeOrNil returns nil for the zero time so that the range is not limited
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func formatAppliedAt(appliedAt *time.Time) string {
+	if appliedAt == nil {
+		return "unknown"
+	}
+	return appliedAt.Format(time.RFC3339)
+}
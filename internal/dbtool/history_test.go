@@ -0,0 +1,66 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteHistory(t *testing.T) {
+	appliedAt := time.Date(2026, 3, 14, 15, 9, 26, 0, time.UTC)
+	history := []historyEntry{
+		{FilePath: "v1/001-init.sql", FileHash: "abc", AppliedAt: &appliedAt, Version: "v1.2.3"},
+		{FilePath: `v2/002-"quoted", name.sql`, FileHash: "def", Version: "v1.2.4", Failed: true},
+	}
+
+	t.Run("Text", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.NoError(t, writeHistory(&buf, history, config.OutputText))
+		assert.Equal(t, "2026-03-14T15:09:26Z v1/001-init.sql abc v1.2.3\nunknown v2/002-\"quoted\", name.sql def v1.2.4 failed\n", buf.String())
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.NoError(t, writeHistory(&buf, history, config.OutputJSON))
+		assert.JSONEq(t, `[
+			{"file_path":"v1/001-init.sql","file_hash":"abc","applied_at":"2026-03-14T15:09:26Z","clbs_dbtool_version":"v1.2.3","failed":false},
+			{"file_path":"v2/002-\"quoted\", name.sql","file_hash":"def","applied_at":null,"clbs_dbtool_version":"v1.2.4","failed":true}
+		]`, buf.String())
+	})
+
+	t.Run("CSV", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.NoError(t, writeHistory(&buf, history, config.OutputCSV))
+		assert.Equal(t, "file_path,file_hash,applied_at,clbs_dbtool_version,failed\r\n"+
+			"v1/001-init.sql,abc,2026-03-14T15:09:26Z,v1.2.3,false\r\n"+
+			"\"v2/002-\"\"quoted\"\", name.sql\",def,,v1.2.4,true\r\n", buf.String())
+	})
+
+	t.Run("Nothing applied", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.NoError(t, writeHistory(&buf, nil, config.OutputText))
+		assert.Equal(t, "No migrations applied\n", buf.String())
+
+		buf.Reset()
+		assert.NoError(t, writeHistory(&buf, nil, config.OutputJSON))
+		assert.JSONEq(t, `[]`, buf.String())
+
+		buf.Reset()
+		assert.NoError(t, writeHistory(&buf, nil, config.OutputCSV))
+		assert.Equal(t, "file_path,file_hash,applied_at,clbs_dbtool_version,failed\r\n", buf.String())
+	})
+}
+
+func TestTimeOrNil(t *testing.T) {
+	assert.Nil(t, timeOrNil(time.Time{}))
+
+	at := time.Date(2026, 3, 14, 15, 0, 0, 0, time.UTC)
+	assert.Equal(t, at, *timeOrNil(at))
+}
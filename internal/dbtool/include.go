@@ -0,0 +1,106 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	// reInclude matches a psql include line, \ir is accepted as well as both include relative to the including file
+	reInclude = regexp.MustCompile(`^\s*\\(?:i|ir|include|include_relative)\s+(?:'([^']*)'|(\S+))\s*$`)
+
+	ErrIncludeCycle   = errors.New("include cycle")
+	ErrInvalidInclude = errors.New("invalid include")
+)
+
+// includedPath returns the path of the file included by the line relative to the directory of the including file,
+// false when the line is no include
+func includedPath(line string, from string) (string, bool, error) {
+	match := reInclude.FindStringSubmatch(line)
+	if match == nil {
+		return "", false, nil
+	}
+	ref := match[1] + match[2]
+	if ref == "" || path.IsAbs(ref) {
+		return "", false, fmt.Errorf("%w: %s in %s must be a path relative to the including file", ErrInvalidInclude, ref, from)
+	}
+	included := path.Join(path.Dir(from), ref)
+	if !fs.ValidPath(included) {
+		return "", false, fmt.Errorf("%w: %s in %s is outside of the migrations directory", ErrInvalidInclude, ref, from)
+	}
+	return included, true, nil
+}
+
+// resolveIncludes replaces the include lines of the SQL read from filePath with the text of the included files,
+// recursively
+func resolveIncludes(fsys fs.FS, filePath string, sql string, enc string) (string, error) {
+	return expandIncludes(fsys, sql, []string{filePath}, enc)
+}
+
+// expandIncludes expands the SQL of the last file of the stack, the stack holds the chain of including files
+func expandIncludes(fsys fs.FS, sql string, stack []string, enc string) (string, error) {
+	from := stack[len(stack)-1]
+	var sb strings.Builder
+	for line := range strings.Lines(sql) {
+		included, ok, err := includedPath(strings.TrimRight(line, "\r\n"), from)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			sb.WriteString(line)
+			continue
+		}
+
+		chain := append(slices.Clip(stack), included)
+		if slices.Contains(stack, included) {
+			return "", fmt.Errorf("%w: %s", ErrIncludeCycle, strings.Join(chain, " -> "))
+		}
+		text, err := readSQLText(fsys, included, enc)
+		if err != nil {
+			return "", fmt.Errorf("error including %s in %s: %w", included, from, err)
+		}
+		expanded, err := expandIncludes(fsys, text, chain, enc)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(expanded)
+		if expanded != "" && !strings.HasSuffix(expanded, "\n") {
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String(), nil
+}
+
+// dropIncludedFiles removes the files included by other files, they are applied as part of the including migration
+func dropIncludedFiles(fsys fs.FS, files []sqlFile, enc string) ([]sqlFile, error) {
+	included := make(map[string]struct{})
+	for _, f := range files {
+		sql, err := readSQLText(fsys, f.path, enc)
+		if err != nil {
+			return nil, err
+		}
+		for line := range strings.Lines(sql) {
+			p, ok, err := includedPath(strings.TrimRight(line, "\r\n"), f.path)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				included[p] = struct{}{}
+			}
+		}
+	}
+
+	return slices.DeleteFunc(files, func(f sqlFile) bool {
+		_, ok := included[f.path]
+		return ok
+	}), nil
+}
@@ -0,0 +1,79 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncludedPath(t *testing.T) {
+	for _, tc := range []struct {
+		line     string
+		included string
+		ok       bool
+	}{
+		{line: `\i helpers/users.sql`, included: "v1/helpers/users.sql", ok: true},
+		{line: `  \ir 'helpers/with space.sql'  `, included: "v1/helpers/with space.sql", ok: true},
+		{line: `\include ../shared/types.sql`, included: "shared/types.sql", ok: true},
+		{line: `SELECT '\i x.sql';`},
+		{line: `-- \i x.sql`},
+		{line: `\if :flag`},
+	} {
+		included, ok, err := includedPath(tc.line, "v1/001-init.sql")
+		assert.NoError(t, err, tc.line)
+		assert.Equal(t, tc.ok, ok, tc.line)
+		assert.Equal(t, tc.included, included, tc.line)
+	}
+
+	_, _, err := includedPath(`\i ../../outside.sql`, "v1/001-init.sql")
+	assert.ErrorIs(t, err, ErrInvalidInclude)
+	_, _, err = includedPath(`\i /etc/passwd`, "v1/001-init.sql")
+	assert.ErrorIs(t, err, ErrInvalidInclude)
+}
+
+func TestResolveIncludes(t *testing.T) {
+	fsys := fstest.MapFS{
+		"v1/001-init.sql":        {Data: []byte("BEGIN;\n\\i helpers/users.sql\nCOMMIT;\n")},
+		"v1/helpers/users.sql":   {Data: []byte("CREATE TABLE users (id INT);\n\\ir roles.sql")},
+		"v1/helpers/roles.sql":   {Data: []byte("CREATE TABLE roles (id INT);\n")},
+		"v1/002-cycle.sql":       {Data: []byte("\\i helpers/cycle-a.sql\n")},
+		"v1/helpers/cycle-a.sql": {Data: []byte("\\i cycle-b.sql\n")},
+		"v1/helpers/cycle-b.sql": {Data: []byte("\\i cycle-a.sql\n")},
+		"v1/003-missing.sql":     {Data: []byte("\\i helpers/missing.sql\n")},
+		"v1/004-no-includes.sql": {Data: []byte("SELECT 1;")},
+	}
+
+	sql, err := resolveIncludes(fsys, "v1/001-init.sql", string(fsys["v1/001-init.sql"].Data), "")
+	require.NoError(t, err)
+	assert.Equal(t, "BEGIN;\nCREATE TABLE users (id INT);\nCREATE TABLE roles (id INT);\nCOMMIT;\n", sql)
+
+	_, err = resolveIncludes(fsys, "v1/002-cycle.sql", string(fsys["v1/002-cycle.sql"].Data), "")
+	assert.ErrorIs(t, err, ErrIncludeCycle)
+	assert.ErrorContains(t, err, "v1/002-cycle.sql -> v1/helpers/cycle-a.sql -> v1/helpers/cycle-b.sql -> v1/helpers/cycle-a.sql")
+
+	_, err = resolveIncludes(fsys, "v1/003-missing.sql", string(fsys["v1/003-missing.sql"].Data), "")
+	assert.ErrorContains(t, err, "error including v1/helpers/missing.sql in v1/003-missing.sql")
+
+	sql, err = resolveIncludes(fsys, "v1/004-no-includes.sql", "SELECT 1;", "")
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT 1;", sql)
+}
+
+func TestDropIncludedFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"v1/001-init.sql":  {Data: []byte("\\i 002-users.sql\n")},
+		"v1/002-users.sql": {Data: []byte("CREATE TABLE users (id INT);\n")},
+		"v1/003-roles.sql": {Data: []byte("CREATE TABLE roles (id INT);\n")},
+	}
+	files := []sqlFile{{path: "v1/001-init.sql"}, {path: "v1/002-users.sql"}, {path: "v1/003-roles.sql"}}
+
+	files, err := dropIncludedFiles(fsys, files, "")
+	require.NoError(t, err)
+	assert.Equal(t, []sqlFile{{path: "v1/001-init.sql"}, {path: "v1/003-roles.sql"}}, files)
+}
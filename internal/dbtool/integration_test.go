@@ -0,0 +1,201 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+//go:build integration
+
+package dbtool
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"go.uber.org/zap/zaptest"
+)
+
+// integrationSamples holds the migrations applied by the integration tests
+const integrationSamples = "../../testing/samples/integration"
+
+// startPostgres starts a PostgreSQL container for the test and returns the address of its server
+func startPostgres(t *testing.T) string {
+	t.Helper()
+	ctx := context.Background()
+
+	ctr, err := testcontainers.Run(ctx, "postgres:17-alpine",
+		testcontainers.WithExposedPorts("5432/tcp"),
+		testcontainers.WithEnv(map[string]string{"POSTGRES_USER": "postgres", "POSTGRES_PASSWORD": "postgres"}),
+		// The server restarts once after the initialization
+		testcontainers.WithWaitStrategy(wait.ForLog("database system is ready to accept connections").WithOccurrence(2).WithStartupTimeout(time.Minute)),
+	)
+	testcontainers.CleanupContainer(t, ctr)
+	require.NoError(t, err)
+
+	endpoint, err := ctr.PortEndpoint(ctx, "5432/tcp", "")
+	require.NoError(t, err)
+	return endpoint
+}
+
+// integrationRun applies the migrations of dir to a database of its own created by the first run
+type integrationRun struct {
+	t                *testing.T
+	connectionString string
+}
+
+func newIntegrationRun(t *testing.T, endpoint string, database string) *integrationRun {
+	return &integrationRun{t: t, connectionString: fmt.Sprintf("postgres://postgres:postgres@%s/%s?sslmode=disable", endpoint, database)}
+}
+
+func (r *integrationRun) run(dir string, steps int) (Result, error) {
+	r.t.Helper()
+	return r.runOptions(config.Options{Dir: dir, Steps: steps})
+}
+
+// runOptions runs with opts completed by the app id and the connection of the run
+func (r *integrationRun) runOptions(opts config.Options) (Result, error) {
+	r.t.Helper()
+	opts.AppId = "app"
+	opts.ConnectionString = r.connectionString
+	opts.CreateDatabase = true
+	cfg, err := config.New(opts)
+	require.NoError(r.t, err)
+	return Run(context.Background(), zaptest.NewLogger(r.t), cfg)
+}
+
+// query returns the first column of the rows of the statement
+func (r *integrationRun) query(sql string, args ...any) []string {
+	r.t.Helper()
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, r.connectionString)
+	require.NoError(r.t, err)
+	defer func() { _ = conn.Close(ctx) }()
+
+	rows, err := conn.Query(ctx, sql, args...)
+	require.NoError(r.t, err)
+	values, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	require.NoError(r.t, err)
+	return values
+}
+
+func (r *integrationRun) appliedFiles() []string {
+	return r.query(`SELECT file_path FROM clbs_dbtool_migrations WHERE app_id = 'app' ORDER BY id`)
+}
+
+func (r *integrationRun) columns(table string) []string {
+	return r.query(`SELECT column_name::TEXT FROM information_schema.columns WHERE table_schema = 'public' AND table_name = $1 ORDER BY ordinal_position`, table)
+}
+
+func TestIntegration_Run(t *testing.T) {
+	endpoint := startPostgres(t)
+	allFiles := []string{"001-users.sql", "002-orders.sql", "003-users-email.sql"}
+
+	t.Run("Applies all migrations", func(t *testing.T) {
+		r := newIntegrationRun(t, endpoint, "apply_all")
+		result, err := r.run(integrationSamples, 0)
+		require.NoError(t, err)
+		assert.Equal(t, Result{Applied: 3}, result)
+
+		assert.Equal(t, allFiles, r.appliedFiles())
+		assert.Equal(t, []string{"id", "name", "email"}, r.columns("users"))
+		assert.Equal(t, []string{"id", "user_id"}, r.columns("orders"))
+		// The sha256 hashes are recorded as bare lower case hex without the algorithm prefix
+		assert.Len(t, r.query(`SELECT file_hash FROM clbs_dbtool_migrations WHERE file_hash ~ '^[0-9a-f]{64}$'`), 3)
+	})
+
+	t.Run("Re-run is idempotent", func(t *testing.T) {
+		r := newIntegrationRun(t, endpoint, "rerun")
+		_, err := r.run(integrationSamples, 0)
+		require.NoError(t, err)
+
+		result, err := r.run(integrationSamples, 0)
+		require.NoError(t, err)
+		assert.Equal(t, Result{Skipped: 3}, result)
+		assert.Equal(t, allFiles, r.appliedFiles())
+	})
+
+	t.Run("Steps limit", func(t *testing.T) {
+		r := newIntegrationRun(t, endpoint, "steps")
+		result, err := r.run(integrationSamples, 2)
+		require.NoError(t, err)
+		assert.Equal(t, Result{Applied: 2, Pending: 1}, result)
+		assert.Equal(t, allFiles[:2], r.appliedFiles())
+		assert.Equal(t, []string{"id", "name"}, r.columns("users"))
+
+		result, err = r.run(integrationSamples, 0)
+		require.NoError(t, err)
+		assert.Equal(t, Result{Applied: 1, Skipped: 2}, result)
+		assert.Equal(t, allFiles, r.appliedFiles())
+		assert.Equal(t, []string{"id", "name", "email"}, r.columns("users"))
+	})
+
+	t.Run("Changed file is detected", func(t *testing.T) {
+		dir := t.TempDir()
+		for _, name := range allFiles {
+			data, err := os.ReadFile(filepath.Join(integrationSamples, name))
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o644))
+		}
+
+		r := newIntegrationRun(t, endpoint, "changed")
+		_, err := r.run(dir, 0)
+		require.NoError(t, err)
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "002-orders.sql"), []byte("CREATE TABLE orders (id BIGINT PRIMARY KEY);\n"), 0o644))
+		result, err := r.run(dir, 0)
+		assert.ErrorIs(t, err, ErrFileChanged)
+		assert.ErrorContains(t, err, "002-orders.sql")
+		assert.Zero(t, result.Applied)
+		assert.Equal(t, allFiles, r.appliedFiles())
+		assert.Equal(t, []string{"id", "user_id"}, r.columns("orders"))
+	})
+}
+
+func TestIntegration_Reconnect(t *testing.T) {
+	endpoint := startPostgres(t)
+
+	// The second migration terminates its own connection the first time it runs, sequences are not rolled back
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "001-sequence.sql"), []byte("CREATE SEQUENCE reconnect_once;\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "002-terminate.sql"), []byte("SELECT CASE WHEN nextval('reconnect_once') = 1 THEN pg_terminate_backend(pg_backend_pid()) END;\n"), 0o644))
+
+	r := newIntegrationRun(t, endpoint, "reconnect")
+	result, err := r.runOptions(config.Options{Dir: dir, MaxReconnects: 1, WriteStatus: true})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Applied: 2}, result)
+	assert.Equal(t, []string{"001-sequence.sql", "002-terminate.sql"}, r.appliedFiles())
+	// Written on the connection opened by the reconnect
+	assert.Equal(t, []string{statusDone}, r.query(`SELECT status FROM clbs_dbtool_status WHERE app_id = 'app'`))
+}
+
+func TestIntegration_TableLockRefreshed(t *testing.T) {
+	endpoint := startPostgres(t)
+
+	// The migration runs longer than the ttl of the lock
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "001-slow.sql"), []byte("SELECT pg_sleep(4);\n"), 0o644))
+
+	r := newIntegrationRun(t, endpoint, "lock")
+	opts := config.Options{Dir: dir, LockStrategy: config.LockStrategyTable, LockTTL: time.Second}
+	first := make(chan error, 1)
+	go func() {
+		_, err := r.runOptions(opts)
+		first <- err
+	}()
+
+	// Started while the first run is applying the migration, it waits instead of taking over the lock
+	time.Sleep(2 * time.Second)
+	result, err := r.runOptions(opts)
+	require.NoError(t, err)
+	require.NoError(t, <-first)
+	assert.Equal(t, Result{Skipped: 1}, result)
+	assert.Equal(t, []string{"001-slow.sql"}, r.appliedFiles())
+}
@@ -0,0 +1,70 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"fmt"
+	"io/fs"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"go.uber.org/zap"
+)
+
+// Lint checks the migration files without connecting to a database: the file names and layout as checked by the
+// discovery, the text encoding and that the SQL of every file splits into statements, it fails on the first problem
+func Lint(logger *zap.Logger, cfg *config.Config) error {
+	fsys, closeFS, err := openMigrationsFS(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeFS()
+
+	sqlFiles, err := discoverFiles(fsys, cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	statements := 0
+	for _, f := range sqlFiles {
+		// Skipped files are work in progress, only their names are checked
+		if f.skip {
+			continue
+		}
+		n, err := lintFile(fsys, f.path, cfg)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			logger.Warn("Migration has no statements", zap.String("file", f.path))
+		}
+		statements += n
+	}
+
+	logger.Info("Migrations linted", zap.Int("files", len(sqlFiles)), zap.Int("statements", statements))
+	return nil
+}
+
+// lintFile returns the number of statements of the file, the variables are not substituted as they are given
+// by the environment
+func lintFile(fsys fs.FS, filePath string, cfg *config.Config) (int, error) {
+	sql, err := readSQLText(fsys, filePath, cfg.Encoding())
+	if err != nil {
+		return 0, err
+	}
+	if cfg.ResolveIncludes() {
+		if sql, err = resolveIncludes(fsys, filePath, sql, cfg.Encoding()); err != nil {
+			return 0, err
+		}
+	}
+	if sql, err = checkMetaCommands(sql, filePath, cfg.StripMetaCommands()); err != nil {
+		return 0, err
+	}
+
+	statements, err := splitStatements(sql)
+	if err != nil {
+		return 0, fmt.Errorf("migration file %s: %w", filePath, err)
+	}
+	return len(statements), nil
+}
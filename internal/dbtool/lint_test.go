@@ -0,0 +1,60 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLintFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001-init.sql":        {Data: []byte("CREATE TABLE users (id INT);\nINSERT INTO users VALUES (1);\n")},
+		"002-broken.sql":      {Data: []byte("SELECT 1;\nSELECT 'abc;\n")},
+		"003-dollar.sql":      {Data: []byte("DO $$ BEGIN\n")},
+		"004-latin1.sql":      {Data: []byte("SELECT '\xe9';")},
+		"005-vars.sql":        {Data: []byte("CREATE SCHEMA ${schema};")},
+		"006-include.sql":     {Data: []byte("\\i helpers/users.sql\nSELECT 1;\n")},
+		"helpers/users.sql":   {Data: []byte("CREATE TABLE users (id INT);\n")},
+		"007-bad-include.sql": {Data: []byte("\\i helpers/broken.sql\n")},
+		"helpers/broken.sql":  {Data: []byte("SELECT \"abc\n")},
+	}
+	cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db"})
+	require.NoError(t, err)
+
+	n, err := lintFile(fsys, "001-init.sql", cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	_, err = lintFile(fsys, "002-broken.sql", cfg)
+	assert.EqualError(t, err, "migration file 002-broken.sql: unterminated string literal starting at line 2")
+
+	_, err = lintFile(fsys, "003-dollar.sql", cfg)
+	assert.EqualError(t, err, "migration file 003-dollar.sql: unterminated dollar-quoted string starting at line 1")
+
+	_, err = lintFile(fsys, "004-latin1.sql", cfg)
+	assert.ErrorIs(t, err, ErrInvalidText)
+
+	// The variables are left as they are
+	n, err = lintFile(fsys, "005-vars.sql", cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	t.Run("Includes", func(t *testing.T) {
+		cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db", ResolveIncludes: true})
+		require.NoError(t, err)
+
+		n, err := lintFile(fsys, "006-include.sql", cfg)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+
+		_, err = lintFile(fsys, "007-bad-include.sql", cfg)
+		assert.EqualError(t, err, "migration file 007-bad-include.sql: unterminated quoted identifier starting at line 1")
+	})
+}
@@ -0,0 +1,287 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"time"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	// lockPollInterval is the delay between two attempts to acquire the lock held by another run
+	lockPollInterval = time.Second
+	// lockReleaseTimeout bounds the release, which also runs after the context has been cancelled
+	lockReleaseTimeout = 5 * time.Second
+)
+
+// ErrLockLost is returned when the row of the table lock has been taken over by another run during the run
+var ErrLockLost = errors.New("lock taken over by another run")
+
+func createLockTableSQL(table string) string {
+	return `
+		CREATE TABLE IF NOT EXISTS ` + table + ` (
+			app_id VARCHAR(255) PRIMARY KEY,
+			owner VARCHAR(128) NOT NULL, -- host name and random token of the run holding the lock
+			locked_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`
+}
+
+// locker serializes the runs of an app
+type locker interface {
+	// tryLock acquires the lock without waiting and reports whether it succeeded
+	tryLock(ctx context.Context, conn *pgx.Conn) (bool, error)
+	unlock(ctx context.Context, conn *pgx.Conn) error
+}
+
+// advisoryLock is a session advisory lock, it is released by PostgreSQL when the connection is closed
+type advisoryLock struct {
+	key int64
+}
+
+// advisoryLockKey derives the key of the advisory lock from the app id
+func advisoryLockKey(appId string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte("clbs_dbtool:" + appId))
+	return int64(h.Sum64())
+}
+
+func (l advisoryLock) tryLock(ctx context.Context, conn *pgx.Conn) (bool, error) {
+	var acquired bool
+	err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, l.key).Scan(&acquired)
+	return acquired, err
+}
+
+func (l advisoryLock) unlock(ctx context.Context, conn *pgx.Conn) error {
+	_, err := conn.Exec(ctx, `SELECT pg_advisory_unlock($1)`, l.key)
+	return err
+}
+
+// tableLock is a row in the clbs_dbtool_lock table, it does not depend on the session and works behind
+// transaction-pooling poolers, the row of a crashed run is taken over once it is older than the ttl
+type tableLock struct {
+	table  string
+	appId  string
+	owner  string
+	ttl    time.Duration
+	logger *zap.Logger
+}
+
+// lockOwner identifies the run in the lock table
+func lockOwner() (string, error) {
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%.100s/%s", hostname, hex.EncodeToString(token)), nil
+}
+
+func (l tableLock) tryLock(ctx context.Context, conn *pgx.Conn) (bool, error) {
+	//goland:noinspection SqlResolve
+	deleteStaleLockSQL := `DELETE FROM ` + l.table + ` WHERE app_id = $1 AND locked_at < CURRENT_TIMESTAMP - make_interval(secs => $2)`
+	//goland:noinspection SqlResolve
+	insertLockSQL := `INSERT INTO ` + l.table + ` (app_id, owner) VALUES ($1, $2) ON CONFLICT (app_id) DO NOTHING`
+
+	tag, err := conn.Exec(ctx, deleteStaleLockSQL, l.appId, l.ttl.Seconds())
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() > 0 {
+		l.logger.Warn("Taking over a stale lock", zap.Duration("ttl", l.ttl))
+	}
+
+	tag, err = conn.Exec(ctx, insertLockSQL, l.appId, l.owner)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// refresh renews locked_at of the row and reports whether it still belongs to the run
+func (l tableLock) refresh(ctx context.Context, conn *pgx.Conn) (bool, error) {
+	//goland:noinspection SqlResolve
+	refreshLockSQL := `UPDATE ` + l.table + ` SET locked_at = CURRENT_TIMESTAMP WHERE app_id = $1 AND owner = $2`
+
+	tag, err := conn.Exec(ctx, refreshLockSQL, l.appId, l.owner)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (l tableLock) unlock(ctx context.Context, conn *pgx.Conn) error {
+	//goland:noinspection SqlResolve
+	deleteLockSQL := `DELETE FROM ` + l.table + ` WHERE app_id = $1 AND owner = $2`
+
+	_, err := conn.Exec(ctx, deleteLockSQL, l.appId, l.owner)
+	return err
+}
+
+// newLocker returns the locker of the configured lock strategy
+func newLocker(ctx context.Context, conn *pgx.Conn, cfg *config.Config, logger *zap.Logger) (locker, error) {
+	if cfg.LockStrategy() != config.LockStrategyTable {
+		return advisoryLock{key: advisoryLockKey(cfg.AppId())}, nil
+	}
+
+	if err := ensureAppSchema(ctx, conn, cfg); err != nil {
+		return nil, fmt.Errorf("error ensuring lock table exists: %w", err)
+	}
+	table := dbtoolTable(cfg, lockTableName)
+	if _, err := conn.Exec(ctx, createLockTableSQL(table)); err != nil {
+		return nil, fmt.Errorf("error ensuring lock table exists: %w", err)
+	}
+	if _, err := conn.Exec(ctx, widenColumnSQL(dbtoolSchema(cfg), lockTableName, "app_id", config.MaxAppIdLength)); err != nil {
+		return nil, fmt.Errorf("error ensuring lock table exists: %w", err)
+	}
+	owner, err := lockOwner()
+	if err != nil {
+		return nil, err
+	}
+	return tableLock{table: table, appId: cfg.AppId(), owner: owner, ttl: cfg.LockTTL(), logger: logger}, nil
+}
+
+// runLock is the lock held by a run on its connection
+type runLock struct {
+	l      locker
+	conn   *pgx.Conn
+	cfg    *config.Config
+	logger *zap.Logger
+}
+
+// acquireLock waits until no other run of the app holds the lock and returns the lock held on conn
+func acquireLock(ctx context.Context, conn *pgx.Conn, cfg *config.Config, logger *zap.Logger) (*runLock, error) {
+	l, err := newLocker(ctx, conn, cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &runLock{l: l, conn: conn, cfg: cfg, logger: logger}
+	if err := lock.wait(ctx); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// wait polls until the lock is acquired on the connection of the lock
+func (lock *runLock) wait(ctx context.Context) error {
+	lock.logger.Info("Acquiring lock...", zap.String("strategy", lock.cfg.LockStrategy()))
+	for waiting := false; ; waiting = true {
+		acquired, err := lock.l.tryLock(ctx, lock.conn)
+		if err != nil {
+			return fmt.Errorf("error acquiring lock: %w", err)
+		}
+		if acquired {
+			return nil
+		}
+		if !waiting {
+			lock.logger.Info("Another run holds the lock, waiting...")
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for lock interrupted: %w", ctx.Err())
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// reacquire moves the lock to conn after the previous connection has been lost, the advisory lock was released
+// with the session and is acquired again, the row of the table lock outlives the session
+func (lock *runLock) reacquire(ctx context.Context, conn *pgx.Conn) error {
+	lock.conn = conn
+	if _, ok := lock.l.(tableLock); ok {
+		return nil
+	}
+	return lock.wait(ctx)
+}
+
+// keepAlive refreshes the row of a table lock every third of the ttl on a connection of its own, so a run taking
+// longer than the ttl is not taken over. The returned context is cancelled with ErrLockLost once the row belongs
+// to another run, stop ends the refreshing. An advisory lock needs no refreshing
+func (lock *runLock) keepAlive(ctx context.Context) (context.Context, func(), error) {
+	l, ok := lock.l.(tableLock)
+	if !ok {
+		return ctx, func() {}, nil
+	}
+
+	conn, err := connect(ctx, lock.cfg.ForTracking(), lock.logger)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error connecting to refresh the lock: %w", err)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			// A lost connection is replaced on the next tick, the row stays valid until the ttl has passed
+			if conn.IsClosed() {
+				newConn, err := connect(ctx, lock.cfg.ForTracking(), lock.logger)
+				if err != nil {
+					lock.logger.Warn("Error reconnecting to refresh the lock", zap.Error(err))
+					continue
+				}
+				conn = newConn
+			}
+			owned, err := l.refresh(ctx, conn)
+			if err != nil {
+				if ctx.Err() == nil {
+					lock.logger.Warn("Error refreshing the lock", zap.Error(err))
+				}
+				continue
+			}
+			if !owned {
+				lock.logger.Error("The lock has been taken over by another run, stopping", zap.Duration("ttl", l.ttl))
+				cancel(ErrLockLost)
+				return
+			}
+		}
+	}()
+
+	stop := func() {
+		cancel(nil)
+		<-done
+		closeCtx, cancelClose := context.WithTimeout(context.Background(), lockReleaseTimeout)
+		defer cancelClose()
+		_ = conn.Close(closeCtx)
+	}
+	return ctx, stop, nil
+}
+
+// lockLostError replaces the error of a run cancelled by keepAlive, it is not an interruption of the run
+func lockLostError(ctx context.Context, err error) error {
+	if err != nil && errors.Is(context.Cause(ctx), ErrLockLost) && !errors.Is(err, ErrLockLost) {
+		return fmt.Errorf("%w, the run has been stopped: %v", ErrLockLost, err)
+	}
+	return err
+}
+
+// release releases the lock, a failed release is logged only
+func (lock *runLock) release(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), lockReleaseTimeout)
+	defer cancel()
+
+	if err := lock.l.unlock(ctx, lock.conn); err != nil {
+		lock.logger.Error("Error releasing lock", zap.Error(err))
+	}
+}
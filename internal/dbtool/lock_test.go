@@ -0,0 +1,79 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestAdvisoryLockKey(t *testing.T) {
+	assert.Equal(t, advisoryLockKey("app"), advisoryLockKey("app"))
+	assert.NotEqual(t, advisoryLockKey("app"), advisoryLockKey("other-app"))
+}
+
+func TestLockOwner(t *testing.T) {
+	first, err := lockOwner()
+	assert.NoError(t, err)
+	second, err := lockOwner()
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, first, second)
+	assert.LessOrEqual(t, len(first), 128)
+}
+
+func TestNewLocker_Advisory(t *testing.T) {
+	cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db"})
+	assert.NoError(t, err)
+
+	// The advisory lock does not touch the connection until it is acquired
+	l, err := newLocker(context.Background(), nil, cfg, zap.NewNop())
+	assert.NoError(t, err)
+	assert.Equal(t, advisoryLock{key: advisoryLockKey("app")}, l)
+}
+
+func TestKeepAlive_Advisory(t *testing.T) {
+	cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db"})
+	assert.NoError(t, err)
+
+	// Nothing to refresh, no connection is opened
+	lock := &runLock{l: advisoryLock{key: advisoryLockKey("app")}, cfg: cfg, logger: zap.NewNop()}
+	ctx := context.Background()
+	keptCtx, stop, err := lock.keepAlive(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, ctx, keptCtx)
+	stop()
+}
+
+func TestLockLostError(t *testing.T) {
+	interrupted := fmt.Errorf("%w before 002-users.sql: %w", ErrInterrupted, context.Canceled)
+
+	t.Run("Run stopped by the lost lock", func(t *testing.T) {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		cancel(ErrLockLost)
+
+		err := lockLostError(ctx, interrupted)
+		assert.ErrorIs(t, err, ErrLockLost)
+		assert.NotErrorIs(t, err, ErrInterrupted, "not reported as an interruption")
+		assert.ErrorContains(t, err, "002-users.sql")
+	})
+
+	t.Run("Other cancellation kept", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.Equal(t, interrupted, lockLostError(ctx, interrupted))
+	})
+
+	t.Run("Successful run", func(t *testing.T) {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		cancel(ErrLockLost)
+		assert.NoError(t, lockLostError(ctx, nil))
+	})
+}
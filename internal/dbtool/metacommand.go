@@ -0,0 +1,110 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrMetaCommand is returned for a psql meta-command in a migration, e.g. \timing or \set, the server cannot
+// execute it
+var ErrMetaCommand = errors.New("psql meta-command")
+
+// includeCommands are the psql meta-commands resolved with --resolve-includes, they are never stripped
+// as the included SQL would be lost silently
+var includeCommands = []string{`\i`, `\ir`, `\include`, `\include_relative`}
+
+// checkMetaCommands fails on the first line of the SQL read from filePath that is a psql meta-command,
+// with strip the meta-commands are removed instead and only an include fails. The line breaks are kept,
+// so the lines of the statements stay the same. Backslashes in string literals, quoted identifiers,
+// dollar-quoted bodies and comments are not meta-commands.
+func checkMetaCommands(sql string, filePath string, strip bool) (string, error) {
+	var sb strings.Builder
+	copied := 0
+	lineStart := true
+	line := 1
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		atLineStart := lineStart
+		lineStart = false
+		switch {
+		case c == '\n':
+			line++
+			lineStart = true
+
+		case c == ' ' || c == '\t' || c == '\r':
+			lineStart = atLineStart
+
+		case c == '\\' && atLineStart:
+			end := strings.IndexByte(sql[i:], '\n')
+			if end == -1 {
+				end = len(sql) - i
+			}
+			command := strings.TrimRight(sql[i:i+end], "\r")
+			name := strings.Fields(command)[0]
+			if slices.Contains(includeCommands, name) {
+				return "", fmt.Errorf("%w %s at line %d of %s: includes are resolved with --resolve-includes", ErrMetaCommand, name, line, filePath)
+			}
+			if !strip {
+				return "", fmt.Errorf("%w %s at line %d of %s: remove it or use --strip-meta-commands", ErrMetaCommand, name, line, filePath)
+			}
+			sb.WriteString(sql[copied:i])
+			copied = i + len(command)
+			i = copied - 1
+
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			end := strings.IndexByte(sql[i:], '\n')
+			if end == -1 {
+				i = len(sql)
+				continue
+			}
+			i += end - 1
+
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			end, err := skipBlockComment(sql, i, line)
+			if err != nil {
+				// The SQL is left to the server, which reports the unterminated comment
+				i = len(sql)
+				continue
+			}
+			line += strings.Count(sql[i:end], "\n")
+			i = end - 1
+
+		case c == '\'' || c == '"':
+			escapes := c == '\'' && i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e') && (i < 2 || !isIdentifierChar(sql[i-2]))
+			end, err := skipQuoted(sql, i, escapes, line)
+			if err != nil {
+				i = len(sql)
+				continue
+			}
+			line += strings.Count(sql[i:end], "\n")
+			i = end - 1
+
+		case c == '$' && (i == 0 || !isIdentifierChar(sql[i-1])):
+			tag, ok := dollarQuoteTag(sql[i:])
+			if !ok {
+				continue
+			}
+			end := strings.Index(sql[i+len(tag):], tag)
+			if end == -1 {
+				i = len(sql)
+				continue
+			}
+			end += i + 2*len(tag)
+			line += strings.Count(sql[i:end], "\n")
+			i = end - 1
+		}
+	}
+
+	if copied == 0 {
+		return sql, nil
+	}
+	sb.WriteString(sql[copied:])
+	return sb.String(), nil
+}
@@ -0,0 +1,52 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckMetaCommands(t *testing.T) {
+	t.Run("Failed with the line", func(t *testing.T) {
+		_, err := checkMetaCommands("CREATE TABLE t (id INT);\n  \\timing on\nSELECT 1;\n", "001-init.sql", false)
+		assert.ErrorIs(t, err, ErrMetaCommand)
+		assert.EqualError(t, err, `psql meta-command \timing at line 2 of 001-init.sql: remove it or use --strip-meta-commands`)
+	})
+
+	t.Run("Stripped keeping the lines", func(t *testing.T) {
+		sql, err := checkMetaCommands("\\set ON_ERROR_STOP on\r\nCREATE TABLE t (id INT);\n\\timing", "001-init.sql", true)
+		assert.NoError(t, err)
+		assert.Equal(t, "\r\nCREATE TABLE t (id INT);\n", sql)
+	})
+
+	t.Run("Includes are never stripped", func(t *testing.T) {
+		_, err := checkMetaCommands("\\ir common.sql\n", "001-init.sql", true)
+		assert.ErrorIs(t, err, ErrMetaCommand)
+		assert.ErrorContains(t, err, `\ir at line 1 of 001-init.sql: includes are resolved with --resolve-includes`)
+	})
+
+	t.Run("Backslashes in SQL are kept", func(t *testing.T) {
+		for _, sql := range []string{
+			"SELECT E'a\n\\timing';",
+			"SELECT 'a\n\\set';",
+			"SELECT \"a\n\\b\" FROM t;",
+			"CREATE FUNCTION f() RETURNS TEXT AS $$\n\\x\n$$ LANGUAGE sql;",
+			"/* block\n\\timing */ SELECT 1;",
+			"-- \\timing\nSELECT 1;",
+			"SELECT 1 \\\\ SELECT 2;",
+		} {
+			got, err := checkMetaCommands(sql, "001-init.sql", false)
+			assert.NoError(t, err, sql)
+			assert.Equal(t, sql, got)
+		}
+	})
+
+	t.Run("Line numbers after multiline literals", func(t *testing.T) {
+		_, err := checkMetaCommands("SELECT 'a\nb';\n/* x\ny */\n\\gset\n", "001-init.sql", false)
+		assert.ErrorContains(t, err, `\gset at line 5 of`)
+	})
+}
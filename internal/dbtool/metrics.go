@@ -0,0 +1,255 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"go.uber.org/zap"
+)
+
+// pushTimeout bounds the push to the Pushgateway, which also runs after the context has been cancelled
+const pushTimeout = 10 * time.Second
+
+// runMetrics holds the values exported at the end of a run
+type runMetrics struct {
+	appId     string
+	timestamp time.Time
+	applied   int
+	success   bool
+	durations []fileDuration
+	// lastSuccess is the time of the last successful run before a failed one, zero when unknown
+	lastSuccess time.Time
+}
+
+// fileDuration is the execution time of an applied migration
+type fileDuration struct {
+	path     string
+	duration time.Duration
+	// appliedAt is the time recorded in the migrations table
+	appliedAt time.Time
+}
+
+// migrationTimings collects the execution times of the applied migrations, also of parallel ones,
+// and the failed best-effort ones, a nil collector records nothing
+type migrationTimings struct {
+	mu        sync.Mutex
+	durations []fileDuration
+	failed    []string
+	// bytes and statements are the totals of the SQL executed by the applied migrations
+	bytes      int64
+	statements int
+}
+
+func (t *migrationTimings) record(path string, duration time.Duration, appliedAt time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.durations = append(t.durations, fileDuration{path: path, duration: duration, appliedAt: appliedAt})
+}
+
+// recordSize adds the size of the SQL of an applied migration to the totals
+func (t *migrationTimings) recordSize(bytes int, statements int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bytes += int64(bytes)
+	t.statements += statements
+}
+
+// totals returns the number of bytes and statements of the SQL executed by the applied migrations
+func (t *migrationTimings) totals() (int64, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.bytes, t.statements
+}
+
+func (t *migrationTimings) recordFailed(path string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failed = append(t.failed, path)
+}
+
+// failedPaths returns the paths of the failed best-effort migrations
+func (t *migrationTimings) failedPaths() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Clone(t.failed)
+}
+
+// sorted returns the recorded execution times ordered by path
+func (t *migrationTimings) sorted() []fileDuration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.SortedFunc(slices.Values(t.durations), func(a, b fileDuration) int {
+		return strings.Compare(a.path, b.path)
+	})
+}
+
+// exportMetrics writes the metrics to the textfile and pushes them to the Pushgateway as configured,
+// failures are logged only so they do not fail the run
+func exportMetrics(ctx context.Context, cfg *config.Config, m runMetrics, logger *zap.Logger) {
+	if path := cfg.MetricsTextfile(); path != "" {
+		if err := writeMetricsTextfile(path, m); err != nil {
+			logger.Error("Error writing metrics textfile", zap.String("path", path), zap.Error(err))
+		}
+	}
+	if pushgatewayURL := cfg.PushgatewayURL(); pushgatewayURL != "" {
+		if err := pushMetrics(ctx, pushgatewayURL, m); err != nil {
+			logger.Error("Error pushing metrics", zap.Error(err))
+		}
+	}
+}
+
+// writeMetrics writes the metrics in the OpenMetrics text format
+func writeMetrics(w io.Writer, m runMetrics) error {
+	labels := fmt.Sprintf(`{app_id="%s"}`, escapeLabelValue(m.appId))
+
+	success := 0
+	if m.success {
+		success = 1
+	}
+
+	var sb strings.Builder
+	sb.WriteString("# TYPE dbtool_last_run_timestamp gauge\n")
+	sb.WriteString("# HELP dbtool_last_run_timestamp Unix time of the last dbtool run.\n")
+	fmt.Fprintf(&sb, "dbtool_last_run_timestamp%s %d\n", labels, m.timestamp.Unix())
+	// A gauge rather than a dbtool_migrations_applied_total counter, the count starts from zero on every run
+	sb.WriteString("# TYPE dbtool_migrations_applied gauge\n")
+	sb.WriteString("# HELP dbtool_migrations_applied Number of migrations applied by the last dbtool run.\n")
+	fmt.Fprintf(&sb, "dbtool_migrations_applied%s %d\n", labels, m.applied)
+	sb.WriteString("# TYPE dbtool_last_run_success gauge\n")
+	sb.WriteString("# HELP dbtool_last_run_success Whether the last dbtool run succeeded (1) or failed (0).\n")
+	fmt.Fprintf(&sb, "dbtool_last_run_success%s %d\n", labels, success)
+	// Left out by a failed run without an earlier success, so a pushed value of an earlier successful run is kept
+	lastSuccess := m.lastSuccess
+	if m.success {
+		lastSuccess = m.timestamp
+	}
+	if !lastSuccess.IsZero() {
+		sb.WriteString("# TYPE dbtool_last_success_timestamp gauge\n")
+		sb.WriteString("# HELP dbtool_last_success_timestamp Unix time of the last successful dbtool run.\n")
+		fmt.Fprintf(&sb, "dbtool_last_success_timestamp%s %d\n", labels, lastSuccess.Unix())
+	}
+	if len(m.durations) > 0 {
+		sb.WriteString("# TYPE dbtool_migration_duration_seconds gauge\n")
+		sb.WriteString("# HELP dbtool_migration_duration_seconds Execution time of the migrations applied by the last dbtool run.\n")
+		for _, d := range m.durations {
+			fmt.Fprintf(&sb, "dbtool_migration_duration_seconds{app_id=\"%s\",file=\"%s\"} %g\n", escapeLabelValue(m.appId), escapeLabelValue(d.path), d.duration.Seconds())
+		}
+	}
+	sb.WriteString("# EOF\n")
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// writeMetricsTextfile atomically replaces the file at path with the metrics, a failed run keeps
+// the last success timestamp of the replaced file
+func writeMetricsTextfile(path string, m runMetrics) error {
+	if !m.success && m.lastSuccess.IsZero() {
+		m.lastSuccess = readLastSuccess(path, m.appId)
+	}
+	return writeFileAtomically(path, func(w io.Writer) error { return writeMetrics(w, m) })
+}
+
+// readLastSuccess returns the last success timestamp of the app written to the metrics file,
+// zero when the file or the sample is missing
+func readLastSuccess(path string, appId string) time.Time {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}
+	}
+	prefix := fmt.Sprintf(`dbtool_last_success_timestamp{app_id="%s"} `, escapeLabelValue(appId))
+	for _, line := range strings.Split(string(data), "\n") {
+		value, ok := strings.CutPrefix(line, prefix)
+		if !ok {
+			continue
+		}
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return time.Unix(seconds, 0)
+		}
+	}
+	return time.Time{}
+}
+
+// writeFileAtomically replaces the file at path with the written contents, the temporary file is created
+// in the same directory so the rename does not cross filesystems
+func writeFileAtomically(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if err = write(tmp); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(0o644); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// pushMetrics pushes the metrics to the Pushgateway grouped by the job dbtool and the app id, with POST only the
+// pushed metrics of the group are replaced
+func pushMetrics(ctx context.Context, pushgatewayURL string, m runMetrics) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), pushTimeout)
+	defer cancel()
+
+	var body bytes.Buffer
+	if err := writeMetrics(&body, m); err != nil {
+		return err
+	}
+
+	endpoint := strings.TrimSuffix(pushgatewayURL, "/") + "/metrics/job/dbtool/app_id/" + url.PathEscape(m.appId)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("pushgateway responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// escapeLabelValue escapes the label value as required by the text format
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
@@ -0,0 +1,177 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	t.Run("OpenMetrics format", func(t *testing.T) {
+		var sb strings.Builder
+		m := runMetrics{appId: "my-app", timestamp: time.Unix(1700000000, 0), applied: 3, success: true, durations: []fileDuration{
+			{path: "001_init.sql", duration: 1500 * time.Millisecond},
+			{path: "002_users.sql", duration: 250 * time.Millisecond},
+		}}
+		err := writeMetrics(&sb, m)
+		assert.NoError(t, err)
+
+		expected := `# TYPE dbtool_last_run_timestamp gauge
+# HELP dbtool_last_run_timestamp Unix time of the last dbtool run.
+dbtool_last_run_timestamp{app_id="my-app"} 1700000000
+# TYPE dbtool_migrations_applied gauge
+# HELP dbtool_migrations_applied Number of migrations applied by the last dbtool run.
+dbtool_migrations_applied{app_id="my-app"} 3
+# TYPE dbtool_last_run_success gauge
+# HELP dbtool_last_run_success Whether the last dbtool run succeeded (1) or failed (0).
+dbtool_last_run_success{app_id="my-app"} 1
+# TYPE dbtool_last_success_timestamp gauge
+# HELP dbtool_last_success_timestamp Unix time of the last successful dbtool run.
+dbtool_last_success_timestamp{app_id="my-app"} 1700000000
+# TYPE dbtool_migration_duration_seconds gauge
+# HELP dbtool_migration_duration_seconds Execution time of the migrations applied by the last dbtool run.
+dbtool_migration_duration_seconds{app_id="my-app",file="001_init.sql"} 1.5
+dbtool_migration_duration_seconds{app_id="my-app",file="002_users.sql"} 0.25
+# EOF
+`
+		assert.Equal(t, expected, sb.String())
+	})
+
+	t.Run("Failed run", func(t *testing.T) {
+		var sb strings.Builder
+		err := writeMetrics(&sb, runMetrics{appId: "my-app", timestamp: time.Unix(0, 0)})
+		assert.NoError(t, err)
+		assert.Contains(t, sb.String(), "dbtool_last_run_success{app_id=\"my-app\"} 0\n")
+		assert.Contains(t, sb.String(), "dbtool_migrations_applied{app_id=\"my-app\"} 0\n")
+		assert.NotContains(t, sb.String(), "dbtool_last_success_timestamp")
+		assert.NotContains(t, sb.String(), "dbtool_migration_duration_seconds")
+	})
+
+	t.Run("Label value is escaped", func(t *testing.T) {
+		var sb strings.Builder
+		err := writeMetrics(&sb, runMetrics{appId: "a\"b\\c"})
+		assert.NoError(t, err)
+		assert.Contains(t, sb.String(), `{app_id="a\"b\\c"}`)
+	})
+}
+
+func TestWriteMetricsTextfile(t *testing.T) {
+	t.Run("Writes and replaces the file", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "dbtool.prom")
+
+		assert.NoError(t, os.WriteFile(path, []byte("stale"), 0o644))
+		assert.NoError(t, writeMetricsTextfile(path, runMetrics{appId: "app", applied: 1, success: true}))
+
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasSuffix(string(data), "# EOF\n"))
+		assert.Contains(t, string(data), "dbtool_migrations_applied{app_id=\"app\"} 1\n")
+
+		// No temporary files are left behind
+		entries, err := os.ReadDir(dir)
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("Failed run keeps the last success timestamp", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dbtool.prom")
+
+		assert.NoError(t, writeMetricsTextfile(path, runMetrics{appId: "app", timestamp: time.Unix(1700000000, 0), applied: 1, success: true}))
+		assert.NoError(t, writeMetricsTextfile(path, runMetrics{appId: "app", timestamp: time.Unix(1700003600, 0)}))
+
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), "dbtool_last_run_success{app_id=\"app\"} 0\n")
+		assert.Contains(t, string(data), "dbtool_last_run_timestamp{app_id=\"app\"} 1700003600\n")
+		assert.Contains(t, string(data), "dbtool_last_success_timestamp{app_id=\"app\"} 1700000000\n")
+
+		// Also after several failed runs
+		assert.NoError(t, writeMetricsTextfile(path, runMetrics{appId: "app", timestamp: time.Unix(1700007200, 0)}))
+		data, err = os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), "dbtool_last_success_timestamp{app_id=\"app\"} 1700000000\n")
+	})
+
+	t.Run("Failed run without an earlier success", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dbtool.prom")
+		assert.NoError(t, writeMetricsTextfile(path, runMetrics{appId: "app", timestamp: time.Unix(1700000000, 0)}))
+
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(data), "dbtool_last_success_timestamp")
+	})
+
+	t.Run("Missing directory returns error", func(t *testing.T) {
+		err := writeMetricsTextfile(filepath.Join(t.TempDir(), "missing", "dbtool.prom"), runMetrics{})
+		assert.Error(t, err)
+	})
+}
+
+func TestMigrationTimings(t *testing.T) {
+	t.Run("Sorted by path", func(t *testing.T) {
+		at := time.Unix(1700000000, 0).UTC()
+		timings := &migrationTimings{}
+		timings.record("b.sql", time.Second, at)
+		timings.record("a.sql", 2*time.Second, at)
+
+		assert.Equal(t, []fileDuration{{path: "a.sql", duration: 2 * time.Second, appliedAt: at}, {path: "b.sql", duration: time.Second, appliedAt: at}}, timings.sorted())
+	})
+
+	t.Run("Totals", func(t *testing.T) {
+		timings := &migrationTimings{}
+		timings.recordSize(120, 3)
+		timings.recordSize(30, 1)
+
+		bytes, statements := timings.totals()
+		assert.Equal(t, int64(150), bytes)
+		assert.Equal(t, 4, statements)
+	})
+
+	t.Run("Nil collector records nothing", func(t *testing.T) {
+		var timings *migrationTimings
+		assert.NotPanics(t, func() { timings.record("a.sql", time.Second, time.Now()) })
+		assert.NotPanics(t, func() { timings.recordSize(10, 1) })
+		assert.NotPanics(t, func() { timings.recordFailed("a.sql") })
+	})
+}
+
+func TestPushMetrics(t *testing.T) {
+	t.Run("Pushes to the app group", func(t *testing.T) {
+		var method, path, body string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			method, path = r.Method, r.URL.EscapedPath()
+			data, _ := io.ReadAll(r.Body)
+			body = string(data)
+		}))
+		defer server.Close()
+
+		err := pushMetrics(context.Background(), server.URL+"/", runMetrics{appId: "my app", applied: 2, success: true})
+		assert.NoError(t, err)
+		assert.Equal(t, http.MethodPost, method)
+		assert.Equal(t, "/metrics/job/dbtool/app_id/my%20app", path)
+		assert.Contains(t, body, "dbtool_migrations_applied{app_id=\"my app\"} 2\n")
+	})
+
+	t.Run("Error status returns error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer server.Close()
+
+		err := pushMetrics(context.Background(), server.URL, runMetrics{appId: "app"})
+		assert.ErrorContains(t, err, "400")
+	})
+}
@@ -0,0 +1,77 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// The reasons an applied migration does not match the file at its position
+var (
+	ErrFileChanged   = errors.New("file content changed")
+	ErrFileReordered = errors.New("file reordered")
+	ErrFileReAdded   = errors.New("file re-added")
+)
+
+// mismatchError explains why the file at idx does not match the applied migration at appliedIdx,
+// with the neighbouring files on disk and in the migration table
+func mismatchError(fsys fs.FS, files []sqlFile, idx int, applied []migration, appliedIdx int) error {
+	f := files[idx]
+	m := applied[appliedIdx]
+	mismatch := func(reason error, detail string) error {
+		return fmt.Errorf("file %s has been moved since applied, %s: %w, %s %s", f.path, m.filePath, reason, detail, positionOf(files, idx, applied, appliedIdx))
+	}
+
+	for i, r := range applied {
+		if r.filePath == f.path {
+			return mismatch(ErrFileReordered, fmt.Sprintf("%s was applied as migration #%d but sorts before the applied %s, restore the previous order", f.path, i+1, m.filePath))
+		}
+	}
+
+	// A file with the content of an applied migration is a copy of it, e.g. a deleted file added back under another name
+	for _, r := range applied {
+		matches, err := hashMatches(r.fileHash, f, fsys)
+		if err != nil {
+			return err
+		}
+		if matches {
+			return mismatch(ErrFileReAdded, fmt.Sprintf("%s has the content of the applied migration %s, remove the copy or rename it back", f.path, r.filePath))
+		}
+	}
+
+	return mismatch(ErrFileReordered, fmt.Sprintf("%s has not been applied yet but sorts before the applied %s, rename it to sort after the applied migrations or use --allow-out-of-order", f.path, m.filePath))
+}
+
+// positionOf describes the position of a mismatch, the paths at the position are in brackets
+func positionOf(files []sqlFile, idx int, applied []migration, appliedIdx int) string {
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.path
+	}
+	appliedPaths := make([]string, len(applied))
+	for i, m := range applied {
+		appliedPaths[i] = m.filePath
+	}
+	return fmt.Sprintf("(at migration #%d, on disk: %s, applied: %s)", idx+1, neighbours(paths, idx), neighbours(appliedPaths, appliedIdx))
+}
+
+// neighbours returns the path at idx in brackets surrounded by the previous and the next one
+func neighbours(paths []string, idx int) string {
+	var around []string
+	for i := max(idx-1, 0); i <= idx+1 && i < len(paths); i++ {
+		if i == idx {
+			around = append(around, "["+paths[i]+"]")
+		} else {
+			around = append(around, paths[i])
+		}
+	}
+	if idx >= len(paths) {
+		around = append(around, "[none]")
+	}
+	return strings.Join(around, ", ")
+}
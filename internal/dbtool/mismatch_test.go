@@ -0,0 +1,93 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkMigrationsMismatch(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001-init.sql":      {Data: []byte("CREATE TABLE init (id INT);")},
+		"002-users.sql":     {Data: []byte("CREATE TABLE users (id INT);")},
+		"003-orders.sql":    {Data: []byte("CREATE TABLE orders (id INT);")},
+		"004-init-copy.sql": {Data: []byte("CREATE TABLE init (id INT);")},
+	}
+
+	files := func(t *testing.T) []sqlFile {
+		var sqlFiles []sqlFile
+		assert.NoError(t, readDir(&sqlFiles, fsys, "", readDirOptions{maxDepth: unlimitedDepth, hashAlgorithm: config.HashSHA256}))
+		prepareFiles(sqlFiles, config.SortLexical)
+		return sqlFiles
+	}
+
+	applied := func(t *testing.T, paths ...string) []migration {
+		var result []migration
+		for _, p := range paths {
+			hash, err := getFileHash(fsys, p, config.HashSHA256)
+			assert.NoError(t, err)
+			result = append(result, migration{filePath: p, fileHash: hash})
+		}
+		return result
+	}
+
+	t.Run("Content changed", func(t *testing.T) {
+		m := applied(t, "001-init.sql", "002-users.sql")
+		m[1].fileHash = "0000"
+		_, err := markMigrations(fsys, files(t), m, -1, false)
+		assert.ErrorIs(t, err, ErrFileChanged)
+		assert.ErrorContains(t, err, "file 002-users.sql has changed since applied")
+		assert.ErrorContains(t, err, "(at migration #2, on disk: 001-init.sql, [002-users.sql], 003-orders.sql, applied: 001-init.sql, [002-users.sql])")
+	})
+
+	t.Run("Reordered", func(t *testing.T) {
+		_, err := markMigrations(fsys, files(t), applied(t, "001-init.sql", "003-orders.sql", "002-users.sql"), -1, false)
+		assert.ErrorIs(t, err, ErrFileReordered)
+		assert.ErrorContains(t, err, "002-users.sql was applied as migration #3 but sorts before the applied 003-orders.sql")
+		assert.ErrorContains(t, err, "(at migration #2, on disk: 001-init.sql, [002-users.sql], 003-orders.sql, applied: 001-init.sql, [003-orders.sql], 002-users.sql)")
+	})
+
+	t.Run("New file sorted before applied ones", func(t *testing.T) {
+		_, err := markMigrations(fsys, files(t), applied(t, "001-init.sql", "003-orders.sql"), -1, false)
+		assert.ErrorIs(t, err, ErrFileReordered)
+		assert.ErrorContains(t, err, "002-users.sql has not been applied yet but sorts before the applied 003-orders.sql")
+	})
+
+	t.Run("Re-added copy", func(t *testing.T) {
+		m := append(applied(t, "001-init.sql", "002-users.sql", "003-orders.sql"), migration{filePath: "005-later.sql", fileHash: "0000"})
+		_, err := markMigrations(fsys, files(t), m, -1, false)
+		assert.ErrorIs(t, err, ErrFileReAdded)
+		assert.ErrorContains(t, err, "004-init-copy.sql has the content of the applied migration 001-init.sql")
+		assert.ErrorContains(t, err, "(at migration #4, on disk: 003-orders.sql, [004-init-copy.sql], applied: 003-orders.sql, [005-later.sql])")
+	})
+
+	t.Run("Applied file missing on disk", func(t *testing.T) {
+		m := append(applied(t, "001-init.sql"), migration{filePath: "001-removed.sql", fileHash: "0000"})
+		_, err := markMigrations(fsys, files(t), m, -1, false)
+		assert.ErrorIs(t, err, ErrFileReordered)
+		assert.ErrorContains(t, err, "file 002-users.sql has been moved since applied, 001-removed.sql")
+		assert.ErrorContains(t, err, "(at migration #2, on disk: 001-init.sql, [002-users.sql], 003-orders.sql, applied: 001-init.sql, [001-removed.sql])")
+	})
+
+	t.Run("Applied migrations after the last file", func(t *testing.T) {
+		// The files missing at the end are reported by the pre-flight check
+		m := append(applied(t, "001-init.sql", "002-users.sql", "003-orders.sql", "004-init-copy.sql"), migration{filePath: "005-later.sql", fileHash: "0000"})
+		matched, err := markMigrations(fsys, files(t), m, -1, false)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, matched)
+	})
+}
+
+func TestNeighbours(t *testing.T) {
+	paths := []string{"a.sql", "b.sql", "c.sql"}
+	assert.Equal(t, "[a.sql], b.sql", neighbours(paths, 0))
+	assert.Equal(t, "a.sql, [b.sql], c.sql", neighbours(paths, 1))
+	assert.Equal(t, "b.sql, [c.sql]", neighbours(paths, 2))
+	assert.Equal(t, "c.sql, [none]", neighbours(paths, 3))
+}
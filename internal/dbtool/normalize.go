@@ -0,0 +1,102 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/clbs-io/dbtool/internal/config"
+)
+
+// normalizedHashSuffix marks the algorithm of a checksum computed over the normalized SQL, e.g. sha256+normalized.
+// The algorithm is stored as the prefix of the checksum, so the file is compared with the same normalization later
+const normalizedHashSuffix = "+" + config.HashModeNormalized
+
+// hashAlgorithmFromConfig returns the algorithm of the checksums of new migrations including the hash mode
+func hashAlgorithmFromConfig(cfg *config.Config) string {
+	if cfg.HashMode() == config.HashModeNormalized {
+		return cfg.HashAlgorithm() + normalizedHashSuffix
+	}
+	return cfg.HashAlgorithm()
+}
+
+// normalizeSQL strips the comments and collapses the whitespace between tokens into a single space.
+// String literals, quoted identifiers and dollar-quoted bodies are kept as they are,
+// so only edits that cannot change what is executed disappear.
+func normalizeSQL(sql string) (string, error) {
+	var sb strings.Builder
+	sb.Grow(len(sql))
+	// A comment separates tokens like whitespace does
+	space := false
+	emit := func(s string) {
+		if space && sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		space = false
+		sb.WriteString(s)
+	}
+
+	line := 1
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '\n':
+			line++
+			space = true
+
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			space = true
+
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			end := strings.IndexByte(sql[i:], '\n')
+			if end == -1 {
+				i = len(sql)
+				continue
+			}
+			i += end - 1
+			space = true
+
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			end, err := skipBlockComment(sql, i, line)
+			if err != nil {
+				return "", err
+			}
+			line += strings.Count(sql[i:end], "\n")
+			i = end - 1
+			space = true
+
+		case c == '\'' || c == '"':
+			escapes := c == '\'' && i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e') && (i < 2 || !isIdentifierChar(sql[i-2]))
+			end, err := skipQuoted(sql, i, escapes, line)
+			if err != nil {
+				return "", err
+			}
+			emit(sql[i:end])
+			line += strings.Count(sql[i:end], "\n")
+			i = end - 1
+
+		case c == '$' && (i == 0 || !isIdentifierChar(sql[i-1])):
+			tag, ok := dollarQuoteTag(sql[i:])
+			if !ok {
+				emit(sql[i : i+1])
+				continue
+			}
+			end := strings.Index(sql[i+len(tag):], tag)
+			if end == -1 {
+				return "", fmt.Errorf("unterminated dollar-quoted string starting at line %d", line)
+			}
+			end += i + 2*len(tag)
+			emit(sql[i:end])
+			line += strings.Count(sql[i:end], "\n")
+			i = end - 1
+
+		default:
+			emit(sql[i : i+1])
+		}
+	}
+
+	return sb.String(), nil
+}
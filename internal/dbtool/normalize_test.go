@@ -0,0 +1,96 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeSQL(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{"Whitespace collapsed", "CREATE TABLE t (\n\tid INT\n);\n", "CREATE TABLE t ( id INT );"},
+		{"Line comments", "-- users\nSELECT 1; -- one\nSELECT 2;", "SELECT 1; SELECT 2;"},
+		{"Block comments", "SELECT/* nested /* comment */ here */1;", "SELECT 1;"},
+		{"String literal kept", "SELECT 'a  -- b\n  c';", "SELECT 'a  -- b\n  c';"},
+		{"Escaped literal kept", `SELECT E'it\'s  /* x */';`, `SELECT E'it\'s  /* x */';`},
+		{"Quoted identifier kept", `SELECT "a   b" FROM t;`, `SELECT "a   b" FROM t;`},
+		{"Dollar-quoted body kept", "CREATE FUNCTION f() RETURNS INT AS $fn$\n  -- body\n  SELECT 1;\n$fn$ LANGUAGE sql;", "CREATE FUNCTION f() RETURNS INT AS $fn$\n  -- body\n  SELECT 1;\n$fn$ LANGUAGE sql;"},
+		{"Parameter placeholder", "SELECT $1 ,  x$y;", "SELECT $1 , x$y;"},
+		{"Only comments", "-- nothing\n/* here */\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeSQL(tt.sql)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("Unterminated", func(t *testing.T) {
+		for _, sql := range []string{"SELECT 'a", "SELECT 1 /* x", "SELECT $$ a"} {
+			_, err := normalizeSQL(sql)
+			assert.Error(t, err, sql)
+		}
+	})
+}
+
+func TestGetFileHash_Normalized(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001-init.sql":      {Data: []byte("CREATE TABLE users (id INT);\n")},
+		"002-comments.sql":  {Data: []byte("\xEF\xBB\xBF-- users table\nCREATE TABLE  users\n\t(id /* key */ INT); -- done\n")},
+		"003-changed.sql":   {Data: []byte("CREATE TABLE users (id BIGINT);\n")},
+		"004-literal.sql":   {Data: []byte("INSERT INTO t VALUES ('a b');")},
+		"005-literal.sql":   {Data: []byte("INSERT INTO t VALUES ('a  b');")},
+		"006-malformed.sql": {Data: []byte("SELECT 'a")},
+	}
+	algorithm := config.HashSHA256 + normalizedHashSuffix
+
+	hash := func(name string) string {
+		h, err := getFileHash(fsys, name, algorithm)
+		require.NoError(t, err)
+		return h
+	}
+
+	t.Run("Cosmetic edits match", func(t *testing.T) {
+		assert.Equal(t, hash("001-init.sql"), hash("002-comments.sql"))
+		assert.True(t, strings.HasPrefix(hash("001-init.sql"), "sha256+normalized:"))
+		assert.Equal(t, algorithm, hashAlgorithmOf(hash("001-init.sql")))
+	})
+
+	t.Run("SQL changes differ", func(t *testing.T) {
+		assert.NotEqual(t, hash("001-init.sql"), hash("003-changed.sql"))
+		assert.NotEqual(t, hash("004-literal.sql"), hash("005-literal.sql"))
+	})
+
+	t.Run("Compared with the stored mode", func(t *testing.T) {
+		exact, err := getFileHash(fsys, "001-init.sql", config.HashSHA256)
+		require.NoError(t, err)
+		f := sqlFile{path: "002-comments.sql", hash: hash("002-comments.sql")}
+
+		matches, err := hashMatches(hash("001-init.sql"), f, fsys)
+		assert.NoError(t, err)
+		assert.True(t, matches)
+
+		// A checksum stored in the exact mode still needs the exact file
+		matches, err = hashMatches(exact, f, fsys)
+		assert.NoError(t, err)
+		assert.False(t, matches)
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		_, err := getFileHash(fsys, "006-malformed.sql", algorithm)
+		assert.ErrorContains(t, err, "could not normalize 006-malformed.sql")
+	})
+}
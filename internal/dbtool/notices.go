@@ -0,0 +1,50 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+// noticeFileKey is the key of the custom data of a connection holding the file it executes
+const noticeFileKey = "clbs_dbtool_file"
+
+// logNotices forwards the notices of the server, e.g. RAISE NOTICE of a long PL/pgSQL migration, to the logger
+// at info level, tagged with the file the connection executes
+func logNotices(connConfig *pgx.ConnConfig, cfg *config.Config, logger *zap.Logger) {
+	if !cfg.LogNotices() {
+		return
+	}
+	connConfig.OnNotice = func(pgConn *pgconn.PgConn, n *pgconn.Notice) {
+		logger.Info("Server notice", noticeFields(pgConn.CustomData(), n)...)
+	}
+}
+
+func noticeFields(data map[string]any, n *pgconn.Notice) []zap.Field {
+	fields := []zap.Field{zap.String("severity", n.Severity), zap.String("message", n.Message)}
+	if file, ok := data[noticeFileKey].(string); ok {
+		fields = append(fields, zap.String("file", file))
+	}
+	if n.Detail != "" {
+		fields = append(fields, zap.String("detail", n.Detail))
+	}
+	return fields
+}
+
+// tagNotices tags the notices received on conn with the file until the returned function is called,
+// the notice handler runs on the goroutine executing the file
+func tagNotices(conn *pgx.Conn, filePath string) func() {
+	if conn == nil {
+		return func() {}
+	}
+	data := conn.PgConn().CustomData()
+	data[noticeFileKey] = filePath
+	return func() {
+		delete(data, noticeFileKey)
+	}
+}
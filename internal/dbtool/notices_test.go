@@ -0,0 +1,56 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestLogNotices(t *testing.T) {
+	t.Run("Enabled by default", func(t *testing.T) {
+		cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db"})
+		require.NoError(t, err)
+
+		connConfig, err := pgx.ParseConfig(cfg.ConnectionString())
+		require.NoError(t, err)
+		logNotices(connConfig, cfg, zap.NewNop())
+		assert.NotNil(t, connConfig.OnNotice)
+	})
+
+	t.Run("Ignored", func(t *testing.T) {
+		cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db", IgnoreNotices: true})
+		require.NoError(t, err)
+
+		connConfig, err := pgx.ParseConfig(cfg.ConnectionString())
+		require.NoError(t, err)
+		logNotices(connConfig, cfg, zap.NewNop())
+		assert.Nil(t, connConfig.OnNotice)
+	})
+}
+
+func TestNoticeFields(t *testing.T) {
+	n := &pgconn.Notice{Severity: "NOTICE", Message: "backfilled 1000 rows"}
+
+	fields := noticeFields(map[string]any{noticeFileKey: "001-backfill.sql"}, n)
+	assert.Equal(t, []zap.Field{zap.String("severity", "NOTICE"), zap.String("message", "backfilled 1000 rows"), zap.String("file", "001-backfill.sql")}, fields)
+
+	// Outside of a migration, e.g. while the migration table is created
+	n.Detail = "skipping"
+	fields = noticeFields(map[string]any{}, n)
+	assert.Equal(t, []zap.Field{zap.String("severity", "NOTICE"), zap.String("message", "backfilled 1000 rows"), zap.String("detail", "skipping")}, fields)
+}
+
+func TestTagNotices_WithoutConnection(t *testing.T) {
+	assert.NotPanics(t, func() {
+		tagNotices(nil, "001-init.sql")()
+	})
+}
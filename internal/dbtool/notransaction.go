@@ -0,0 +1,112 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// sessionResetTimeout bounds resetting the session after a migration run outside of a transaction
+const sessionResetTimeout = 5 * time.Second
+
+// execer executes statements, it is implemented by connections and transactions
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+// executeWithoutTransaction executes a migration with the -- dbtool:no-transaction directive on a single session
+// and returns the duration of its statements. They are always executed one by one, as PostgreSQL runs several
+// statements sent at once in an implicit transaction. The settings and the role are set for the session
+// and reset afterwards, nothing is rolled back when a statement fails
+func executeWithoutTransaction(ctx context.Context, db txBeginner, f sqlFile, sql string, cfg *config.Config, logger *zap.Logger) (time.Duration, error) {
+	session, release, err := acquireSession(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	defer resetSession(ctx, session, f, cfg, logger)
+
+	if timeout := cfg.StatementTimeout(); timeout > 0 {
+		if _, err := session.Exec(ctx, fmt.Sprintf("SET statement_timeout = %d", timeout.Milliseconds())); err != nil {
+			return 0, fmt.Errorf("error while setting statement_timeout: %w", err)
+		}
+	}
+	if searchPath := cfg.SearchPath(); len(searchPath) > 0 {
+		if _, err := session.Exec(ctx, "SET search_path TO "+strings.Join(searchPath, ", ")); err != nil {
+			return 0, fmt.Errorf("error while setting search_path: %w", err)
+		}
+	}
+	if f.role != "" {
+		if _, err := session.Exec(ctx, "SET ROLE "+pgx.Identifier{f.role}.Sanitize()); err != nil {
+			return 0, fmt.Errorf("error while setting role %s of migration %s: %w", f.role, f.path, err)
+		}
+	}
+
+	if hook := cfg.BeforeEach(); hook != "" {
+		if _, err := session.Exec(ctx, hook); err != nil {
+			return 0, fmt.Errorf("error while executing the before-each hook of migration %s: %w", f.path, err)
+		}
+	}
+
+	start := time.Now()
+	err = executeMigration(ctx, session, f.path, sql, true, sqlLogger(cfg, logger))
+	duration := time.Since(start)
+	if err != nil {
+		return duration, err
+	}
+
+	if hook := cfg.AfterEach(); hook != "" {
+		if _, err := session.Exec(ctx, hook); err != nil {
+			return duration, fmt.Errorf("error while executing the after-each hook of migration %s: %w", f.path, err)
+		}
+	}
+	return duration, nil
+}
+
+// acquireSession returns a single session of db, a connection is acquired from the pool in parallel mode
+func acquireSession(ctx context.Context, db txBeginner) (execer, func(), error) {
+	switch db := db.(type) {
+	case *pgxpool.Pool:
+		conn, err := db.Acquire(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error acquiring a connection from the pool: %w", err)
+		}
+		return conn, conn.Release, nil
+	case execer:
+		return db, func() {}, nil
+	}
+	return nil, nil, fmt.Errorf("%T cannot execute statements outside of a transaction", db)
+}
+
+// resetSession resets what executeWithoutTransaction set for the session, so the next migrations are not affected
+func resetSession(ctx context.Context, session execer, f sqlFile, cfg *config.Config, logger *zap.Logger) {
+	var statements []string
+	if f.role != "" {
+		statements = append(statements, "RESET ROLE")
+	}
+	if cfg.StatementTimeout() > 0 {
+		statements = append(statements, "RESET statement_timeout")
+	}
+	if len(cfg.SearchPath()) > 0 {
+		statements = append(statements, "RESET search_path")
+	}
+
+	resetCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sessionResetTimeout)
+	defer cancel()
+	for _, statement := range statements {
+		if _, err := session.Exec(resetCtx, statement); err != nil {
+			logger.Warn("Error resetting the session after the migration", zap.String("file", f.path), zap.String("sql", statement), zap.Error(err))
+		}
+	}
+}
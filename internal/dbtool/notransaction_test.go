@@ -0,0 +1,127 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// fakeSession records the statements executed outside of a transaction and fails the ones listed in errs,
+// its transactions are the fakeTx of fakeBeginner
+type fakeSession struct {
+	fakeBeginner
+	executed []string
+	errs     map[string]error
+	begun    int
+}
+
+func (s *fakeSession) Begin(ctx context.Context) (pgx.Tx, error) {
+	s.begun++
+	return s.fakeBeginner.Begin(ctx)
+}
+
+func (s *fakeSession) Exec(_ context.Context, sql string, _ ...any) (pgconn.CommandTag, error) {
+	s.executed = append(s.executed, sql)
+	return pgconn.CommandTag{}, s.errs[sql]
+}
+
+func TestApplyMigration_NoTransaction(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001-index.sql": {Data: []byte("-- dbtool:no-transaction\nCREATE INDEX CONCURRENTLY users_id ON users (id);\nCREATE INDEX CONCURRENTLY users_email ON users (email);\n")},
+	}
+	f, err := readSQLFile(fsys, "001-index.sql", readDirOptions{hashAlgorithm: config.HashSHA256})
+	require.NoError(t, err)
+	require.True(t, f.noTransaction)
+	f.apply = true
+
+	t.Run("Statements executed one by one and recorded afterwards", func(t *testing.T) {
+		cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db"})
+		require.NoError(t, err)
+
+		db := &fakeSession{fakeBeginner: fakeBeginner{tx: &fakeTx{}}}
+		err = applyMigration(context.Background(), db, nil, fsys, f, cfg, nil, zap.NewNop())
+		assert.NoError(t, err)
+
+		assert.Equal(t, []string{
+			"-- dbtool:no-transaction\nCREATE INDEX CONCURRENTLY users_id ON users (id)",
+			"CREATE INDEX CONCURRENTLY users_email ON users (email)",
+		}, db.executed)
+		assert.Equal(t, 1, db.begun, "only the bookkeeping insert runs in a transaction")
+		require.Len(t, db.tx.executed, 1)
+		assert.Contains(t, db.tx.executed[0], "INSERT INTO")
+		assert.True(t, db.tx.committed)
+	})
+
+	t.Run("Session settings and role reset afterwards", func(t *testing.T) {
+		cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db", StatementTimeout: 90 * time.Second, SearchPath: []string{"app"}})
+		require.NoError(t, err)
+
+		withRole := f
+		withRole.role = "ddl_owner"
+		db := &fakeSession{fakeBeginner: fakeBeginner{tx: &fakeTx{}}}
+		err = applyMigration(context.Background(), db, nil, fsys, withRole, cfg, nil, zap.NewNop())
+		assert.NoError(t, err)
+
+		require.Len(t, db.executed, 8)
+		assert.Equal(t, []string{"SET statement_timeout = 90000", "SET search_path TO app", `SET ROLE "ddl_owner"`}, db.executed[:3])
+		assert.Equal(t, []string{"RESET ROLE", "RESET statement_timeout", "RESET search_path"}, db.executed[5:])
+	})
+
+	t.Run("Failed statement is not recorded", func(t *testing.T) {
+		cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db"})
+		require.NoError(t, err)
+
+		failure := errors.New("relation users does not exist")
+		db := &fakeSession{fakeBeginner: fakeBeginner{tx: &fakeTx{}}, errs: map[string]error{
+			"CREATE INDEX CONCURRENTLY users_email ON users (email)": failure,
+		}}
+		err = applyMigration(context.Background(), db, nil, fsys, f, cfg, nil, zap.NewNop())
+		assert.ErrorIs(t, err, failure)
+		assert.ErrorContains(t, err, "statement 2 of migration 001-index.sql")
+		assert.Zero(t, db.begun)
+		assert.Empty(t, db.tx.executed)
+	})
+
+	t.Run("Failed insert is reported as not recorded", func(t *testing.T) {
+		cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db"})
+		require.NoError(t, err)
+
+		db := &fakeSession{fakeBeginner: fakeBeginner{tx: &fakeTx{execErr: errors.New("connection reset")}}}
+		err = applyMigration(context.Background(), db, nil, fsys, f, cfg, nil, zap.NewNop())
+		assert.ErrorIs(t, err, ErrNotRecorded)
+		assert.True(t, db.tx.rolledBack)
+	})
+}
+
+func TestValidateExecute_NoTransaction(t *testing.T) {
+	cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db"})
+	require.NoError(t, err)
+
+	fsys := fstest.MapFS{
+		"001-init.sql":  {Data: []byte("CREATE TABLE users (id INT)")},
+		"002-index.sql": {Data: []byte("-- dbtool:no-transaction\nCREATE INDEX CONCURRENTLY users_id ON users (id)")},
+	}
+	files := []sqlFile{
+		{path: "001-init.sql", apply: true},
+		{path: "002-index.sql", apply: true, noTransaction: true},
+	}
+
+	tx := &fakeSavepointTx{}
+	err = validateExecute(context.Background(), &fakeSavepointBeginner{tx: tx}, fsys, files, cfg, zap.NewNop())
+	assert.NoError(t, err)
+	require.Len(t, tx.savepoints, 1, "the no-transaction file is not executed")
+	assert.Equal(t, []string{"CREATE TABLE users (id INT)"}, tx.savepoints[0].executed)
+}
@@ -0,0 +1,86 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+// orderFileName is the name of the optional manifest in the migrations root listing the files in the order they are applied
+const orderFileName = "migrations.order"
+
+var ErrInvalidOrderFile = errors.New("invalid " + orderFileName + " file")
+
+// readOrderFile returns the relative paths listed in the order file, one per line,
+// blank lines and lines starting with # are ignored, it reports false when there is no order file
+func readOrderFile(fsys fs.FS) ([]string, bool, error) {
+	data, err := fs.ReadFile(fsys, orderFileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var paths []string
+	seen := make(map[string]int)
+	for idx, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if first, ok := seen[line]; ok {
+			return nil, false, fmt.Errorf("%w: %s is listed on line %d and %d", ErrInvalidOrderFile, line, first, idx+1)
+		}
+		seen[line] = idx + 1
+		paths = append(paths, line)
+	}
+	return paths, true, nil
+}
+
+// orderByManifest sorts the files in the order of the manifest, every discovered file must be listed
+// and every listed file selected by the options must exist
+func orderByManifest(files []sqlFile, order []string, opts readDirOptions) error {
+	positions := make(map[string]int, len(order))
+	for idx, p := range order {
+		positions[p] = idx
+	}
+
+	var unlisted []string
+	found := make(map[string]struct{}, len(files))
+	for _, f := range files {
+		if _, ok := positions[f.path]; !ok {
+			unlisted = append(unlisted, f.path)
+		}
+		found[f.path] = struct{}{}
+	}
+
+	var missing []string
+	for _, p := range order {
+		if _, ok := found[p]; !ok && opts.isFileSelected(p) {
+			missing = append(missing, p)
+		}
+	}
+
+	var problems []string
+	if len(unlisted) > 0 {
+		problems = append(problems, "not listed: "+strings.Join(unlisted, ", "))
+	}
+	if len(missing) > 0 {
+		problems = append(problems, "listed but missing: "+strings.Join(missing, ", "))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidOrderFile, strings.Join(problems, "; "))
+	}
+
+	slices.SortStableFunc(files, func(a, b sqlFile) int {
+		return positions[a.path] - positions[b.path]
+	})
+	return nil
+}
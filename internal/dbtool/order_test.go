@@ -0,0 +1,72 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadOrderFile(t *testing.T) {
+	t.Run("No order file", func(t *testing.T) {
+		_, ok, err := readOrderFile(fstest.MapFS{})
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("Paths, comments and blank lines", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			orderFileName: {Data: []byte("# schema\nv2/b.sql\n\n  a.sql  \n")},
+		}
+		order, ok, err := readOrderFile(fsys)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, []string{"v2/b.sql", "a.sql"}, order)
+	})
+
+	t.Run("Duplicate path", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			orderFileName: {Data: []byte("a.sql\nb.sql\na.sql\n")},
+		}
+		_, _, err := readOrderFile(fsys)
+		assert.ErrorIs(t, err, ErrInvalidOrderFile)
+		assert.ErrorContains(t, err, "a.sql is listed on line 1 and 3")
+	})
+}
+
+func TestOrderByManifest(t *testing.T) {
+	files := func() []sqlFile {
+		return []sqlFile{{path: "a.sql"}, {path: "v2/b.sql"}, {path: "v2/c.sql"}}
+	}
+
+	paths := func(files []sqlFile) []string {
+		var result []string
+		for _, f := range files {
+			result = append(result, f.path)
+		}
+		return result
+	}
+
+	t.Run("Manifest order", func(t *testing.T) {
+		sqlFiles := files()
+		assert.NoError(t, orderByManifest(sqlFiles, []string{"v2/c.sql", "a.sql", "v2/b.sql"}, readDirOptions{}))
+		assert.Equal(t, []string{"v2/c.sql", "a.sql", "v2/b.sql"}, paths(sqlFiles))
+	})
+
+	t.Run("Unlisted and missing files", func(t *testing.T) {
+		err := orderByManifest(files(), []string{"a.sql", "v2/b.sql", "v2/d.sql"}, readDirOptions{})
+		assert.ErrorIs(t, err, ErrInvalidOrderFile)
+		assert.ErrorContains(t, err, "not listed: v2/c.sql; listed but missing: v2/d.sql")
+	})
+
+	t.Run("Excluded files may be listed", func(t *testing.T) {
+		sqlFiles := files()
+		opts := readDirOptions{exclude: []string{"v3/*"}}
+		assert.NoError(t, orderByManifest(sqlFiles, []string{"a.sql", "v3/x.sql", "v2/b.sql", "v2/c.sql"}, opts))
+		assert.Equal(t, []string{"a.sql", "v2/b.sql", "v2/c.sql"}, paths(sqlFiles))
+	})
+}
@@ -0,0 +1,115 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// parallelDirName is the name of the directory holding migrations independent of each other
+const parallelDirName = "parallel"
+
+// parallelGroup returns the path of the parallel directory the file is placed in, empty for a serial file
+func parallelGroup(filePath string) string {
+	dirs := strings.Split(filePath, "/")
+	dirs = dirs[:len(dirs)-1]
+	for i, dir := range dirs {
+		if dir == parallelDirName {
+			return strings.Join(dirs[:i+1], "/") + "/"
+		}
+	}
+	return ""
+}
+
+// migrationBatches splits the files marked for apply into batches executed one after another,
+// the files of a parallel directory form one batch, every serial file forms a batch of its own
+func migrationBatches(files []sqlFile) [][]sqlFile {
+	var batches [][]sqlFile
+	lastGroup := ""
+	batchIdx, lastBatchIdx := -1, -1
+	for _, f := range files {
+		group := parallelGroup(f.path)
+		if group == "" || group != lastGroup {
+			batchIdx++
+		}
+		lastGroup = group
+
+		if !f.apply {
+			continue
+		}
+		if batchIdx != lastBatchIdx {
+			batches = append(batches, nil)
+			lastBatchIdx = batchIdx
+		}
+		batches[len(batches)-1] = append(batches[len(batches)-1], f)
+	}
+	return batches
+}
+
+// hasParallelBatch reports whether any batch holds more than one file
+func hasParallelBatch(files []sqlFile) bool {
+	for _, batch := range migrationBatches(files) {
+		if len(batch) > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// newPool creates the pool used to run parallel migrations, it holds at most max-parallel connections
+func newPool(ctx context.Context, cfg *config.Config, logger *zap.Logger) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(cfg.ConnectionString())
+	if err != nil {
+		return nil, fmt.Errorf("error parsing connection string: %w", err)
+	}
+	poolConfig.MaxConns = int32(cfg.MaxParallel())
+	setApplicationName(poolConfig.ConnConfig, cfg)
+	cancelQueriesOnCancel(poolConfig.ConnConfig)
+	logNotices(poolConfig.ConnConfig, cfg, logger)
+	poolConfig.ConnConfig.ConnectTimeout = time.Duration(cfg.ConnectionTimeout()) * time.Second
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating connection pool: %w", err)
+	}
+	return pool, nil
+}
+
+// applyParallelBatch executes the migrations of the batch concurrently and returns the number of applied and of failed
+// best-effort ones, the first failure cancels the migrations still running, the ones already committed stay applied
+func applyParallelBatch(ctx context.Context, pool *pgxpool.Pool, tr *tracker, fsys fs.FS, batch []sqlFile, cfg *config.Config, timings *migrationTimings, logger *zap.Logger) (int, int, error) {
+	logger.Info("Running parallel migrations...", zap.Int("files", len(batch)), zap.Int("max_parallel", cfg.MaxParallel()))
+
+	var applied, failed atomic.Int64
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(cfg.MaxParallel())
+	for _, f := range batch {
+		g.Go(func() error {
+			migrationFailed, err := applyMigrationOrContinue(gctx, pool, tr, fsys, f, cfg, timings, logger)
+			if err != nil {
+				return err
+			}
+			if migrationFailed {
+				failed.Add(1)
+			} else {
+				applied.Add(1)
+			}
+			return nil
+		})
+	}
+
+	err := g.Wait()
+	return int(applied.Load()), int(failed.Load()), err
+}
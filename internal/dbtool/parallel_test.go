@@ -0,0 +1,161 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParallelGroup(t *testing.T) {
+	assert.Equal(t, "", parallelGroup("001-init.sql"))
+	assert.Equal(t, "", parallelGroup("v1/parallel.sql"))
+	assert.Equal(t, "parallel/", parallelGroup("parallel/001-a.sql"))
+	assert.Equal(t, "v1/parallel/", parallelGroup("v1/parallel/001-a.sql"))
+	assert.Equal(t, "v1/parallel/", parallelGroup("v1/parallel/sub/001-a.sql"))
+}
+
+func TestMigrationBatches(t *testing.T) {
+	files := []sqlFile{
+		{path: "v1/001-init.sql", apply: true},
+		{path: "v1/parallel/a.sql", apply: true},
+		{path: "v1/parallel/b.sql", apply: true},
+		{path: "v1/parallel/c.sql", apply: true},
+		{path: "v1/zzz-after.sql", apply: true},
+		{path: "v2/parallel/a.sql", apply: true},
+		{path: "v3/parallel/a.sql", apply: true},
+		{path: "v3/parallel/b.sql", apply: true},
+	}
+
+	paths := func(batches [][]sqlFile) [][]string {
+		var result [][]string
+		for _, batch := range batches {
+			var b []string
+			for _, f := range batch {
+				b = append(b, f.path)
+			}
+			result = append(result, b)
+		}
+		return result
+	}
+
+	t.Run("Parallel directories form batches", func(t *testing.T) {
+		assert.Equal(t, [][]string{
+			{"v1/001-init.sql"},
+			{"v1/parallel/a.sql", "v1/parallel/b.sql", "v1/parallel/c.sql"},
+			{"v1/zzz-after.sql"},
+			{"v2/parallel/a.sql"},
+			{"v3/parallel/a.sql", "v3/parallel/b.sql"},
+		}, paths(migrationBatches(files)))
+		assert.True(t, hasParallelBatch(files))
+	})
+
+	t.Run("Files not marked for apply are left out", func(t *testing.T) {
+		partial := append([]sqlFile(nil), files...)
+		partial[1].apply = false
+		partial[4].apply = false
+		partial[6].apply = false
+		assert.Equal(t, [][]string{
+			{"v1/001-init.sql"},
+			{"v1/parallel/b.sql", "v1/parallel/c.sql"},
+			{"v2/parallel/a.sql"},
+			{"v3/parallel/b.sql"},
+		}, paths(migrationBatches(partial)))
+	})
+
+	t.Run("Serial files only", func(t *testing.T) {
+		assert.False(t, hasParallelBatch(files[:1]))
+	})
+}
+
+func TestMarkMigrationsParallel(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001-init.sql":   {Data: []byte("CREATE SCHEMA a; CREATE SCHEMA b;")},
+		"parallel/a.sql": {Data: []byte("CREATE TABLE a.t (id INT);")},
+		"parallel/b.sql": {Data: []byte("CREATE TABLE b.t (id INT);")},
+		"parallel/c.sql": {Data: []byte("CREATE TABLE c.t (id INT);")},
+		"zzz-after.sql":  {Data: []byte("SELECT 1;")},
+	}
+
+	files := func(t *testing.T) []sqlFile {
+		var sqlFiles []sqlFile
+		assert.NoError(t, readDir(&sqlFiles, fsys, "", readDirOptions{maxDepth: unlimitedDepth, hashAlgorithm: config.HashSHA256}))
+		prepareFiles(sqlFiles, config.SortLexical)
+		return sqlFiles
+	}
+
+	applied := func(t *testing.T, paths ...string) []migration {
+		var result []migration
+		for _, p := range paths {
+			hash, err := getFileHash(fsys, p, config.HashSHA256)
+			assert.NoError(t, err)
+			result = append(result, migration{filePath: p, fileHash: hash})
+		}
+		return result
+	}
+
+	marked := func(files []sqlFile) []string {
+		var result []string
+		for _, f := range files {
+			if f.apply {
+				result = append(result, f.path)
+			}
+		}
+		return result
+	}
+
+	t.Run("Recorded in completion order", func(t *testing.T) {
+		sqlFiles := files(t)
+		skipped, err := markMigrations(fsys, sqlFiles, applied(t, "001-init.sql", "parallel/c.sql", "parallel/a.sql", "parallel/b.sql"), -1, false)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, skipped)
+		assert.Equal(t, []string{"zzz-after.sql"}, marked(sqlFiles))
+	})
+
+	t.Run("Partially applied batch", func(t *testing.T) {
+		sqlFiles := files(t)
+		skipped, err := markMigrations(fsys, sqlFiles, applied(t, "001-init.sql", "parallel/b.sql"), -1, false)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, skipped)
+		assert.Equal(t, []string{"parallel/a.sql", "parallel/c.sql", "zzz-after.sql"}, marked(sqlFiles))
+	})
+
+	t.Run("Steps", func(t *testing.T) {
+		sqlFiles := files(t)
+		_, err := markMigrations(fsys, sqlFiles, applied(t, "001-init.sql", "parallel/b.sql"), 1, false)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"parallel/a.sql"}, marked(sqlFiles))
+	})
+
+	t.Run("Serial file applied after an incomplete batch", func(t *testing.T) {
+		_, err := markMigrations(fsys, files(t), applied(t, "001-init.sql", "parallel/a.sql", "zzz-after.sql"), -1, false)
+		assert.ErrorContains(t, err, "file parallel/b.sql has been moved since applied, zzz-after.sql")
+	})
+
+	t.Run("Batch file applied after a later serial file", func(t *testing.T) {
+		m := applied(t, "001-init.sql", "parallel/a.sql", "parallel/b.sql", "zzz-after.sql", "parallel/c.sql")
+		_, err := markMigrations(fsys, files(t), m, -1, false)
+		assert.ErrorIs(t, err, ErrFileReordered)
+		assert.ErrorContains(t, err, "parallel/c.sql was applied as migration #5 but sorts before the applied zzz-after.sql")
+	})
+
+	t.Run("Batch ends with its directory", func(t *testing.T) {
+		sqlFiles := files(t)
+		assert.Equal(t, 1, batchEnd(sqlFiles, 0))
+		assert.Equal(t, 4, batchEnd(sqlFiles, 1))
+		assert.Equal(t, 4, batchEnd(sqlFiles, 2))
+		assert.Equal(t, 5, batchEnd(sqlFiles, 4))
+	})
+
+	t.Run("Changed file in batch", func(t *testing.T) {
+		m := applied(t, "001-init.sql", "parallel/a.sql")
+		m[1].fileHash = "0000"
+		_, err := markMigrations(fsys, files(t), m, -1, false)
+		assert.ErrorContains(t, err, "file parallel/a.sql has changed")
+	})
+}
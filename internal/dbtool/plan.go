@@ -0,0 +1,51 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// migrationPlan summarizes the marked files before they are applied
+type migrationPlan struct {
+	toApply int
+	applied int
+	// changed counts the repeatable migrations applied again, they are part of toApply
+	changed int
+	pending int
+	first   string
+	last    string
+}
+
+// newMigrationPlan counts the files marked for apply, applied is the number of already applied ones
+func newMigrationPlan(files []sqlFile, applied int) migrationPlan {
+	plan := migrationPlan{applied: applied}
+	for _, f := range files {
+		if !f.apply {
+			continue
+		}
+		if plan.toApply == 0 {
+			plan.first = f.path
+		}
+		plan.toApply++
+		plan.last = f.path
+		if f.changed {
+			plan.changed++
+		}
+	}
+	plan.pending = len(files) - applied - plan.toApply
+	return plan
+}
+
+// log writes the summary of the plan at the info level, with the first and last file when something is applied
+func (p migrationPlan) log(logger *zap.Logger) {
+	fields := []zap.Field{zap.Int("to_apply", p.toApply), zap.Int("applied", p.applied), zap.Int("changed", p.changed), zap.Int("pending", p.pending)}
+	if p.toApply > 0 {
+		fields = append(fields, zap.String("first", p.first), zap.String("last", p.last))
+	}
+	logger.Info(fmt.Sprintf("%d migrations to apply, %d already applied, %d changed", p.toApply, p.applied, p.changed), fields...)
+}
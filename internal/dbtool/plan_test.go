@@ -0,0 +1,49 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestNewMigrationPlan(t *testing.T) {
+	files := []sqlFile{
+		{path: "001-init.sql"},
+		{path: "002-users.sql"},
+		{path: "003-orders.sql", apply: true},
+		{path: "004-items.sql", apply: true},
+		{path: "005-later.sql"},
+		{path: "R__views.sql", repeatable: true, changed: true, apply: true},
+		{path: "R__functions.sql", repeatable: true},
+	}
+
+	plan := newMigrationPlan(files, 3)
+	assert.Equal(t, migrationPlan{toApply: 3, applied: 3, changed: 1, pending: 1, first: "003-orders.sql", last: "R__views.sql"}, plan)
+
+	t.Run("Log", func(t *testing.T) {
+		core, logs := observer.New(zap.InfoLevel)
+		plan.log(zap.New(core))
+
+		entries := logs.FilterMessage("3 migrations to apply, 3 already applied, 1 changed").All()
+		assert.Len(t, entries, 1)
+		assert.Equal(t, map[string]any{
+			"to_apply": int64(3), "applied": int64(3), "changed": int64(1), "pending": int64(1),
+			"first": "003-orders.sql", "last": "R__views.sql",
+		}, entries[0].ContextMap())
+	})
+
+	t.Run("Nothing to apply", func(t *testing.T) {
+		core, logs := observer.New(zap.InfoLevel)
+		newMigrationPlan(files[:2], 2).log(zap.New(core))
+
+		entries := logs.FilterMessage("0 migrations to apply, 2 already applied, 0 changed").All()
+		assert.Len(t, entries, 1)
+		assert.NotContains(t, entries[0].ContextMap(), "first")
+	})
+}
@@ -0,0 +1,112 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+const (
+	planStatusApplied = "applied"
+	planStatusPending = "pending"
+	planStatusSkipped = "skipped"
+	planStatusChanged = "changed"
+	planStatusFailed  = "failed"
+)
+
+// planFile is the JSON plan of a migrate run
+type planFile struct {
+	AppId      string             `json:"app_id"`
+	FinishedAt time.Time          `json:"finished_at"`
+	Success    bool               `json:"success"`
+	Error      string             `json:"error,omitempty"`
+	Applied    int                `json:"applied"`
+	Migrations []plannedMigration `json:"migrations"`
+}
+
+// plannedMigration is a discovered migration with its status after the run,
+// the time and duration are set for the migrations applied by the run
+type plannedMigration struct {
+	Path       string     `json:"path"`
+	Hash       string     `json:"hash"`
+	Status     string     `json:"status"`
+	AppliedAt  *time.Time `json:"applied_at,omitempty"`
+	DurationMs *int64     `json:"duration_ms,omitempty"`
+}
+
+// markRecorded flags the files found in the migrations table before the run
+func markRecorded(files []sqlFile, applied []migration) {
+	recorded := make(map[string]struct{}, len(applied))
+	for _, m := range applied {
+		recorded[m.filePath] = struct{}{}
+	}
+	for idx, f := range files {
+		_, files[idx].recorded = recorded[f.path]
+	}
+}
+
+// newPlanFile lists the discovered files with the status of the planned ones, files left out of the plan,
+// i.e. before the last snapshot or tagged for other environments, are skipped
+func newPlanFile(appId string, discovered []sqlFile, planned []sqlFile, timings *migrationTimings, applied int, runErr error) planFile {
+	byPath := make(map[string]sqlFile, len(planned))
+	for _, f := range planned {
+		byPath[f.path] = f
+	}
+
+	executed := make(map[string]fileDuration)
+	failed := make(map[string]struct{})
+	for _, d := range timings.sorted() {
+		executed[d.path] = d
+	}
+	for _, p := range timings.failedPaths() {
+		failed[p] = struct{}{}
+	}
+
+	plan := planFile{AppId: appId, FinishedAt: time.Now().UTC(), Success: runErr == nil, Applied: applied, Migrations: []plannedMigration{}}
+	if runErr != nil {
+		plan.Error = runErr.Error()
+	}
+
+	for _, f := range discovered {
+		m := plannedMigration{Path: f.path, Hash: f.hash}
+		p, ok := byPath[f.path]
+		d, wasExecuted := executed[f.path]
+		_, hasFailed := failed[f.path]
+		switch {
+		case !ok:
+			m.Status = planStatusSkipped
+		case wasExecuted:
+			m.Status = planStatusApplied
+			if p.changed {
+				m.Status = planStatusChanged
+			}
+			appliedAt, durationMs := d.appliedAt, d.duration.Milliseconds()
+			m.AppliedAt, m.DurationMs = &appliedAt, &durationMs
+		case hasFailed:
+			m.Status = planStatusFailed
+		case p.changed:
+			m.Status = planStatusChanged
+		case p.recorded:
+			m.Status = planStatusApplied
+		default:
+			m.Status = planStatusPending
+		}
+		plan.Migrations = append(plan.Migrations, m)
+	}
+	return plan
+}
+
+func writePlan(w io.Writer, plan planFile) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(plan)
+}
+
+// writePlanFile atomically replaces the file at path with the plan
+func writePlanFile(path string, plan planFile) error {
+	return writeFileAtomically(path, func(w io.Writer) error { return writePlan(w, plan) })
+}
@@ -0,0 +1,107 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkRecorded(t *testing.T) {
+	files := []sqlFile{{path: "001-init.sql"}, {path: "002-users.sql"}, {path: "R__views.sql"}}
+	markRecorded(files, []migration{{filePath: "001-init.sql"}, {filePath: "R__views.sql"}})
+	assert.True(t, files[0].recorded)
+	assert.False(t, files[1].recorded)
+	assert.True(t, files[2].recorded)
+}
+
+func TestNewPlanFile(t *testing.T) {
+	discovered := []sqlFile{
+		{path: "v1/001-init.sql", hash: "h1"},
+		{path: "v2/001-init.sql", hash: "h2"},
+		{path: "v2/002-seed.sql", hash: "h3", tags: []string{"dev"}},
+		{path: "v2/003-users.sql", hash: "h4"},
+		{path: "v2/best-effort/004-backfill.sql", hash: "h5"},
+		{path: "v2/005-orders.sql", hash: "h6"},
+		{path: "R__functions.sql", hash: "h7", repeatable: true},
+		{path: "R__views.sql", hash: "h8", repeatable: true},
+	}
+	// v1 is before the last snapshot and the seed is tagged for another environment
+	planned := []sqlFile{
+		{path: "v2/001-init.sql", hash: "h2", recorded: true},
+		{path: "v2/003-users.sql", hash: "h4", apply: true},
+		{path: "v2/best-effort/004-backfill.sql", hash: "h5", apply: true},
+		{path: "v2/005-orders.sql", hash: "h6", apply: true},
+		{path: "R__functions.sql", hash: "h7", repeatable: true, recorded: true, changed: true, apply: true},
+		{path: "R__views.sql", hash: "h8", repeatable: true, recorded: true},
+	}
+
+	at := time.Date(2026, 10, 14, 8, 30, 0, 0, time.UTC)
+	timings := &migrationTimings{}
+	timings.record("v2/003-users.sql", 1500*time.Millisecond, at)
+	timings.recordFailed("v2/best-effort/004-backfill.sql")
+
+	plan := newPlanFile("app", discovered, planned, timings, 1, errors.New("connection reset"))
+	assert.Equal(t, "app", plan.AppId)
+	assert.False(t, plan.Success)
+	assert.Equal(t, "connection reset", plan.Error)
+	assert.Equal(t, 1, plan.Applied)
+
+	durationMs := int64(1500)
+	assert.Equal(t, []plannedMigration{
+		{Path: "v1/001-init.sql", Hash: "h1", Status: planStatusSkipped},
+		{Path: "v2/001-init.sql", Hash: "h2", Status: planStatusApplied},
+		{Path: "v2/002-seed.sql", Hash: "h3", Status: planStatusSkipped},
+		{Path: "v2/003-users.sql", Hash: "h4", Status: planStatusApplied, AppliedAt: &at, DurationMs: &durationMs},
+		{Path: "v2/best-effort/004-backfill.sql", Hash: "h5", Status: planStatusFailed},
+		{Path: "v2/005-orders.sql", Hash: "h6", Status: planStatusPending},
+		{Path: "R__functions.sql", Hash: "h7", Status: planStatusChanged},
+		{Path: "R__views.sql", Hash: "h8", Status: planStatusApplied},
+	}, plan.Migrations)
+
+	t.Run("Changed repeatable applied by the run", func(t *testing.T) {
+		timings.record("R__functions.sql", 0, at)
+		plan := newPlanFile("app", discovered, planned, timings, 2, nil)
+		assert.True(t, plan.Success)
+		assert.Empty(t, plan.Error)
+		assert.Equal(t, planStatusChanged, plan.Migrations[6].Status)
+		assert.Equal(t, &at, plan.Migrations[6].AppliedAt)
+	})
+}
+
+func TestWritePlanFile(t *testing.T) {
+	at := time.Date(2026, 10, 14, 8, 30, 0, 0, time.UTC)
+	durationMs := int64(12)
+	plan := planFile{AppId: "app", FinishedAt: at, Success: true, Applied: 1, Migrations: []plannedMigration{
+		{Path: "001-init.sql", Hash: "h1", Status: planStatusApplied, AppliedAt: &at, DurationMs: &durationMs},
+		{Path: "002-users.sql", Hash: "h2", Status: planStatusPending},
+	}}
+
+	path := filepath.Join(t.TempDir(), "plan.json")
+	assert.NoError(t, writePlanFile(path, plan))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"app_id": "app",
+		"finished_at": "2026-10-14T08:30:00Z",
+		"success": true,
+		"applied": 1,
+		"migrations": [
+			{"path": "001-init.sql", "hash": "h1", "status": "applied", "applied_at": "2026-10-14T08:30:00Z", "duration_ms": 12},
+			{"path": "002-users.sql", "hash": "h2", "status": "pending"}
+		]
+	}`, string(data))
+
+	var decoded planFile
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, plan.Migrations[1], decoded.Migrations[1])
+}
@@ -0,0 +1,59 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"go.uber.org/zap"
+)
+
+// planHashOutput is the JSON written by the plan-hash command
+type planHashOutput struct {
+	PlanHash string `json:"plan_hash"`
+	Files    int    `json:"files"`
+}
+
+// PlanHash writes the hash of the ordered paths and checksums of the discovered migrations to w without connecting
+// to a database, e.g. to skip a deploy step when nothing changed. It reflects the files on disk, not the applied
+// migrations
+func PlanHash(logger *zap.Logger, cfg *config.Config, w io.Writer) error {
+	fsys, closeFS, err := openMigrationsFS(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeFS()
+
+	sqlFiles, err := discoverFiles(fsys, cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Migrations hashed", zap.Int("files", len(sqlFiles)))
+	return writePlanHash(w, planHash(sqlFiles), len(sqlFiles), cfg.Output())
+}
+
+func writePlanHash(w io.Writer, hash string, files int, output string) error {
+	if output == config.OutputJSON {
+		return json.NewEncoder(w).Encode(planHashOutput{PlanHash: hash, Files: files})
+	}
+	_, err := fmt.Fprintln(w, hash)
+	return err
+}
+
+// planHash returns the SHA-256 of the paths and checksums in the order the files are applied
+func planHash(files []sqlFile) string {
+	h := sha256.New()
+	for _, f := range files {
+		// A NUL byte is part of neither a path nor a checksum
+		_, _ = io.WriteString(h, f.path+"\x00"+f.hash+"\x00")
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
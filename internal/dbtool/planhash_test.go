@@ -0,0 +1,56 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPlanHash(t *testing.T) {
+	files := []sqlFile{{path: "001-init.sql", hash: "aaa"}, {path: "002-users.sql", hash: "bbb"}}
+
+	hash := planHash(files)
+	assert.Len(t, hash, 64)
+	assert.Equal(t, hash, planHash([]sqlFile{{path: "001-init.sql", hash: "aaa"}, {path: "002-users.sql", hash: "bbb"}}))
+
+	// A changed checksum, order or path changes the hash
+	assert.NotEqual(t, hash, planHash([]sqlFile{{path: "001-init.sql", hash: "aaa"}, {path: "002-users.sql", hash: "ccc"}}))
+	assert.NotEqual(t, hash, planHash([]sqlFile{files[1], files[0]}))
+	assert.NotEqual(t, hash, planHash([]sqlFile{{path: "001-init.sqlaaa", hash: ""}, files[1]}))
+	assert.NotEqual(t, hash, planHash(files[:1]))
+
+	assert.Equal(t, planHash(nil), planHash([]sqlFile{}))
+}
+
+func TestPlanHashCommand(t *testing.T) {
+	cfg, err := config.New(config.Options{AppId: "app", Dir: "../../testing/samples/test-dir", ConnectionString: "postgres://localhost/db"})
+	require.NoError(t, err)
+
+	var first, second bytes.Buffer
+	require.NoError(t, PlanHash(zap.NewNop(), cfg, &first))
+	require.NoError(t, PlanHash(zap.NewNop(), cfg, &second))
+	assert.Len(t, strings.TrimSpace(first.String()), 64)
+	assert.Equal(t, first.String(), second.String())
+}
+
+func TestWritePlanHash(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writePlanHash(&buf, "abc", 2, config.OutputText))
+	assert.Equal(t, "abc\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, writePlanHash(&buf, "abc", 2, config.OutputJSON))
+	var out planHashOutput
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &out))
+	assert.Equal(t, planHashOutput{PlanHash: "abc", Files: 2}, out)
+}
@@ -0,0 +1,149 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+var ErrPreflightFailed = errors.New("pre-flight check failed")
+
+const (
+	problemMissingOnDisk = "missing-on-disk"
+	problemHashChanged   = "hash-changed"
+	problemPathMoved     = "path-moved"
+)
+
+type preflightProblem struct {
+	kind string
+	// path is the path recorded in the migration table
+	path string
+	// diskPath is the file on disk the recorded migration most likely corresponds to, empty when there is none
+	diskPath string
+}
+
+func (p preflightProblem) String() string {
+	if p.diskPath == "" {
+		return fmt.Sprintf("%s: %s", p.kind, p.path)
+	}
+	return fmt.Sprintf("%s: %s (on disk: %s)", p.kind, p.path, p.diskPath)
+}
+
+// preflightCheck diffs the applied migrations against the discovered files. A recorded file that is gone is reported as
+// moved when a file with the same content exists elsewhere and as missing otherwise, with skipFileValidation the
+// changed files are not reported.
+func preflightCheck(fsys fs.FS, files []sqlFile, applied []migration, skipFileValidation bool) ([]preflightProblem, error) {
+	onDisk := make(map[string]sqlFile, len(files))
+	for _, f := range files {
+		onDisk[f.path] = f
+	}
+	recorded := make(map[string]struct{}, len(applied))
+	for _, m := range applied {
+		recorded[m.filePath] = struct{}{}
+	}
+
+	var problems []preflightProblem
+	for _, m := range applied {
+		if f, ok := onDisk[m.filePath]; ok {
+			if skipFileValidation {
+				continue
+			}
+			matches, err := hashMatches(m.fileHash, f, fsys)
+			if err != nil {
+				return nil, err
+			}
+			if !matches {
+				problems = append(problems, preflightProblem{kind: problemHashChanged, path: m.filePath, diskPath: f.path})
+			}
+			continue
+		}
+
+		moved, err := findMovedFile(fsys, files, recorded, m)
+		if err != nil {
+			return nil, err
+		}
+		if moved != "" {
+			problems = append(problems, preflightProblem{kind: problemPathMoved, path: m.filePath, diskPath: moved})
+			continue
+		}
+
+		problems = append(problems, preflightProblem{kind: problemMissingOnDisk, path: m.filePath, diskPath: nearestFile(files, m.filePath)})
+	}
+
+	return problems, nil
+}
+
+// findMovedFile returns the not recorded file with the same content as the applied migration
+func findMovedFile(fsys fs.FS, files []sqlFile, recorded map[string]struct{}, m migration) (string, error) {
+	for _, f := range files {
+		if _, ok := recorded[f.path]; ok {
+			continue
+		}
+		matches, err := hashMatches(m.fileHash, f, fsys)
+		if err != nil {
+			return "", err
+		}
+		if matches {
+			return f.path, nil
+		}
+	}
+	return "", nil
+}
+
+// nearestFile returns the file with the same name in another directory, or else the file that would follow the missing one
+func nearestFile(files []sqlFile, missing string) string {
+	name := path.Base(missing)
+	for _, f := range files {
+		if path.Base(f.path) == name {
+			return f.path
+		}
+	}
+
+	for _, f := range files {
+		if f.path > missing {
+			return f.path
+		}
+	}
+	return ""
+}
+
+// checkPreflight logs the problems found by the pre-flight check and fails unless only missing files are allowed,
+// it returns the applied migrations whose files are present on disk
+func checkPreflight(fsys fs.FS, files []sqlFile, applied []migration, skipFileValidation bool, allowMissing bool, logger *zap.Logger) ([]migration, error) {
+	problems, err := preflightCheck(fsys, files, applied, skipFileValidation)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := make(map[string]struct{})
+	var failures []string
+	for _, p := range problems {
+		if p.kind == problemMissingOnDisk && allowMissing {
+			logger.Warn("Applied migration is missing on disk", zap.String("file", p.path), zap.String("nearest", p.diskPath))
+			missing[p.path] = struct{}{}
+			continue
+		}
+		logger.Error("Pre-flight check problem", zap.String("problem", p.kind), zap.String("file", p.path), zap.String("disk_path", p.diskPath))
+		failures = append(failures, p.String())
+	}
+	if len(failures) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrPreflightFailed, strings.Join(failures, "; "))
+	}
+
+	if len(missing) == 0 {
+		return applied, nil
+	}
+	return slices.DeleteFunc(slices.Clone(applied), func(m migration) bool {
+		_, ok := missing[m.filePath]
+		return ok
+	}), nil
+}
@@ -0,0 +1,76 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestPreflightCheck(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001-init.sql":       {Data: []byte("CREATE TABLE init (id INT);")},
+		"v2/002-users.sql":   {Data: []byte("CREATE TABLE users (id INT);")},
+		"v2/004-items.sql":   {Data: []byte("CREATE TABLE items (id INT);")},
+		"v3/005-changed.sql": {Data: []byte("CREATE TABLE changed (id INT, name TEXT);")},
+	}
+
+	var files []sqlFile
+	assert.NoError(t, readDir(&files, fsys, "", readDirOptions{maxDepth: unlimitedDepth, hashAlgorithm: config.HashSHA256}))
+	prepareFiles(files, config.SortLexical)
+
+	hash := func(t *testing.T, name string) string {
+		h, err := getFileHash(fsys, name, config.HashSHA256)
+		assert.NoError(t, err)
+		return h
+	}
+
+	applied := []migration{
+		{filePath: "001-init.sql", fileHash: hash(t, "001-init.sql")},
+		{filePath: "002-users.sql", fileHash: hash(t, "v2/002-users.sql")},
+		{filePath: "v2/003-orders.sql", fileHash: "0000"},
+		{filePath: "v3/005-changed.sql", fileHash: "0000"},
+	}
+
+	t.Run("Problems", func(t *testing.T) {
+		problems, err := preflightCheck(fsys, files, applied, false)
+		assert.NoError(t, err)
+		assert.Equal(t, []preflightProblem{
+			{kind: problemPathMoved, path: "002-users.sql", diskPath: "v2/002-users.sql"},
+			{kind: problemMissingOnDisk, path: "v2/003-orders.sql", diskPath: "v2/004-items.sql"},
+			{kind: problemHashChanged, path: "v3/005-changed.sql", diskPath: "v3/005-changed.sql"},
+		}, problems)
+	})
+
+	t.Run("Skip file validation", func(t *testing.T) {
+		problems, err := preflightCheck(fsys, files, applied[3:], true)
+		assert.NoError(t, err)
+		assert.Empty(t, problems)
+	})
+
+	t.Run("Missing file with the same name elsewhere", func(t *testing.T) {
+		assert.Equal(t, "v2/004-items.sql", nearestFile(files, "old/004-items.sql"))
+		assert.Equal(t, "", nearestFile(files, "v9/999-last.sql"))
+	})
+
+	t.Run("Errors name the recorded and disk path", func(t *testing.T) {
+		_, err := checkPreflight(fsys, files, applied[:3], false, false, zap.NewNop())
+		assert.ErrorIs(t, err, ErrPreflightFailed)
+		assert.EqualError(t, err, "pre-flight check failed: path-moved: 002-users.sql (on disk: v2/002-users.sql); missing-on-disk: v2/003-orders.sql (on disk: v2/004-items.sql)")
+	})
+
+	t.Run("Allow missing", func(t *testing.T) {
+		remaining, err := checkPreflight(fsys, files, []migration{applied[0], applied[2]}, false, true, zap.NewNop())
+		assert.NoError(t, err)
+		assert.Equal(t, []migration{applied[0]}, remaining)
+
+		_, err = checkPreflight(fsys, files, applied[:2], false, true, zap.NewNop())
+		assert.ErrorIs(t, err, ErrPreflightFailed)
+	})
+}
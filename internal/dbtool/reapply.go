@@ -0,0 +1,40 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"io/fs"
+
+	"go.uber.org/zap"
+)
+
+// markChanged marks the applied versioned migrations whose checksum differs from the recorded one to be applied again
+// in their position, up to the target when set, and returns their number
+func markChanged(fsys fs.FS, files []sqlFile, applied []migration, target string, logger *zap.Logger) (int, error) {
+	stored := make(map[string]string, len(applied))
+	for _, m := range applied {
+		stored[m.filePath] = m.fileHash
+	}
+
+	changed := 0
+	for idx, f := range files {
+		if hash, ok := stored[f.path]; ok {
+			matches, err := hashMatches(hash, f, fsys)
+			if err != nil {
+				return 0, err
+			}
+			if !matches {
+				logger.Info("Applied migration has changed, applying it again", zap.String("file", f.path))
+				files[idx].apply = true
+				files[idx].changed = true
+				changed++
+			}
+		}
+		if f.path == target {
+			break
+		}
+	}
+	return changed, nil
+}
@@ -0,0 +1,62 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestMarkChanged(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001-init.sql":  {Data: []byte("CREATE TABLE users (id INT);")},
+		"002-views.sql": {Data: []byte("CREATE OR REPLACE VIEW active_users AS SELECT * FROM users;")},
+		"003-funcs.sql": {Data: []byte("SELECT 3;")},
+		"004-new.sql":   {Data: []byte("SELECT 4;")},
+	}
+	hashOf := func(name string) string {
+		h, err := getFileHash(fsys, name, config.HashSHA256)
+		assert.NoError(t, err)
+		return h
+	}
+	newFiles := func() []sqlFile {
+		return []sqlFile{
+			{path: "001-init.sql", hash: hashOf("001-init.sql")},
+			{path: "002-views.sql", hash: hashOf("002-views.sql")},
+			{path: "003-funcs.sql", hash: hashOf("003-funcs.sql")},
+			{path: "004-new.sql", hash: hashOf("004-new.sql"), apply: true},
+		}
+	}
+	applied := []migration{
+		{filePath: "001-init.sql", fileHash: hashOf("001-init.sql")},
+		{filePath: "002-views.sql", fileHash: "stale"},
+		{filePath: "003-funcs.sql", fileHash: "stale"},
+	}
+
+	t.Run("All changed", func(t *testing.T) {
+		files := newFiles()
+		changed, err := markChanged(fsys, files, applied, "", zap.NewNop())
+		assert.NoError(t, err)
+		assert.Equal(t, 2, changed)
+		assert.False(t, files[0].apply)
+		assert.True(t, files[1].apply && files[1].changed)
+		assert.True(t, files[2].apply && files[2].changed)
+		assert.True(t, files[3].apply)
+		assert.False(t, files[3].changed)
+	})
+
+	t.Run("Up to the target", func(t *testing.T) {
+		files := newFiles()
+		changed, err := markChanged(fsys, files, applied, "002-views.sql", zap.NewNop())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, changed)
+		assert.True(t, files[1].changed)
+		assert.False(t, files[2].apply)
+	})
+}
@@ -0,0 +1,74 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// ErrConnectionLost is returned when the connection is lost more often than --max-reconnects allows
+var ErrConnectionLost = errors.New("connection lost")
+
+// reconnectFunc replaces the lost connection of the run and returns the new one
+type reconnectFunc func(ctx context.Context) (*pgx.Conn, error)
+
+// reconnect closes the lost connection, connects again with the retries of the config and moves the lock
+// to the new connection, the lock held in a separate tracking database stays
+func reconnect(ctx context.Context, lost *pgx.Conn, lock *runLock, cfg *config.Config, logger *zap.Logger) (*pgx.Conn, error) {
+	_ = lost.Close(ctx)
+
+	conn, err := connect(ctx, cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	if lock.conn != lost {
+		return conn, nil
+	}
+	if err := lock.reacquire(ctx, conn); err != nil {
+		_ = conn.Close(ctx)
+		return nil, err
+	}
+	return conn, nil
+}
+
+// connectionLost reports whether the connection has been closed by a network error, pgx closes it on such errors,
+// a connection closed because the run was cancelled is not lost
+func connectionLost(ctx context.Context, conn *pgx.Conn) bool {
+	return conn != nil && ctx.Err() == nil && conn.IsClosed()
+}
+
+// reconnectsExhausted is the error of a connection lost once all reconnects have been used
+func reconnectsExhausted(cfg *config.Config, err error) error {
+	if cfg.MaxReconnects() == 0 {
+		return err
+	}
+	return fmt.Errorf("%w after %d reconnects: %w", ErrConnectionLost, cfg.MaxReconnects(), err)
+}
+
+// recordedMigrations returns the hashes of the migrations recorded for the app by path
+func recordedMigrations(ctx context.Context, conn *pgx.Conn, cfg *config.Config) (map[string]string, error) {
+	applied, err := getAppliedMigrations(ctx, *conn, cfg)
+	if err != nil {
+		return nil, err
+	}
+	recorded := make(map[string]string, len(applied))
+	for _, m := range applied {
+		recorded[m.filePath] = m.fileHash
+	}
+	return recorded, nil
+}
+
+// isRecorded reports whether f is recorded with its current content, a repeatable or changed file recorded
+// with an older hash still needs to be applied
+func isRecorded(recorded map[string]string, f sqlFile) bool {
+	hash, ok := recorded[f.path]
+	return ok && hash == f.hash
+}
@@ -0,0 +1,61 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestIsRecorded(t *testing.T) {
+	recorded := map[string]string{"001-init.sql": "hash1", "R__views.sql": "old"}
+
+	assert.True(t, isRecorded(recorded, sqlFile{path: "001-init.sql", hash: "hash1"}))
+	assert.False(t, isRecorded(recorded, sqlFile{path: "002-users.sql", hash: "hash2"}))
+	// A repeatable file recorded with its previous content is applied again
+	assert.False(t, isRecorded(recorded, sqlFile{path: "R__views.sql", hash: "new"}))
+	// Nothing is recorded before the first reconnect
+	assert.False(t, isRecorded(nil, sqlFile{path: "001-init.sql", hash: "hash1"}))
+}
+
+func TestConnectionLost(t *testing.T) {
+	assert.False(t, connectionLost(context.Background(), nil))
+}
+
+func TestReconnectsExhausted(t *testing.T) {
+	cause := errors.New("unexpected EOF")
+
+	t.Run("Disabled", func(t *testing.T) {
+		cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db"})
+		assert.NoError(t, err)
+		assert.Equal(t, cause, reconnectsExhausted(cfg, cause))
+	})
+
+	t.Run("Exhausted", func(t *testing.T) {
+		cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db", MaxReconnects: 2})
+		assert.NoError(t, err)
+		err = reconnectsExhausted(cfg, cause)
+		assert.ErrorIs(t, err, ErrConnectionLost)
+		assert.ErrorIs(t, err, cause)
+		assert.EqualError(t, err, "connection lost after 2 reconnects: unexpected EOF")
+	})
+}
+
+func TestRunLockReacquire_Table(t *testing.T) {
+	cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db"})
+	assert.NoError(t, err)
+
+	// The row of the table lock outlives the lost session, only the connection is replaced
+	lock := &runLock{l: tableLock{appId: "app", owner: "host/token"}, cfg: cfg, logger: zap.NewNop()}
+	conn := &pgx.Conn{}
+	assert.NoError(t, lock.reacquire(context.Background(), conn))
+	assert.Same(t, conn, lock.conn)
+}
@@ -0,0 +1,115 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// relocation is an applied migration whose file has been moved to another path with the same content
+type relocation struct {
+	oldPath string
+	file    sqlFile
+}
+
+// findRelocations matches the applied migrations missing on disk to the not recorded files with the same checksum
+func findRelocations(fsys fs.FS, files []sqlFile, applied []migration) ([]relocation, error) {
+	onDisk := make(map[string]sqlFile, len(files))
+	for _, f := range files {
+		onDisk[f.path] = f
+	}
+	recorded := make(map[string]struct{}, len(applied))
+	for _, m := range applied {
+		recorded[m.filePath] = struct{}{}
+	}
+
+	var relocations []relocation
+	for _, m := range applied {
+		if _, ok := onDisk[m.filePath]; ok {
+			continue
+		}
+		moved, err := findMovedFile(fsys, files, recorded, m)
+		if err != nil {
+			return nil, err
+		}
+		if moved == "" {
+			continue
+		}
+		// A file matches at most one applied migration
+		recorded[moved] = struct{}{}
+		relocations = append(relocations, relocation{oldPath: m.filePath, file: onDisk[moved]})
+	}
+	return relocations, nil
+}
+
+// relocateMovedMigrations marks the files of the applied migrations moved on disk with their recorded path
+// and returns the applied migrations with the new paths, nothing is written until writeRelocations
+func relocateMovedMigrations(fsys fs.FS, files []sqlFile, applied []migration, logger *zap.Logger) ([]migration, error) {
+	relocations, err := findRelocations(fsys, files, applied)
+	if err != nil || len(relocations) == 0 {
+		return applied, err
+	}
+
+	newPaths := make(map[string]string, len(relocations))
+	oldPaths := make(map[string]string, len(relocations))
+	for _, r := range relocations {
+		logger.Info("Applied migration has been moved", zap.String("old_path", r.oldPath), zap.String("new_path", r.file.path))
+		newPaths[r.oldPath] = r.file.path
+		oldPaths[r.file.path] = r.oldPath
+	}
+	for idx, f := range files {
+		files[idx].movedFrom = oldPaths[f.path]
+	}
+
+	relocated := make([]migration, len(applied))
+	for i, m := range applied {
+		if newPath, ok := newPaths[m.filePath]; ok {
+			m.filePath = newPath
+		}
+		relocated[i] = m
+	}
+	return relocated, nil
+}
+
+// writeRelocations updates the stored paths of the moved files in one transaction, it is called once
+// the plan has been matched and before the first migration is applied
+func writeRelocations(ctx context.Context, db txBeginner, files []sqlFile, cfg *config.Config, logger *zap.Logger) error {
+	var moved []sqlFile
+	for _, f := range files {
+		if f.movedFrom != "" {
+			moved = append(moved, f)
+		}
+	}
+	if len(moved) == 0 {
+		return nil
+	}
+
+	//goland:noinspection SqlResolve
+	updatePathSQL := `UPDATE ` + dbtoolTable(cfg, migrationsTableName) + ` SET file_path = $1, migrations_root = $2 WHERE app_id = $3 AND file_path = $4`
+
+	err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		for _, f := range moved {
+			_, err := tx.Exec(ctx, updatePathSQL, f.path, f.rootOrNil(), cfg.AppId(), f.movedFrom)
+			if err != nil {
+				return fmt.Errorf("error while updating path of %s: %w", f.movedFrom, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, f := range moved {
+		logger.Info("Path of the moved migration updated", zap.String("old_path", f.movedFrom), zap.String("new_path", f.path))
+	}
+	return nil
+}
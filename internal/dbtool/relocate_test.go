@@ -0,0 +1,85 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestFindRelocations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"v1/001-init.sql":    {Data: []byte("CREATE TABLE init (id INT);")},
+		"v1/002-users.sql":   {Data: []byte("CREATE TABLE users (id INT);")},
+		"v1/003-copy.sql":    {Data: []byte("CREATE TABLE users (id INT);")},
+		"v1/004-changed.sql": {Data: []byte("CREATE TABLE changed (id INT, name TEXT);")},
+	}
+
+	var files []sqlFile
+	assert.NoError(t, readDir(&files, fsys, "", readDirOptions{maxDepth: unlimitedDepth, hashAlgorithm: config.HashSHA256}))
+	prepareFiles(files, config.SortLexical)
+
+	hash := func(t *testing.T, name string) string {
+		h, err := getFileHash(fsys, name, config.HashSHA256)
+		assert.NoError(t, err)
+		return h
+	}
+
+	applied := []migration{
+		{filePath: "001-init.sql", fileHash: hash(t, "v1/001-init.sql")},
+		{filePath: "002-users.sql", fileHash: hash(t, "v1/002-users.sql")},
+		{filePath: "004-changed.sql", fileHash: "0000"},
+	}
+
+	relocations, err := findRelocations(fsys, files, applied)
+	assert.NoError(t, err)
+	assert.Len(t, relocations, 2)
+	assert.Equal(t, "001-init.sql", relocations[0].oldPath)
+	assert.Equal(t, "v1/001-init.sql", relocations[0].file.path)
+	assert.Equal(t, "002-users.sql", relocations[1].oldPath)
+	assert.Equal(t, "v1/002-users.sql", relocations[1].file.path)
+}
+
+func TestRelocateMovedMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"v1/001-init.sql":  {Data: []byte("CREATE TABLE init (id INT);")},
+		"v1/002-users.sql": {Data: []byte("CREATE TABLE users (id INT);")},
+	}
+
+	var files []sqlFile
+	assert.NoError(t, readDir(&files, fsys, "", readDirOptions{maxDepth: unlimitedDepth, hashAlgorithm: config.HashSHA256}))
+	prepareFiles(files, config.SortLexical)
+
+	applied := []migration{{filePath: "001-init.sql", fileHash: files[0].hash}}
+
+	relocated, err := relocateMovedMigrations(fsys, files, applied, zap.NewNop())
+	assert.NoError(t, err)
+	assert.Equal(t, []migration{{filePath: "v1/001-init.sql", fileHash: files[0].hash}}, relocated)
+	assert.Equal(t, "001-init.sql", files[0].movedFrom)
+	assert.Empty(t, files[1].movedFrom)
+
+	cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db"})
+	assert.NoError(t, err)
+
+	t.Run("Moved paths written in one transaction", func(t *testing.T) {
+		db := &fakeBeginner{tx: &fakeTx{}}
+		assert.NoError(t, writeRelocations(context.Background(), db, files, cfg, zap.NewNop()))
+		assert.Len(t, db.tx.executed, 1)
+		assert.Contains(t, db.tx.executed[0], "UPDATE")
+		assert.True(t, db.tx.committed)
+	})
+
+	t.Run("Nothing written without moved files", func(t *testing.T) {
+		db := &fakeBeginner{tx: &fakeTx{}}
+		assert.NoError(t, writeRelocations(context.Background(), db, files[1:], cfg, zap.NewNop()))
+		assert.Empty(t, db.tx.executed)
+		assert.False(t, db.tx.committed)
+	})
+}
@@ -0,0 +1,122 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+type hashRepair struct {
+	path    string
+	oldHash string
+	newHash string
+}
+
+// Repair updates the stored hashes of the applied migrations that have changed on disk,
+// rows of files that no longer exist are left untouched
+func Repair(ctx context.Context, logger *zap.Logger, cfg *config.Config) (err error) {
+	fsys, closeFS, err := openMigrationsFS(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeFS()
+
+	sqlFiles, err := discoverFiles(fsys, cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	conn, err := connect(ctx, cfg.ForTracking(), logger)
+	if err != nil {
+		return err
+	}
+	defer closeConnection(ctx, conn, &err)
+
+	exists, err := migrationTableExists(ctx, *conn, cfg)
+	if err != nil {
+		return fmt.Errorf("error checking migration table: %w", err)
+	}
+	if !exists {
+		logger.Warn("Migration table does not exist, nothing to repair")
+		return nil
+	}
+
+	appliedMigrations, err := getAppliedMigrations(ctx, *conn, cfg)
+	if err != nil {
+		return fmt.Errorf("error reading applied migrations: %w", err)
+	}
+
+	sqlFiles, err = dropSkipped(sqlFiles, appliedMigrations, logger)
+	if err != nil {
+		return err
+	}
+
+	repairs, err := selectHashRepairs(fsys, sqlFiles, appliedMigrations)
+	if err != nil {
+		return err
+	}
+
+	//goland:noinspection SqlResolve
+	updateHashSQL := `UPDATE ` + dbtoolTable(cfg, migrationsTableName) + ` SET file_hash = $1 WHERE app_id = $2 AND file_path = $3 AND file_hash = $4`
+
+	err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		for _, r := range repairs {
+			_, err := tx.Exec(ctx, updateHashSQL, r.newHash, cfg.AppId(), r.path, r.oldHash)
+			if err != nil {
+				return fmt.Errorf("error while updating hash of %s: %w", r.path, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, r := range repairs {
+		logger.Info("Repaired migration hash", zap.String("file", r.path), zap.String("old_hash", r.oldHash), zap.String("new_hash", r.newHash))
+	}
+	logger.Info("Repair finished", zap.Int("updated", len(repairs)))
+
+	return nil
+}
+
+// selectHashRepairs returns the applied migrations whose file on disk no longer matches the stored hash,
+// the new hash is computed with the algorithm of the stored one
+func selectHashRepairs(fsys fs.FS, files []sqlFile, applied []migration) ([]hashRepair, error) {
+	onDisk := make(map[string]sqlFile, len(files))
+	for _, f := range files {
+		onDisk[f.path] = f
+	}
+
+	var repairs []hashRepair
+	for _, m := range applied {
+		f, ok := onDisk[m.filePath]
+		if !ok {
+			continue
+		}
+
+		matches, err := hashMatches(m.fileHash, f, fsys)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			continue
+		}
+
+		newHash, err := getFileHash(fsys, f.path, hashAlgorithmOf(m.fileHash))
+		if err != nil {
+			return nil, err
+		}
+		repairs = append(repairs, hashRepair{path: m.filePath, oldHash: m.fileHash, newHash: newHash})
+	}
+
+	return repairs, nil
+}
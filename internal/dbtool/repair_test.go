@@ -0,0 +1,54 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectHashRepairs(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001-init.sql":  {Data: []byte("CREATE TABLE init (id INT); -- fixed typo")},
+		"002-users.sql": {Data: []byte("CREATE TABLE users (id INT);")},
+	}
+
+	var files []sqlFile
+	assert.NoError(t, readDir(&files, fsys, "", readDirOptions{maxDepth: unlimitedDepth, hashAlgorithm: config.HashSHA256}))
+
+	usersHash, err := getFileHash(fsys, "002-users.sql", config.HashSHA256)
+	assert.NoError(t, err)
+	initSHA512, err := getFileHash(fsys, "001-init.sql", config.HashSHA512)
+	assert.NoError(t, err)
+	initSHA256, err := getFileHash(fsys, "001-init.sql", config.HashSHA256)
+	assert.NoError(t, err)
+
+	t.Run("Changed files only", func(t *testing.T) {
+		repairs, err := selectHashRepairs(fsys, files, []migration{
+			{filePath: "001-init.sql", fileHash: "0000"},
+			{filePath: "002-users.sql", fileHash: usersHash},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []hashRepair{{path: "001-init.sql", oldHash: "0000", newHash: initSHA256}}, repairs)
+	})
+
+	t.Run("Keeps the stored algorithm", func(t *testing.T) {
+		repairs, err := selectHashRepairs(fsys, files, []migration{{filePath: "001-init.sql", fileHash: "sha512:0000"}})
+		assert.NoError(t, err)
+		assert.Len(t, repairs, 1)
+		assert.Equal(t, initSHA512, repairs[0].newHash)
+		assert.True(t, strings.HasPrefix(repairs[0].newHash, "sha512:"))
+	})
+
+	t.Run("Files missing on disk are left untouched", func(t *testing.T) {
+		repairs, err := selectHashRepairs(fsys, files, []migration{{filePath: "000-removed.sql", fileHash: "0000"}})
+		assert.NoError(t, err)
+		assert.Empty(t, repairs)
+	})
+}
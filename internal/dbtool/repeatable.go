@@ -0,0 +1,132 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	// repeatablePrefix marks a repeatable migration file, the rest of the name has to match the file name pattern
+	repeatablePrefix = "R__"
+	// repeatableDirName is the first level directory holding repeatable migrations
+	repeatableDirName = "repeatable"
+)
+
+// isRepeatablePath reports whether the file is a repeatable migration, re-applied whenever its checksum changes
+func isRepeatablePath(filePath string) bool {
+	return strings.HasPrefix(path.Base(filePath), repeatablePrefix) || strings.HasPrefix(filePath, repeatableDirName+"/")
+}
+
+// versionedName returns the name matched against the file name pattern, without the repeatable prefix
+func versionedName(name string) string {
+	return strings.TrimPrefix(name, repeatablePrefix)
+}
+
+// moveRepeatableLast moves the repeatable migrations after the versioned ones keeping the order of both
+func moveRepeatableLast(files []sqlFile) {
+	slices.SortStableFunc(files, func(a, b sqlFile) int {
+		switch {
+		case a.repeatable == b.repeatable:
+			return 0
+		case a.repeatable:
+			return 1
+		default:
+			return -1
+		}
+	})
+}
+
+// splitRepeatable returns the versioned files and the repeatable ones that follow them, both share the backing array
+func splitRepeatable(files []sqlFile) ([]sqlFile, []sqlFile) {
+	idx := slices.IndexFunc(files, func(f sqlFile) bool { return f.repeatable })
+	if idx == -1 {
+		return files, nil
+	}
+	return files[:idx], files[idx:]
+}
+
+// splitRepeatableMigrations separates the rows of the repeatable migrations from the versioned ones
+func splitRepeatableMigrations(applied []migration) ([]migration, []migration) {
+	var versioned, repeatable []migration
+	for _, m := range applied {
+		if isRepeatablePath(m.filePath) {
+			repeatable = append(repeatable, m)
+		} else {
+			versioned = append(versioned, m)
+		}
+	}
+	return versioned, repeatable
+}
+
+// markRepeatable marks the repeatable migrations that have not been applied yet or whose checksum has changed
+// and returns the number of unchanged ones, the rows of removed files are kept
+func markRepeatable(fsys fs.FS, files []sqlFile, applied []migration, logger *zap.Logger) (int, error) {
+	// The rows are ordered by id, so the last one of a path wins
+	stored := make(map[string]string, len(applied))
+	for _, m := range applied {
+		stored[m.filePath] = m.fileHash
+	}
+
+	unchanged := 0
+	for idx, f := range files {
+		hash, ok := stored[f.path]
+		delete(stored, f.path)
+		if ok {
+			matches, err := hashMatches(hash, f, fsys)
+			if err != nil {
+				return 0, err
+			}
+			if matches {
+				unchanged++
+				continue
+			}
+			files[idx].changed = true
+		}
+		files[idx].apply = true
+	}
+
+	for _, m := range applied {
+		if _, ok := stored[m.filePath]; ok {
+			logger.Warn("Applied repeatable migration is missing on disk", zap.String("file", m.filePath))
+			delete(stored, m.filePath)
+		}
+	}
+
+	return unchanged, nil
+}
+
+// hasPending reports whether any of the files is neither applied nor marked for apply
+func hasPending(files []sqlFile, applied int) bool {
+	marked := 0
+	for _, f := range files {
+		if f.apply {
+			marked++
+		}
+	}
+	return applied+marked < len(files)
+}
+
+// recordRepeatableMigration updates the row of the repeatable migration in the migrations table in place, the row is
+// inserted on the first apply, the arguments are the ones of the insert
+func recordRepeatableMigration(ctx context.Context, tx pgx.Tx, table string, insertSQL string, args []any) error {
+	//goland:noinspection SqlResolve
+	updateRepeatableSQL := `UPDATE ` + table + ` SET file_hash = $2, clbs_dbtool_version = $4, duration_ms = $5, sql_text = $6,
+		migrations_root = $7, git_commit = $8, applied_at = $9, metadata = $10, applied_by = $11, failed = FALSE WHERE file_path = $1 AND app_id = $3`
+
+	tag, err := tx.Exec(ctx, updateRepeatableSQL, args...)
+	if err != nil || tag.RowsAffected() > 0 {
+		return err
+	}
+	_, err = tx.Exec(ctx, insertSQL, args...)
+	return err
+}
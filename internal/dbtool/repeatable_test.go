@@ -0,0 +1,108 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestIsRepeatablePath(t *testing.T) {
+	assert.True(t, isRepeatablePath("R__views.sql"))
+	assert.True(t, isRepeatablePath("002/R__views.sql"))
+	assert.True(t, isRepeatablePath("repeatable/views.sql"))
+	assert.False(t, isRepeatablePath("001_init.sql"))
+	assert.False(t, isRepeatablePath("001/repeatable/views.sql"))
+}
+
+func TestReadDir_Repeatable(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001_init.sql":          {Data: []byte("SELECT 1;")},
+		"R__views.sql":          {Data: []byte("SELECT 2;")},
+		"002/001_users.sql":     {Data: []byte("SELECT 3;")},
+		"repeatable/funcs.sql":  {Data: []byte("SELECT 4;")},
+		"repeatable/R__a.sql":   {Data: []byte("SELECT 5;")},
+		"003_orders.sql":        {Data: []byte("SELECT 6;")},
+		"R__Invalid-Name.sql.x": {Data: []byte("SELECT 7;")},
+	}
+
+	var files []sqlFile
+	assert.NoError(t, readDir(&files, fsys, "", readDirOptions{maxDepth: unlimitedDepth, hashAlgorithm: config.HashSHA256}))
+	prepareFiles(files, config.SortLexical)
+	moveRepeatableLast(files)
+
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.path)
+	}
+	assert.Equal(t, []string{"001_init.sql", "002/001_users.sql", "003_orders.sql", "R__views.sql", "repeatable/R__a.sql", "repeatable/funcs.sql"}, paths)
+
+	versioned, repeatable := splitRepeatable(files)
+	assert.Len(t, versioned, 3)
+	assert.Len(t, repeatable, 3)
+}
+
+func TestReadDir_InvalidRepeatableName(t *testing.T) {
+	fsys := fstest.MapFS{"R__Views.sql": {Data: []byte("SELECT 1;")}}
+
+	var files []sqlFile
+	err := readDir(&files, fsys, "", readDirOptions{maxDepth: unlimitedDepth, hashAlgorithm: config.HashSHA256})
+	assert.Error(t, err)
+}
+
+func TestMarkRepeatable(t *testing.T) {
+	fsys := fstest.MapFS{
+		"R__changed.sql":   {Data: []byte("SELECT 1;")},
+		"R__new.sql":       {Data: []byte("SELECT 2;")},
+		"R__unchanged.sql": {Data: []byte("SELECT 3;")},
+	}
+	hashOf := func(name string) string {
+		h, err := getFileHash(fsys, name, config.HashSHA256)
+		assert.NoError(t, err)
+		return h
+	}
+
+	files := []sqlFile{
+		{path: "R__changed.sql", hash: hashOf("R__changed.sql"), repeatable: true},
+		{path: "R__new.sql", hash: hashOf("R__new.sql"), repeatable: true},
+		{path: "R__unchanged.sql", hash: hashOf("R__unchanged.sql"), repeatable: true},
+	}
+	applied := []migration{
+		{filePath: "R__unchanged.sql", fileHash: "stale"},
+		{filePath: "R__changed.sql", fileHash: "stale"},
+		{filePath: "R__removed.sql", fileHash: "stale"},
+		// The last row of a path wins
+		{filePath: "R__unchanged.sql", fileHash: hashOf("R__unchanged.sql")},
+	}
+
+	unchanged, err := markRepeatable(fsys, files, applied, zap.NewNop())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, unchanged)
+	assert.True(t, files[0].apply)
+	assert.True(t, files[1].apply)
+	assert.False(t, files[2].apply)
+	assert.True(t, files[0].changed)
+	assert.False(t, files[1].changed)
+}
+
+func TestSplitRepeatableMigrations(t *testing.T) {
+	versioned, repeatable := splitRepeatableMigrations([]migration{
+		{filePath: "001_init.sql"},
+		{filePath: "R__views.sql"},
+		{filePath: "002_users.sql"},
+	})
+	assert.Equal(t, []migration{{filePath: "001_init.sql"}, {filePath: "002_users.sql"}}, versioned)
+	assert.Equal(t, []migration{{filePath: "R__views.sql"}}, repeatable)
+}
+
+func TestHasPending(t *testing.T) {
+	files := []sqlFile{{path: "001.sql"}, {path: "002.sql", apply: true}}
+	assert.False(t, hasPending(files, 1))
+	assert.True(t, hasPending(files, 0))
+}
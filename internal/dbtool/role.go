@@ -0,0 +1,105 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// roleFileName is the name of the optional file in a directory holding the role its migrations are applied as,
+// it applies to the subdirectories too unless they have their own
+const roleFileName = ".role"
+
+// maxRoleLength is the longest identifier PostgreSQL keeps, longer ones are truncated
+const maxRoleLength = 63
+
+// resetRoleSQL switches back to the connecting user for the rest of the transaction
+const resetRoleSQL = "SET LOCAL ROLE NONE"
+
+var ErrInvalidRole = errors.New("invalid " + roleFileName + " file")
+
+// reRole matches the accepted role names, lower case as an unquoted name would be folded by the server
+var reRole = regexp.MustCompile(`^[a-z_][a-z0-9_$]*$`)
+
+// assignRoles sets the role of every file from the role file of its directory or the nearest parent having one
+func assignRoles(fsys fs.FS, files []sqlFile) error {
+	roles := make(map[string]string)
+	for i := range files {
+		role, err := dirRole(fsys, path.Dir(files[i].path), roles)
+		if err != nil {
+			return err
+		}
+		files[i].role = role
+	}
+	return nil
+}
+
+// dirRole returns the role of the migrations of the directory, the roles of the visited directories are cached
+func dirRole(fsys fs.FS, dir string, roles map[string]string) (string, error) {
+	if role, ok := roles[dir]; ok {
+		return role, nil
+	}
+
+	role, err := readRoleFile(fsys, dir)
+	if err != nil {
+		return "", err
+	}
+	if role == "" && dir != "." {
+		if role, err = dirRole(fsys, path.Dir(dir), roles); err != nil {
+			return "", err
+		}
+	}
+	roles[dir] = role
+	return role, nil
+}
+
+// readRoleFile returns the role in the role file of the directory, empty when there is none
+func readRoleFile(fsys fs.FS, dir string) (string, error) {
+	name := path.Join(dir, roleFileName)
+	data, err := fs.ReadFile(fsys, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	role := strings.TrimSpace(strings.TrimPrefix(string(data), "\ufeff"))
+	if len(role) > maxRoleLength || !reRole.MatchString(role) {
+		return "", fmt.Errorf("%w: %s: %q must be a lower case identifier of at most %d characters", ErrInvalidRole, name, role, maxRoleLength)
+	}
+	return role, nil
+}
+
+// setRole switches the transaction to the role of the migration, nothing is executed without a role
+func setRole(ctx context.Context, tx pgx.Tx, f sqlFile) error {
+	if f.role == "" {
+		return nil
+	}
+	if _, err := tx.Exec(ctx, "SET LOCAL ROLE "+pgx.Identifier{f.role}.Sanitize()); err != nil {
+		return fmt.Errorf("error while setting role %s of migration %s: %w", f.role, f.path, err)
+	}
+	return nil
+}
+
+// resetRole switches the transaction back to the connecting user after a migration with a role,
+// so the migrations table is written with the privileges of dbtool
+func resetRole(ctx context.Context, tx pgx.Tx, f sqlFile) error {
+	if f.role == "" {
+		return nil
+	}
+	if _, err := tx.Exec(ctx, resetRoleSQL); err != nil {
+		return fmt.Errorf("error while resetting role %s of migration %s: %w", f.role, f.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssignRoles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001-init.sql":                  {Data: []byte("SELECT 1;")},
+		"ddl/.role":                     {Data: []byte("ddl_owner\n")},
+		"ddl/002-tables.sql":            {Data: []byte("SELECT 1;")},
+		"ddl/grants/003-grants.sql":     {Data: []byte("SELECT 1;")},
+		"ddl/app/.role":                 {Data: []byte("\ufeffapp_user")},
+		"ddl/app/004-data.sql":          {Data: []byte("SELECT 1;")},
+		"other/nested/deeper/008-x.sql": {Data: []byte("SELECT 1;")},
+	}
+
+	t.Run("Nearest role file", func(t *testing.T) {
+		var files []sqlFile
+		for _, p := range []string{"001-init.sql", "ddl/002-tables.sql", "ddl/grants/003-grants.sql", "ddl/app/004-data.sql", "other/nested/deeper/008-x.sql"} {
+			files = append(files, sqlFile{path: p})
+		}
+		assert.NoError(t, assignRoles(fsys, files))
+
+		roles := make(map[string]string)
+		for _, f := range files {
+			roles[f.path] = f.role
+		}
+		assert.Equal(t, map[string]string{
+			"001-init.sql":                  "",
+			"ddl/002-tables.sql":            "ddl_owner",
+			"ddl/grants/003-grants.sql":     "ddl_owner",
+			"ddl/app/004-data.sql":          "app_user",
+			"other/nested/deeper/008-x.sql": "",
+		}, roles)
+	})
+
+	t.Run("Invalid role", func(t *testing.T) {
+		for _, role := range []string{"", "DDL_Owner", "ddl owner", "ddl;DROP TABLE x", `"ddl"`, strings.Repeat("r", 64)} {
+			fsys := fstest.MapFS{
+				"ddl/.role":          {Data: []byte(role)},
+				"ddl/002-tables.sql": {Data: []byte("SELECT 1;")},
+			}
+			err := assignRoles(fsys, []sqlFile{{path: "ddl/002-tables.sql"}})
+			assert.ErrorIs(t, err, ErrInvalidRole, role)
+			assert.ErrorContains(t, err, "ddl/.role", role)
+		}
+	})
+}
+
+func TestSetRole(t *testing.T) {
+	t.Run("Set and reset", func(t *testing.T) {
+		tx := &fakeTx{}
+		f := sqlFile{path: "ddl/002-tables.sql", role: "ddl_owner"}
+		assert.NoError(t, setRole(context.Background(), tx, f))
+		assert.NoError(t, resetRole(context.Background(), tx, f))
+		assert.Equal(t, []string{`SET LOCAL ROLE "ddl_owner"`, "SET LOCAL ROLE NONE"}, tx.executed)
+	})
+
+	t.Run("Without a role", func(t *testing.T) {
+		tx := &fakeTx{}
+		assert.NoError(t, setRole(context.Background(), tx, sqlFile{path: "001-init.sql"}))
+		assert.NoError(t, resetRole(context.Background(), tx, sqlFile{path: "001-init.sql"}))
+		assert.Empty(t, tx.executed)
+	})
+}
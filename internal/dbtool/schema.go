@@ -0,0 +1,76 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+)
+
+// defaultSchema holds the dbtool tables unless --schema-per-app is set
+const defaultSchema = "public"
+
+const (
+	migrationsTableName  = "clbs_dbtool_migrations"
+	lockTableName        = "clbs_dbtool_lock"
+	statusTableName      = "clbs_dbtool_status"
+	schemaStateTableName = "clbs_dbtool_schema_state"
+)
+
+// dbtoolSchema returns the schema of the dbtool tables, the schema of the app with --schema-per-app
+func dbtoolSchema(cfg *config.Config) string {
+	if schema := cfg.AppSchema(); schema != "" {
+		return schema
+	}
+	return defaultSchema
+}
+
+// dbtoolTable returns the quoted qualified name of the dbtool table, e.g. "public"."clbs_dbtool_migrations"
+func dbtoolTable(cfg *config.Config, name string) string {
+	return pgx.Identifier{dbtoolSchema(cfg), name}.Sanitize()
+}
+
+// quoteLiteral quotes the value as an SQL string literal, for statements that cannot take parameters
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// widenColumnSQL returns the statement widening the VARCHAR column of a dbtool table created by an older version
+// to length, the column is left as it is when a view depends on it, altering it would fail
+func widenColumnSQL(schema string, table string, column string, length int) string {
+	return fmt.Sprintf(`DO $$
+	BEGIN
+		IF (SELECT character_maximum_length FROM information_schema.columns
+			WHERE table_schema = %[1]s AND table_name = '%[2]s' AND column_name = '%[3]s') < %[4]d THEN
+			ALTER TABLE %[5]s ALTER COLUMN %[3]s TYPE VARCHAR(%[4]d);
+		END IF;
+	EXCEPTION WHEN feature_not_supported THEN
+		RAISE NOTICE '%[3]s of %[2]s is used by a view, it is left narrower than %[4]d';
+	END $$`, quoteLiteral(schema), table, column, length, pgx.Identifier{schema, table}.Sanitize())
+}
+
+// ensureAppSchema creates the schema of the app with --schema-per-app, an existing schema is left as it is,
+// so the CREATE privilege on the database is needed by the first run only
+func ensureAppSchema(ctx context.Context, conn *pgx.Conn, cfg *config.Config) error {
+	schema := cfg.AppSchema()
+	if schema == "" {
+		return nil
+	}
+
+	var exists bool
+	err := conn.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_namespace WHERE nspname = $1)`, schema).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+
+	if _, err := conn.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS "+pgx.Identifier{schema}.Sanitize()); err != nil {
+		return fmt.Errorf("error creating schema %s: %w", schema, err)
+	}
+	return nil
+}
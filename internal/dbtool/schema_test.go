@@ -0,0 +1,58 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"testing"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDbtoolTable(t *testing.T) {
+	newConfig := func(t *testing.T, appId string, schemaPerApp bool) *config.Config {
+		cfg, err := config.New(config.Options{AppId: appId, WithoutDir: true, ConnectionString: "postgres://localhost/db", SchemaPerApp: schemaPerApp})
+		assert.NoError(t, err)
+		return cfg
+	}
+
+	t.Run("Public schema", func(t *testing.T) {
+		cfg := newConfig(t, "my-app", false)
+		assert.Equal(t, `"public"."clbs_dbtool_migrations"`, dbtoolTable(cfg, migrationsTableName))
+		assert.NoError(t, ensureAppSchema(context.Background(), nil, cfg))
+	})
+
+	t.Run("Schema per app", func(t *testing.T) {
+		cfg := newConfig(t, "my-app", true)
+		assert.Equal(t, `"my-app"."clbs_dbtool_migrations"`, dbtoolTable(cfg, migrationsTableName))
+		assert.Equal(t, `"my-app"."clbs_dbtool_lock"`, dbtoolTable(cfg, lockTableName))
+		assert.Equal(t, []string{`"my-app"`}, cfg.SearchPath())
+	})
+
+	t.Run("Quoted app id", func(t *testing.T) {
+		cfg := newConfig(t, `o'neil "app"`, true)
+		assert.Equal(t, `"o'neil ""app"""."clbs_dbtool_status"`, dbtoolTable(cfg, statusTableName))
+	})
+}
+
+func TestQuoteLiteral(t *testing.T) {
+	assert.Equal(t, `'public'`, quoteLiteral("public"))
+	assert.Equal(t, `'o''neil'`, quoteLiteral("o'neil"))
+}
+
+func TestUpgradeMigrationTableSQL(t *testing.T) {
+	for _, statement := range upgradeMigrationTableSQL("o'neil") {
+		assert.Contains(t, statement, `ALTER TABLE "o'neil"."clbs_dbtool_migrations"`)
+	}
+	assert.Contains(t, upgradeMigrationTableSQL("o'neil")[0], `table_schema = 'o''neil'`)
+}
+
+func TestWidenColumnSQL(t *testing.T) {
+	statement := widenColumnSQL("o'neil", lockTableName, "app_id", 255)
+	assert.Contains(t, statement, `table_schema = 'o''neil' AND table_name = 'clbs_dbtool_lock' AND column_name = 'app_id') < 255`)
+	assert.Contains(t, statement, `ALTER TABLE "o'neil"."clbs_dbtool_lock" ALTER COLUMN app_id TYPE VARCHAR(255);`)
+	assert.Contains(t, statement, "EXCEPTION WHEN feature_not_supported")
+}
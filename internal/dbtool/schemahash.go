@@ -0,0 +1,101 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// schemaHashTimeout bounds recording the schema hash, which also runs after the context has been cancelled
+const schemaHashTimeout = 30 * time.Second
+
+func createSchemaStateTableSQL(table string) string {
+	return `
+		CREATE TABLE IF NOT EXISTS ` + table + ` (
+			app_id VARCHAR(255) PRIMARY KEY,
+			schema_hash VARCHAR(64) NOT NULL, -- sha256 of the schema objects as listed by the drift command
+			recorded_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			clbs_dbtool_version VARCHAR(10) NOT NULL
+		)`
+}
+
+// hashSchemaLines returns the hex sha256 of the sorted schema lines
+func hashSchemaLines(lines []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+func currentSchemaHash(ctx context.Context, conn *pgx.Conn) (string, error) {
+	lines, err := dumpSchema(ctx, conn)
+	if err != nil {
+		return "", err
+	}
+	return hashSchemaLines(lines), nil
+}
+
+// checkSchemaHash warns when the live schema differs from the one recorded at the end of the previous run,
+// i.e. it has been changed out-of-band
+func checkSchemaHash(ctx context.Context, conn *pgx.Conn, cfg *config.Config, logger *zap.Logger) error {
+	if err := ensureAppSchema(ctx, conn, cfg); err != nil {
+		return err
+	}
+	if _, err := conn.Exec(ctx, createSchemaStateTableSQL(dbtoolTable(cfg, schemaStateTableName))); err != nil {
+		return err
+	}
+	if _, err := conn.Exec(ctx, widenColumnSQL(dbtoolSchema(cfg), schemaStateTableName, "app_id", config.MaxAppIdLength)); err != nil {
+		return err
+	}
+
+	//goland:noinspection SqlResolve
+	selectSchemaHashSQL := `SELECT schema_hash, recorded_at FROM ` + dbtoolTable(cfg, schemaStateTableName) + ` WHERE app_id = $1`
+
+	var stored string
+	var recordedAt time.Time
+	err := conn.QueryRow(ctx, selectSchemaHashSQL, cfg.AppId()).Scan(&stored, &recordedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Info("No schema hash recorded yet")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	live, err := currentSchemaHash(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if live != stored {
+		logger.Warn("Schema has changed since the previous run, it has been modified out-of-band, use the drift command to see the differences",
+			zap.String("recorded_hash", stored), zap.Time("recorded_at", recordedAt), zap.String("live_hash", live))
+	}
+	return nil
+}
+
+// recordSchemaHash stores the hash of the live schema for the check of the next run
+func recordSchemaHash(ctx context.Context, conn *pgx.Conn, cfg *config.Config) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), schemaHashTimeout)
+	defer cancel()
+
+	live, err := currentSchemaHash(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	//goland:noinspection SqlResolve
+	upsertSchemaHashSQL := `INSERT INTO ` + dbtoolTable(cfg, schemaStateTableName) + ` (app_id, schema_hash, recorded_at, clbs_dbtool_version) VALUES ($1, $2, CURRENT_TIMESTAMP, $3)
+		ON CONFLICT (app_id) DO UPDATE SET schema_hash = EXCLUDED.schema_hash, recorded_at = EXCLUDED.recorded_at, clbs_dbtool_version = EXCLUDED.clbs_dbtool_version`
+
+	_, err = conn.Exec(ctx, upsertSchemaHashSQL, cfg.AppId(), live, cfg.Version())
+	return err
+}
@@ -0,0 +1,21 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashSchemaLines(t *testing.T) {
+	lines := []string{"schema public", "table public.users"}
+
+	assert.Equal(t, hashSchemaLines(lines), hashSchemaLines([]string{"schema public", "table public.users"}))
+	assert.Len(t, hashSchemaLines(lines), 64)
+	assert.NotEqual(t, hashSchemaLines(lines), hashSchemaLines([]string{"schema public", "table public.orders"}))
+	// Lines are not concatenated ambiguously
+	assert.NotEqual(t, hashSchemaLines([]string{"ab", "c"}), hashSchemaLines([]string{"a", "bc"}))
+}
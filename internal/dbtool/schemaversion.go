@@ -0,0 +1,78 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// schemaVersion describes the applied migrations of an app, the zero value means no migration has been applied
+type schemaVersion struct {
+	LastFile  string     `json:"last_file"`
+	AppliedAt *time.Time `json:"applied_at"`
+	Applied   int        `json:"applied"`
+}
+
+// SchemaVersion writes the last applied migration, when it was applied and the number of applied migrations to w,
+// it never modifies the database
+func SchemaVersion(ctx context.Context, logger *zap.Logger, cfg *config.Config, w io.Writer) (err error) {
+	conn, err := connect(ctx, cfg.ForTracking(), logger)
+	if err != nil {
+		return err
+	}
+	defer closeConnection(ctx, conn, &err)
+
+	version, err := getSchemaVersion(ctx, *conn, cfg)
+	if err != nil {
+		return fmt.Errorf("error reading schema version: %w", err)
+	}
+
+	return writeSchemaVersion(w, version, cfg.Output())
+}
+
+func getSchemaVersion(ctx context.Context, conn pgx.Conn, cfg *config.Config) (schemaVersion, error) {
+	var version schemaVersion
+
+	exists, err := migrationTableExists(ctx, conn, cfg)
+	if err != nil || !exists {
+		return version, err
+	}
+
+	//goland:noinspection SqlResolve
+	selectLastMigrationSQL := `SELECT file_path, applied_at, count(*) OVER () FROM ` + dbtoolTable(cfg, migrationsTableName) + ` WHERE app_id = $1 ORDER BY id DESC LIMIT 1`
+
+	err = conn.QueryRow(ctx, selectLastMigrationSQL, cfg.AppId()).Scan(&version.LastFile, &version.AppliedAt, &version.Applied)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return version, nil
+	}
+	return version, err
+}
+
+func writeSchemaVersion(w io.Writer, version schemaVersion, output string) error {
+	if output == config.OutputJSON {
+		return json.NewEncoder(w).Encode(version)
+	}
+
+	if version.Applied == 0 {
+		_, err := fmt.Fprintln(w, "No migrations applied")
+		return err
+	}
+
+	appliedAt := "unknown"
+	if version.AppliedAt != nil {
+		appliedAt = version.AppliedAt.Format(time.RFC3339)
+	}
+	_, err := fmt.Fprintf(w, "Last applied migration: %s\nApplied at: %s\nApplied migrations: %d\n", version.LastFile, appliedAt, version.Applied)
+	return err
+}
@@ -0,0 +1,41 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteSchemaVersion(t *testing.T) {
+	appliedAt := time.Date(2026, 3, 14, 15, 9, 26, 0, time.UTC)
+	version := schemaVersion{LastFile: "v2/003-orders.sql", AppliedAt: &appliedAt, Applied: 3}
+
+	t.Run("Text", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.NoError(t, writeSchemaVersion(&buf, version, config.OutputText))
+		assert.Equal(t, "Last applied migration: v2/003-orders.sql\nApplied at: 2026-03-14T15:09:26Z\nApplied migrations: 3\n", buf.String())
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.NoError(t, writeSchemaVersion(&buf, version, config.OutputJSON))
+		assert.JSONEq(t, `{"last_file":"v2/003-orders.sql","applied_at":"2026-03-14T15:09:26Z","applied":3}`, buf.String())
+	})
+
+	t.Run("Nothing applied", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.NoError(t, writeSchemaVersion(&buf, schemaVersion{}, config.OutputText))
+		assert.Equal(t, "No migrations applied\n", buf.String())
+
+		buf.Reset()
+		assert.NoError(t, writeSchemaVersion(&buf, schemaVersion{}, config.OutputJSON))
+		assert.JSONEq(t, `{"last_file":"","applied_at":null,"applied":0}`, buf.String())
+	})
+}
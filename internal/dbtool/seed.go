@@ -0,0 +1,73 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+var ErrSeedFailed = errors.New("seeding failed")
+
+// readSeedFiles returns the names of the .sql and .sql.gz files of the seed directory in sorted order,
+// subdirectories are not read
+func readSeedFiles(fsys fs.FS) ([]string, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || !strings.HasSuffix(strings.TrimSuffix(name, gzipExtension), ".sql") {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// runSeeds executes the seed scripts of the seed directory, each in its own transaction, nothing is recorded
+// so they run on every run and have to be idempotent
+func runSeeds(ctx context.Context, conn *pgx.Conn, cfg *config.Config, logger *zap.Logger) error {
+	fsys := os.DirFS(cfg.SeedDir())
+	names, err := readSeedFiles(fsys)
+	if err != nil {
+		return fmt.Errorf("%w: error reading seed directory: %w", ErrSeedFailed, err)
+	}
+
+	logger.Info("Running seed scripts...", zap.String("dir", cfg.SeedDir()), zap.Int("files", len(names)))
+	for _, name := range names {
+		if err := runSeed(ctx, conn, fsys, name, cfg, logger); err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrSeedFailed, name, err)
+		}
+	}
+	return nil
+}
+
+func runSeed(ctx context.Context, db txBeginner, fsys fs.FS, name string, cfg *config.Config, logger *zap.Logger) error {
+	logger.Info("Running seed...", zap.String("file", name))
+
+	sql, err := readMigrationSQL(fsys, name, cfg)
+	if err != nil {
+		return err
+	}
+
+	return inMigrationTx(ctx, db, name, logger, func(tx pgx.Tx) error {
+		if err := setLocalSettings(ctx, tx, cfg); err != nil {
+			return err
+		}
+		return executeMigration(ctx, tx, name, sql, cfg.SplitStatements(), sqlLogger(cfg, logger))
+	})
+}
@@ -0,0 +1,50 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestReadSeedFiles(t *testing.T) {
+	names, err := readSeedFiles(fstest.MapFS{
+		"20-countries.sql":     {Data: []byte("SELECT 1;")},
+		"10-currencies.sql.gz": {Data: []byte("")},
+		"README.md":            {Data: []byte("# Seeds")},
+		"nested/30-other.sql":  {Data: []byte("SELECT 3;")},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10-currencies.sql.gz", "20-countries.sql"}, names)
+}
+
+func TestRunSeed(t *testing.T) {
+	fsys := fstest.MapFS{
+		"countries.sql": {Data: []byte("INSERT INTO ${schema}.countries VALUES ('CZ') ON CONFLICT DO NOTHING;")},
+	}
+	cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db", Vars: map[string]string{"schema": "ref"}, SearchPath: []string{"ref"}})
+	assert.NoError(t, err)
+
+	t.Run("Executed in a transaction", func(t *testing.T) {
+		db := &fakeBeginner{tx: &fakeTx{}}
+		assert.NoError(t, runSeed(context.Background(), db, fsys, "countries.sql", cfg, zap.NewNop()))
+		assert.Equal(t, []string{"SET LOCAL search_path TO ref", "INSERT INTO ref.countries VALUES ('CZ') ON CONFLICT DO NOTHING;"}, db.tx.executed)
+		assert.True(t, db.tx.committed)
+	})
+
+	t.Run("Failure is rolled back", func(t *testing.T) {
+		db := &fakeBeginner{tx: &fakeTx{execErr: errors.New("relation does not exist")}}
+		err := runSeed(context.Background(), db, fsys, "countries.sql", cfg, zap.NewNop())
+		assert.ErrorContains(t, err, "relation does not exist")
+		assert.False(t, db.tx.committed)
+		assert.True(t, db.tx.rolledBack)
+	})
+}
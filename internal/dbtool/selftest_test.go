@@ -0,0 +1,42 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestSelfTest(t *testing.T) {
+	t.Run("Reports every check", func(t *testing.T) {
+		checks := runSelfTest()
+
+		names := make([]string, 0, len(checks))
+		for _, check := range checks {
+			names = append(names, check.Name)
+			assert.NoError(t, check.Err, "check %s failed", check.Name)
+		}
+		assert.Equal(t, []string{"filename pattern", "BOM handling", "URL connection string", "ADO connection string", "migration table DDL"}, names)
+	})
+
+	t.Run("Passes", func(t *testing.T) {
+		assert.NoError(t, SelfTest(zap.NewNop()))
+	})
+
+	t.Run("Invalid connection string fails", func(t *testing.T) {
+		assert.Error(t, selfTestConnectionString("User ID=uid;Invalid", "ado"))
+		assert.Error(t, selfTestConnectionString("mysql://localhost", "default"))
+	})
+
+	t.Run("Malformed DDL fails", func(t *testing.T) {
+		assert.Error(t, selfTestDDL("DROP TABLE x"))
+		assert.Error(t, selfTestDDL("CREATE TABLE x (id INT"))
+		assert.Error(t, selfTestDDL("CREATE TABLE x (id INT))"))
+		assert.Error(t, selfTestDDL("CREATE TABLE x (name TEXT DEFAULT 'x)"))
+		assert.NoError(t, selfTestDDL("CREATE TABLE x (name TEXT DEFAULT ')' -- trailing (\n)"))
+	})
+}
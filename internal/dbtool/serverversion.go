@@ -0,0 +1,41 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrServerVersionTooOld = errors.New("server version is below the minimum version")
+
+// checkServerVersion fails when the server_version_num of the server is below the minimum
+func checkServerVersion(ctx context.Context, conn *pgx.Conn, minVersion int) error {
+	var setting string
+	if err := conn.QueryRow(ctx, `SHOW server_version_num`).Scan(&setting); err != nil {
+		return fmt.Errorf("error reading server version: %w", err)
+	}
+	version, err := strconv.Atoi(setting)
+	if err != nil {
+		return fmt.Errorf("error parsing server version %q: %w", setting, err)
+	}
+
+	if version < minVersion {
+		return fmt.Errorf("%w: server is %s, required is %s", ErrServerVersionTooOld, formatServerVersion(version), formatServerVersion(minVersion))
+	}
+	return nil
+}
+
+// formatServerVersion formats a server_version_num the way PostgreSQL reports the version, e.g. 150002 as 15.2
+func formatServerVersion(num int) string {
+	if num >= 100000 {
+		return fmt.Sprintf("%d.%d", num/10000, num%10000)
+	}
+	return fmt.Sprintf("%d.%d.%d", num/10000, num/100%100, num%100)
+}
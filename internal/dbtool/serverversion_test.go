@@ -0,0 +1,17 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatServerVersion(t *testing.T) {
+	assert.Equal(t, "15.2", formatServerVersion(150002))
+	assert.Equal(t, "15.0", formatServerVersion(150000))
+	assert.Equal(t, "9.6.3", formatServerVersion(90603))
+}
@@ -0,0 +1,74 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"go.uber.org/zap"
+)
+
+const sidecarExtension = ".sha256"
+
+var (
+	ErrSidecarChecksumMismatch = errors.New("checksum does not match the sidecar file")
+	ErrSidecarChecksumMissing  = errors.New("sidecar checksum file is missing")
+)
+
+// verifySidecarChecksums compares the hash of every file with the checksum stored in its <file>.sha256 sidecar
+func verifySidecarChecksums(fsys fs.FS, files []sqlFile, missingPolicy string, logger *zap.Logger) error {
+	for _, f := range files {
+		expected, err := readSidecarChecksum(fsys, f.path+sidecarExtension)
+		if errors.Is(err, fs.ErrNotExist) {
+			switch missingPolicy {
+			case config.MissingSidecarIgnore:
+				continue
+			case config.MissingSidecarWarn:
+				logger.Warn("Sidecar checksum file is missing", zap.String("file", f.path))
+				continue
+			default:
+				return fmt.Errorf("%w: %s", ErrSidecarChecksumMissing, f.path)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		// The sidecar always holds a sha256 checksum regardless of the configured algorithm
+		computed := f.hash
+		if hashAlgorithmOf(computed) != config.HashSHA256 {
+			computed, err = getFileHash(fsys, f.path, config.HashSHA256)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !strings.EqualFold(expected, computed) {
+			return fmt.Errorf("%w: %s (expected %s, computed %s)", ErrSidecarChecksumMismatch, f.path, expected, computed)
+		}
+	}
+
+	return nil
+}
+
+// readSidecarChecksum reads the checksum from the sidecar file,
+// both the bare hex digest and the sha256sum output format ("<digest>  <file name>") are accepted
+func readSidecarChecksum(fsys fs.FS, name string) (string, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return "", err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("sidecar checksum file %s is empty", name)
+	}
+
+	return fields[0], nil
+}
@@ -0,0 +1,74 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestVerifySidecarChecksums(t *testing.T) {
+	setup := func(t *testing.T, sidecar string) (fstest.MapFS, []sqlFile) {
+		fsys := fstest.MapFS{
+			"001-init.sql": {Data: []byte("SELECT 1;")},
+		}
+		if sidecar != "" {
+			fsys["001-init.sql.sha256"] = &fstest.MapFile{Data: []byte(sidecar)}
+		}
+
+		var files []sqlFile
+		assert.NoError(t, readDir(&files, fsys, "", readDirOptions{maxDepth: unlimitedDepth, hashAlgorithm: config.HashSHA256}))
+		assert.Len(t, files, 1)
+		return fsys, files
+	}
+
+	t.Run("Matching sidecar", func(t *testing.T) {
+		dir, files := setup(t, "")
+		dir["001-init.sql.sha256"] = &fstest.MapFile{Data: []byte(files[0].hash + "\n")}
+
+		err := verifySidecarChecksums(dir, files, config.MissingSidecarError, zap.NewNop())
+		assert.NoError(t, err)
+	})
+
+	t.Run("Matching sidecar in sha256sum format", func(t *testing.T) {
+		dir, files := setup(t, "")
+		dir["001-init.sql.sha256"] = &fstest.MapFile{Data: []byte(files[0].hash + "  001-init.sql\n")}
+
+		err := verifySidecarChecksums(dir, files, config.MissingSidecarError, zap.NewNop())
+		assert.NoError(t, err)
+	})
+
+	t.Run("Mismatched sidecar", func(t *testing.T) {
+		dir, files := setup(t, "0000000000000000000000000000000000000000000000000000000000000000")
+
+		err := verifySidecarChecksums(dir, files, config.MissingSidecarIgnore, zap.NewNop())
+		assert.ErrorIs(t, err, ErrSidecarChecksumMismatch)
+	})
+
+	t.Run("Missing sidecar with error policy", func(t *testing.T) {
+		dir, files := setup(t, "")
+
+		err := verifySidecarChecksums(dir, files, config.MissingSidecarError, zap.NewNop())
+		assert.ErrorIs(t, err, ErrSidecarChecksumMissing)
+	})
+
+	t.Run("Missing sidecar with warn and ignore policy", func(t *testing.T) {
+		dir, files := setup(t, "")
+
+		assert.NoError(t, verifySidecarChecksums(dir, files, config.MissingSidecarWarn, zap.NewNop()))
+		assert.NoError(t, verifySidecarChecksums(dir, files, config.MissingSidecarIgnore, zap.NewNop()))
+	})
+
+	t.Run("Empty sidecar", func(t *testing.T) {
+		dir, files := setup(t, "\n")
+
+		err := verifySidecarChecksums(dir, files, config.MissingSidecarError, zap.NewNop())
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,35 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"go.uber.org/zap"
+)
+
+var ErrSkippedApplied = errors.New("skipped migration has been applied")
+
+// dropSkipped leaves out the files with the skip directive, a skipped file that has been applied already
+// fails as skipping it would drop it from the validation
+func dropSkipped(files []sqlFile, applied []migration, logger *zap.Logger) ([]sqlFile, error) {
+	recorded := make(map[string]struct{}, len(applied))
+	for _, m := range applied {
+		recorded[m.filePath] = struct{}{}
+	}
+
+	for _, f := range files {
+		if !f.skip {
+			continue
+		}
+		if _, ok := recorded[f.path]; ok {
+			return nil, fmt.Errorf("%w: file %s, remove the -- %s directive", ErrSkippedApplied, f.path, skipDirective)
+		}
+		logger.Info("Skipping migration with the -- "+skipDirective+" directive", zap.String("file", f.path))
+	}
+	return slices.DeleteFunc(files, func(f sqlFile) bool { return f.skip }), nil
+}
@@ -0,0 +1,26 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestDropSkipped(t *testing.T) {
+	files := func() []sqlFile {
+		return []sqlFile{{path: "001-init.sql"}, {path: "002-wip.sql", skip: true}, {path: "003-users.sql"}}
+	}
+
+	selected, err := dropSkipped(files(), []migration{{filePath: "001-init.sql"}}, zap.NewNop())
+	assert.NoError(t, err)
+	assert.Equal(t, []sqlFile{{path: "001-init.sql"}, {path: "003-users.sql"}}, selected)
+
+	_, err = dropSkipped(files(), []migration{{filePath: "001-init.sql"}, {filePath: "002-wip.sql"}}, zap.NewNop())
+	assert.ErrorIs(t, err, ErrSkippedApplied)
+	assert.ErrorContains(t, err, "file 002-wip.sql")
+}
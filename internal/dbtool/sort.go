@@ -0,0 +1,54 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"strings"
+)
+
+// naturalCompare compares two path segments with their digit runs as numbers, so file2.sql precedes file10.sql.
+// Numbers of equal value but different zero padding, e.g. 01 and 1, are ordered lexically, so the order stays total.
+func naturalCompare(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if !isDigit(a[i]) || !isDigit(b[j]) {
+			if a[i] != b[j] {
+				return strings.Compare(a[i:i+1], b[j:j+1])
+			}
+			i++
+			j++
+			continue
+		}
+
+		endA, endB := digitsEnd(a, i), digitsEnd(b, j)
+		numA := strings.TrimLeft(a[i:endA], "0")
+		numB := strings.TrimLeft(b[j:endB], "0")
+		// Without leading zeros the longer number is the greater one
+		if len(numA) != len(numB) {
+			return len(numA) - len(numB)
+		}
+		if c := strings.Compare(numA, numB); c != 0 {
+			return c
+		}
+		i, j = endA, endB
+	}
+
+	if c := (len(a) - i) - (len(b) - j); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// digitsEnd returns the index following the run of digits starting at i
+func digitsEnd(s string, i int) int {
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return i
+}
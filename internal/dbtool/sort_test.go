@@ -0,0 +1,94 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNaturalCompare(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"file2.sql", "file10.sql", -1},
+		{"file10.sql", "file2.sql", 1},
+		{"file2.sql", "file2.sql", 0},
+		{"2-users.sql", "10-orders.sql", -1},
+		{"v2", "v10", -1},
+		{"file.sql", "file2.sql", -1},
+		{"file2a.sql", "file2b.sql", -1},
+		{"file2.sql", "file2a.sql", -1},
+		{"a10b2.sql", "a10b10.sql", -1},
+		{"a9b10.sql", "a10b2.sql", -1},
+		// Zero padding does not change the value, equal values are ordered lexically
+		{"002.sql", "10.sql", -1},
+		{"01.sql", "1.sql", -1},
+		{"1.sql", "01.sql", 1},
+		{"0010-base.sql", "9-init.sql", 1},
+		{"99999999999999999999999-big.sql", "100000000000000000000000-big.sql", -1},
+	} {
+		c := naturalCompare(tc.a, tc.b)
+		switch {
+		case tc.want < 0:
+			assert.Negative(t, c, "%s < %s", tc.a, tc.b)
+		case tc.want > 0:
+			assert.Positive(t, c, "%s > %s", tc.a, tc.b)
+		default:
+			assert.Zero(t, c, "%s = %s", tc.a, tc.b)
+		}
+	}
+}
+
+func TestPrepareFilesNatural(t *testing.T) {
+	paths := func(files []sqlFile) []string {
+		var p []string
+		for _, f := range files {
+			p = append(p, f.path)
+		}
+		return p
+	}
+	newFiles := func(paths ...string) []sqlFile {
+		files := make([]sqlFile, len(paths))
+		for i, p := range paths {
+			files[i] = sqlFile{path: p}
+		}
+		rand.Shuffle(len(files), func(i, j int) { files[i], files[j] = files[j], files[i] })
+		return files
+	}
+
+	t.Run("Unpadded numbers", func(t *testing.T) {
+		files := newFiles("file1.sql", "file2.sql", "file10.sql", "file11.sql", "file100.sql")
+		prepareFiles(files, config.SortNatural)
+		assert.Equal(t, []string{"file1.sql", "file2.sql", "file10.sql", "file11.sql", "file100.sql"}, paths(files))
+
+		prepareFiles(files, config.SortLexical)
+		assert.Equal(t, []string{"file1.sql", "file10.sql", "file100.sql", "file11.sql", "file2.sql"}, paths(files))
+	})
+
+	t.Run("Directories", func(t *testing.T) {
+		files := newFiles("v10/1-init.sql", "v2/10-orders.sql", "v2/2-users.sql", "v2/sub/1-nested.sql")
+		prepareFiles(files, config.SortNatural)
+		assert.Equal(t, []string{"v2/2-users.sql", "v2/10-orders.sql", "v2/sub/1-nested.sql", "v10/1-init.sql"}, paths(files))
+	})
+
+	t.Run("Padded and unpadded", func(t *testing.T) {
+		files := newFiles("001-init.sql", "2-users.sql", "010-orders.sql", "11-items.sql")
+		prepareFiles(files, config.SortNatural)
+		assert.Equal(t, []string{"001-init.sql", "2-users.sql", "010-orders.sql", "11-items.sql"}, paths(files))
+	})
+
+	t.Run("Padded names keep the lexical order", func(t *testing.T) {
+		files := newFiles("0001-init.sql", "0002-users.sql", "0010-orders.sql", "0100-items.sql")
+		prepareFiles(files, config.SortNatural)
+		natural := paths(files)
+		prepareFiles(files, config.SortLexical)
+		assert.Equal(t, paths(files), natural)
+	})
+}
@@ -0,0 +1,189 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"fmt"
+	"strings"
+)
+
+// countStatements returns the number of statements of the SQL text, a text that cannot be split counts as one
+func countStatements(sql string) int {
+	statements, err := splitStatements(sql)
+	if err != nil {
+		return 1
+	}
+	return len(statements)
+}
+
+// splitStatements splits the SQL text into individual statements separated by semicolons,
+// semicolons inside string literals, quoted identifiers, dollar-quoted bodies, comments and SQL-standard
+// BEGIN ATOMIC ... END function bodies are ignored.
+// Statements consisting only of whitespace and comments are dropped.
+func splitStatements(sql string) ([]string, error) {
+	var statements []string
+
+	start := 0
+	hasContent := false
+	line := 1
+	// Like psql, BEGIN and CASE open a block that END closes unless BEGIN starts the statement,
+	// which is a transaction, the semicolons of an open block do not end the statement
+	words := 0
+	depth := 0
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '\n':
+			line++
+
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			end := strings.IndexByte(sql[i:], '\n')
+			if end == -1 {
+				i = len(sql)
+				continue
+			}
+			i += end - 1
+
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			end, err := skipBlockComment(sql, i, line)
+			if err != nil {
+				return nil, err
+			}
+			line += strings.Count(sql[i:end], "\n")
+			i = end - 1
+
+		case c == '\'' || c == '"':
+			hasContent = true
+			escapes := c == '\'' && i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e') && (i < 2 || !isIdentifierChar(sql[i-2]))
+			end, err := skipQuoted(sql, i, escapes, line)
+			if err != nil {
+				return nil, err
+			}
+			line += strings.Count(sql[i:end], "\n")
+			i = end - 1
+
+		case c == '$' && (i == 0 || !isIdentifierChar(sql[i-1])):
+			hasContent = true
+			tag, ok := dollarQuoteTag(sql[i:])
+			if !ok {
+				continue
+			}
+			end := strings.Index(sql[i+len(tag):], tag)
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated dollar-quoted string starting at line %d", line)
+			}
+			end += i + 2*len(tag)
+			line += strings.Count(sql[i:end], "\n")
+			i = end - 1
+
+		case isIdentifierStart(c) && (i == 0 || !isIdentifierChar(sql[i-1])):
+			hasContent = true
+			end := i + 1
+			for end < len(sql) && (isIdentifierChar(sql[end]) || sql[end] == '$') {
+				end++
+			}
+			words++
+			switch word := sql[i:end]; {
+			case strings.EqualFold(word, "begin") || strings.EqualFold(word, "case"):
+				if words > 1 {
+					depth++
+				}
+			case strings.EqualFold(word, "end"):
+				if depth > 0 {
+					depth--
+				}
+			}
+			i = end - 1
+
+		case c == ';':
+			if depth > 0 {
+				continue
+			}
+			if hasContent {
+				statements = append(statements, strings.TrimSpace(sql[start:i]))
+			}
+			start = i + 1
+			hasContent = false
+			words = 0
+
+		case c != ' ' && c != '\t' && c != '\r':
+			hasContent = true
+		}
+	}
+
+	if hasContent {
+		statements = append(statements, strings.TrimSpace(sql[start:]))
+	}
+
+	return statements, nil
+}
+
+// skipQuoted returns the index just after the string literal or quoted identifier starting at start,
+// a doubled quote character is an escaped quote, with escapes a backslash escapes the next character as well
+func skipQuoted(sql string, start int, escapes bool, line int) (int, error) {
+	quote := sql[start]
+	for i := start + 1; i < len(sql); i++ {
+		switch sql[i] {
+		case '\\':
+			if escapes {
+				i++
+			}
+		case quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1, nil
+		}
+	}
+
+	if quote == '"' {
+		return 0, fmt.Errorf("unterminated quoted identifier starting at line %d", line)
+	}
+	return 0, fmt.Errorf("unterminated string literal starting at line %d", line)
+}
+
+// skipBlockComment returns the index just after the block comment starting at start, block comments can be nested
+func skipBlockComment(sql string, start int, line int) (int, error) {
+	depth := 0
+	for i := start; i+1 < len(sql); i++ {
+		switch {
+		case sql[i] == '/' && sql[i+1] == '*':
+			depth++
+			i++
+		case sql[i] == '*' && sql[i+1] == '/':
+			depth--
+			i++
+			if depth == 0 {
+				return i + 1, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unterminated block comment starting at line %d", line)
+}
+
+// dollarQuoteTag returns the opening tag ($$ or $tag$) the text starts with
+func dollarQuoteTag(s string) (string, bool) {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == '$' {
+			return s[:i+1], true
+		}
+		// The tag follows the identifier rules, except that it cannot contain a dollar sign
+		if !isIdentifierChar(c) || (i == 1 && c >= '0' && c <= '9') {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func isIdentifierStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c >= 0x80
+}
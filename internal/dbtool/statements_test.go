@@ -0,0 +1,138 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		expected []string
+	}{
+		{
+			name:     "Simple statements",
+			sql:      "CREATE TABLE a (id INT);\nCREATE TABLE b (id INT);\n",
+			expected: []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)"},
+		},
+		{
+			name:     "Last statement without semicolon",
+			sql:      "SELECT 1;\nSELECT 2",
+			expected: []string{"SELECT 1", "SELECT 2"},
+		},
+		{
+			name:     "Semicolon in string literal",
+			sql:      "INSERT INTO t VALUES ('a;b', 'it''s;');SELECT 1;",
+			expected: []string{"INSERT INTO t VALUES ('a;b', 'it''s;')", "SELECT 1"},
+		},
+		{
+			name:     "Escape string",
+			sql:      `SELECT E'a\';b';SELECT 2;`,
+			expected: []string{`SELECT E'a\';b'`, "SELECT 2"},
+		},
+		{
+			name:     "Backslash in standard string",
+			sql:      `SELECT 'C:\';SELECT 2;`,
+			expected: []string{`SELECT 'C:\'`, "SELECT 2"},
+		},
+		{
+			name:     "Semicolon in quoted identifier",
+			sql:      `CREATE TABLE "a;b" (id INT);SELECT 1;`,
+			expected: []string{`CREATE TABLE "a;b" (id INT)`, "SELECT 1"},
+		},
+		{
+			name: "Dollar-quoted function body",
+			sql: `CREATE FUNCTION f() RETURNS void AS $$
+BEGIN
+	PERFORM 1;
+	RAISE NOTICE 'done;';
+END;
+$$ LANGUAGE plpgsql;
+SELECT f();`,
+			expected: []string{"CREATE FUNCTION f() RETURNS void AS $$\nBEGIN\n\tPERFORM 1;\n\tRAISE NOTICE 'done;';\nEND;\n$$ LANGUAGE plpgsql", "SELECT f()"},
+		},
+		{
+			name:     "Tagged dollar quote containing $$",
+			sql:      "DO $body$ BEGIN EXECUTE $$SELECT 1;$$; END $body$;SELECT 2;",
+			expected: []string{"DO $body$ BEGIN EXECUTE $$SELECT 1;$$; END $body$", "SELECT 2"},
+		},
+		{
+			name:     "Positional parameter is not a dollar quote",
+			sql:      "PREPARE p AS SELECT $1;EXECUTE p(1);",
+			expected: []string{"PREPARE p AS SELECT $1", "EXECUTE p(1)"},
+		},
+		{
+			name:     "Comments",
+			sql:      "-- comment; with semicolon\nSELECT 1; /* block; /* nested; */ comment */ SELECT 2;",
+			expected: []string{"-- comment; with semicolon\nSELECT 1", "/* block; /* nested; */ comment */ SELECT 2"},
+		},
+		{
+			name: "BEGIN ATOMIC function body",
+			sql:  "CREATE FUNCTION f() RETURNS int LANGUAGE sql\nBEGIN ATOMIC\n\tSELECT 1;\n\tSELECT CASE WHEN true THEN 2 END;\nEND;\nSELECT f();",
+			expected: []string{
+				"CREATE FUNCTION f() RETURNS int LANGUAGE sql\nBEGIN ATOMIC\n\tSELECT 1;\n\tSELECT CASE WHEN true THEN 2 END;\nEND",
+				"SELECT f()",
+			},
+		},
+		{
+			name:     "BEGIN ATOMIC procedure body",
+			sql:      "create or replace procedure p() begin atomic insert into t values (1); end; call p();",
+			expected: []string{"create or replace procedure p() begin atomic insert into t values (1); end", "call p()"},
+		},
+		{
+			name:     "Transaction blocks are split",
+			sql:      "BEGIN; SELECT CASE WHEN true THEN 1 END; END; begin_date; SELECT $1;",
+			expected: []string{"BEGIN", "SELECT CASE WHEN true THEN 1 END", "END", "begin_date", "SELECT $1"},
+		},
+		{
+			name:     "Empty statements are dropped",
+			sql:      ";;\n-- only a comment\n;SELECT 1;;",
+			expected: []string{"SELECT 1"},
+		},
+		{
+			name:     "Empty input",
+			sql:      "",
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statements, err := splitStatements(tt.sql)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, statements)
+		})
+	}
+}
+
+func TestSplitStatementsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		sql     string
+		message string
+	}{
+		{name: "Unterminated string", sql: "SELECT 1;\nSELECT 'abc;", message: "unterminated string literal starting at line 2"},
+		{name: "Unterminated identifier", sql: `SELECT "abc`, message: "unterminated quoted identifier starting at line 1"},
+		{name: "Unterminated dollar quote", sql: "SELECT 1;\n\nDO $$ BEGIN", message: "unterminated dollar-quoted string starting at line 3"},
+		{name: "Unterminated block comment", sql: "/* /* */ SELECT 1;", message: "unterminated block comment starting at line 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := splitStatements(tt.sql)
+			assert.EqualError(t, err, tt.message)
+		})
+	}
+}
+
+func TestCountStatements(t *testing.T) {
+	assert.Equal(t, 2, countStatements("CREATE TABLE a (id INT);\n-- comment;\nINSERT INTO a VALUES (1);"))
+	assert.Equal(t, 0, countStatements("-- only a comment"))
+	assert.Equal(t, 1, countStatements("SELECT 'abc;"))
+}
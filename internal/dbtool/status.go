@@ -0,0 +1,72 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"time"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	statusRunning = "running"
+	statusDone    = "done"
+	statusFailed  = "failed"
+)
+
+// statusUpdateTimeout bounds the final status update, which also runs after the context has been cancelled
+const statusUpdateTimeout = 5 * time.Second
+
+func createStatusTableSQL(table string) string {
+	return `
+		CREATE TABLE IF NOT EXISTS ` + table + ` (
+			app_id VARCHAR(255) PRIMARY KEY,
+			status VARCHAR(16) NOT NULL, -- running, done or failed
+			started_at TIMESTAMP NOT NULL,
+			finished_at TIMESTAMP,
+			clbs_dbtool_version VARCHAR(10) NOT NULL
+		)`
+}
+
+// writeStatusRunning marks the migrations of the app as running, outside a transaction so the app sees it at once
+func writeStatusRunning(ctx context.Context, conn *pgx.Conn, cfg *config.Config) error {
+	if err := ensureAppSchema(ctx, conn, cfg); err != nil {
+		return err
+	}
+	if _, err := conn.Exec(ctx, createStatusTableSQL(dbtoolTable(cfg, statusTableName))); err != nil {
+		return err
+	}
+	if _, err := conn.Exec(ctx, widenColumnSQL(dbtoolSchema(cfg), statusTableName, "app_id", config.MaxAppIdLength)); err != nil {
+		return err
+	}
+
+	//goland:noinspection SqlResolve
+	upsertStatusSQL := `INSERT INTO ` + dbtoolTable(cfg, statusTableName) + ` (app_id, status, started_at, finished_at, clbs_dbtool_version) VALUES ($1, $2, CURRENT_TIMESTAMP, NULL, $3)
+		ON CONFLICT (app_id) DO UPDATE SET status = EXCLUDED.status, started_at = EXCLUDED.started_at, finished_at = NULL, clbs_dbtool_version = EXCLUDED.clbs_dbtool_version`
+
+	_, err := conn.Exec(ctx, upsertStatusSQL, cfg.AppId(), statusRunning, cfg.Version())
+	return err
+}
+
+// writeStatusFinished marks the migrations of the app as done or failed depending on the error of the run
+func writeStatusFinished(ctx context.Context, conn *pgx.Conn, cfg *config.Config, runErr error) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), statusUpdateTimeout)
+	defer cancel()
+
+	//goland:noinspection SqlResolve
+	updateStatusSQL := `UPDATE ` + dbtoolTable(cfg, statusTableName) + ` SET status = $1, finished_at = CURRENT_TIMESTAMP WHERE app_id = $2`
+
+	_, err := conn.Exec(ctx, updateStatusSQL, finishedStatus(runErr), cfg.AppId())
+	return err
+}
+
+func finishedStatus(runErr error) string {
+	if runErr != nil {
+		return statusFailed
+	}
+	return statusDone
+}
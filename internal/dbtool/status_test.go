@@ -0,0 +1,17 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFinishedStatus(t *testing.T) {
+	assert.Equal(t, statusDone, finishedStatus(nil))
+	assert.Equal(t, statusFailed, finishedStatus(errors.New("migration failed")))
+}
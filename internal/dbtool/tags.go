@@ -0,0 +1,112 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+const (
+	// tagsDirective declares the environments of a migration in its leading comment, e.g. -- dbtool:tags=staging,dev
+	tagsDirective = "dbtool:tags="
+	// skipDirective excludes a migration that is not ready yet, e.g. -- dbtool:skip waiting for the backfill
+	skipDirective = "dbtool:skip"
+	// noTransactionDirective runs a migration outside of a transaction, e.g. for CREATE INDEX CONCURRENTLY
+	noTransactionDirective = "dbtool:no-transaction"
+)
+
+// directives are declared in the leading comment lines of a migration
+type directives struct {
+	// tags is nil for an untagged migration
+	tags          []string
+	skip          bool
+	noTransaction bool
+}
+
+// readDirectives returns the directives declared in the leading comment lines of the migration
+func readDirectives(fsys fs.FS, name string) (directives, error) {
+	var d directives
+	f, err := openMigrationFile(fsys, name)
+	if err != nil {
+		return d, err
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for first := true; scanner.Scan(); first = false {
+		line := strings.TrimSpace(scanner.Text())
+		if first {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if line == "" {
+			continue
+		}
+		comment, ok := strings.CutPrefix(line, "--")
+		if !ok {
+			// The leading comment ends with the first statement
+			break
+		}
+		comment = strings.TrimSpace(comment)
+		// The directive may be followed by a reason
+		if comment == skipDirective || strings.HasPrefix(comment, skipDirective+" ") {
+			d.skip = true
+			continue
+		}
+		if comment == noTransactionDirective {
+			d.noTransaction = true
+			continue
+		}
+		value, ok := strings.CutPrefix(comment, tagsDirective)
+		if !ok || d.tags != nil {
+			continue
+		}
+		if d.tags, err = parseTags(name, value); err != nil {
+			return d, err
+		}
+	}
+	return d, scanner.Err()
+}
+
+func parseTags(name string, value string) ([]string, error) {
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			return nil, fmt.Errorf("migration file %s: empty tag in %s%s", name, tagsDirective, value)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// isSelectedByTags reports whether the file is applied with the requested tags, untagged files always are
+func (f sqlFile) isSelectedByTags(tags []string) bool {
+	if len(f.tags) == 0 {
+		return true
+	}
+	for _, tag := range f.tags {
+		if slices.Contains(tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectByTags leaves out the files whose tags do not match the requested ones, unless they have been applied
+// already, so the applied migrations are still matched and validated as before
+func selectByTags(files []sqlFile, applied []migration, tags []string) []sqlFile {
+	recorded := make(map[string]struct{}, len(applied))
+	for _, m := range applied {
+		recorded[m.filePath] = struct{}{}
+	}
+	return slices.DeleteFunc(files, func(f sqlFile) bool {
+		_, ok := recorded[f.path]
+		return !ok && !f.isSelectedByTags(tags)
+	})
+}
@@ -0,0 +1,121 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadDirectives(t *testing.T) {
+	fsys := fstest.MapFS{
+		"tagged.sql":      {Data: []byte("-- Seed data\n-- dbtool:tags=staging, dev\nINSERT INTO users VALUES (1);")},
+		"bom.sql":         {Data: []byte("\ufeff--dbtool:tags=dev\nSELECT 1;")},
+		"untagged.sql":    {Data: []byte("-- Users\nCREATE TABLE users (id INT);")},
+		"after-stmt.sql":  {Data: []byte("SELECT 1;\n-- dbtool:tags=dev\n")},
+		"empty-tag.sql":   {Data: []byte("-- dbtool:tags=dev,,staging\nSELECT 1;")},
+		"blank-lines.sql": {Data: []byte("\n\n-- dbtool:tags=prod\nSELECT 1;")},
+		"empty-file.sql":  {Data: []byte("")},
+		"inline.sql":      {Data: []byte("CREATE TABLE t (id INT); -- dbtool:tags=dev")},
+		"mention.sql":     {Data: []byte("-- see dbtool:tags=dev\nSELECT 1;")},
+		"skip.sql":        {Data: []byte("-- dbtool:tags=dev\n--dbtool:skip\nSELECT 1;")},
+		"skip-later.sql":  {Data: []byte("SELECT 1;\n-- dbtool:skip\n")},
+		"skip-prefix.sql": {Data: []byte("-- dbtool:skipped\nSELECT 1;")},
+		"skip-reason.sql": {Data: []byte("-- dbtool:skip until the backfill is ready\nSELECT 1;")},
+		"no-tx.sql":       {Data: []byte("-- Index\n-- dbtool:no-transaction\nCREATE INDEX CONCURRENTLY i ON t (id);")},
+		"no-tx-later.sql": {Data: []byte("SELECT 1;\n-- dbtool:no-transaction\n")},
+	}
+
+	tests := []struct {
+		name     string
+		expected directives
+	}{
+		{"tagged.sql", directives{tags: []string{"staging", "dev"}}},
+		{"bom.sql", directives{tags: []string{"dev"}}},
+		{"untagged.sql", directives{}},
+		{"after-stmt.sql", directives{}},
+		{"blank-lines.sql", directives{tags: []string{"prod"}}},
+		{"empty-file.sql", directives{}},
+		{"inline.sql", directives{}},
+		{"mention.sql", directives{}},
+		{"skip.sql", directives{tags: []string{"dev"}, skip: true}},
+		{"skip-later.sql", directives{}},
+		{"skip-prefix.sql", directives{}},
+		{"skip-reason.sql", directives{skip: true}},
+		{"no-tx.sql", directives{noTransaction: true}},
+		{"no-tx-later.sql", directives{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := readDirectives(fsys, tt.name)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, d)
+		})
+	}
+
+	t.Run("Empty tag", func(t *testing.T) {
+		_, err := readDirectives(fsys, "empty-tag.sql")
+		assert.ErrorContains(t, err, "empty tag")
+	})
+}
+
+func TestSelectByTags(t *testing.T) {
+	files := func() []sqlFile {
+		return []sqlFile{
+			{path: "001-init.sql"},
+			{path: "002-seed.sql", tags: []string{"staging", "dev"}},
+			{path: "003-users.sql"},
+			{path: "004-prod.sql", tags: []string{"prod"}},
+		}
+	}
+	paths := func(files []sqlFile) []string {
+		var result []string
+		for _, f := range files {
+			result = append(result, f.path)
+		}
+		return result
+	}
+
+	t.Run("Untagged files only without tags", func(t *testing.T) {
+		assert.Equal(t, []string{"001-init.sql", "003-users.sql"}, paths(selectByTags(files(), nil, nil)))
+	})
+
+	t.Run("Matching tag", func(t *testing.T) {
+		assert.Equal(t, []string{"001-init.sql", "002-seed.sql", "003-users.sql"}, paths(selectByTags(files(), nil, []string{"dev"})))
+	})
+
+	t.Run("Applied files are kept", func(t *testing.T) {
+		applied := []migration{{filePath: "001-init.sql"}, {filePath: "002-seed.sql"}}
+		assert.Equal(t, []string{"001-init.sql", "002-seed.sql", "003-users.sql", "004-prod.sql"}, paths(selectByTags(files(), applied, []string{"prod"})))
+	})
+}
+
+func TestMarkMigrations_Tags(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001-init.sql":  {Data: []byte("CREATE TABLE init (id INT);")},
+		"002-seed.sql":  {Data: []byte("-- dbtool:tags=staging\nINSERT INTO init VALUES (1);")},
+		"003-users.sql": {Data: []byte("CREATE TABLE users (id INT);")},
+	}
+
+	var files []sqlFile
+	assert.NoError(t, readDir(&files, fsys, "", readDirOptions{maxDepth: unlimitedDepth, hashAlgorithm: config.HashSHA256}))
+	prepareFiles(files, config.SortLexical)
+
+	hash, err := getFileHash(fsys, "001-init.sql", config.HashSHA256)
+	assert.NoError(t, err)
+	applied := []migration{{filePath: "001-init.sql", fileHash: hash}}
+
+	// The skipped seed of another environment does not break the positional matching
+	selected := selectByTags(files, applied, []string{"prod"})
+	matched, err := markMigrations(fsys, selected, applied, -1, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, matched)
+	assert.Len(t, selected, 2)
+	assert.Equal(t, "003-users.sql", selected[1].path)
+	assert.True(t, selected[1].apply)
+}
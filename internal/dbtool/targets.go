@@ -0,0 +1,74 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"go.uber.org/zap"
+)
+
+var ErrTargetsFailed = errors.New("migrations failed on some databases")
+
+// Target states in the summary of a run against several databases
+const (
+	targetDone   = "done"
+	targetFailed = "failed"
+	targetNotRun = "not run"
+)
+
+// runTargets applies the migrations to the configured databases one after another, it stops at the first failed
+// database unless --continue-on-error is set
+func runTargets(ctx context.Context, logger *zap.Logger, cfg *config.Config, fsys fs.FS) (Result, error) {
+	targets := cfg.Targets()
+	states := make([]string, len(targets))
+	for i := range states {
+		states[i] = targetNotRun
+	}
+
+	var total Result
+	var firstErr error
+	failed := 0
+	for i, connectionString := range targets {
+		targetLogger := logger.With(zap.String("database", config.RedactConnectionString(connectionString)))
+		result, err := RunFS(ctx, targetLogger, cfg.ForTarget(i), fsys)
+		total.Applied += result.Applied
+		total.Skipped += result.Skipped
+		total.Pending += result.Pending
+		total.Failed += result.Failed
+		if err == nil {
+			states[i] = targetDone
+			continue
+		}
+
+		states[i] = targetFailed
+		failed++
+		targetLogger.Error("Migrating database failed", zap.Error(err))
+		if firstErr == nil {
+			firstErr = err
+		}
+		if !cfg.ContinueOnError() || errors.Is(err, ErrInterrupted) {
+			break
+		}
+	}
+
+	logTargetsSummary(logger, targets, states, total)
+	if failed == 0 {
+		return total, nil
+	}
+	return total, fmt.Errorf("%w: %d of %d: %w", ErrTargetsFailed, failed, len(targets), firstErr)
+}
+
+func logTargetsSummary(logger *zap.Logger, targets []string, states []string, total Result) {
+	for i, connectionString := range targets {
+		logger.Info("Database summary", zap.String("database", config.RedactConnectionString(connectionString)), zap.String("state", states[i]))
+	}
+	logger.Info("Databases migrated", zap.Int("databases", len(targets)), zap.Int("applied", total.Applied),
+		zap.Int("skipped", total.Skipped), zap.Int("pending", total.Pending), zap.Int("failed", total.Failed))
+}
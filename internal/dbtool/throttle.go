@@ -0,0 +1,83 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// replicationLagSQL lists the standbys with the WAL they have not replayed yet, the lag columns are NULL
+// unless the user has the pg_monitor role
+const replicationLagSQL = `SELECT application_name, pg_wal_lsn_diff(pg_current_wal_lsn(), replay_lsn)::BIGINT, EXTRACT(EPOCH FROM replay_lag)::FLOAT8 FROM pg_stat_replication ORDER BY application_name`
+
+// pauseBetweenMigrations waits for the --inter-migration-delay before the next migration, the replication lag
+// is logged first with --check-replication-lag
+func pauseBetweenMigrations(ctx context.Context, conn *pgx.Conn, next string, cfg *config.Config, logger *zap.Logger) error {
+	delay := cfg.InterMigrationDelay()
+	if delay <= 0 {
+		return nil
+	}
+
+	if cfg.CheckReplicationLag() {
+		logReplicationLag(ctx, conn, logger)
+	}
+
+	logger.Info("Waiting before the next migration...", zap.String("file", next), zap.Duration("delay", delay))
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("%w before %s: %w", ErrInterrupted, next, ctx.Err())
+	case <-time.After(delay):
+	}
+	return nil
+}
+
+// logReplicationLag logs the lag of every standby, failing to query it only logs a warning as the throttle is
+// informational
+func logReplicationLag(ctx context.Context, conn *pgx.Conn, logger *zap.Logger) {
+	rows, err := conn.Query(ctx, replicationLagSQL)
+	if err != nil {
+		logger.Warn("Could not query the replication lag", zap.Error(err))
+		return
+	}
+	defer rows.Close()
+
+	standbys := 0
+	for rows.Next() {
+		var name string
+		var lagBytes *int64
+		var lagSeconds *float64
+		if err := rows.Scan(&name, &lagBytes, &lagSeconds); err != nil {
+			logger.Warn("Could not query the replication lag", zap.Error(err))
+			return
+		}
+		standbys++
+		logger.Info("Replication lag", replicationLagFields(name, lagBytes, lagSeconds)...)
+	}
+	if err := rows.Err(); err != nil {
+		logger.Warn("Could not query the replication lag", zap.Error(err))
+		return
+	}
+	if standbys == 0 {
+		logger.Info("No standby is replicating from the database")
+	}
+}
+
+// replicationLagFields returns the log fields of a standby, the unknown lags are left out
+func replicationLagFields(name string, lagBytes *int64, lagSeconds *float64) []zap.Field {
+	fields := []zap.Field{zap.String("standby", name)}
+	if lagBytes != nil {
+		fields = append(fields, zap.Int64("lag_bytes", *lagBytes))
+	}
+	if lagSeconds != nil {
+		fields = append(fields, zap.Duration("replay_lag", time.Duration(*lagSeconds*float64(time.Second))))
+	}
+	return fields
+}
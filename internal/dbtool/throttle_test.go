@@ -0,0 +1,57 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestPauseBetweenMigrations(t *testing.T) {
+	newConfig := func(delay time.Duration) *config.Config {
+		cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db", InterMigrationDelay: delay})
+		require.NoError(t, err)
+		return cfg
+	}
+
+	t.Run("Without a delay", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.NoError(t, pauseBetweenMigrations(ctx, nil, "002-users.sql", newConfig(0), zap.NewNop()))
+	})
+
+	t.Run("Waits for the delay", func(t *testing.T) {
+		core, logs := observer.New(zapcore.InfoLevel)
+		start := time.Now()
+		assert.NoError(t, pauseBetweenMigrations(context.Background(), nil, "002-users.sql", newConfig(20*time.Millisecond), zap.New(core)))
+		assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+		assert.Equal(t, 1, logs.FilterMessage("Waiting before the next migration...").FilterField(zap.String("file", "002-users.sql")).Len())
+	})
+
+	t.Run("Interrupted while waiting", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		err := pauseBetweenMigrations(ctx, nil, "002-users.sql", newConfig(time.Hour), zap.NewNop())
+		assert.ErrorIs(t, err, ErrInterrupted)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.ErrorContains(t, err, "before 002-users.sql")
+	})
+}
+
+func TestReplicationLagFields(t *testing.T) {
+	lagBytes := int64(4096)
+	lagSeconds := 1.5
+	assert.Equal(t, []zap.Field{zap.String("standby", "replica-1"), zap.Int64("lag_bytes", 4096), zap.Duration("replay_lag", 1500*time.Millisecond)},
+		replicationLagFields("replica-1", &lagBytes, &lagSeconds))
+	assert.Equal(t, []zap.Field{zap.String("standby", "replica-2")}, replicationLagFields("replica-2", nil, nil))
+}
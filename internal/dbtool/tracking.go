@@ -0,0 +1,68 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// ErrNotRecorded is returned when a migration has been committed to the migrated database but its row could not be
+// inserted into the separate tracking database, the next run applies the migration again
+var ErrNotRecorded = errors.New("migration applied but not recorded")
+
+// recordTimeout bounds recording a committed migration, which also runs after the context has been cancelled
+const recordTimeout = 5 * time.Second
+
+// tracker records the migrations in the separate tracking database with --tracking-connection-string,
+// the parallel migrations share its connection
+type tracker struct {
+	mu   sync.Mutex
+	conn *pgx.Conn
+}
+
+// record runs fn in a transaction on the tracking connection
+func (t *tracker) record(ctx context.Context, fn func(pgx.Tx) error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return pgx.BeginFunc(ctx, t.conn, fn)
+}
+
+// inTrackingTx runs fn in a transaction of the tracking database, of db when the dbtool tables are not separate
+func inTrackingTx(ctx context.Context, db txBeginner, tr *tracker, fn func(pgx.Tx) error) error {
+	if tr == nil {
+		return pgx.BeginFunc(ctx, db, fn)
+	}
+	return tr.record(ctx, fn)
+}
+
+// connectTracking returns the connection of the dbtool tables and the tracker recording in them, conn and no tracker
+// when they are in the migrated database
+func connectTracking(ctx context.Context, conn *pgx.Conn, cfg *config.Config, logger *zap.Logger) (*pgx.Conn, *tracker, error) {
+	if cfg.TrackingConnectionString() == "" {
+		return conn, nil, nil
+	}
+
+	logger.Info("Using a separate tracking database, migrations are recorded after they are committed")
+	track, err := connect(ctx, cfg.ForTracking(), logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	return track, &tracker{conn: track}, nil
+}
+
+// trackingConn returns the connection of the dbtool tables, conn when they are in the migrated database
+func trackingConn(conn *pgx.Conn, tr *tracker) *pgx.Conn {
+	if tr == nil {
+		return conn
+	}
+	return tr.conn
+}
@@ -0,0 +1,58 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestInTrackingTx(t *testing.T) {
+	t.Run("Committed on db without tracker", func(t *testing.T) {
+		db := &fakeBeginner{tx: &fakeTx{}}
+		err := inTrackingTx(context.Background(), db, nil, func(tx pgx.Tx) error {
+			_, err := tx.Exec(context.Background(), "INSERT INTO migrations")
+			return err
+		})
+		assert.NoError(t, err)
+		assert.True(t, db.tx.committed)
+		assert.Equal(t, []string{"INSERT INTO migrations"}, db.tx.executed)
+	})
+
+	t.Run("Rolled back on failure", func(t *testing.T) {
+		db := &fakeBeginner{tx: &fakeTx{}}
+		failed := errors.New("insert failed")
+		err := inTrackingTx(context.Background(), db, nil, func(pgx.Tx) error { return failed })
+		assert.ErrorIs(t, err, failed)
+		assert.False(t, db.tx.committed)
+		assert.True(t, db.tx.rolledBack)
+	})
+}
+
+func TestConnectTracking_NotSeparate(t *testing.T) {
+	cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db"})
+	require.NoError(t, err)
+
+	conn := &pgx.Conn{}
+	track, tr, err := connectTracking(context.Background(), conn, cfg, zap.NewNop())
+	assert.NoError(t, err)
+	assert.Same(t, conn, track)
+	assert.Nil(t, tr)
+}
+
+func TestTrackingConn(t *testing.T) {
+	conn := &pgx.Conn{}
+	assert.Same(t, conn, trackingConn(conn, nil))
+
+	track := &pgx.Conn{}
+	assert.Same(t, track, trackingConn(conn, &tracker{conn: track}))
+}
@@ -0,0 +1,151 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/clbs-io/dbtool/internal/config"
+)
+
+// unionFS merges several migration roots into one tree, the directories are merged
+// while a file is read from the first root holding it
+type unionFS struct {
+	names []string
+	roots []fs.FS
+}
+
+// openMigrationsFS returns the file system of the configured migrations directories
+// and the function closing the zip archives read from
+func openMigrationsFS(cfg *config.Config) (fs.FS, func(), error) {
+	var closers []io.Closer
+	closeAll := func() {
+		for _, c := range closers {
+			_ = c.Close()
+		}
+	}
+
+	u := &unionFS{}
+	for _, dir := range cfg.Dirs() {
+		root, closer, err := openRoot(dir)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		if closer != nil {
+			closers = append(closers, closer)
+		}
+		u.names = append(u.names, filepath.Clean(dir))
+		u.roots = append(u.roots, root)
+	}
+
+	if len(u.roots) == 1 {
+		return u.roots[0], closeAll, nil
+	}
+	return u, closeAll, nil
+}
+
+// openRoot returns the file system of a migrations directory, a directory inside a zip archive is read
+// from the archive, which has to be closed then
+func openRoot(dir string) (fs.FS, io.Closer, error) {
+	archive, inner, ok := config.SplitZipPath(dir)
+	if !ok {
+		return os.DirFS(dir), nil, nil
+	}
+
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening migrations archive: %w", err)
+	}
+	root, err := fs.Sub(r, inner)
+	if err != nil {
+		_ = r.Close()
+		return nil, nil, err
+	}
+	return root, r, nil
+}
+
+func (u *unionFS) Open(name string) (fs.File, error) {
+	var firstErr error
+	for _, root := range u.roots {
+		f, err := root.Open(name)
+		if err == nil {
+			return f, nil
+		}
+		if firstErr == nil || !errors.Is(err, fs.ErrNotExist) {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+// ReadDir merges the entries of the directory in all roots, a SQL file present in several roots is an error
+// as its position in the order of migrations would be ambiguous
+func (u *unionFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	found := false
+	owners := make(map[string]int)
+	var entries []fs.DirEntry
+	for i, root := range u.roots {
+		rootEntries, err := fs.ReadDir(root, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = true
+
+		for _, entry := range rootEntries {
+			owner, exists := owners[entry.Name()]
+			if !exists {
+				owners[entry.Name()] = i
+				entries = append(entries, entry)
+				continue
+			}
+			if !entry.IsDir() && isSQLFileName(entry.Name()) {
+				return nil, fmt.Errorf("migration %s exists in both %s and %s", path.Join(name, entry.Name()), u.names[owner], u.names[i])
+			}
+		}
+	}
+
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries, nil
+}
+
+// rootOf returns the name of the root the file is read from
+func (u *unionFS) rootOf(name string) string {
+	for i, root := range u.roots {
+		if _, err := fs.Stat(root, name); err == nil {
+			return u.names[i]
+		}
+	}
+	return ""
+}
+
+// tagRoots records the root of every file when the migrations are read from several roots
+func tagRoots(fsys fs.FS, files []sqlFile) {
+	u, ok := fsys.(*unionFS)
+	if !ok {
+		return
+	}
+	for i := range files {
+		files[i].root = u.rootOf(files[i].path)
+	}
+}
@@ -0,0 +1,120 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"archive/zip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnionFS(t *testing.T) {
+	core := fstest.MapFS{
+		"001-init.sql":       {Data: []byte("CREATE SCHEMA core;")},
+		"v2/003-orders.sql":  {Data: []byte("CREATE TABLE core.orders (id INT);")},
+		"README.md":          {Data: []byte("core")},
+		"v2/005-indexes.sql": {Data: []byte("CREATE INDEX ON core.orders (id);")},
+	}
+	tenant := fstest.MapFS{
+		"002-tenant.sql":    {Data: []byte("CREATE SCHEMA tenant;")},
+		"v2/004-users.sql":  {Data: []byte("CREATE TABLE tenant.users (id INT);")},
+		"README.md":         {Data: []byte("tenant")},
+		"v3/006-extras.sql": {Data: []byte("SELECT 1;")},
+	}
+	u := &unionFS{names: []string{"core", "tenant"}, roots: []fs.FS{core, tenant}}
+
+	t.Run("Files are merged into one sequence", func(t *testing.T) {
+		var files []sqlFile
+		assert.NoError(t, readDir(&files, u, "", readDirOptions{maxDepth: unlimitedDepth, hashAlgorithm: config.HashSHA256}))
+		prepareFiles(files, config.SortLexical)
+		tagRoots(u, files)
+
+		var paths, roots []string
+		for _, f := range files {
+			paths = append(paths, f.path)
+			roots = append(roots, f.root)
+		}
+		assert.Equal(t, []string{"001-init.sql", "002-tenant.sql", "v2/003-orders.sql", "v2/004-users.sql", "v2/005-indexes.sql", "v3/006-extras.sql"}, paths)
+		assert.Equal(t, []string{"core", "tenant", "core", "tenant", "core", "tenant"}, roots)
+	})
+
+	t.Run("Files are read from their root", func(t *testing.T) {
+		data, err := fs.ReadFile(u, "v2/004-users.sql")
+		assert.NoError(t, err)
+		assert.Equal(t, "CREATE TABLE tenant.users (id INT);", string(data))
+
+		_, err = fs.ReadFile(u, "missing.sql")
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+
+		_, err = fs.ReadDir(u, "missing")
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("Duplicate migration", func(t *testing.T) {
+		dup := &unionFS{names: []string{"core", "tenant"}, roots: []fs.FS{core, fstest.MapFS{"v2/003-orders.sql": {Data: []byte("SELECT 1;")}}}}
+		var files []sqlFile
+		err := readDir(&files, dup, "", readDirOptions{maxDepth: unlimitedDepth, hashAlgorithm: config.HashSHA256})
+		assert.ErrorContains(t, err, "migration v2/003-orders.sql exists in both core and tenant")
+	})
+
+	t.Run("Single root is not tagged", func(t *testing.T) {
+		files := []sqlFile{{path: "001-init.sql"}}
+		tagRoots(core, files)
+		assert.Equal(t, "", files[0].root)
+		assert.Nil(t, files[0].rootOrNil())
+	})
+}
+
+func TestOpenRoot(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "migrations.zip")
+	f, err := os.Create(archive)
+	assert.NoError(t, err)
+	w := zip.NewWriter(f)
+	for name, content := range map[string]string{"v2/001_init.sql": "CREATE SCHEMA app;", "v2/002_users.sql": "SELECT 1;"} {
+		fw, err := w.Create(name)
+		assert.NoError(t, err)
+		_, err = fw.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+	assert.NoError(t, f.Close())
+
+	t.Run("Directory inside a zip archive", func(t *testing.T) {
+		root, closer, err := openRoot(filepath.Join(archive, "v2"))
+		assert.NoError(t, err)
+		assert.NotNil(t, closer)
+		defer func() { _ = closer.Close() }()
+
+		var files []sqlFile
+		assert.NoError(t, readDir(&files, root, "", readDirOptions{maxDepth: unlimitedDepth, hashAlgorithm: config.HashSHA256}))
+		prepareFiles(files, config.SortLexical)
+
+		// The checksums are the same as of the files on disk
+		disk := fstest.MapFS{"001_init.sql": {Data: []byte("CREATE SCHEMA app;")}}
+		hash, err := getFileHash(disk, "001_init.sql", config.HashSHA256)
+		assert.NoError(t, err)
+		assert.Equal(t, []sqlFile{{path: "001_init.sql", hash: hash}, {path: "002_users.sql", hash: files[1].hash}}, files)
+	})
+
+	t.Run("Plain directory", func(t *testing.T) {
+		_, closer, err := openRoot(dir)
+		assert.NoError(t, err)
+		assert.Nil(t, closer)
+	})
+
+	t.Run("Invalid archive", func(t *testing.T) {
+		broken := filepath.Join(dir, "broken.zip")
+		assert.NoError(t, os.WriteFile(broken, []byte("not a zip"), 0o644))
+		_, _, err := openRoot(broken)
+		assert.Error(t, err)
+	})
+}
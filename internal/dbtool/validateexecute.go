@@ -0,0 +1,128 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// ErrValidationFailed is returned with --validate-execute when a pending migration fails to execute
+var ErrValidationFailed = errors.New("migration failed validation")
+
+// pgCodeActiveSQLTransaction is raised by the statements that cannot run inside a transaction block,
+// e.g. CREATE INDEX CONCURRENTLY
+const pgCodeActiveSQLTransaction = "25001"
+
+// validateExecute executes the pending migrations in order in one transaction that is always rolled back,
+// every file runs in a savepoint so the later files see the changes of the earlier ones. A file that cannot run
+// in a transaction is reported as not validatable and rolled back to its savepoint, a file declaring
+// -- dbtool:no-transaction is reported without being executed, any other error stops the validation as the later
+// files usually depend on it
+func validateExecute(ctx context.Context, db txBeginner, fsys fs.FS, files []sqlFile, cfg *config.Config, logger *zap.Logger) error {
+	logger.Info("Validating migrations by executing them in a transaction that is rolled back...")
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		rollbackCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+		defer cancel()
+		if err := tx.Rollback(rollbackCtx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			logger.Warn("Error rolling back the validation, the transaction is discarded with the connection", zap.Error(err))
+		}
+	}()
+
+	validated := 0
+	var notValidatable []string
+	for _, f := range files {
+		if !f.apply {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("%w before %s: %w", ErrInterrupted, f.path, err)
+		}
+
+		if f.noTransaction {
+			logger.Warn("Migration runs outside of a transaction, it is not validated", zap.String("file", f.path))
+			notValidatable = append(notValidatable, f.path)
+			continue
+		}
+
+		err := validateFile(ctx, tx, fsys, f, cfg, logger)
+		if isPgError(err, pgCodeActiveSQLTransaction) {
+			logger.Warn("Migration cannot run inside a transaction, it is not validated", zap.String("file", f.path), zap.Error(err))
+			notValidatable = append(notValidatable, f.path)
+			continue
+		}
+		if err != nil && ctx.Err() != nil {
+			return fmt.Errorf("%w, validation of %s rolled back: %w", ErrInterrupted, f.path, ctx.Err())
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrValidationFailed, f.path, err)
+		}
+		validated++
+		logger.Info("Migration validated", zap.String("file", f.path))
+	}
+
+	level := zapcore.InfoLevel
+	if len(notValidatable) > 0 {
+		level = zapcore.WarnLevel
+	}
+	logger.Log(level, "Migrations validated, everything rolled back", zap.Int("validated", validated), zap.Strings("not_validatable", notValidatable))
+	return nil
+}
+
+// validateFile executes the migration with its hooks in a savepoint of tx, the savepoint is released
+// when the file succeeds and rolled back otherwise
+func validateFile(ctx context.Context, tx pgx.Tx, fsys fs.FS, f sqlFile, cfg *config.Config, logger *zap.Logger) (err error) {
+	sql, err := readMigrationSQL(fsys, f.path, cfg)
+	if err != nil {
+		return err
+	}
+
+	savepoint, err := tx.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tagNotices(tx.Conn(), f.path)()
+	defer func() {
+		if err != nil {
+			_ = savepoint.Rollback(ctx)
+		}
+	}()
+
+	if err := setLocalSettings(ctx, savepoint, cfg); err != nil {
+		return err
+	}
+	if err := setRole(ctx, savepoint, f); err != nil {
+		return err
+	}
+	if hook := cfg.BeforeEach(); hook != "" {
+		if _, err := savepoint.Exec(ctx, hook); err != nil {
+			return fmt.Errorf("error while executing the before-each hook of migration %s: %w", f.path, err)
+		}
+	}
+	if err := executeMigration(ctx, savepoint, f.path, sql, cfg.SplitStatements(), sqlLogger(cfg, logger)); err != nil {
+		return err
+	}
+	if hook := cfg.AfterEach(); hook != "" {
+		if _, err := savepoint.Exec(ctx, hook); err != nil {
+			return fmt.Errorf("error while executing the after-each hook of migration %s: %w", f.path, err)
+		}
+	}
+	if err := resetRole(ctx, savepoint, f); err != nil {
+		return err
+	}
+	return savepoint.Commit(ctx)
+}
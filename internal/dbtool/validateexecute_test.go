@@ -0,0 +1,99 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// fakeSavepointTx fails the statements listed in errs, its savepoints are nested fakes sharing them
+type fakeSavepointTx struct {
+	fakeTx
+	errs       map[string]error
+	savepoints []*fakeSavepointTx
+}
+
+func (tx *fakeSavepointTx) Begin(context.Context) (pgx.Tx, error) {
+	sp := &fakeSavepointTx{errs: tx.errs}
+	tx.savepoints = append(tx.savepoints, sp)
+	return sp, nil
+}
+
+func (tx *fakeSavepointTx) Exec(_ context.Context, sql string, _ ...any) (pgconn.CommandTag, error) {
+	tx.executed = append(tx.executed, sql)
+	return pgconn.CommandTag{}, tx.errs[sql]
+}
+
+type fakeSavepointBeginner struct {
+	tx *fakeSavepointTx
+}
+
+func (b *fakeSavepointBeginner) Begin(context.Context) (pgx.Tx, error) {
+	return b.tx, nil
+}
+
+func TestValidateExecute(t *testing.T) {
+	cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db"})
+	require.NoError(t, err)
+
+	fsys := fstest.MapFS{
+		"001-init.sql":    {Data: []byte("CREATE TABLE users (id INT)")},
+		"002-index.sql":   {Data: []byte("CREATE INDEX CONCURRENTLY users_id ON users (id)")},
+		"003-insert.sql":  {Data: []byte("INSERT INTO users VALUES (1)")},
+		"004-applied.sql": {Data: []byte("SELECT 1")},
+	}
+	files := []sqlFile{
+		{path: "001-init.sql", apply: true},
+		{path: "002-index.sql", apply: true},
+		{path: "003-insert.sql", apply: true},
+		{path: "004-applied.sql"},
+	}
+
+	t.Run("Rolled back with the not validatable files reported", func(t *testing.T) {
+		core, logs := observer.New(zapcore.InfoLevel)
+		tx := &fakeSavepointTx{errs: map[string]error{
+			"CREATE INDEX CONCURRENTLY users_id ON users (id)": &pgconn.PgError{Code: pgCodeActiveSQLTransaction},
+		}}
+		err := validateExecute(context.Background(), &fakeSavepointBeginner{tx: tx}, fsys, files, cfg, zap.New(core))
+		assert.NoError(t, err)
+
+		assert.True(t, tx.rolledBack)
+		assert.False(t, tx.committed)
+		require.Len(t, tx.savepoints, 3)
+		assert.True(t, tx.savepoints[0].committed)
+		assert.True(t, tx.savepoints[1].rolledBack)
+		assert.True(t, tx.savepoints[2].committed)
+
+		summary := logs.FilterMessage("Migrations validated, everything rolled back").All()
+		require.Len(t, summary, 1)
+		assert.Equal(t, zapcore.WarnLevel, summary[0].Level)
+		assert.Equal(t, int64(2), summary[0].ContextMap()["validated"])
+		assert.Equal(t, []any{"002-index.sql"}, summary[0].ContextMap()["not_validatable"])
+	})
+
+	t.Run("Failure stops the validation", func(t *testing.T) {
+		failed := &pgconn.PgError{Code: "23505", Message: "duplicate key value"}
+		tx := &fakeSavepointTx{errs: map[string]error{"CREATE TABLE users (id INT)": failed}}
+		err := validateExecute(context.Background(), &fakeSavepointBeginner{tx: tx}, fsys, files, cfg, zap.NewNop())
+		assert.ErrorIs(t, err, ErrValidationFailed)
+		assert.ErrorIs(t, err, failed)
+		assert.ErrorContains(t, err, "001-init.sql")
+
+		assert.True(t, tx.rolledBack)
+		require.Len(t, tx.savepoints, 1)
+		assert.True(t, tx.savepoints[0].rolledBack)
+	})
+}
@@ -0,0 +1,39 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	rePlaceholder = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+	ErrUnresolvedPlaceholder = errors.New("unresolved placeholder")
+)
+
+// substituteVars replaces the ${key} placeholders in the SQL text with the variable values,
+// a placeholder without a variable is an error listing all unresolved keys
+func substituteVars(sql string, vars map[string]string) (string, error) {
+	var unresolved []string
+	result := rePlaceholder.ReplaceAllStringFunc(sql, func(placeholder string) string {
+		key := placeholder[2 : len(placeholder)-1]
+		value, ok := vars[key]
+		if !ok {
+			unresolved = append(unresolved, key)
+			return placeholder
+		}
+		return value
+	})
+
+	if len(unresolved) > 0 {
+		return "", fmt.Errorf("%w: %s", ErrUnresolvedPlaceholder, strings.Join(unresolved, ", "))
+	}
+
+	return result, nil
+}
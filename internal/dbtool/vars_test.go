@@ -0,0 +1,33 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubstituteVars(t *testing.T) {
+	vars := map[string]string{"tablespace": "fast_ssd", "app_role": "app_rw"}
+
+	t.Run("Replaces placeholders", func(t *testing.T) {
+		sql, err := substituteVars("CREATE TABLE t (id INT) TABLESPACE ${tablespace};\nGRANT SELECT ON t TO ${app_role};", vars)
+		assert.NoError(t, err)
+		assert.Equal(t, "CREATE TABLE t (id INT) TABLESPACE fast_ssd;\nGRANT SELECT ON t TO app_rw;", sql)
+	})
+
+	t.Run("Leaves other dollar signs alone", func(t *testing.T) {
+		sql, err := substituteVars("DO $$ BEGIN PERFORM $1; END $$; SELECT '${'", vars)
+		assert.NoError(t, err)
+		assert.Equal(t, "DO $$ BEGIN PERFORM $1; END $$; SELECT '${'", sql)
+	})
+
+	t.Run("Unresolved placeholders", func(t *testing.T) {
+		_, err := substituteVars("SELECT ${schema}.${table}, ${app_role}", vars)
+		assert.ErrorIs(t, err, ErrUnresolvedPlaceholder)
+		assert.EqualError(t, err, "unresolved placeholder: schema, table")
+	})
+}
@@ -0,0 +1,148 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"go.uber.org/zap"
+)
+
+var ErrVerificationFailed = errors.New("verification failed")
+
+const (
+	problemHashMismatch = "hash mismatch"
+	problemMissing      = "missing on disk"
+	problemNotApplied   = "not applied"
+)
+
+type verifyProblem struct {
+	kind string
+	path string
+}
+
+// Verify checks that the files recorded in the migrations table match the files on disk, it never modifies the database
+func Verify(ctx context.Context, logger *zap.Logger, cfg *config.Config) (err error) {
+	fsys, closeFS, err := openMigrationsFS(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeFS()
+
+	sqlFiles, err := discoverFiles(fsys, cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	conn, err := connect(ctx, cfg.ForTracking(), logger)
+	if err != nil {
+		return err
+	}
+	defer closeConnection(ctx, conn, &err)
+
+	var appliedMigrations []migration
+
+	exists, err := migrationTableExists(ctx, *conn, cfg)
+	if err != nil {
+		return fmt.Errorf("error checking migration table: %w", err)
+	}
+	if exists {
+		appliedMigrations, err = getAppliedMigrations(ctx, *conn, cfg)
+		if err != nil {
+			return fmt.Errorf("error reading applied migrations: %w", err)
+		}
+	} else {
+		logger.Warn("Migration table does not exist, no migrations have been applied yet")
+	}
+
+	// Files of other environments and skipped ones are not pending
+	sqlFiles, err = dropSkipped(sqlFiles, appliedMigrations, logger)
+	if err != nil {
+		return err
+	}
+	sqlFiles = selectByTags(sqlFiles, appliedMigrations, cfg.Tags())
+
+	if cfg.ChecksumOnlyValidation() {
+		appliedMigrations, err = relocateApplied(fsys, sqlFiles, appliedMigrations, logger)
+		if err != nil {
+			return err
+		}
+	}
+
+	problems, err := verifyMigrations(fsys, sqlFiles, appliedMigrations, cfg.FailOnPending())
+	if err != nil {
+		return err
+	}
+
+	for _, p := range problems {
+		logger.Error("Verification problem", zap.String("problem", p.kind), zap.String("file", p.path))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %d problem(s) found", ErrVerificationFailed, len(problems))
+	}
+
+	logger.Info("Verification succeeded", zap.Int("applied", len(appliedMigrations)))
+
+	return nil
+}
+
+// relocateApplied matches the applied versioned migrations moved on disk like a migrate run
+// with --checksum-only-validation, without storing the new paths
+func relocateApplied(fsys fs.FS, files []sqlFile, applied []migration, logger *zap.Logger) ([]migration, error) {
+	versioned, _ := splitRepeatable(files)
+	appliedVersioned, appliedRepeatable := splitRepeatableMigrations(applied)
+	relocated, err := relocateMovedMigrations(fsys, versioned, appliedVersioned, logger)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Concat(relocated, appliedRepeatable), nil
+}
+
+// verifyMigrations recomputes the hash of every applied migration and reports the files that differ or are missing,
+// with failOnPending the discovered files that have not been applied are reported as well
+func verifyMigrations(fsys fs.FS, files []sqlFile, applied []migration, failOnPending bool) ([]verifyProblem, error) {
+	var problems []verifyProblem
+
+	recorded := make(map[string]struct{}, len(applied))
+	for _, m := range applied {
+		recorded[m.filePath] = struct{}{}
+
+		hash, err := getFileHash(fsys, m.filePath, hashAlgorithmOf(m.fileHash))
+		if errors.Is(err, fs.ErrNotExist) {
+			problems = append(problems, verifyProblem{kind: problemMissing, path: m.filePath})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// A changed repeatable migration is re-applied by the next run
+		if hash != m.fileHash && isRepeatablePath(m.filePath) {
+			if failOnPending {
+				problems = append(problems, verifyProblem{kind: problemNotApplied, path: m.filePath})
+			}
+			continue
+		}
+
+		if hash != m.fileHash {
+			problems = append(problems, verifyProblem{kind: problemHashMismatch, path: m.filePath})
+		}
+	}
+
+	if failOnPending {
+		for _, f := range files {
+			if _, ok := recorded[f.path]; !ok {
+				problems = append(problems, verifyProblem{kind: problemNotApplied, path: f.path})
+			}
+		}
+	}
+
+	return problems, nil
+}
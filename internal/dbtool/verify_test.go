@@ -0,0 +1,87 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestVerifyMigrations(t *testing.T) {
+	rootDir := os.DirFS(filepath.Join("..", "..", "testing", "samples", "valid"))
+	hash, err := getFileHash(rootDir, "001_valid.sql", config.HashSHA256)
+	assert.NoError(t, err)
+
+	files := []sqlFile{{path: "001_valid.sql", hash: hash}}
+
+	t.Run("Matching files", func(t *testing.T) {
+		problems, err := verifyMigrations(rootDir, files, []migration{{filePath: "001_valid.sql", fileHash: hash}}, true)
+		assert.NoError(t, err)
+		assert.Empty(t, problems)
+	})
+
+	t.Run("Changed file", func(t *testing.T) {
+		problems, err := verifyMigrations(rootDir, files, []migration{{filePath: "001_valid.sql", fileHash: "invalid"}}, false)
+		assert.NoError(t, err)
+		assert.Equal(t, []verifyProblem{{kind: problemHashMismatch, path: "001_valid.sql"}}, problems)
+	})
+
+	t.Run("Changed repeatable file", func(t *testing.T) {
+		fsys := fstest.MapFS{"R__views.sql": {Data: []byte("SELECT 1;")}}
+		applied := []migration{{filePath: "R__views.sql", fileHash: "stale"}}
+
+		problems, err := verifyMigrations(fsys, nil, applied, false)
+		assert.NoError(t, err)
+		assert.Empty(t, problems)
+
+		problems, err = verifyMigrations(fsys, nil, applied, true)
+		assert.NoError(t, err)
+		assert.Equal(t, []verifyProblem{{kind: problemNotApplied, path: "R__views.sql"}}, problems)
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		applied := []migration{
+			{filePath: "001_valid.sql", fileHash: hash},
+			{filePath: "002_deleted.sql", fileHash: hash},
+		}
+		problems, err := verifyMigrations(rootDir, files, applied, false)
+		assert.NoError(t, err)
+		assert.Equal(t, []verifyProblem{{kind: problemMissing, path: "002_deleted.sql"}}, problems)
+	})
+
+	t.Run("Moved file matched by checksum", func(t *testing.T) {
+		fsys := fstest.MapFS{"v1/001_valid.sql": {Data: []byte("SELECT 1;")}}
+		moved, err := getFileHash(fsys, "v1/001_valid.sql", config.HashSHA256)
+		assert.NoError(t, err)
+		movedFiles := []sqlFile{{path: "v1/001_valid.sql", hash: moved}}
+		applied := []migration{{filePath: "001_valid.sql", fileHash: moved}}
+
+		problems, err := verifyMigrations(fsys, movedFiles, applied, true)
+		assert.NoError(t, err)
+		assert.Equal(t, []verifyProblem{{kind: problemMissing, path: "001_valid.sql"}, {kind: problemNotApplied, path: "v1/001_valid.sql"}}, problems)
+
+		applied, err = relocateApplied(fsys, movedFiles, applied, zap.NewNop())
+		assert.NoError(t, err)
+		problems, err = verifyMigrations(fsys, movedFiles, applied, true)
+		assert.NoError(t, err)
+		assert.Empty(t, problems)
+	})
+
+	t.Run("Pending files are reported only when requested", func(t *testing.T) {
+		problems, err := verifyMigrations(rootDir, files, nil, false)
+		assert.NoError(t, err)
+		assert.Empty(t, problems)
+
+		problems, err = verifyMigrations(rootDir, files, nil, true)
+		assert.NoError(t, err)
+		assert.Equal(t, []verifyProblem{{kind: problemNotApplied, path: "001_valid.sql"}}, problems)
+	})
+}
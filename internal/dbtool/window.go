@@ -0,0 +1,77 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.uber.org/zap"
+)
+
+// ErrOutsideMaintenanceWindow is returned when migrations are pending outside of --not-before and --not-after
+var ErrOutsideMaintenanceWindow = errors.New("outside the maintenance window")
+
+// checkMaintenanceWindow fails when the time of day on the database clock is outside the window, so the clock
+// of the client does not matter, with --force it is only logged
+func checkMaintenanceWindow(ctx context.Context, conn *pgx.Conn, cfg *config.Config, logger *zap.Logger) error {
+	notBefore, notAfter, ok := cfg.MaintenanceWindow()
+	if !ok {
+		return nil
+	}
+
+	now, err := serverTimeOfDay(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("error reading the database clock: %w", err)
+	}
+	if inWindow(now, notBefore, notAfter) {
+		return nil
+	}
+
+	window := windowString(cfg)
+	if cfg.Force() {
+		logger.Warn("Outside the maintenance window, applying with --force", zap.String("window", window), zap.String("database_time", formatTimeOfDay(now)))
+		return nil
+	}
+	return fmt.Errorf("%w %s, the database time is %s, use --force to apply anyway", ErrOutsideMaintenanceWindow, window, formatTimeOfDay(now))
+}
+
+// serverTimeOfDay returns the time since midnight in the time zone of the session
+func serverTimeOfDay(ctx context.Context, conn *pgx.Conn) (time.Duration, error) {
+	var t pgtype.Time
+	if err := conn.QueryRow(ctx, `SELECT LOCALTIME`).Scan(&t); err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Microseconds) * time.Microsecond, nil
+}
+
+// inWindow reports whether now is at or after notBefore and before notAfter, a window ending before it starts
+// spans midnight
+func inWindow(now, notBefore, notAfter time.Duration) bool {
+	if notBefore < notAfter {
+		return now >= notBefore && now < notAfter
+	}
+	return now >= notBefore || now < notAfter
+}
+
+func windowString(cfg *config.Config) string {
+	notBefore, notAfter := cfg.NotBefore(), cfg.NotAfter()
+	if notBefore == "" {
+		notBefore = "00:00"
+	}
+	if notAfter == "" {
+		notAfter = "24:00"
+	}
+	return notBefore + "-" + notAfter
+}
+
+func formatTimeOfDay(d time.Duration) string {
+	return time.Time{}.Add(d).Format("15:04:05")
+}
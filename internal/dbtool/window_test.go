@@ -0,0 +1,70 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dbtool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/clbs-io/dbtool/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestInWindow(t *testing.T) {
+	at := func(hour, minute int) time.Duration {
+		return time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute
+	}
+
+	t.Run("Within the day", func(t *testing.T) {
+		assert.True(t, inWindow(at(2, 0), at(2, 0), at(4, 0)))
+		assert.True(t, inWindow(at(3, 59), at(2, 0), at(4, 0)))
+		assert.False(t, inWindow(at(4, 0), at(2, 0), at(4, 0)))
+		assert.False(t, inWindow(at(12, 0), at(2, 0), at(4, 0)))
+	})
+
+	t.Run("Spanning midnight", func(t *testing.T) {
+		assert.True(t, inWindow(at(23, 0), at(22, 0), at(4, 0)))
+		assert.True(t, inWindow(at(0, 30), at(22, 0), at(4, 0)))
+		assert.False(t, inWindow(at(4, 0), at(22, 0), at(4, 0)))
+		assert.False(t, inWindow(at(12, 0), at(22, 0), at(4, 0)))
+	})
+
+	t.Run("Open ended", func(t *testing.T) {
+		assert.True(t, inWindow(at(23, 59), at(20, 0), 24*time.Hour))
+		assert.False(t, inWindow(at(19, 59), at(20, 0), 24*time.Hour))
+		assert.True(t, inWindow(at(0, 0), 0, at(6, 0)))
+		assert.False(t, inWindow(at(6, 0), 0, at(6, 0)))
+	})
+}
+
+func TestWindowString(t *testing.T) {
+	cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db", NotBefore: "22:00", NotAfter: "04:00"})
+	require.NoError(t, err)
+	assert.Equal(t, "22:00-04:00", windowString(cfg))
+
+	cfg, err = config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db", NotBefore: "20:00"})
+	require.NoError(t, err)
+	assert.Equal(t, "20:00-24:00", windowString(cfg))
+
+	cfg, err = config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db", NotAfter: "06:00"})
+	require.NoError(t, err)
+	assert.Equal(t, "00:00-06:00", windowString(cfg))
+}
+
+func TestFormatTimeOfDay(t *testing.T) {
+	assert.Equal(t, "00:00:00", formatTimeOfDay(0))
+	assert.Equal(t, "13:05:09", formatTimeOfDay(13*time.Hour+5*time.Minute+9*time.Second+500*time.Millisecond))
+}
+
+func TestCheckMaintenanceWindow_WithoutWindow(t *testing.T) {
+	cfg, err := config.New(config.Options{AppId: "app", WithoutDir: true, ConnectionString: "postgres://localhost/db"})
+	require.NoError(t, err)
+
+	// The database clock is not queried
+	assert.NoError(t, checkMaintenanceWindow(context.Background(), nil, cfg, zap.NewNop()))
+}
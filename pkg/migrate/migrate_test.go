@@ -0,0 +1,42 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package migrate
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateValidation(t *testing.T) {
+	fsys := fstest.MapFS{"001-init.sql": {Data: []byte("SELECT 1;")}}
+
+	t.Run("No migrations source", func(t *testing.T) {
+		_, err := Migrate(context.Background(), Options{AppId: "test-app", ConnectionString: "postgres://localhost/db"})
+		assert.ErrorIs(t, err, ErrNoMigrations)
+	})
+
+	t.Run("Missing app id", func(t *testing.T) {
+		_, err := Migrate(context.Background(), Options{FS: fsys, ConnectionString: "postgres://localhost/db"})
+		assert.ErrorIs(t, err, ErrInvalidAppId)
+	})
+
+	t.Run("Missing connection string", func(t *testing.T) {
+		_, err := Migrate(context.Background(), Options{FS: fsys, AppId: "test-app"})
+		assert.ErrorIs(t, err, ErrInvalidConnectionString)
+	})
+
+	t.Run("Invalid dir", func(t *testing.T) {
+		_, err := Migrate(context.Background(), Options{Dir: "/non/existent", AppId: "test-app", ConnectionString: "postgres://localhost/db"})
+		assert.ErrorIs(t, err, ErrInvalidMigrationsDirectory)
+	})
+
+	t.Run("Invalid hash algorithm", func(t *testing.T) {
+		_, err := Migrate(context.Background(), Options{FS: fsys, AppId: "test-app", ConnectionString: "postgres://localhost/db", HashAlgorithm: "md5"})
+		assert.ErrorIs(t, err, ErrInvalidHashAlgorithm)
+	})
+}
@@ -0,0 +1,4 @@
+CREATE TABLE orders (
+    id      BIGINT PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users (id)
+);